@@ -0,0 +1,178 @@
+// Command fake-push-service is a push service for local development: it hands out subscriptions, and
+// decrypts and records the messages sent to them instead of delivering them to a browser.
+//
+// Start the backend with --webpush-allow-private-endpoints, create a subscription with
+// `POST /subscriptions`, register the returned JSON with `POST /api/v1/push/subscriptions`, then read the
+// received messages with `GET /subscriptions/:id/messages`. `DELETE /subscriptions/:id` simulates an
+// expired subscription.
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"github.com/alexflint/go-arg"
+	"github.com/denysvitali/social/backend/pkg/webpush"
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+var args struct {
+	ListenAddr string `arg:"--listen-addr,env:LISTEN_ADDR" default:"localhost:8090"`
+	// PublicURL is the URL of this service as seen by the backend
+	PublicURL string `arg:"--public-url,env:PUBLIC_URL" default:"http://localhost:8090"`
+}
+
+var logger = logrus.New()
+
+type subscription struct {
+	key        *ecdsa.PrivateKey
+	authSecret []byte
+	gone       bool
+	messages   []message
+}
+
+type message struct {
+	ReceivedAt time.Time       `json:"receivedAt"`
+	TTL        string          `json:"ttl"`
+	Urgency    string          `json:"urgency,omitempty"`
+	Topic      string          `json:"topic,omitempty"`
+	Payload    json.RawMessage `json:"payload"`
+}
+
+type service struct {
+	mu            sync.Mutex
+	subscriptions map[string]*subscription
+}
+
+func main() {
+	arg.MustParse(&args)
+	args.PublicURL = strings.TrimSuffix(args.PublicURL, "/")
+
+	s := service{subscriptions: map[string]*subscription{}}
+	e := gin.New()
+	e.Use(gin.Logger())
+	e.POST("/subscriptions", s.createSubscription)
+	e.DELETE("/subscriptions/:id", s.deleteSubscription)
+	e.GET("/subscriptions/:id/messages", s.getMessages)
+	e.POST("/push/:id", s.push)
+
+	err := e.Run(args.ListenAddr)
+	if err != nil {
+		logger.Fatalf("unable to listen: %v", err)
+	}
+}
+
+// createSubscription returns a new subscription, in the format of PushSubscription.toJSON
+func (s *service) createSubscription(c *gin.Context) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+	authSecret := make([]byte, 16)
+	rawId := make([]byte, 8)
+	_, err = rand.Read(authSecret)
+	if err == nil {
+		_, err = rand.Read(rawId)
+	}
+	if err != nil {
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+	id := hex.EncodeToString(rawId)
+
+	s.mu.Lock()
+	s.subscriptions[id] = &subscription{key: key, authSecret: authSecret}
+	s.mu.Unlock()
+
+	c.JSON(http.StatusCreated, gin.H{
+		"endpoint": args.PublicURL + "/push/" + id,
+		"keys": gin.H{
+			"p256dh": base64.RawURLEncoding.EncodeToString(elliptic.Marshal(key.Curve, key.X, key.Y)),
+			"auth":   base64.RawURLEncoding.EncodeToString(authSecret),
+		},
+	})
+}
+
+func (s *service) deleteSubscription(c *gin.Context) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sub, ok := s.subscriptions[c.Param("id")]
+	if !ok {
+		c.AbortWithStatus(http.StatusNotFound)
+		return
+	}
+	sub.gone = true
+	c.Status(http.StatusNoContent)
+}
+
+func (s *service) getMessages(c *gin.Context) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sub, ok := s.subscriptions[c.Param("id")]
+	if !ok {
+		c.AbortWithStatus(http.StatusNotFound)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"messages": sub.messages})
+}
+
+// push receives a message, as a push service would
+func (s *service) push(c *gin.Context) {
+	_, err := webpush.VerifyAuthorization(c.GetHeader("Authorization"), args.PublicURL, time.Now())
+	if err != nil {
+		logger.Warnf("rejected push: %v", err)
+		c.AbortWithStatus(http.StatusUnauthorized)
+		return
+	}
+	if c.GetHeader("Content-Encoding") != "aes128gcm" || c.GetHeader("TTL") == "" {
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+	body, err := io.ReadAll(io.LimitReader(c.Request.Body, 4097))
+	if err != nil || len(body) > 4096 {
+		c.AbortWithStatus(http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sub, ok := s.subscriptions[c.Param("id")]
+	if !ok {
+		c.AbortWithStatus(http.StatusNotFound)
+		return
+	}
+	if sub.gone {
+		c.AbortWithStatus(http.StatusGone)
+		return
+	}
+
+	payload, err := webpush.Decrypt(body, sub.key, sub.authSecret)
+	if err != nil {
+		logger.Warnf("unable to decrypt push: %v", err)
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+	if !json.Valid(payload) {
+		payload, _ = json.Marshal(string(payload))
+	}
+	logger.Infof("push to %s: %s", c.Param("id"), payload)
+
+	sub.messages = append(sub.messages, message{
+		ReceivedAt: time.Now(),
+		TTL:        c.GetHeader("TTL"),
+		Urgency:    c.GetHeader("Urgency"),
+		Topic:      c.GetHeader("Topic"),
+		Payload:    payload,
+	})
+	c.Status(http.StatusCreated)
+}
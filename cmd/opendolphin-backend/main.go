@@ -1,18 +1,58 @@
 package main
 
 import (
+	"fmt"
 	"github.com/alexflint/go-arg"
 	server "github.com/denysvitali/social/backend/pkg"
+	"github.com/denysvitali/social/backend/pkg/mail"
+	"github.com/denysvitali/social/backend/pkg/storage"
+	"github.com/denysvitali/social/backend/pkg/webpush"
 	"github.com/sirupsen/logrus"
+	"time"
 )
 
 var args struct {
 	Debug       *bool  `arg:"-D"`
 	PostgresDSN string `arg:"--postgres-dsn,env:DATABASE_URL"`
 
+	ArangoEndpoints []string `arg:"--arango-endpoint,env:ARANGO_ENDPOINTS"`
+	ArangoUsername  string   `arg:"--arango-username,env:ARANGO_USERNAME" default:"root"`
+	ArangoPassword  string   `arg:"--arango-password,env:ARANGO_PASSWORD"`
+	ArangoDatabase  string   `arg:"--arango-database,env:ARANGO_DATABASE" default:"_system"`
+
 	IsDemo bool `arg:"env:DEMO_MODE" default:"false"`
 
 	ListenAddr string `arg:"--listen-addr,env:LISTEN_ADDR"`
+
+	AdminToken string `arg:"--admin-token,env:ADMIN_TOKEN"`
+
+	MediaDir       string `arg:"--media-dir,env:MEDIA_DIR" default:"media"`
+	MediaPublicURL string `arg:"--media-public-url,env:MEDIA_PUBLIC_URL"`
+	S3Endpoint     string `arg:"--s3-endpoint,env:S3_ENDPOINT"`
+	S3Region       string `arg:"--s3-region,env:S3_REGION"`
+	S3Bucket       string `arg:"--s3-bucket,env:S3_BUCKET"`
+	S3AccessKey    string `arg:"--s3-access-key,env:S3_ACCESS_KEY"`
+	S3SecretKey    string `arg:"--s3-secret-key,env:S3_SECRET_KEY"`
+
+	ImageCacheDir     string `arg:"--image-cache-dir,env:IMAGE_CACHE_DIR" default:"image-cache"`
+	ImageCacheSizeMiB int64  `arg:"--image-cache-size-mib,env:IMAGE_CACHE_SIZE_MIB" default:"512"`
+
+	VAPIDPrivateKey              string `arg:"--vapid-private-key,env:VAPID_PRIVATE_KEY"`
+	VAPIDSubject                 string `arg:"--vapid-subject,env:VAPID_SUBJECT"`
+	WebPushAllowPrivateEndpoints bool   `arg:"--webpush-allow-private-endpoints,env:WEBPUSH_ALLOW_PRIVATE_ENDPOINTS"`
+	GenerateVAPIDKey             bool   `arg:"--generate-vapid-key" help:"print a new VAPID private key and exit"`
+
+	SMTPHost        string `arg:"--smtp-host,env:SMTP_HOST"`
+	SMTPPort        int    `arg:"--smtp-port,env:SMTP_PORT" default:"587"`
+	SMTPUsername    string `arg:"--smtp-username,env:SMTP_USERNAME"`
+	SMTPPassword    string `arg:"--smtp-password,env:SMTP_PASSWORD"`
+	SMTPImplicitTLS bool   `arg:"--smtp-implicit-tls,env:SMTP_IMPLICIT_TLS"`
+	EmailFrom       string `arg:"--email-from,env:EMAIL_FROM"`
+	EmailBaseURL    string `arg:"--email-base-url,env:EMAIL_BASE_URL"`
+	EmailSecret     string `arg:"--email-secret,env:EMAIL_SECRET"`
+
+	TrendingWindow   time.Duration `arg:"--trending-window,env:TRENDING_WINDOW" default:"6h"`
+	TrendingBaseline time.Duration `arg:"--trending-baseline,env:TRENDING_BASELINE" default:"168h"`
 }
 
 var logger = logrus.New()
@@ -20,14 +60,67 @@ var logger = logrus.New()
 func main() {
 	arg.MustParse(&args)
 
+	if args.GenerateVAPIDKey {
+		key, err := webpush.GenerateVAPIDKey()
+		if err != nil {
+			logger.Fatalf("unable to generate VAPID key: %v", err)
+		}
+		fmt.Println(key)
+		return
+	}
+
 	if args.Debug != nil && *args.Debug {
 		logger.SetLevel(logrus.DebugLevel)
 	}
 
 	s, err := server.New(server.Config{
 		PostgresDSN: args.PostgresDSN,
-		Logger:      logger,
-		DemoMode:    args.IsDemo,
+		Arango: server.ArangoConfig{
+			Endpoints: args.ArangoEndpoints,
+			Username:  args.ArangoUsername,
+			Password:  args.ArangoPassword,
+			Database:  args.ArangoDatabase,
+		},
+		Logger:   logger,
+		DemoMode: args.IsDemo,
+
+		AdminToken: args.AdminToken,
+
+		Media: server.MediaConfig{
+			Dir: args.MediaDir,
+			S3: storage.S3Config{
+				Endpoint:  args.S3Endpoint,
+				Region:    args.S3Region,
+				Bucket:    args.S3Bucket,
+				AccessKey: args.S3AccessKey,
+				SecretKey: args.S3SecretKey,
+			},
+			PublicURL: args.MediaPublicURL,
+		},
+		ImageCache: server.ImageCacheConfig{
+			Dir:      args.ImageCacheDir,
+			MaxBytes: args.ImageCacheSizeMiB << 20,
+		},
+		WebPush: server.WebPushConfig{
+			VAPIDPrivateKey:       args.VAPIDPrivateKey,
+			Subject:               args.VAPIDSubject,
+			AllowPrivateEndpoints: args.WebPushAllowPrivateEndpoints,
+		},
+		Email: server.EmailConfig{
+			SMTP: mail.Config{
+				Host:        args.SMTPHost,
+				Port:        args.SMTPPort,
+				Username:    args.SMTPUsername,
+				Password:    args.SMTPPassword,
+				From:        args.EmailFrom,
+				ImplicitTLS: args.SMTPImplicitTLS,
+			},
+			BaseURL: args.EmailBaseURL,
+			Secret:  args.EmailSecret,
+		},
+
+		TrendingWindow:   args.TrendingWindow,
+		TrendingBaseline: args.TrendingBaseline,
 	})
 
 	if err != nil {
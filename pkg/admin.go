@@ -0,0 +1,26 @@
+package server
+
+import (
+	"crypto/subtle"
+	"github.com/gin-gonic/gin"
+	"strings"
+)
+
+// requireAdmin guards the admin API: requests must carry the configured admin token as a bearer token
+func (s *Server) requireAdmin(c *gin.Context) {
+	if s.adminToken == "" {
+		s.notFound(c, "admin API called, but no admin token is configured")
+		c.Abort()
+		return
+	}
+
+	auth := c.GetHeader("Authorization")
+	token := strings.TrimPrefix(auth, "Bearer ")
+	if token == auth || subtle.ConstantTimeCompare([]byte(token), []byte(s.adminToken)) != 1 {
+		s.unauthorized(c, "invalid admin token from %s", c.ClientIP())
+		c.Abort()
+		return
+	}
+
+	c.Next()
+}
@@ -3,11 +3,13 @@ package server
 import (
 	"errors"
 	"fmt"
+	"github.com/denysvitali/social/backend/pkg/models/api"
 	pg_model "github.com/denysvitali/social/backend/pkg/models/postgres"
 	v1requests "github.com/denysvitali/social/backend/pkg/requests/v1"
 	"github.com/gin-gonic/gin"
 	"gorm.io/gorm"
 	"net/http"
+	"strconv"
 )
 
 func (s *Server) initAPIv1(g *gin.RouterGroup) {
@@ -17,18 +19,116 @@ func (s *Server) initAPIv1(g *gin.RouterGroup) {
 	g.GET("/users/:id", s.apiV1GetUserById)
 	g.GET("/users/@:username/profile_picture", s.apiV1ProfilePictureByUsername)
 	g.GET("/users/@:username/bio_picture", s.apiV1BioPictureByUsername)
+	g.POST("/users/@:username/profile_picture", s.apiV1UploadProfilePicture)
+	g.POST("/users/@:username/bio_picture", s.apiV1UploadBioPicture)
 	g.POST("/users/:id/follows/:target_id", s.apiV1SetUserFollows)
+	g.DELETE("/users/:id/follows/:target_id", s.apiV1UnsetUserFollows)
+	g.PUT("/users/:id/block", s.apiV1BlockUser)
+	g.DELETE("/users/:id/block", s.apiV1UnblockUser)
+	g.PUT("/users/:id/mute", s.apiV1MuteUser)
+	g.DELETE("/users/:id/mute", s.apiV1UnmuteUser)
+	g.GET("/blocks", s.apiV1GetBlocks)
+	g.GET("/mutes", s.apiV1GetMutes)
 
 	// User Posts
 	g.GET("/users/@:username/posts", s.apiV1PostsByAuthorUsername)
 	g.GET("/users/:id/posts", s.apiV1PostsByAuthorId)
 
 	g.GET("/posts", s.apiV1GetPosts)
+	g.POST("/posts", s.apiV1CreatePost)
 	g.GET("/posts/:id", s.apiV1GetSinglePost)
 	g.GET("/posts/:id/liked_by", s.apiV1PostLikedBy)
+	g.PUT("/posts/:id/like", s.apiV1LikePost)
+	g.DELETE("/posts/:id/like", s.apiV1UnlikePost)
+	g.GET("/posts/:id/analytics", s.apiV1PostAnalytics)
+	g.PUT("/posts/:id/bookmark", s.apiV1BookmarkPost)
+	g.DELETE("/posts/:id/bookmark", s.apiV1UnbookmarkPost)
+	g.PUT("/posts/:id/pin", s.apiV1PinPost)
+	g.DELETE("/posts/:id/pin", s.apiV1UnpinPost)
 
+	// Media
+	g.POST("/media", s.apiV1UploadMedia)
+	g.GET("/media/:id", s.apiV1GetMedia)
+	g.GET("/media/:id/metadata", s.apiV1GetMediaMetadata)
+
+	// Bookmarks
+	g.GET("/bookmarks", s.apiV1GetBookmarks)
+	g.GET("/bookmarks/collections", s.apiV1GetBookmarkCollections)
+	g.POST("/bookmarks/collections", s.apiV1CreateBookmarkCollection)
+	g.DELETE("/bookmarks/collections/:collection_id", s.apiV1DeleteBookmarkCollection)
+
+	g.GET("/tags/trending", s.apiV1TrendingTags)
+	g.GET("/tags/autocomplete", s.apiV1TagsAutocomplete)
 	g.GET("/tags/:text", s.apiV1TagsByText)
 	g.GET("/tags/:text/posts", s.apiV1TagsGetPosts)
+	g.PUT("/tags/:text/follow", s.apiV1FollowTag)
+	g.DELETE("/tags/:text/follow", s.apiV1UnfollowTag)
+
+	// Search
+	g.GET("/search/posts", s.apiV1SearchPosts)
+	g.GET("/search/users", s.apiV1SearchUsers)
+
+	// Notifications
+	g.GET("/notifications", s.apiV1GetNotifications)
+	g.GET("/notifications/unread_count", s.apiV1GetUnreadNotificationsCount)
+	g.POST("/notifications/read", s.apiV1MarkNotificationsRead)
+
+	// Email
+	g.GET("/settings/email", s.apiV1GetEmailSettings)
+	g.PUT("/settings/email", s.apiV1UpdateEmailSettings)
+	g.GET("/email/unsubscribe", s.apiV1UnsubscribePage)
+	g.POST("/email/unsubscribe", s.apiV1Unsubscribe)
+	g.GET("/email/confirm", s.apiV1ConfirmEmailPage)
+	g.POST("/email/confirm", s.apiV1ConfirmEmail)
+
+	// Muted words
+	g.GET("/settings/muted_words", s.apiV1GetMutedWords)
+	g.POST("/settings/muted_words", s.apiV1CreateMutedWord)
+	g.DELETE("/settings/muted_words/:id", s.apiV1DeleteMutedWord)
+
+	// Web Push
+	g.GET("/push/vapid_public_key", s.apiV1VAPIDPublicKey)
+	g.GET("/push/subscriptions", s.apiV1GetPushSubscriptions)
+	g.POST("/push/subscriptions", s.apiV1CreatePushSubscription)
+	g.DELETE("/push/subscriptions/:id", s.apiV1DeletePushSubscription)
+
+	// Direct messages
+	g.GET("/conversations", s.apiV1GetConversations)
+	g.POST("/conversations", s.apiV1CreateConversation)
+	g.GET("/conversations/unread_count", s.apiV1GetUnreadConversationsCount)
+	g.GET("/conversations/:id", s.apiV1GetConversation)
+	g.DELETE("/conversations/:id", s.apiV1LeaveConversation)
+	g.GET("/conversations/:id/messages", s.apiV1GetMessages)
+	g.POST("/conversations/:id/messages", s.apiV1SendMessage)
+	g.POST("/conversations/:id/read", s.apiV1MarkConversationRead)
+	g.POST("/conversations/:id/accept", s.apiV1AcceptConversation)
+	g.PUT("/conversations/:id/mute", s.apiV1MuteConversation)
+	g.DELETE("/conversations/:id/mute", s.apiV1UnmuteConversation)
+
+	// Reports
+	g.POST("/reports", s.apiV1CreateReport)
+	g.GET("/warnings", s.apiV1GetWarnings)
+
+	// Timelines
+	g.GET("/timelines/home", s.apiV1HomeTimeline)
+
+	// Streaming
+	g.GET("/streaming", s.apiV1Streaming)
+
+	admin := g.Group("/admin", s.requireAdmin)
+	admin.POST("/tags/:text/merge", s.apiV1AdminMergeTag)
+	admin.GET("/tags/:text/aliases", s.apiV1AdminGetTagAliases)
+	admin.POST("/tags/:text/aliases", s.apiV1AdminCreateTagAlias)
+	admin.DELETE("/tags/aliases/:alias", s.apiV1AdminDeleteTagAlias)
+	admin.GET("/webhooks", s.apiV1AdminGetWebhooks)
+	admin.POST("/webhooks", s.apiV1AdminCreateWebhook)
+	admin.PATCH("/webhooks/:id", s.apiV1AdminUpdateWebhook)
+	admin.DELETE("/webhooks/:id", s.apiV1AdminDeleteWebhook)
+	admin.GET("/webhooks/:id/deliveries", s.apiV1AdminGetWebhookDeliveries)
+	admin.POST("/webhooks/:id/deliveries/:delivery_id/redeliver", s.apiV1AdminRedeliverWebhook)
+	admin.GET("/reports", s.apiV1AdminGetReports)
+	admin.POST("/reports/:id/resolve", s.apiV1AdminResolveReport)
+	admin.DELETE("/users/:id/suspension", s.apiV1AdminUnsuspendUser)
 }
 
 func (s *Server) apiV1GetUserById(c *gin.Context) {
@@ -56,90 +156,192 @@ func (s *Server) apiV1UserByUsername(c *gin.Context) {
 		return
 	}
 
-	if user.Deleted {
+	if userUnavailable(user) {
 		s.notFound(c, "user doesn't exist anymore")
 		return
 	}
 
-	c.JSON(http.StatusOK, user)
-}
+	viewer, _ := s.viewerId(c)
+	blocked, err := s.isBlocked(c.Request.Context(), viewer, user.ID)
+	if err != nil {
+		s.internalServerError(c, "unable to check blocks between users %d and %d: %v", viewer, user.ID, err)
+		return
+	}
+	if blocked {
+		s.notFound(c, "profile of %s hidden from user %d", user.Username, viewer)
+		return
+	}
 
-func (s *Server) apiV1ProfilePictureByUsername(c *gin.Context) {
-	usernameKey := c.Param("username")
-	if usernameKey == "" {
-		s.badRequest(c,
-			"user provided an invalid parameter username",
-			"invalid parameter username",
-		)
+	pinned, err := s.pinnedPosts(c.Request.Context(), viewer, user.ID)
+	if err != nil {
+		s.internalServerError(c, "unable to get pinned posts: %v", err)
 		return
 	}
 
-	var pp pg_model.ProfilePicture
-	tx := s.pgDB.
-		Joins("left join users ON users.id = profile_pictures.user_id").
-		Where("users.username = ?", usernameKey).
-		First(&pp).
-		Order("profile_pictures.last_updated DESC")
-	if tx.Error != nil {
-		if errors.Is(tx.Error, gorm.ErrRecordNotFound) {
-			s.notFound(c, "not found")
-			return
-		}
-		s.internalServerError(c, "unable to find profile picture: %v", tx.Error)
+	pinnedResponse, err := s.postsResponse(viewer, pinned)
+	if err != nil {
+		s.internalServerError(c, "unable to build posts response: %v", err)
 		return
 	}
+	for i := range pinnedResponse.Posts {
+		pinnedResponse.Posts[i].Pinned = true
+	}
 
-	c.Redirect(http.StatusTemporaryRedirect, pp.Url)
+	c.JSON(http.StatusOK, api.Profile{
+		ID:             user.ID,
+		CreatedAt:      user.CreatedAt,
+		Username:       user.Username,
+		DisplayName:    user.DisplayName,
+		Biography:      user.Biography,
+		Location:       user.Location,
+		FollowersCount: user.FollowersCount,
+		FollowingCount: user.FollowingCount,
+		Verified:       user.Verified,
+		PinnedPosts:    pinnedResponse.Posts,
+	})
+}
+
+func (s *Server) apiV1ProfilePictureByUsername(c *gin.Context) {
+	s.servePicture(c, profilePictures)
 }
 
 func (s *Server) apiV1BioPictureByUsername(c *gin.Context) {
-	usernameKey := c.Param("username")
-	if usernameKey == "" {
-		s.badRequest(c,
-			"user provided an invalid parameter username",
-			"invalid parameter username",
-		)
+	s.servePicture(c, bioPictures)
+}
+
+var NotImplementedError = map[string]string{
+	"error": "not implemented",
+}
+
+func (s *Server) apiV1SetUserFollows(c *gin.Context) {
+	actorId, targetId, ok := s.parseFollowParams(c)
+	if !ok {
+		return
+	}
+
+	if actorId == targetId {
+		s.badRequest(c, fmt.Sprintf("user %d tried to follow themselves", actorId), "you cannot follow yourself")
 		return
 	}
 
-	var pp pg_model.BioPicture
-	tx := s.pgDB.
-		Joins("left join users ON users.id = bio_pictures.user_id").
-		Where("users.username = ?", usernameKey).
-		First(&pp).
-		Order("bio_pictures.last_updated DESC")
+	var target pg_model.User
+	tx := s.pgDB.Select("id", "deleted", "suspended_at").Take(&target, "id = ?", targetId)
 	if tx.Error != nil {
 		if errors.Is(tx.Error, gorm.ErrRecordNotFound) {
-			s.logger.Infof("bio_picture not found for %s", usernameKey)
-			s.notFound(c, "not found")
+			s.notFound(c, "user %d not found", targetId)
 			return
 		}
-		s.internalServerError(c, "unable to find profile picture: %v", tx.Error)
+		s.internalServerError(c, "unable to get user %d: %v", targetId, tx.Error)
+		return
+	}
+	if userUnavailable(target) {
+		s.notFound(c, "user %d doesn't exist anymore", targetId)
+		return
+	}
+	blocked, err := s.isBlocked(c.Request.Context(), actorId, targetId)
+	if err != nil {
+		s.internalServerError(c, "unable to check blocks between users %d and %d: %v", actorId, targetId, err)
+		return
+	}
+	if blocked {
+		s.forbidden(c, "user %d tried to follow user %d, but one blocks the other", actorId, targetId)
 		return
 	}
 
-	c.Redirect(http.StatusTemporaryRedirect, pp.Url)
+	created, err := s.addRelation(c.Request.Context(), RelationFollows, userVertex(actorId), userVertex(targetId))
+	if err != nil {
+		s.internalServerError(c, "unable to follow user: %v", err)
+		return
+	}
+	if created {
+		err = s.updateFollowCounts(actorId, targetId, 1)
+		if err != nil {
+			s.internalServerError(c, "unable to update follow counts: %v", err)
+			return
+		}
+		s.notify(targetId, pg_model.NotificationFollow, actorId, nil)
+		s.emitWebhookEvent(pg_model.WebhookEventUserFollowed, func() (any, error) {
+			return api.UserFollowedEvent{Follower: actorId, Followed: targetId}, nil
+		})
+	}
+
+	c.Status(http.StatusNoContent)
 }
 
-var NotImplementedError = map[string]string{
-	"error": "not implemented",
+func (s *Server) apiV1UnsetUserFollows(c *gin.Context) {
+	actorId, targetId, ok := s.parseFollowParams(c)
+	if !ok {
+		return
+	}
+
+	removed, err := s.removeRelation(c.Request.Context(), RelationFollows, userVertex(actorId), userVertex(targetId))
+	if err != nil {
+		s.internalServerError(c, "unable to unfollow user: %v", err)
+		return
+	}
+	if removed {
+		err = s.updateFollowCounts(actorId, targetId, -1)
+		if err != nil {
+			s.internalServerError(c, "unable to update follow counts: %v", err)
+			return
+		}
+		s.unnotify(targetId, pg_model.NotificationFollow, actorId, nil)
+	}
+
+	c.Status(http.StatusNoContent)
 }
 
-func (s *Server) apiV1SetUserFollows(c *gin.Context) {
+// parseFollowParams parses the "id" and "target_id" parameters. Users can only manage their own follows,
+// so "id" must be the viewer.
+func (s *Server) parseFollowParams(c *gin.Context) (uint64, uint64, bool) {
 	actorUserIdKey := c.Param("id")
 	if actorUserIdKey == "" {
 		s.paramCantBeEmpty(c, "id")
-		return
+		return 0, 0, false
 	}
 
 	targetUserIdKey := c.Param("target_id")
 	if targetUserIdKey == "" {
 		s.paramCantBeEmpty(c, "target_id")
-		return
+		return 0, 0, false
 	}
 
-	c.JSON(http.StatusNotImplemented, NotImplementedError)
-	return
+	actorId, err := strconv.ParseUint(actorUserIdKey, 10, 64)
+	if err != nil {
+		s.badRequest(c, fmt.Sprintf("invalid id: %v", err), "invalid id")
+		return 0, 0, false
+	}
+	targetId, err := strconv.ParseUint(targetUserIdKey, 10, 64)
+	if err != nil {
+		s.badRequest(c, fmt.Sprintf("invalid target_id: %v", err), "invalid target_id")
+		return 0, 0, false
+	}
+
+	viewer, ok := s.requireViewer(c)
+	if !ok {
+		return 0, 0, false
+	}
+	if viewer != actorId {
+		s.forbidden(c, "user %d tried to manage the follows of user %d", viewer, actorId)
+		return 0, 0, false
+	}
+
+	return actorId, targetId, true
+}
+
+// updateFollowCounts adds delta to the following count of actorId and to the followers count of targetId
+func (s *Server) updateFollowCounts(actorId uint64, targetId uint64, delta int) error {
+	return s.pgDB.Transaction(func(tx *gorm.DB) error {
+		err := tx.Model(&pg_model.User{}).
+			Where("id = ?", actorId).
+			UpdateColumn("following_count", gorm.Expr("GREATEST(following_count + ?, 0)", delta)).Error
+		if err != nil {
+			return err
+		}
+		return tx.Model(&pg_model.User{}).
+			Where("id = ?", targetId).
+			UpdateColumn("followers_count", gorm.Expr("GREATEST(followers_count + ?, 0)", delta)).Error
+	})
 }
 
 func (s *Server) apiV1CreateUser(c *gin.Context) {
@@ -0,0 +1,367 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"github.com/denysvitali/social/backend/pkg/models/api"
+	pgmodel "github.com/denysvitali/social/backend/pkg/models/postgres"
+	v1requests "github.com/denysvitali/social/backend/pkg/requests/v1"
+	"github.com/gin-gonic/gin"
+	"github.com/oklog/ulid/v2"
+	"gorm.io/gorm"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// MaxWarningMessageLength is the maximum length of the message of a warning, in Unicode code points
+const MaxWarningMessageLength = 1000
+
+// maxReportsPerGroup is the number of reports listed in a group of the moderation queue, the others are
+// only counted
+const maxReportsPerGroup = 10
+
+var errReportAlreadyResolved = errors.New("report already resolved")
+
+// apiV1AdminGetReports returns the moderation queue: the reports, grouped by target, most recently
+// reported first. The "status" query parameter selects the open (the default) or resolved reports.
+func (s *Server) apiV1AdminGetReports(c *gin.Context) {
+	status := c.DefaultQuery("status", pgmodel.ReportOpen)
+	if status != pgmodel.ReportOpen && status != pgmodel.ReportResolved {
+		s.badRequest(c, fmt.Sprintf("invalid report status %q", status), "status must be one of open, resolved")
+		return
+	}
+
+	p, err := parsePage(c)
+	if err != nil {
+		s.badRequest(c, fmt.Sprintf("invalid pagination: %v", err), err.Error())
+		return
+	}
+
+	var groups []struct {
+		TargetKey       string
+		TargetType      string
+		TargetPostID    []byte
+		TargetUserID    uint64
+		LatestID        []byte
+		Count           int64
+		FirstReportedAt time.Time
+		LastReportedAt  time.Time
+	}
+	// Each group is represented by its latest report. IDs are bytea, which has no MAX() before
+	// PostgreSQL 18, hence the window functions.
+	vars := map[string]any{
+		"status": status,
+		"limit":  p.Limit,
+	}
+	pagination := ""
+	if p.Cursor != nil {
+		pagination = "AND latest_id < @cursor"
+		vars["cursor"] = p.Cursor
+	}
+	tx := s.pgDB.Raw(`
+		SELECT target_key, target_type, target_post_id, target_user_id, latest_id, count,
+			first_reported_at, last_reported_at
+		FROM (
+			SELECT target_key, target_type, target_post_id, target_user_id, id AS latest_id,
+				COUNT(*) OVER (PARTITION BY target_key) AS count,
+				MIN(created_at) OVER (PARTITION BY target_key) AS first_reported_at,
+				created_at AS last_reported_at,
+				ROW_NUMBER() OVER (PARTITION BY target_key ORDER BY id DESC) AS rank
+			FROM reports
+			WHERE status = @status
+		) grouped
+		WHERE rank = 1 `+pagination+`
+		ORDER BY latest_id DESC
+		LIMIT @limit`,
+		vars,
+	).Scan(&groups)
+	if tx.Error != nil {
+		s.internalServerError(c, "unable to get report groups: %v", tx.Error)
+		return
+	}
+
+	response := api.ReportGroupsResponse{
+		Groups: []api.ReportGroup{},
+		Users:  []api.User{},
+		Posts:  []api.Post{},
+	}
+	if len(groups) == 0 {
+		c.JSON(http.StatusOK, response)
+		return
+	}
+	response.NextCursor = p.nextCursor(len(groups), groups[len(groups)-1].LatestID)
+
+	var targetKeys []string
+	var postIds [][]byte
+	userIds := map[uint64]bool{}
+	for _, g := range groups {
+		targetKeys = append(targetKeys, g.TargetKey)
+		if g.TargetPostID != nil {
+			postIds = append(postIds, g.TargetPostID)
+		}
+		userIds[g.TargetUserID] = true
+	}
+
+	var reasons []struct {
+		TargetKey string
+		Reason    string
+		Count     int64
+	}
+	tx = s.pgDB.
+		Model(&pgmodel.Report{}).
+		Select("target_key, reason, COUNT(*) AS count").
+		Where("status = ? AND target_key IN ?", status, targetKeys).
+		Group("target_key, reason").
+		Scan(&reasons)
+	if tx.Error != nil {
+		s.internalServerError(c, "unable to count report reasons: %v", tx.Error)
+		return
+	}
+	reasonsByGroup := map[string]map[string]int64{}
+	for _, r := range reasons {
+		if reasonsByGroup[r.TargetKey] == nil {
+			reasonsByGroup[r.TargetKey] = map[string]int64{}
+		}
+		reasonsByGroup[r.TargetKey][r.Reason] = r.Count
+	}
+
+	// The most recent reports of each group
+	var reports []pgmodel.Report
+	tx = s.pgDB.
+		Where(`id IN (
+			SELECT id FROM (
+				SELECT id, ROW_NUMBER() OVER (PARTITION BY target_key ORDER BY id DESC) AS rank
+				FROM reports
+				WHERE status = ? AND target_key IN ?
+			) ranked
+			WHERE rank <= ?
+		)`, status, targetKeys, maxReportsPerGroup).
+		Order("id DESC").
+		Find(&reports)
+	if tx.Error != nil {
+		s.internalServerError(c, "unable to get reports: %v", tx.Error)
+		return
+	}
+	reportsByGroup := map[string][]api.ReportWithReporter{}
+	for _, r := range reports {
+		reportsByGroup[r.TargetKey] = append(reportsByGroup[r.TargetKey], api.ReportWithReporter{
+			Report:   getApiReport(r),
+			Reporter: r.ReporterID,
+		})
+		userIds[r.ReporterID] = true
+	}
+
+	// Sideloaded posts, including the deleted ones, and users
+	var posts []pgmodel.Post
+	if len(postIds) > 0 {
+		tx = s.pgDB.Where("id IN ?", postIds).Order("id DESC").Find(&posts)
+		if tx.Error != nil {
+			s.internalServerError(c, "unable to get reported posts: %v", tx.Error)
+			return
+		}
+	}
+	postsResponse, err := s.postsResponse(0, posts)
+	if err != nil {
+		s.internalServerError(c, "unable to build posts response: %v", err)
+		return
+	}
+	response.Posts = postsResponse.Posts
+	response.Users = postsResponse.Users
+	for _, u := range response.Users {
+		delete(userIds, u.ID)
+	}
+	var userIdList []uint64
+	for id := range userIds {
+		userIdList = append(userIdList, id)
+	}
+	if len(userIdList) > 0 {
+		var users []pgmodel.User
+		tx = s.pgDB.Where("id IN ?", userIdList).Order("id ASC").Find(&users)
+		if tx.Error != nil {
+			s.internalServerError(c, "unable to get reported users: %v", tx.Error)
+			return
+		}
+		for _, u := range users {
+			response.Users = append(response.Users, getApiUser(u))
+		}
+	}
+
+	for _, g := range groups {
+		group := api.ReportGroup{
+			ID:              bytesToUlid(g.LatestID).String(),
+			TargetType:      g.TargetType,
+			User:            g.TargetUserID,
+			Count:           g.Count,
+			Reasons:         reasonsByGroup[g.TargetKey],
+			Reports:         reportsByGroup[g.TargetKey],
+			FirstReportedAt: g.FirstReportedAt,
+			LastReportedAt:  g.LastReportedAt,
+		}
+		if g.TargetPostID != nil {
+			group.Post = bytesToUlid(g.TargetPostID).String()
+		}
+		response.Groups = append(response.Groups, group)
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+// apiV1AdminResolveReport takes a moderator action on the target of a report, which resolves all the
+// open reports about it. The reporters are notified of the action.
+func (s *Server) apiV1AdminResolveReport(c *gin.Context) {
+	report, ok := s.findReportParam(c)
+	if !ok {
+		return
+	}
+
+	var req v1requests.ResolveReport
+	err := c.ShouldBindJSON(&req)
+	if err != nil {
+		s.badRequest(c,
+			fmt.Sprintf("unable to bind JSON: %v", err),
+			"unable to parse JSON",
+		)
+		return
+	}
+	switch req.Action {
+	case pgmodel.ModerationDismiss, pgmodel.ModerationSuspendUser, pgmodel.ModerationWarn:
+	case pgmodel.ModerationDeletePost:
+		if report.TargetType != pgmodel.ReportTargetPost {
+			s.badRequest(c,
+				fmt.Sprintf("delete_post on report %s about a %s", bytesToUlid(report.ID), report.TargetType),
+				"delete_post only applies to reported posts",
+			)
+			return
+		}
+	default:
+		s.badRequest(c,
+			fmt.Sprintf("invalid moderation action %q", req.Action),
+			"action must be one of dismiss, delete_post, suspend_user, warn",
+		)
+		return
+	}
+	message := strings.TrimSpace(req.Message)
+	if utf8.RuneCountInString(message) > MaxWarningMessageLength {
+		s.badRequest(c, "message too long",
+			fmt.Sprintf("message cannot be longer than %d characters", MaxWarningMessageLength),
+		)
+		return
+	}
+	if report.Status != pgmodel.ReportOpen {
+		s.badRequest(c, fmt.Sprintf("report %s is already resolved", bytesToUlid(report.ID)), errReportAlreadyResolved.Error())
+		return
+	}
+
+	now := time.Now()
+	var resolved []pgmodel.Report
+	var warning *pgmodel.Warning
+	err = s.pgDB.Transaction(func(tx *gorm.DB) error {
+		err := tx.Raw(`
+			UPDATE reports SET status = @resolved, action = @action, resolved_at = @now
+			WHERE target_key = @target_key AND status = @open
+			RETURNING *`,
+			map[string]any{
+				"resolved":   pgmodel.ReportResolved,
+				"action":     req.Action,
+				"now":        now,
+				"target_key": report.TargetKey,
+				"open":       pgmodel.ReportOpen,
+			},
+		).Scan(&resolved).Error
+		if err != nil {
+			return err
+		}
+		if len(resolved) == 0 {
+			// Resolved concurrently by another moderator
+			return errReportAlreadyResolved
+		}
+
+		switch req.Action {
+		case pgmodel.ModerationDeletePost:
+			return tx.Model(&pgmodel.Post{}).
+				Where("id = ?", *report.TargetPostID).
+				UpdateColumn("deleted", true).Error
+		case pgmodel.ModerationSuspendUser:
+			return tx.Model(&pgmodel.User{}).
+				Where("id = ? AND suspended_at IS NULL", report.TargetUserID).
+				UpdateColumn("suspended_at", now).Error
+		case pgmodel.ModerationWarn:
+			warning = &pgmodel.Warning{
+				ID:        ulid.Make().Bytes(),
+				UserID:    report.TargetUserID,
+				ReportID:  &report.ID,
+				Reason:    report.Reason,
+				Message:   message,
+				CreatedAt: now,
+			}
+			return tx.Create(warning).Error
+		}
+		return nil
+	})
+	if errors.Is(err, errReportAlreadyResolved) {
+		s.badRequest(c, fmt.Sprintf("report %s is already resolved", bytesToUlid(report.ID)), err.Error())
+		return
+	}
+	if err != nil {
+		s.internalServerError(c, "unable to resolve report %s: %v", bytesToUlid(report.ID), err)
+		return
+	}
+
+	for _, r := range resolved {
+		s.notifyModeration(r.ReporterID, pgmodel.NotificationReportResolved, r.ID)
+	}
+	if warning != nil {
+		s.notifyModeration(warning.UserID, pgmodel.NotificationWarning, warning.ID)
+	}
+
+	c.JSON(http.StatusOK, api.ReportsResolved{Count: int64(len(resolved))})
+}
+
+// apiV1AdminUnsuspendUser lifts the suspension of a user
+func (s *Server) apiV1AdminUnsuspendUser(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		s.badRequest(c, fmt.Sprintf("invalid id: %v", err), "invalid id")
+		return
+	}
+
+	tx := s.pgDB.Model(&pgmodel.User{}).
+		Where("id = ? AND suspended_at IS NOT NULL", id).
+		UpdateColumn("suspended_at", nil)
+	if tx.Error != nil {
+		s.internalServerError(c, "unable to unsuspend user %d: %v", id, tx.Error)
+		return
+	}
+	if tx.RowsAffected == 0 {
+		s.notFound(c, "suspended user %d not found", id)
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// findReportParam fetches the report identified by the "id" parameter.
+// When the report can't be fetched, it replies to the request and returns false.
+func (s *Server) findReportParam(c *gin.Context) (pgmodel.Report, bool) {
+	var report pgmodel.Report
+
+	id, err := ulid.Parse(c.Param("id"))
+	if err != nil {
+		s.badRequest(c, fmt.Sprintf("invalid id: %v", err), "invalid id")
+		return report, false
+	}
+
+	tx := s.pgDB.Take(&report, "id = ?", id)
+	if tx.Error != nil {
+		if errors.Is(tx.Error, gorm.ErrRecordNotFound) {
+			s.notFound(c, "report %s not found", id)
+			return report, false
+		}
+		s.internalServerError(c, "unable to get report %s: %v", id, tx.Error)
+		return report, false
+	}
+	return report, true
+}
@@ -0,0 +1,189 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/denysvitali/social/backend/pkg/entities"
+	pgmodel "github.com/denysvitali/social/backend/pkg/models/postgres"
+	v1requests "github.com/denysvitali/social/backend/pkg/requests/v1"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"net/http"
+	"strings"
+)
+
+// apiV1AdminMergeTag merges the tag :text into another one. Merging runs in the background,
+// as moving the posts of a popular tag can take a while.
+func (s *Server) apiV1AdminMergeTag(c *gin.Context) {
+	source, ok := s.findTagParam(c)
+	if !ok {
+		return
+	}
+
+	var req v1requests.MergeTag
+	err := c.ShouldBindJSON(&req)
+	if err != nil {
+		s.badRequest(c,
+			fmt.Sprintf("unable to bind JSON: %v", err),
+			"unable to parse JSON",
+		)
+		return
+	}
+	if strings.TrimSpace(req.Into) == "" {
+		s.paramCantBeEmpty(c, "into")
+		return
+	}
+
+	target, err := s.findTagByText(req.Into)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			s.notFound(c, "tag %s not found", req.Into)
+			return
+		}
+		s.internalServerError(c, "unable to get tag %s: %v", req.Into, err)
+		return
+	}
+	if source.ID == target.ID {
+		s.badRequest(c, "tried to merge a tag into itself", "cannot merge a tag into itself")
+		return
+	}
+
+	s.mergeTagsInBackground(source, target)
+	c.Status(http.StatusAccepted)
+}
+
+func (s *Server) apiV1AdminGetTagAliases(c *gin.Context) {
+	tag, ok := s.findTagParam(c)
+	if !ok {
+		return
+	}
+
+	var aliases []pgmodel.TagAlias
+	tx := s.pgDB.
+		Where("tag_id = ?", tag.ID).
+		Order("canonical ASC").
+		Find(&aliases)
+	if tx.Error != nil {
+		s.internalServerError(c, "unable to get aliases of tag %d: %v", tag.ID, tx.Error)
+		return
+	}
+	if aliases == nil {
+		aliases = []pgmodel.TagAlias{}
+	}
+
+	c.JSON(http.StatusOK, aliases)
+}
+
+// apiV1AdminCreateTagAlias makes an alias resolve to the tag :text. If the alias is an existing tag,
+// that tag is merged into :text.
+func (s *Server) apiV1AdminCreateTagAlias(c *gin.Context) {
+	tag, ok := s.findTagParam(c)
+	if !ok {
+		return
+	}
+
+	var req v1requests.CreateTagAlias
+	err := c.ShouldBindJSON(&req)
+	if err != nil {
+		s.badRequest(c,
+			fmt.Sprintf("unable to bind JSON: %v", err),
+			"unable to parse JSON",
+		)
+		return
+	}
+	canonical := entities.CanonicalTag(strings.TrimPrefix(strings.TrimSpace(req.Alias), "#"))
+	if canonical == "" {
+		s.paramCantBeEmpty(c, "alias")
+		return
+	}
+	if canonical == tag.Canonical {
+		s.badRequest(c, "tried to alias a tag to itself", "alias is the same as the tag")
+		return
+	}
+
+	var existing pgmodel.Tag
+	tx := s.pgDB.Take(&existing, "canonical = ?", canonical)
+	if tx.Error == nil {
+		s.mergeTagsInBackground(existing, tag)
+		c.Status(http.StatusAccepted)
+		return
+	}
+	if !errors.Is(tx.Error, gorm.ErrRecordNotFound) {
+		s.internalServerError(c, "unable to get tag %s: %v", canonical, tx.Error)
+		return
+	}
+
+	alias := pgmodel.TagAlias{
+		Canonical: canonical,
+		TagID:     tag.ID,
+	}
+	tx = s.pgDB.
+		Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "canonical"}},
+			DoUpdates: clause.AssignmentColumns([]string{"tag_id"}),
+		}).
+		Create(&alias)
+	if tx.Error != nil {
+		s.internalServerError(c, "unable to create tag alias: %v", tx.Error)
+		return
+	}
+
+	c.JSON(http.StatusCreated, alias)
+}
+
+func (s *Server) apiV1AdminDeleteTagAlias(c *gin.Context) {
+	canonical := entities.CanonicalTag(c.Param("alias"))
+	if canonical == "" {
+		s.paramCantBeEmpty(c, "alias")
+		return
+	}
+
+	tx := s.pgDB.
+		Where("canonical = ?", canonical).
+		Delete(&pgmodel.TagAlias{})
+	if tx.Error != nil {
+		s.internalServerError(c, "unable to delete tag alias: %v", tx.Error)
+		return
+	}
+	if tx.RowsAffected == 0 {
+		s.notFound(c, "tag alias %s not found", canonical)
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// findTagParam fetches the tag identified by the "text" parameter.
+// When the tag can't be fetched, it replies to the request and returns false.
+func (s *Server) findTagParam(c *gin.Context) (pgmodel.Tag, bool) {
+	text := c.Param("text")
+	if text == "" {
+		s.badRequest(c, "text is empty", "text cannot be empty")
+		return pgmodel.Tag{}, false
+	}
+
+	tag, err := s.findTagByText(text)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			s.notFound(c, "tag %s not found", text)
+			return tag, false
+		}
+		s.internalServerError(c, "unable to get tag %s: %v", text, err)
+		return tag, false
+	}
+	return tag, true
+}
+
+func (s *Server) mergeTagsInBackground(source pgmodel.Tag, target pgmodel.Tag) {
+	s.logger.Infof("merging tag %q (%d) into %q (%d)", source.Text, source.ID, target.Text, target.ID)
+	go func() {
+		err := s.mergeTags(context.Background(), source, target)
+		if err != nil {
+			s.logger.Errorf("unable to merge tag %d into %d: %v", source.ID, target.ID, err)
+			return
+		}
+		s.logger.Infof("merged tag %d into %d", source.ID, target.ID)
+	}()
+}
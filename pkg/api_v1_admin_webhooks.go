@@ -0,0 +1,356 @@
+package server
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/denysvitali/social/backend/pkg/models/api"
+	pgmodel "github.com/denysvitali/social/backend/pkg/models/postgres"
+	v1requests "github.com/denysvitali/social/backend/pkg/requests/v1"
+	"github.com/gin-gonic/gin"
+	"github.com/oklog/ulid/v2"
+	"gorm.io/gorm"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+func (s *Server) apiV1AdminGetWebhooks(c *gin.Context) {
+	var webhooks []pgmodel.Webhook
+	tx := s.pgDB.
+		Preload("Events").
+		Order("id ASC").
+		Find(&webhooks)
+	if tx.Error != nil {
+		s.internalServerError(c, "unable to get webhooks: %v", tx.Error)
+		return
+	}
+
+	response := api.WebhooksResponse{Webhooks: []api.Webhook{}}
+	for _, w := range webhooks {
+		response.Webhooks = append(response.Webhooks, getApiWebhook(w))
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// apiV1AdminCreateWebhook registers a webhook. Its secret is only returned in the response.
+func (s *Server) apiV1AdminCreateWebhook(c *gin.Context) {
+	var req v1requests.CreateWebhook
+	err := c.ShouldBindJSON(&req)
+	if err != nil {
+		s.badRequest(c,
+			fmt.Sprintf("unable to bind JSON: %v", err),
+			"unable to parse JSON",
+		)
+		return
+	}
+	err = validateWebhookURL(req.URL)
+	if err != nil {
+		s.badRequest(c, fmt.Sprintf("invalid webhook URL: %v", err), err.Error())
+		return
+	}
+	events, err := parseWebhookEvents(req.Events)
+	if err != nil {
+		s.badRequest(c, fmt.Sprintf("invalid webhook events: %v", err), err.Error())
+		return
+	}
+
+	secret := req.Secret
+	if secret == "" {
+		b := make([]byte, 32)
+		_, err = rand.Read(b)
+		if err != nil {
+			s.internalServerError(c, "unable to generate webhook secret: %v", err)
+			return
+		}
+		secret = hex.EncodeToString(b)
+	}
+
+	webhook := pgmodel.Webhook{
+		CreatedAt:   time.Now(),
+		URL:         req.URL,
+		Description: req.Description,
+		Secret:      secret,
+		Active:      true,
+		Events:      events,
+	}
+	tx := s.pgDB.Create(&webhook)
+	if tx.Error != nil {
+		s.internalServerError(c, "unable to create webhook: %v", tx.Error)
+		return
+	}
+
+	response := getApiWebhook(webhook)
+	response.Secret = secret
+	c.JSON(http.StatusCreated, response)
+}
+
+func (s *Server) apiV1AdminUpdateWebhook(c *gin.Context) {
+	webhook, ok := s.findWebhookParam(c)
+	if !ok {
+		return
+	}
+
+	var req v1requests.UpdateWebhook
+	err := c.ShouldBindJSON(&req)
+	if err != nil {
+		s.badRequest(c,
+			fmt.Sprintf("unable to bind JSON: %v", err),
+			"unable to parse JSON",
+		)
+		return
+	}
+
+	updates := map[string]any{}
+	if req.URL != nil {
+		err = validateWebhookURL(*req.URL)
+		if err != nil {
+			s.badRequest(c, fmt.Sprintf("invalid webhook URL: %v", err), err.Error())
+			return
+		}
+		updates["url"] = *req.URL
+	}
+	if req.Description != nil {
+		updates["description"] = *req.Description
+	}
+	if req.Active != nil {
+		updates["active"] = *req.Active
+	}
+	var events []pgmodel.WebhookEvent
+	if req.Events != nil {
+		events, err = parseWebhookEvents(*req.Events)
+		if err != nil {
+			s.badRequest(c, fmt.Sprintf("invalid webhook events: %v", err), err.Error())
+			return
+		}
+	}
+
+	err = s.pgDB.Transaction(func(tx *gorm.DB) error {
+		if len(updates) > 0 {
+			err := tx.Model(&pgmodel.Webhook{}).Where("id = ?", webhook.ID).Updates(updates).Error
+			if err != nil {
+				return err
+			}
+		}
+		if req.Events == nil {
+			return nil
+		}
+		err := tx.Delete(&pgmodel.WebhookEvent{}, "webhook_id = ?", webhook.ID).Error
+		if err != nil {
+			return err
+		}
+		for i := range events {
+			events[i].WebhookID = webhook.ID
+		}
+		return tx.Create(&events).Error
+	})
+	if err != nil {
+		s.internalServerError(c, "unable to update webhook %d: %v", webhook.ID, err)
+		return
+	}
+
+	webhook, ok = s.findWebhookParam(c)
+	if !ok {
+		return
+	}
+	c.JSON(http.StatusOK, getApiWebhook(webhook))
+}
+
+func (s *Server) apiV1AdminDeleteWebhook(c *gin.Context) {
+	webhook, ok := s.findWebhookParam(c)
+	if !ok {
+		return
+	}
+
+	// Events and deliveries are removed by the cascade
+	tx := s.pgDB.Delete(&pgmodel.Webhook{}, "id = ?", webhook.ID)
+	if tx.Error != nil {
+		s.internalServerError(c, "unable to delete webhook %d: %v", webhook.ID, tx.Error)
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// apiV1AdminGetWebhookDeliveries returns the deliveries of a webhook, newest first, optionally filtered
+// by their status, e.g. "dead" for the dead-lettered ones
+func (s *Server) apiV1AdminGetWebhookDeliveries(c *gin.Context) {
+	webhook, ok := s.findWebhookParam(c)
+	if !ok {
+		return
+	}
+
+	p, err := parsePage(c)
+	if err != nil {
+		s.badRequest(c, fmt.Sprintf("invalid pagination: %v", err), err.Error())
+		return
+	}
+
+	tx := s.pgDB.Where("webhook_id = ?", webhook.ID)
+	if status := c.Query("status"); status != "" {
+		switch status {
+		case pgmodel.WebhookDeliveryPending, pgmodel.WebhookDeliverySucceeded, pgmodel.WebhookDeliveryDead:
+		default:
+			s.badRequest(c, fmt.Sprintf("invalid delivery status %q", status), "status must be one of pending, succeeded, dead")
+			return
+		}
+		tx = tx.Where("status = ?", status)
+	}
+	if p.Cursor != nil {
+		tx = tx.Where("id < ?", p.Cursor)
+	}
+
+	var deliveries []pgmodel.WebhookDelivery
+	tx = tx.
+		Order("id DESC").
+		Limit(p.Limit).
+		Find(&deliveries)
+	if tx.Error != nil {
+		s.internalServerError(c, "unable to get deliveries of webhook %d: %v", webhook.ID, tx.Error)
+		return
+	}
+
+	response := api.WebhookDeliveriesResponse{Deliveries: []api.WebhookDelivery{}}
+	for _, d := range deliveries {
+		response.Deliveries = append(response.Deliveries, getApiWebhookDelivery(d))
+	}
+	if len(deliveries) > 0 {
+		response.NextCursor = p.nextCursor(len(deliveries), deliveries[len(deliveries)-1].ID)
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// apiV1AdminRedeliverWebhook queues a new delivery of the event of a past delivery, whatever its status
+func (s *Server) apiV1AdminRedeliverWebhook(c *gin.Context) {
+	webhook, ok := s.findWebhookParam(c)
+	if !ok {
+		return
+	}
+
+	deliveryId, err := ulid.Parse(c.Param("delivery_id"))
+	if err != nil {
+		s.badRequest(c, fmt.Sprintf("invalid delivery id: %v", err), "invalid delivery id")
+		return
+	}
+	var original pgmodel.WebhookDelivery
+	tx := s.pgDB.Take(&original, "id = ? AND webhook_id = ?", deliveryId, webhook.ID)
+	if tx.Error != nil {
+		if errors.Is(tx.Error, gorm.ErrRecordNotFound) {
+			s.notFound(c, "delivery %s of webhook %d not found", deliveryId, webhook.ID)
+			return
+		}
+		s.internalServerError(c, "unable to get delivery %s: %v", deliveryId, tx.Error)
+		return
+	}
+
+	now := time.Now()
+	delivery := pgmodel.WebhookDelivery{
+		ID:            ulid.Make().Bytes(),
+		WebhookID:     webhook.ID,
+		EventID:       original.EventID,
+		Event:         original.Event,
+		Payload:       original.Payload,
+		Status:        pgmodel.WebhookDeliveryPending,
+		NextAttemptAt: now,
+		CreatedAt:     now,
+	}
+	tx = s.pgDB.Create(&delivery)
+	if tx.Error != nil {
+		s.internalServerError(c, "unable to create delivery: %v", tx.Error)
+		return
+	}
+	s.wakeWebhookWorker()
+
+	c.JSON(http.StatusAccepted, getApiWebhookDelivery(delivery))
+}
+
+// findWebhookParam fetches the webhook identified by the "id" parameter.
+// When the webhook can't be fetched, it replies to the request and returns false.
+func (s *Server) findWebhookParam(c *gin.Context) (pgmodel.Webhook, bool) {
+	var webhook pgmodel.Webhook
+
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		s.badRequest(c, fmt.Sprintf("invalid id: %v", err), "invalid id")
+		return webhook, false
+	}
+
+	tx := s.pgDB.Preload("Events").Take(&webhook, "id = ?", id)
+	if tx.Error != nil {
+		if errors.Is(tx.Error, gorm.ErrRecordNotFound) {
+			s.notFound(c, "webhook %d not found", id)
+			return webhook, false
+		}
+		s.internalServerError(c, "unable to get webhook %d: %v", id, tx.Error)
+		return webhook, false
+	}
+	return webhook, true
+}
+
+func validateWebhookURL(rawURL string) error {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Host == "" || (u.Scheme != "http" && u.Scheme != "https") {
+		return fmt.Errorf("url must be an absolute http or https URL")
+	}
+	return nil
+}
+
+func parseWebhookEvents(events []string) ([]pgmodel.WebhookEvent, error) {
+	if len(events) == 0 {
+		return nil, fmt.Errorf("events cannot be empty")
+	}
+	seen := map[string]bool{}
+	var result []pgmodel.WebhookEvent
+	for _, e := range events {
+		known := false
+		for _, v := range WebhookEvents {
+			known = known || v == e
+		}
+		if !known {
+			return nil, fmt.Errorf("unknown event %q", e)
+		}
+		if seen[e] {
+			continue
+		}
+		seen[e] = true
+		result = append(result, pgmodel.WebhookEvent{Event: e})
+	}
+	return result, nil
+}
+
+func getApiWebhook(w pgmodel.Webhook) api.Webhook {
+	events := []string{}
+	for _, e := range w.Events {
+		events = append(events, e.Event)
+	}
+	return api.Webhook{
+		ID:          w.ID,
+		URL:         w.URL,
+		Description: w.Description,
+		Events:      events,
+		Active:      w.Active,
+		CreatedAt:   w.CreatedAt,
+	}
+}
+
+func getApiWebhookDelivery(d pgmodel.WebhookDelivery) api.WebhookDelivery {
+	delivery := api.WebhookDelivery{
+		ID:             bytesToUlid(d.ID).String(),
+		EventID:        bytesToUlid(d.EventID).String(),
+		Event:          d.Event,
+		Payload:        []byte(d.Payload),
+		Status:         d.Status,
+		Attempts:       d.Attempts,
+		LastAttemptAt:  d.LastAttemptAt,
+		ResponseStatus: d.ResponseStatus,
+		Error:          d.Error,
+		CreatedAt:      d.CreatedAt,
+	}
+	if d.Status == pgmodel.WebhookDeliveryPending {
+		next := d.NextAttemptAt
+		delivery.NextAttemptAt = &next
+	}
+	return delivery
+}
@@ -0,0 +1,163 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"github.com/denysvitali/social/backend/pkg/models/api"
+	pgmodel "github.com/denysvitali/social/backend/pkg/models/postgres"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"net/http"
+	"strconv"
+)
+
+// apiV1BlockUser blocks the user :id, which also removes the follows between the viewer and them
+func (s *Server) apiV1BlockUser(c *gin.Context) {
+	viewer, target, ok := s.parseRelationTarget(c, "block")
+	if !ok {
+		return
+	}
+
+	ctx := c.Request.Context()
+	_, err := s.addRelation(ctx, RelationBlocks, userVertex(viewer), userVertex(target))
+	if err != nil {
+		s.internalServerError(c, "unable to block user: %v", err)
+		return
+	}
+
+	for _, pair := range [][2]uint64{{viewer, target}, {target, viewer}} {
+		follower, followed := pair[0], pair[1]
+		removed, err := s.removeRelation(ctx, RelationFollows, userVertex(follower), userVertex(followed))
+		if err != nil {
+			s.internalServerError(c, "unable to remove follow of user %d by %d: %v", followed, follower, err)
+			return
+		}
+		if !removed {
+			continue
+		}
+		err = s.updateFollowCounts(follower, followed, -1)
+		if err != nil {
+			s.internalServerError(c, "unable to update follow counts: %v", err)
+			return
+		}
+		s.unnotify(followed, pgmodel.NotificationFollow, follower, nil)
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+func (s *Server) apiV1UnblockUser(c *gin.Context) {
+	viewer, target, ok := s.parseRelationTarget(c, "unblock")
+	if !ok {
+		return
+	}
+
+	_, err := s.removeRelation(c.Request.Context(), RelationBlocks, userVertex(viewer), userVertex(target))
+	if err != nil {
+		s.internalServerError(c, "unable to unblock user: %v", err)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+func (s *Server) apiV1MuteUser(c *gin.Context) {
+	viewer, target, ok := s.parseRelationTarget(c, "mute")
+	if !ok {
+		return
+	}
+
+	_, err := s.addRelation(c.Request.Context(), RelationMutes, userVertex(viewer), userVertex(target))
+	if err != nil {
+		s.internalServerError(c, "unable to mute user: %v", err)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+func (s *Server) apiV1UnmuteUser(c *gin.Context) {
+	viewer, target, ok := s.parseRelationTarget(c, "unmute")
+	if !ok {
+		return
+	}
+
+	_, err := s.removeRelation(c.Request.Context(), RelationMutes, userVertex(viewer), userVertex(target))
+	if err != nil {
+		s.internalServerError(c, "unable to unmute user: %v", err)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// apiV1GetBlocks lists the users blocked by the viewer
+func (s *Server) apiV1GetBlocks(c *gin.Context) {
+	s.relatedUsers(c, RelationBlocks)
+}
+
+// apiV1GetMutes lists the users muted by the viewer
+func (s *Server) apiV1GetMutes(c *gin.Context) {
+	s.relatedUsers(c, RelationMutes)
+}
+
+func (s *Server) relatedUsers(c *gin.Context, relType string) {
+	viewer, ok := s.requireViewer(c)
+	if !ok {
+		return
+	}
+
+	response := api.UsersResponse{Users: []api.User{}}
+	ids, err := s.outboundIds(c.Request.Context(), relType, userVertex(viewer), UsersCollection)
+	if errors.Is(err, errGraphUnavailable) {
+		c.JSON(http.StatusOK, response)
+		return
+	}
+	if err != nil {
+		s.internalServerError(c, "unable to get %s relations of user %d: %v", relType, viewer, err)
+		return
+	}
+	if len(ids) == 0 {
+		c.JSON(http.StatusOK, response)
+		return
+	}
+
+	var users []pgmodel.User
+	tx := s.pgDB.Where("id IN ? AND deleted = false AND suspended_at IS NULL", ids).Order("username ASC").Find(&users)
+	if tx.Error != nil {
+		s.internalServerError(c, "unable to get users: %v", tx.Error)
+		return
+	}
+	for _, u := range users {
+		response.Users = append(response.Users, getApiUser(u))
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// parseRelationTarget returns the viewer and the user :id they want to action.
+// When the target is invalid, it replies to the request and returns false.
+func (s *Server) parseRelationTarget(c *gin.Context, action string) (uint64, uint64, bool) {
+	viewer, ok := s.requireViewer(c)
+	if !ok {
+		return 0, 0, false
+	}
+
+	target, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		s.badRequest(c, fmt.Sprintf("invalid id: %v", err), "invalid id")
+		return 0, 0, false
+	}
+	if target == viewer {
+		s.badRequest(c, fmt.Sprintf("user %d tried to %s themselves", viewer, action), "you cannot "+action+" yourself")
+		return 0, 0, false
+	}
+
+	var user pgmodel.User
+	tx := s.pgDB.Select("id").Take(&user, "id = ?", target)
+	if tx.Error != nil {
+		if errors.Is(tx.Error, gorm.ErrRecordNotFound) {
+			s.notFound(c, "user %d not found", target)
+			return 0, 0, false
+		}
+		s.internalServerError(c, "unable to get user %d: %v", target, tx.Error)
+		return 0, 0, false
+	}
+	return viewer, target, true
+}
@@ -0,0 +1,357 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"github.com/denysvitali/social/backend/pkg/models/api"
+	pgmodel "github.com/denysvitali/social/backend/pkg/models/postgres"
+	v1requests "github.com/denysvitali/social/backend/pkg/requests/v1"
+	"github.com/gin-gonic/gin"
+	"github.com/oklog/ulid/v2"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+const maxCollectionNameLength = 64
+
+// Bookmarks are private: every handler in this file only ever reads or writes rows owned by the viewer,
+// and collections owned by someone else are reported as not found.
+
+func (s *Server) apiV1BookmarkPost(c *gin.Context) {
+	viewer, ok := s.requireViewer(c)
+	if !ok {
+		return
+	}
+
+	postId, err := parsePostId(c)
+	if err != nil {
+		s.badRequest(c, fmt.Sprintf("unable to parse post id: %v", err), "invalid post id")
+		return
+	}
+
+	var req v1requests.CreateBookmark
+	err = c.ShouldBindJSON(&req)
+	if err != nil && !errors.Is(err, io.EOF) {
+		s.badRequest(c,
+			fmt.Sprintf("unable to bind JSON: %v", err),
+			"unable to parse JSON",
+		)
+		return
+	}
+
+	var post pgmodel.Post
+	tx := excludeSuspendedAuthors(s.pgDB.Select("id", "author_id", "visibility")).
+		Where("id = ? AND deleted = false", postId).
+		Take(&post)
+	if tx.Error != nil {
+		if errors.Is(tx.Error, gorm.ErrRecordNotFound) {
+			s.notFound(c, "post not found")
+			return
+		}
+		s.internalServerError(c, "unable to fetch post: %v", tx.Error)
+		return
+	}
+	visible, err := s.postVisibleTo(c.Request.Context(), viewer, post)
+	if err != nil {
+		s.internalServerError(c, "unable to check visibility of post %s: %v", postId, err)
+		return
+	}
+	if !visible {
+		s.notFound(c, "post %s not visible to user %d", postId, viewer)
+		return
+	}
+
+	if req.CollectionID != nil {
+		_, err = s.findBookmarkCollection(viewer, *req.CollectionID)
+		if err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				s.notFound(c, "bookmark collection not found")
+				return
+			}
+			s.internalServerError(c, "unable to fetch bookmark collection: %v", err)
+			return
+		}
+	}
+
+	bookmark := pgmodel.Bookmark{
+		ID:           ulid.Make().Bytes(),
+		UserID:       viewer,
+		PostID:       post.ID,
+		CollectionID: req.CollectionID,
+	}
+	tx = s.pgDB.
+		Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "user_id"}, {Name: "post_id"}},
+			DoUpdates: clause.AssignmentColumns([]string{"collection_id"}),
+		}).
+		Create(&bookmark)
+	if tx.Error != nil {
+		s.internalServerError(c, "unable to create bookmark: %v", tx.Error)
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+func (s *Server) apiV1UnbookmarkPost(c *gin.Context) {
+	viewer, ok := s.requireViewer(c)
+	if !ok {
+		return
+	}
+
+	postId, err := parsePostId(c)
+	if err != nil {
+		s.badRequest(c, fmt.Sprintf("unable to parse post id: %v", err), "invalid post id")
+		return
+	}
+
+	tx := s.pgDB.
+		Where("user_id = ? AND post_id = ?", viewer, postId).
+		Delete(&pgmodel.Bookmark{})
+	if tx.Error != nil {
+		s.internalServerError(c, "unable to delete bookmark: %v", tx.Error)
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+func (s *Server) apiV1GetBookmarks(c *gin.Context) {
+	viewer, ok := s.requireViewer(c)
+	if !ok {
+		return
+	}
+
+	p, err := parsePage(c)
+	if err != nil {
+		s.badRequest(c, fmt.Sprintf("invalid pagination: %v", err), err.Error())
+		return
+	}
+
+	tx := s.pgDB.
+		Preload("Post", notSuspendedAuthor).
+		Where("user_id = ?", viewer)
+
+	if v := c.Query("collectionId"); v != "" {
+		collectionId, err := strconv.ParseUint(v, 10, 64)
+		if err != nil {
+			s.badRequest(c, fmt.Sprintf("invalid collection id: %v", err), "invalid collectionId")
+			return
+		}
+		_, err = s.findBookmarkCollection(viewer, collectionId)
+		if err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				s.notFound(c, "bookmark collection not found")
+				return
+			}
+			s.internalServerError(c, "unable to fetch bookmark collection: %v", err)
+			return
+		}
+		tx = tx.Where("collection_id = ?", collectionId)
+	}
+
+	if p.Cursor != nil {
+		tx = tx.Where("id < ?", p.Cursor)
+	}
+
+	var bookmarks []pgmodel.Bookmark
+	tx = tx.
+		Order("id DESC").
+		Limit(p.Limit).
+		Find(&bookmarks)
+	if tx.Error != nil {
+		s.internalServerError(c, "unable to fetch bookmarks: %v", tx.Error)
+		return
+	}
+
+	// The viewer and the author may have blocked each other since the post was bookmarked
+	hidden, err := s.hiddenUserIds(c.Request.Context(), viewer, false)
+	if err != nil {
+		s.internalServerError(c, "unable to get users hidden from user %d: %v", viewer, err)
+		return
+	}
+	isHidden := map[uint64]bool{}
+	for _, id := range hidden {
+		isHidden[id] = true
+	}
+
+	var posts []pgmodel.Post
+	for _, b := range bookmarks {
+		if b.Post == nil || b.Post.Deleted || isHidden[b.Post.AuthorID] {
+			continue
+		}
+		posts = append(posts, *b.Post)
+	}
+	// The viewer may have stopped following the author of a followers-only post since they bookmarked it
+	posts, err = s.visiblePosts(c.Request.Context(), viewer, posts)
+	if err != nil {
+		s.internalServerError(c, "unable to check visibility of bookmarked posts: %v", err)
+		return
+	}
+
+	postsResponse, err := s.postsResponse(viewer, posts)
+	if err != nil {
+		s.internalServerError(c, "unable to build posts response: %v", err)
+		return
+	}
+	filter, err := s.loadPostFilter(viewer, pgmodel.FilterContextEverywhere)
+	if err != nil {
+		s.internalServerError(c, "unable to get muted words of user %d: %v", viewer, err)
+		return
+	}
+	postsResponse.Posts = filter.apply(postsResponse.Posts)
+	if len(bookmarks) > 0 {
+		postsResponse.NextCursor = p.nextCursor(len(bookmarks), bookmarks[len(bookmarks)-1].ID)
+	}
+
+	c.JSON(http.StatusOK, postsResponse)
+}
+
+func (s *Server) apiV1GetBookmarkCollections(c *gin.Context) {
+	viewer, ok := s.requireViewer(c)
+	if !ok {
+		return
+	}
+
+	var collections []pgmodel.BookmarkCollection
+	tx := s.pgDB.
+		Where("user_id = ?", viewer).
+		Order("name ASC").
+		Find(&collections)
+	if tx.Error != nil {
+		s.internalServerError(c, "unable to fetch bookmark collections: %v", tx.Error)
+		return
+	}
+
+	apiCollections := []api.BookmarkCollection{}
+	for _, v := range collections {
+		apiCollections = append(apiCollections, getApiBookmarkCollection(v))
+	}
+
+	c.JSON(http.StatusOK, apiCollections)
+}
+
+func (s *Server) apiV1CreateBookmarkCollection(c *gin.Context) {
+	viewer, ok := s.requireViewer(c)
+	if !ok {
+		return
+	}
+
+	var req v1requests.CreateBookmarkCollection
+	err := c.ShouldBindJSON(&req)
+	if err != nil {
+		s.badRequest(c,
+			fmt.Sprintf("unable to bind JSON: %v", err),
+			"unable to parse JSON",
+		)
+		return
+	}
+
+	name := strings.TrimSpace(req.Name)
+	if name == "" {
+		s.paramCantBeEmpty(c, "name")
+		return
+	}
+	if len([]rune(name)) > maxCollectionNameLength {
+		s.badRequest(c,
+			"bookmark collection name is too long",
+			fmt.Sprintf("name cannot be longer than %d characters", maxCollectionNameLength),
+		)
+		return
+	}
+
+	collection := pgmodel.BookmarkCollection{
+		UserID: viewer,
+		Name:   name,
+	}
+	tx := s.pgDB.Create(&collection)
+	if tx.Error != nil {
+		if isUniqueViolation(tx.Error) {
+			s.badRequest(c, "bookmark collection already exists", "a collection with this name already exists")
+			return
+		}
+		s.internalServerError(c, "unable to create bookmark collection: %v", tx.Error)
+		return
+	}
+
+	c.JSON(http.StatusCreated, getApiBookmarkCollection(collection))
+}
+
+func (s *Server) apiV1DeleteBookmarkCollection(c *gin.Context) {
+	viewer, ok := s.requireViewer(c)
+	if !ok {
+		return
+	}
+
+	collectionId, err := strconv.ParseUint(c.Param("collection_id"), 10, 64)
+	if err != nil {
+		s.badRequest(c, fmt.Sprintf("invalid collection id: %v", err), "invalid collection id")
+		return
+	}
+
+	collection, err := s.findBookmarkCollection(viewer, collectionId)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			s.notFound(c, "bookmark collection not found")
+			return
+		}
+		s.internalServerError(c, "unable to fetch bookmark collection: %v", err)
+		return
+	}
+
+	// Bookmarks survive the deletion of their collection
+	err = s.pgDB.Transaction(func(tx *gorm.DB) error {
+		err := tx.Model(&pgmodel.Bookmark{}).
+			Where("user_id = ? AND collection_id = ?", viewer, collection.ID).
+			Update("collection_id", nil).Error
+		if err != nil {
+			return err
+		}
+		return tx.Delete(&collection).Error
+	})
+	if err != nil {
+		s.internalServerError(c, "unable to delete bookmark collection: %v", err)
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// findBookmarkCollection returns the collection with the given id, as long as it belongs to userId
+func (s *Server) findBookmarkCollection(userId uint64, collectionId uint64) (pgmodel.BookmarkCollection, error) {
+	var collection pgmodel.BookmarkCollection
+	tx := s.pgDB.
+		Where("id = ? AND user_id = ?", collectionId, userId).
+		Take(&collection)
+	return collection, tx.Error
+}
+
+// bookmarkedPostIds returns the set of post ULIDs, among postIds, that userId has bookmarked
+func (s *Server) bookmarkedPostIds(userId uint64, postIds [][]byte) (map[string]bool, error) {
+	var bookmarked [][]byte
+	tx := s.pgDB.
+		Model(&pgmodel.Bookmark{}).
+		Where("user_id = ? AND post_id IN ?", userId, postIds).
+		Pluck("post_id", &bookmarked)
+	if tx.Error != nil {
+		return nil, tx.Error
+	}
+
+	result := map[string]bool{}
+	for _, id := range bookmarked {
+		result[bytesToUlid(id).String()] = true
+	}
+	return result, nil
+}
+
+func getApiBookmarkCollection(c pgmodel.BookmarkCollection) api.BookmarkCollection {
+	return api.BookmarkCollection{
+		ID:        c.ID,
+		Name:      c.Name,
+		CreatedAt: c.CreatedAt,
+	}
+}
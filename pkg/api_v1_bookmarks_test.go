@@ -0,0 +1,54 @@
+package server
+
+import (
+	"database/sql/driver"
+	"errors"
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgconn"
+	"github.com/sirupsen/logrus"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestCreateBookmarkCollection(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	for _, tc := range []struct {
+		name   string
+		err    error
+		want   int
+		output string
+	}{
+		{"created", nil, http.StatusCreated, `"name":"Recipes"`},
+		{"duplicate", &pgconn.PgError{Code: pgUniqueViolation}, http.StatusBadRequest, "a collection with this name already exists"},
+		{"failure", errors.New("connection reset"), http.StatusInternalServerError, "internal server error"},
+	} {
+		db, _ := newFakeDB(t, func(query string, args []driver.Value) (fakeResult, error) {
+			if strings.Contains(query, `INSERT INTO "bookmark_collections"`) {
+				if tc.err != nil {
+					return fakeResult{}, tc.err
+				}
+				return fakeResult{columns: []string{"id"}, rows: [][]driver.Value{{int64(1)}}}, nil
+			}
+			return fakeResult{}, nil
+		})
+		log := logrus.New()
+		log.SetOutput(io.Discard)
+		s := &Server{logger: log, pgDB: db}
+		e := gin.New()
+		e.POST("/bookmarks/collections", s.apiV1CreateBookmarkCollection)
+
+		req := httptest.NewRequest(http.MethodPost, "/bookmarks/collections", strings.NewReader(`{"name":" Recipes "}`))
+		req.Header.Set(ViewerHeader, "42")
+		w := httptest.NewRecorder()
+		e.ServeHTTP(w, req)
+		if w.Code != tc.want {
+			t.Errorf("%s: got status %d, want %d", tc.name, w.Code, tc.want)
+		}
+		if !strings.Contains(w.Body.String(), tc.output) {
+			t.Errorf("%s: got %s, want %s", tc.name, w.Body.String(), tc.output)
+		}
+	}
+}
@@ -0,0 +1,593 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"github.com/denysvitali/social/backend/pkg/models/api"
+	pgmodel "github.com/denysvitali/social/backend/pkg/models/postgres"
+	v1requests "github.com/denysvitali/social/backend/pkg/requests/v1"
+	"github.com/gin-gonic/gin"
+	"github.com/oklog/ulid/v2"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"net/http"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// apiV1GetConversations lists the conversations of an inbox of the viewer, most recently active first:
+// "primary" (the default) or "requests", for the conversations started by accounts the viewer doesn't
+// follow
+func (s *Server) apiV1GetConversations(c *gin.Context) {
+	viewer, ok := s.requireViewer(c)
+	if !ok {
+		return
+	}
+	status, ok := s.inboxParam(c)
+	if !ok {
+		return
+	}
+
+	p, err := parsePage(c)
+	if err != nil {
+		s.badRequest(c, fmt.Sprintf("invalid pagination: %v", err), err.Error())
+		return
+	}
+
+	// Conversations without messages are only listed for their creator
+	tx := s.pgDB.
+		Joins("INNER JOIN conversation_members ON conversation_members.conversation_id = conversations.id").
+		Where("conversation_members.user_id = ? AND conversation_members.status = ?", viewer, status).
+		Where("conversations.last_message_id IS NOT NULL OR conversations.creator_id = ?", viewer)
+	if p.Cursor != nil {
+		tx = tx.Where("COALESCE(conversations.last_message_id, conversations.id) < ?", p.Cursor)
+	}
+
+	var convs []pgmodel.Conversation
+	tx = tx.
+		Order("COALESCE(conversations.last_message_id, conversations.id) DESC").
+		Limit(p.Limit).
+		Find(&convs)
+	if tx.Error != nil {
+		s.internalServerError(c, "unable to get conversations of user %d: %v", viewer, tx.Error)
+		return
+	}
+
+	response, err := s.conversationsResponse(viewer, convs)
+	if err != nil {
+		s.internalServerError(c, "unable to render conversations: %v", err)
+		return
+	}
+	if len(convs) > 0 {
+		last := convs[len(convs)-1]
+		lastId := last.ID
+		if last.LastMessageID != nil {
+			lastId = *last.LastMessageID
+		}
+		response.NextCursor = p.nextCursor(len(convs), lastId)
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// apiV1GetUnreadConversationsCount returns the number of conversations of an inbox with unread messages
+func (s *Server) apiV1GetUnreadConversationsCount(c *gin.Context) {
+	viewer, ok := s.requireViewer(c)
+	if !ok {
+		return
+	}
+	status, ok := s.inboxParam(c)
+	if !ok {
+		return
+	}
+
+	var count int64
+	err := s.pgDB.Raw(`
+		SELECT COUNT(*) FROM conversation_members m
+		WHERE m.user_id = ? AND m.status = ? AND m.muted = false AND EXISTS (
+			SELECT 1 FROM messages
+			WHERE messages.conversation_id = m.conversation_id
+				AND messages.sender_id <> m.user_id
+				AND (m.last_read_message_id IS NULL OR messages.id > m.last_read_message_id)
+		)`,
+		viewer, status,
+	).Scan(&count).Error
+	if err != nil {
+		s.internalServerError(c, "unable to count unread conversations of user %d: %v", viewer, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, api.UnreadConversationsCount{Count: count})
+}
+
+// apiV1CreateConversation starts a conversation with one or more users. With a single user, the existing
+// 1:1 conversation is returned instead, if any, with the viewer back in it if they left. The conversation
+// lands in the requests inbox of the members who don't follow the viewer.
+func (s *Server) apiV1CreateConversation(c *gin.Context) {
+	viewer, ok := s.requireViewer(c)
+	if !ok {
+		return
+	}
+
+	var req v1requests.CreateConversation
+	err := c.BindJSON(&req)
+	if err != nil {
+		s.badRequest(c,
+			fmt.Sprintf("unable to bind JSON: %v", err),
+			"unable to parse JSON",
+		)
+		return
+	}
+
+	var others []uint64
+	seen := map[uint64]bool{viewer: true}
+	for _, id := range req.Members {
+		if seen[id] {
+			continue
+		}
+		seen[id] = true
+		others = append(others, id)
+	}
+	if len(others) == 0 {
+		s.badRequest(c, "no members", "members must contain at least another user")
+		return
+	}
+	if len(others) >= MaxConversationMembers {
+		s.badRequest(c,
+			fmt.Sprintf("too many members: %d", len(others)),
+			fmt.Sprintf("a conversation can have at most %d members", MaxConversationMembers),
+		)
+		return
+	}
+	name := strings.TrimSpace(req.Name)
+	if utf8.RuneCountInString(name) > MaxConversationNameLength {
+		s.badRequest(c, "name too long",
+			fmt.Sprintf("name cannot be longer than %d characters", MaxConversationNameLength),
+		)
+		return
+	}
+	content := strings.TrimSpace(req.Content)
+	if utf8.RuneCountInString(content) > MaxMessageLength {
+		s.badRequest(c, "content too long",
+			fmt.Sprintf("content cannot be longer than %d characters", MaxMessageLength),
+		)
+		return
+	}
+
+	var existing int64
+	err = s.pgDB.Model(&pgmodel.User{}).Where("id IN ? AND deleted = false AND suspended_at IS NULL", others).Count(&existing).Error
+	if err != nil {
+		s.internalServerError(c, "unable to get members: %v", err)
+		return
+	}
+	if int(existing) != len(others) {
+		s.notFound(c, "members %v not found", others)
+		return
+	}
+	for _, id := range others {
+		blocked, err := s.isBlocked(c.Request.Context(), viewer, id)
+		if err != nil {
+			s.internalServerError(c, "unable to check blocks between users %d and %d: %v", viewer, id, err)
+			return
+		}
+		if blocked {
+			s.forbidden(c, "user %d tried to start a conversation with user %d, but one blocks the other", viewer, id)
+			return
+		}
+	}
+
+	now := time.Now()
+	conv := pgmodel.Conversation{
+		ID:        ulid.Make().Bytes(),
+		CreatedAt: now,
+		CreatorID: viewer,
+		IsGroup:   len(others) > 1,
+		Members: []pgmodel.ConversationMember{
+			{UserID: viewer, Status: pgmodel.MembershipAccepted, JoinedAt: now},
+		},
+	}
+	if conv.IsGroup {
+		conv.Name = name
+	} else {
+		key := directKey(viewer, others[0])
+		conv.DirectKey = &key
+	}
+	for _, id := range others {
+		status, err := s.membershipStatus(c.Request.Context(), id, viewer)
+		if err != nil {
+			s.internalServerError(c, "unable to get membership status of user %d: %v", id, err)
+			return
+		}
+		conv.Members = append(conv.Members, pgmodel.ConversationMember{
+			UserID:   id,
+			Status:   status,
+			JoinedAt: now,
+		})
+	}
+
+	created := true
+	if conv.DirectKey != nil {
+		found, err := s.findDirectConversation(*conv.DirectKey)
+		if err != nil {
+			s.internalServerError(c, "unable to get conversation %s: %v", *conv.DirectKey, err)
+			return
+		}
+		if found != nil {
+			conv, created = *found, false
+		}
+	}
+	if created {
+		err = s.pgDB.Create(&conv).Error
+		if err != nil && conv.DirectKey != nil {
+			// The other user may have started the conversation concurrently
+			found, findErr := s.findDirectConversation(*conv.DirectKey)
+			if findErr == nil && found != nil {
+				conv, created, err = *found, false, nil
+			}
+		}
+		if err != nil {
+			s.internalServerError(c, "unable to create conversation: %v", err)
+			return
+		}
+	}
+	if !created {
+		// The viewer may have left the conversation, the other member is only brought back by a message
+		err = s.pgDB.Clauses(clause.OnConflict{DoNothing: true}).Create(&pgmodel.ConversationMember{
+			ConversationID: conv.ID,
+			UserID:         viewer,
+			Status:         pgmodel.MembershipAccepted,
+			JoinedAt:       now,
+		}).Error
+		if err != nil {
+			s.internalServerError(c, "unable to add user %d back to conversation %s: %v", viewer, bytesToUlid(conv.ID), err)
+			return
+		}
+	}
+
+	if content != "" {
+		_, err = s.sendMessage(c.Request.Context(), conv, viewer, content)
+		if err != nil {
+			s.internalServerError(c, "unable to send message: %v", err)
+			return
+		}
+		err = s.pgDB.Take(&conv, "id = ?", conv.ID).Error
+		if err != nil {
+			s.internalServerError(c, "unable to get conversation: %v", err)
+			return
+		}
+	}
+
+	response, err := s.conversationsResponse(viewer, []pgmodel.Conversation{conv})
+	if err != nil {
+		s.internalServerError(c, "unable to render conversation: %v", err)
+		return
+	}
+	status := http.StatusOK
+	if created {
+		status = http.StatusCreated
+	}
+	c.JSON(status, response)
+}
+
+func (s *Server) apiV1GetConversation(c *gin.Context) {
+	viewer, ok := s.requireViewer(c)
+	if !ok {
+		return
+	}
+	conv, _, ok := s.findConversationParam(c, viewer)
+	if !ok {
+		return
+	}
+
+	response, err := s.conversationsResponse(viewer, []pgmodel.Conversation{conv})
+	if err != nil {
+		s.internalServerError(c, "unable to render conversation: %v", err)
+		return
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// apiV1GetMessages returns the messages of a conversation, newest first
+func (s *Server) apiV1GetMessages(c *gin.Context) {
+	viewer, ok := s.requireViewer(c)
+	if !ok {
+		return
+	}
+	conv, _, ok := s.findConversationParam(c, viewer)
+	if !ok {
+		return
+	}
+
+	p, err := parsePage(c)
+	if err != nil {
+		s.badRequest(c, fmt.Sprintf("invalid pagination: %v", err), err.Error())
+		return
+	}
+
+	tx := s.pgDB.Where("conversation_id = ?", conv.ID)
+	if p.Cursor != nil {
+		tx = tx.Where("id < ?", p.Cursor)
+	}
+	var messages []pgmodel.Message
+	tx = tx.
+		Order("id DESC").
+		Limit(p.Limit).
+		Find(&messages)
+	if tx.Error != nil {
+		s.internalServerError(c, "unable to get messages of conversation %s: %v", bytesToUlid(conv.ID), tx.Error)
+		return
+	}
+
+	response := api.MessagesResponse{Messages: []api.Message{}}
+	for _, m := range messages {
+		response.Messages = append(response.Messages, getApiMessage(m))
+	}
+	if len(messages) > 0 {
+		response.NextCursor = p.nextCursor(len(messages), messages[len(messages)-1].ID)
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// apiV1SendMessage sends a message to a conversation. Replying to a request accepts it.
+func (s *Server) apiV1SendMessage(c *gin.Context) {
+	viewer, ok := s.requireViewer(c)
+	if !ok {
+		return
+	}
+	conv, _, ok := s.findConversationParam(c, viewer)
+	if !ok {
+		return
+	}
+
+	var req v1requests.SendMessage
+	err := c.BindJSON(&req)
+	if err != nil {
+		s.badRequest(c,
+			fmt.Sprintf("unable to bind JSON: %v", err),
+			"unable to parse JSON",
+		)
+		return
+	}
+	content := strings.TrimSpace(req.Content)
+	if content == "" {
+		s.paramCantBeEmpty(c, "content")
+		return
+	}
+	if utf8.RuneCountInString(content) > MaxMessageLength {
+		s.badRequest(c, "content too long",
+			fmt.Sprintf("content cannot be longer than %d characters", MaxMessageLength),
+		)
+		return
+	}
+
+	if !conv.IsGroup {
+		a, b, err := parseDirectKey(*conv.DirectKey)
+		if err != nil {
+			s.internalServerError(c, "unable to get members of conversation %s: %v", bytesToUlid(conv.ID), err)
+			return
+		}
+		blocked, err := s.isBlocked(c.Request.Context(), a, b)
+		if err != nil {
+			s.internalServerError(c, "unable to check blocks between users %d and %d: %v", a, b, err)
+			return
+		}
+		if blocked {
+			s.forbidden(c, "user %d tried to write to conversation %s, but one member blocks the other", viewer, bytesToUlid(conv.ID))
+			return
+		}
+	}
+
+	msg, err := s.sendMessage(c.Request.Context(), conv, viewer, content)
+	if err != nil {
+		s.internalServerError(c, "unable to send message: %v", err)
+		return
+	}
+	c.JSON(http.StatusCreated, getApiMessage(msg))
+}
+
+// apiV1MarkConversationRead moves the read marker of the viewer, for read receipts, to the given message,
+// or to the last one. The marker never moves backwards.
+func (s *Server) apiV1MarkConversationRead(c *gin.Context) {
+	viewer, ok := s.requireViewer(c)
+	if !ok {
+		return
+	}
+	conv, member, ok := s.findConversationParam(c, viewer)
+	if !ok {
+		return
+	}
+
+	var req v1requests.MarkConversationRead
+	if c.Request.ContentLength != 0 {
+		err := c.BindJSON(&req)
+		if err != nil {
+			s.badRequest(c,
+				fmt.Sprintf("unable to bind JSON: %v", err),
+				"unable to parse JSON",
+			)
+			return
+		}
+	}
+
+	var messageId []byte
+	if req.Message == "" {
+		if conv.LastMessageID == nil {
+			c.Status(http.StatusNoContent)
+			return
+		}
+		messageId = *conv.LastMessageID
+	} else {
+		id, err := ulid.Parse(req.Message)
+		if err != nil {
+			s.badRequest(c, fmt.Sprintf("invalid message id: %v", err), "invalid message id")
+			return
+		}
+		var count int64
+		err = s.pgDB.Model(&pgmodel.Message{}).
+			Where("id = ? AND conversation_id = ?", id, conv.ID).
+			Count(&count).Error
+		if err != nil {
+			s.internalServerError(c, "unable to get message %s: %v", id, err)
+			return
+		}
+		if count == 0 {
+			s.notFound(c, "message %s of conversation %s not found", id, bytesToUlid(conv.ID))
+			return
+		}
+		messageId = id.Bytes()
+	}
+
+	tx := s.pgDB.Model(&pgmodel.ConversationMember{}).
+		Where("conversation_id = ? AND user_id = ?", conv.ID, member.UserID).
+		Where("last_read_message_id IS NULL OR last_read_message_id < ?", messageId).
+		UpdateColumn("last_read_message_id", messageId)
+	if tx.Error != nil {
+		s.internalServerError(c, "unable to mark conversation %s read: %v", bytesToUlid(conv.ID), tx.Error)
+		return
+	}
+	if tx.RowsAffected > 0 {
+		event := api.ConversationRead{
+			Conversation:    bytesToUlid(conv.ID).String(),
+			User:            viewer,
+			LastReadMessage: bytesToUlid(messageId).String(),
+		}
+		s.publishToMembers(conv.ID, streamEventConversationRead, func(pgmodel.ConversationMember) any {
+			return event
+		})
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+func (s *Server) apiV1MuteConversation(c *gin.Context) {
+	s.setConversationMuted(c, true)
+}
+
+func (s *Server) apiV1UnmuteConversation(c *gin.Context) {
+	s.setConversationMuted(c, false)
+}
+
+func (s *Server) setConversationMuted(c *gin.Context, muted bool) {
+	viewer, ok := s.requireViewer(c)
+	if !ok {
+		return
+	}
+	conv, _, ok := s.findConversationParam(c, viewer)
+	if !ok {
+		return
+	}
+
+	err := s.pgDB.Model(&pgmodel.ConversationMember{}).
+		Where("conversation_id = ? AND user_id = ?", conv.ID, viewer).
+		UpdateColumn("muted", muted).Error
+	if err != nil {
+		s.internalServerError(c, "unable to update conversation %s: %v", bytesToUlid(conv.ID), err)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// apiV1AcceptConversation moves a conversation from the requests inbox of the viewer to the primary one
+func (s *Server) apiV1AcceptConversation(c *gin.Context) {
+	viewer, ok := s.requireViewer(c)
+	if !ok {
+		return
+	}
+	conv, _, ok := s.findConversationParam(c, viewer)
+	if !ok {
+		return
+	}
+
+	err := s.pgDB.Model(&pgmodel.ConversationMember{}).
+		Where("conversation_id = ? AND user_id = ?", conv.ID, viewer).
+		UpdateColumn("status", pgmodel.MembershipAccepted).Error
+	if err != nil {
+		s.internalServerError(c, "unable to accept conversation %s: %v", bytesToUlid(conv.ID), err)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// apiV1LeaveConversation removes the viewer from a conversation, which also declines a request. The
+// conversation is deleted with its last member. The other member of a 1:1 conversation can write again,
+// which brings the viewer back.
+func (s *Server) apiV1LeaveConversation(c *gin.Context) {
+	viewer, ok := s.requireViewer(c)
+	if !ok {
+		return
+	}
+	conv, _, ok := s.findConversationParam(c, viewer)
+	if !ok {
+		return
+	}
+
+	err := s.pgDB.Transaction(func(tx *gorm.DB) error {
+		err := tx.Delete(&pgmodel.ConversationMember{}, "conversation_id = ? AND user_id = ?", conv.ID, viewer).Error
+		if err != nil {
+			return err
+		}
+		// Messages are removed by the cascade
+		return tx.
+			Where("id = ? AND NOT EXISTS (SELECT 1 FROM conversation_members WHERE conversation_id = ?)", conv.ID, conv.ID).
+			Delete(&pgmodel.Conversation{}).Error
+	})
+	if err != nil {
+		s.internalServerError(c, "unable to leave conversation %s: %v", bytesToUlid(conv.ID), err)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// inboxParam returns the membership status of the conversations of the "inbox" query parameter.
+// When it is invalid, it replies to the request and returns false.
+func (s *Server) inboxParam(c *gin.Context) (string, bool) {
+	switch c.DefaultQuery("inbox", "primary") {
+	case "primary":
+		return pgmodel.MembershipAccepted, true
+	case "requests":
+		return pgmodel.MembershipRequest, true
+	default:
+		s.badRequest(c, fmt.Sprintf("invalid inbox %q", c.Query("inbox")), "inbox must be one of primary, requests")
+		return "", false
+	}
+}
+
+func (s *Server) findDirectConversation(key string) (*pgmodel.Conversation, error) {
+	var conv pgmodel.Conversation
+	err := s.pgDB.Take(&conv, "direct_key = ?", key).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &conv, nil
+}
+
+// findConversationParam fetches the conversation identified by the "id" parameter, and the membership of
+// viewer. Conversations the viewer isn't a member of aren't found.
+// When the conversation can't be fetched, it replies to the request and returns false.
+func (s *Server) findConversationParam(c *gin.Context, viewer uint64) (pgmodel.Conversation, pgmodel.ConversationMember, bool) {
+	var conv pgmodel.Conversation
+	var member pgmodel.ConversationMember
+
+	id, err := ulid.Parse(c.Param("id"))
+	if err != nil {
+		s.badRequest(c, fmt.Sprintf("invalid id: %v", err), "invalid id")
+		return conv, member, false
+	}
+
+	err = s.pgDB.Take(&member, "conversation_id = ? AND user_id = ?", id, viewer).Error
+	if err == nil {
+		err = s.pgDB.Take(&conv, "id = ?", id).Error
+	}
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			s.notFound(c, "conversation %s of user %d not found", id, viewer)
+			return conv, member, false
+		}
+		s.internalServerError(c, "unable to get conversation %s: %v", id, err)
+		return conv, member, false
+	}
+	return conv, member, true
+}
@@ -0,0 +1,231 @@
+package server
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/denysvitali/social/backend/pkg/models/api"
+	pgmodel "github.com/denysvitali/social/backend/pkg/models/postgres"
+	v1requests "github.com/denysvitali/social/backend/pkg/requests/v1"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"net/mail"
+	"time"
+)
+
+// maxEmailLength is the maximum length of an email address (RFC 5321)
+const maxEmailLength = 254
+
+func (s *Server) apiV1GetEmailSettings(c *gin.Context) {
+	viewer, ok := s.requireViewer(c)
+	if !ok {
+		return
+	}
+
+	var user pgmodel.User
+	err := s.pgDB.Take(&user, "id = ?", viewer).Error
+	if err != nil {
+		s.internalServerError(c, "unable to get user %d: %v", viewer, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, getApiEmailSettings(user))
+}
+
+func (s *Server) apiV1UpdateEmailSettings(c *gin.Context) {
+	viewer, ok := s.requireViewer(c)
+	if !ok {
+		return
+	}
+
+	var req v1requests.UpdateEmailSettings
+	err := c.BindJSON(&req)
+	if err != nil {
+		s.badRequest(c,
+			fmt.Sprintf("unable to bind JSON: %v", err),
+			"unable to parse JSON",
+		)
+		return
+	}
+
+	var user pgmodel.User
+	err = s.pgDB.Take(&user, "id = ?", viewer).Error
+	if err != nil {
+		s.internalServerError(c, "unable to get user %d: %v", viewer, err)
+		return
+	}
+
+	updates := map[string]any{}
+	// confirm is the new address to send a confirmation link to, it is only used once confirmed
+	confirm := ""
+	if req.Email != nil {
+		email := *req.Email
+		switch email {
+		case "":
+			updates["email"] = ""
+			updates["pending_email"] = ""
+		case user.Email:
+			updates["pending_email"] = ""
+		default:
+			addr, err := mail.ParseAddress(email)
+			if err != nil || addr.Address != email || len(email) > maxEmailLength {
+				s.badRequest(c, fmt.Sprintf("invalid email %q", email), "invalid email")
+				return
+			}
+			if s.mailer == nil {
+				s.badRequest(c, "emails are disabled", "emails aren't enabled on this server")
+				return
+			}
+			updates["pending_email"] = email
+			confirm = email
+		}
+	}
+	if req.Notifications != nil {
+		updates["email_notifications"] = *req.Notifications
+	}
+	if req.Digest != nil {
+		switch *req.Digest {
+		case pgmodel.DigestOff, pgmodel.DigestDaily, pgmodel.DigestWeekly:
+		default:
+			s.badRequest(c, fmt.Sprintf("invalid digest frequency %q", *req.Digest), "digest must be one of off, daily, weekly")
+			return
+		}
+		updates["digest_frequency"] = *req.Digest
+		// The first digest is sent after a full period
+		updates["last_digest_at"] = time.Now()
+	}
+
+	if len(updates) > 0 {
+		err = s.pgDB.Model(&pgmodel.User{}).Where("id = ?", viewer).Updates(updates).Error
+		if err != nil {
+			s.internalServerError(c, "unable to update email settings of user %d: %v", viewer, err)
+			return
+		}
+	}
+	if confirm != "" {
+		err = s.sendEmailConfirmation(user, confirm)
+		if err != nil {
+			s.internalServerError(c, "unable to send email confirmation to user %d: %v", viewer, err)
+			return
+		}
+	}
+
+	err = s.pgDB.Take(&user, "id = ?", viewer).Error
+	if err != nil {
+		s.internalServerError(c, "unable to get user %d: %v", viewer, err)
+		return
+	}
+	c.JSON(http.StatusOK, getApiEmailSettings(user))
+}
+
+// apiV1ConfirmEmailPage asks for a confirmation, as link checkers follow the links of the emails
+func (s *Server) apiV1ConfirmEmailPage(c *gin.Context) {
+	_, email, err := s.parseEmailConfirmationToken(c.Query("token"), time.Now())
+	if err != nil {
+		s.badRequest(c, fmt.Sprintf("invalid email confirmation token: %v", err), "invalid or expired confirmation link")
+		return
+	}
+	s.renderConfirmEmailPage(c, email, false)
+}
+
+// apiV1ConfirmEmail replaces the email address of a user with the pending one of the token. Links of
+// addresses replaced since they were sent don't confirm anything.
+func (s *Server) apiV1ConfirmEmail(c *gin.Context) {
+	userId, email, err := s.parseEmailConfirmationToken(c.Query("token"), time.Now())
+	if err != nil {
+		s.badRequest(c, fmt.Sprintf("invalid email confirmation token: %v", err), "invalid or expired confirmation link")
+		return
+	}
+
+	tx := s.pgDB.Model(&pgmodel.User{}).
+		Where("id = ? AND pending_email = ?", userId, email).
+		UpdateColumns(map[string]any{"email": email, "pending_email": ""})
+	if tx.Error != nil {
+		s.internalServerError(c, "unable to confirm email of user %d: %v", userId, tx.Error)
+		return
+	}
+	if tx.RowsAffected == 0 {
+		// The link may have been followed already
+		var confirmed int64
+		err = s.pgDB.Model(&pgmodel.User{}).Where("id = ? AND email = ?", userId, email).Count(&confirmed).Error
+		if err != nil {
+			s.internalServerError(c, "unable to get email of user %d: %v", userId, err)
+			return
+		}
+		if confirmed == 0 {
+			s.badRequest(c, fmt.Sprintf("email %q of user %d isn't pending", email, userId), "invalid or expired confirmation link")
+			return
+		}
+	}
+	s.renderConfirmEmailPage(c, email, true)
+}
+
+func (s *Server) renderConfirmEmailPage(c *gin.Context, email string, done bool) {
+	var page bytes.Buffer
+	err := emailHTMLTemplates.ExecuteTemplate(&page, "confirm.html.tmpl", map[string]any{
+		"Email": email,
+		"Done":  done,
+	})
+	if err != nil {
+		s.internalServerError(c, "unable to render email confirmation page: %v", err)
+		return
+	}
+	c.Data(http.StatusOK, "text/html; charset=utf-8", page.Bytes())
+}
+
+// apiV1UnsubscribePage asks for a confirmation, as link checkers follow the links of the emails
+func (s *Server) apiV1UnsubscribePage(c *gin.Context) {
+	_, kind, err := s.parseUnsubscribeToken(c.Query("token"))
+	if err != nil {
+		s.badRequest(c, fmt.Sprintf("invalid unsubscribe token: %v", err), "invalid unsubscribe link")
+		return
+	}
+	s.renderUnsubscribePage(c, kind, false)
+}
+
+// apiV1Unsubscribe unsubscribes a user from the emails of the token, either from the confirmation page or
+// with the one-click unsubscribe of mail clients (RFC 8058)
+func (s *Server) apiV1Unsubscribe(c *gin.Context) {
+	userId, kind, err := s.parseUnsubscribeToken(c.Query("token"))
+	if err != nil {
+		s.badRequest(c, fmt.Sprintf("invalid unsubscribe token: %v", err), "invalid unsubscribe link")
+		return
+	}
+
+	column, value := "email_notifications", any(false)
+	if kind == unsubscribeDigest {
+		column, value = "digest_frequency", pgmodel.DigestOff
+	}
+	err = s.pgDB.Model(&pgmodel.User{}).Where("id = ?", userId).UpdateColumn(column, value).Error
+	if err != nil {
+		s.internalServerError(c, "unable to unsubscribe user %d from %s: %v", userId, kind, err)
+		return
+	}
+	s.renderUnsubscribePage(c, kind, true)
+}
+
+func (s *Server) renderUnsubscribePage(c *gin.Context, kind string, done bool) {
+	what := "email notifications"
+	if kind == unsubscribeDigest {
+		what = "the email digest"
+	}
+
+	var page bytes.Buffer
+	err := emailHTMLTemplates.ExecuteTemplate(&page, "unsubscribe.html.tmpl", map[string]any{
+		"What": what,
+		"Done": done,
+	})
+	if err != nil {
+		s.internalServerError(c, "unable to render unsubscribe page: %v", err)
+		return
+	}
+	c.Data(http.StatusOK, "text/html; charset=utf-8", page.Bytes())
+}
+
+func getApiEmailSettings(u pgmodel.User) api.EmailSettings {
+	return api.EmailSettings{
+		Email:         u.Email,
+		PendingEmail:  u.PendingEmail,
+		Notifications: u.EmailNotifications,
+		Digest:        u.DigestFrequency,
+	}
+}
@@ -0,0 +1,311 @@
+package server
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"github.com/denysvitali/social/backend/pkg/imaging"
+	pgmodel "github.com/denysvitali/social/backend/pkg/models/postgres"
+	v1requests "github.com/denysvitali/social/backend/pkg/requests/v1"
+	"github.com/denysvitali/social/backend/pkg/storage"
+	"github.com/gin-gonic/gin"
+	"github.com/oklog/ulid/v2"
+	"gorm.io/gorm"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// apiV1UploadMedia stores the image in the "file" field of a multipart form. The image is processed in the
+// background: the returned media is pending, but its ID can already be attached to a post of the uploader.
+func (s *Server) apiV1UploadMedia(c *gin.Context) {
+	viewer, ok := s.requireViewer(c)
+	if !ok {
+		return
+	}
+
+	media, ok := s.storeUpload(c, viewer)
+	if !ok {
+		return
+	}
+
+	c.JSON(http.StatusAccepted, s.getApiMedia(media))
+}
+
+// storeUpload validates and stores the image in the "file" field of a multipart form, and queues it for processing
+func (s *Server) storeUpload(c *gin.Context, owner uint64) (pgmodel.Media, bool) {
+	// Leave some room for the other fields of the form
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, MaxMediaSize+64<<10)
+	fh, err := c.FormFile("file")
+	if err != nil {
+		s.badRequest(c, fmt.Sprintf("unable to read uploaded file: %v", err), "file is missing or too large")
+		return pgmodel.Media{}, false
+	}
+	if fh.Size > MaxMediaSize {
+		s.badRequest(c, "uploaded file is too large", fmt.Sprintf("file cannot be larger than %d bytes", MaxMediaSize))
+		return pgmodel.Media{}, false
+	}
+
+	altText := strings.TrimSpace(c.PostForm("altText"))
+	if utf8.RuneCountInString(altText) > MaxAltTextLength {
+		s.badRequest(c, "alt text is too long", fmt.Sprintf("altText cannot be longer than %d characters", MaxAltTextLength))
+		return pgmodel.Media{}, false
+	}
+
+	f, err := fh.Open()
+	if err != nil {
+		s.internalServerError(c, "unable to open uploaded file: %v", err)
+		return pgmodel.Media{}, false
+	}
+	data, err := io.ReadAll(f)
+	f.Close()
+	if err != nil {
+		s.internalServerError(c, "unable to read uploaded file: %v", err)
+		return pgmodel.Media{}, false
+	}
+
+	// The content type declared by the client isn't trusted
+	contentType, err := imaging.Sniff(data)
+	if err != nil {
+		s.badRequest(c, fmt.Sprintf("unsupported media type %s", http.DetectContentType(data)), "file must be a JPEG, PNG or GIF image")
+		return pgmodel.Media{}, false
+	}
+	cfg, err := imaging.CheckDimensions(data)
+	if err != nil {
+		s.badRequest(c, fmt.Sprintf("invalid image: %v", err), err.Error())
+		return pgmodel.Media{}, false
+	}
+
+	id := ulid.Make()
+	media := pgmodel.Media{
+		ID:              id.Bytes(),
+		OwnerID:         owner,
+		SourceKey:       "uploads/" + id.String(),
+		Key:             "media/" + id.String() + imaging.Extensions[contentType],
+		ContentType:     contentType,
+		Size:            fh.Size,
+		Width:           cfg.Width,
+		Height:          cfg.Height,
+		AltText:         altText,
+		Status:          pgmodel.MediaStatusPending,
+		StatusUpdatedAt: time.Now(),
+	}
+	err = s.storage.Put(c.Request.Context(), media.SourceKey, bytes.NewReader(data), int64(len(data)), contentType)
+	if err != nil {
+		s.internalServerError(c, "unable to store media %s: %v", media.SourceKey, err)
+		return pgmodel.Media{}, false
+	}
+
+	tx := s.pgDB.Create(&media)
+	if tx.Error != nil {
+		s.internalServerError(c, "unable to create media: %v", tx.Error)
+		return pgmodel.Media{}, false
+	}
+	s.enqueueMedia(media.ID)
+
+	return media, true
+}
+
+// privateMediaMaxAge is how long clients can cache the media that can't be stored by shared caches. It is
+// short, so that they stop being served once they aren't visible anymore.
+const privateMediaMaxAge = 5 * time.Minute
+
+// apiV1GetMedia serves a processed media, or its variant named by the "variant" parameter, from the storage.
+// Keys are never reused, so public media can be cached forever.
+func (s *Server) apiV1GetMedia(c *gin.Context) {
+	media, public, ok := s.findVisibleMedia(c)
+	if !ok {
+		return
+	}
+	if media.Status != pgmodel.MediaStatusReady {
+		s.notFound(c, "media is %s", media.Status)
+		return
+	}
+
+	key, size, contentType := media.Key, media.Size, media.ContentType
+	if name := c.Query("variant"); name != "" {
+		found := false
+		for _, v := range media.Variants {
+			if v.Name == name {
+				key, size, contentType = v.Key, v.Size, v.ContentType
+				found = true
+			}
+		}
+		if !found {
+			s.notFound(c, "variant %s of media not found", name)
+			return
+		}
+	}
+
+	cacheControl := "public, max-age=31536000, immutable"
+	if !public {
+		cacheControl = fmt.Sprintf("private, max-age=%d", int(privateMediaMaxAge.Seconds()))
+	}
+	s.serveStoredFile(c, key, size, contentType, map[string]string{
+		"Cache-Control": cacheControl,
+	})
+}
+
+// serveStoredFile streams the file stored under key, with headers
+func (s *Server) serveStoredFile(c *gin.Context, key string, size int64, contentType string, headers map[string]string) {
+	r, err := s.storage.Get(c.Request.Context(), key)
+	if err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			s.notFound(c, "media %s not found in storage", key)
+			return
+		}
+		s.internalServerError(c, "unable to get media %s from storage: %v", key, err)
+		return
+	}
+	defer r.Close()
+
+	c.DataFromReader(http.StatusOK, size, contentType, r, headers)
+}
+
+// apiV1GetMediaMetadata returns a media, so that uploaders can poll its processing status
+func (s *Server) apiV1GetMediaMetadata(c *gin.Context) {
+	media, _, ok := s.findVisibleMedia(c)
+	if !ok {
+		return
+	}
+	c.JSON(http.StatusOK, s.getApiMedia(media))
+}
+
+// findVisibleMedia is like findMediaParam, but replies with 404 Not Found when the viewer can't see the media.
+// Media attached to a post are visible to those who can see the post, the others only to their owner, unless
+// they are the picture of a user. public is true when the media can be stored by shared caches.
+func (s *Server) findVisibleMedia(c *gin.Context) (pgmodel.Media, bool, bool) {
+	media, ok := s.findMediaParam(c)
+	if !ok {
+		return media, false, false
+	}
+	viewer, _ := s.viewerId(c)
+
+	if media.PostID == nil {
+		var pictures int64
+		err := s.pgDB.Raw(`
+			SELECT COUNT(*) FROM users
+			WHERE deleted = false AND suspended_at IS NULL AND (
+				id IN (SELECT user_id FROM profile_pictures WHERE media_id = ?) OR
+				id IN (SELECT user_id FROM bio_pictures WHERE media_id = ?)
+			)`, media.ID, media.ID).Scan(&pictures).Error
+		if err != nil {
+			s.internalServerError(c, "unable to get pictures of media: %v", err)
+			return media, false, false
+		}
+		if pictures > 0 {
+			return media, true, true
+		}
+		if viewer == 0 || viewer != media.OwnerID {
+			s.notFound(c, "media isn't attached to a post and isn't owned by %d", viewer)
+			return media, false, false
+		}
+		return media, false, true
+	}
+
+	var post pgmodel.Post
+	tx := excludeSuspendedAuthors(s.pgDB.Select("id", "author_id", "visibility")).
+		Where("id = ? AND deleted = false", *media.PostID).
+		Limit(1).
+		Find(&post)
+	if tx.Error != nil {
+		s.internalServerError(c, "unable to get post of media: %v", tx.Error)
+		return media, false, false
+	}
+	if tx.RowsAffected == 0 {
+		s.notFound(c, "post of media not found")
+		return media, false, false
+	}
+	visible, err := s.postVisibleTo(c.Request.Context(), viewer, post)
+	if err != nil {
+		s.internalServerError(c, "unable to check visibility of post: %v", err)
+		return media, false, false
+	}
+	if !visible {
+		s.notFound(c, "post of media not visible to user %d", viewer)
+		return media, false, false
+	}
+	return media, post.Visibility != pgmodel.VisibilityFollowers, true
+}
+
+func (s *Server) findMediaParam(c *gin.Context) (pgmodel.Media, bool) {
+	var media pgmodel.Media
+	id, err := ulid.Parse(c.Param("id"))
+	if err != nil {
+		s.badRequest(c, fmt.Sprintf("unable to parse media id: %v", err), "invalid media id")
+		return media, false
+	}
+
+	tx := s.pgDB.Preload("Variants").Take(&media, "id = ?", id)
+	if tx.Error != nil {
+		if errors.Is(tx.Error, gorm.ErrRecordNotFound) {
+			s.notFound(c, "media not found")
+			return media, false
+		}
+		s.internalServerError(c, "unable to get media: %v", tx.Error)
+		return media, false
+	}
+	return media, true
+}
+
+// findAttachableMedia returns the media referenced by attachments, in order, with their alt text set.
+// They must belong to owner and not be attached to a post yet.
+func (s *Server) findAttachableMedia(c *gin.Context, owner uint64, attachments []v1requests.PostMedia) ([]pgmodel.Media, bool) {
+	if len(attachments) > MaxPostMedia {
+		s.badRequest(c, "too many attachments", fmt.Sprintf("a post cannot have more than %d attachments", MaxPostMedia))
+		return nil, false
+	}
+
+	var ids []ulid.ULID
+	seen := map[ulid.ULID]bool{}
+	for _, a := range attachments {
+		id, err := ulid.Parse(a.ID)
+		if err != nil {
+			s.badRequest(c, fmt.Sprintf("unable to parse media id: %v", err), "invalid media id")
+			return nil, false
+		}
+		if seen[id] {
+			s.badRequest(c, fmt.Sprintf("media %s attached twice", id), "media cannot be attached twice")
+			return nil, false
+		}
+		if a.AltText != nil && utf8.RuneCountInString(strings.TrimSpace(*a.AltText)) > MaxAltTextLength {
+			s.badRequest(c, "alt text is too long", fmt.Sprintf("altText cannot be longer than %d characters", MaxAltTextLength))
+			return nil, false
+		}
+		seen[id] = true
+		ids = append(ids, id)
+	}
+	if len(ids) == 0 {
+		return nil, true
+	}
+
+	var found []pgmodel.Media
+	tx := s.pgDB.Where("id IN ? AND owner_id = ? AND post_id IS NULL", ids, owner).Find(&found)
+	if tx.Error != nil {
+		s.internalServerError(c, "unable to get media: %v", tx.Error)
+		return nil, false
+	}
+	byId := map[ulid.ULID]pgmodel.Media{}
+	for _, m := range found {
+		byId[bytesToUlid(m.ID)] = m
+	}
+
+	var media []pgmodel.Media
+	for i, id := range ids {
+		m, ok := byId[id]
+		if !ok {
+			s.badRequest(c,
+				fmt.Sprintf("media %s doesn't exist, isn't owned by %d or is already attached", id, owner),
+				fmt.Sprintf("media %s cannot be attached", id),
+			)
+			return nil, false
+		}
+		if alt := attachments[i].AltText; alt != nil {
+			m.AltText = strings.TrimSpace(*alt)
+		}
+		media = append(media, m)
+	}
+	return media, true
+}
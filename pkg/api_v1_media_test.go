@@ -0,0 +1,107 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"database/sql/driver"
+	pgmodel "github.com/denysvitali/social/backend/pkg/models/postgres"
+	"github.com/denysvitali/social/backend/pkg/storage"
+	"github.com/gin-gonic/gin"
+	"github.com/oklog/ulid/v2"
+	"github.com/sirupsen/logrus"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestGetMedia(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	store, err := storage.NewLocal(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	content := []byte("not really an image")
+	err = store.Put(context.Background(), "media/1.png", bytes.NewReader(content), int64(len(content)), "image/png")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	private := "private, max-age=300"
+	public := "public, max-age=31536000, immutable"
+	for _, tt := range []struct {
+		name string
+		// visibility of the post of the media, empty when it isn't attached
+		visibility string
+		picture    bool
+		viewer     string
+		want       int
+		cache      string
+	}{
+		{"public post", pgmodel.VisibilityPublic, false, "", http.StatusOK, public},
+		{"unlisted post", pgmodel.VisibilityUnlisted, false, "", http.StatusOK, public},
+		{"followers post, anonymous", pgmodel.VisibilityFollowers, false, "", http.StatusNotFound, ""},
+		{"followers post, stranger", pgmodel.VisibilityFollowers, false, "3", http.StatusNotFound, ""},
+		{"followers post, author", pgmodel.VisibilityFollowers, false, "2", http.StatusOK, private},
+		{"unattached, anonymous", "", false, "", http.StatusNotFound, ""},
+		{"unattached, owner", "", false, "2", http.StatusOK, private},
+		{"picture", "", true, "", http.StatusOK, public},
+	} {
+		mediaId := ulid.Make()
+		postId := ulid.Make()
+		db, fake := newFakeDB(t, func(query string, args []driver.Value) (fakeResult, error) {
+			switch {
+			case strings.Contains(query, `FROM "media"`):
+				var post any
+				if tt.visibility != "" {
+					post = postId.Bytes()
+				}
+				return fakeResult{
+					columns: []string{"id", "owner_id", "post_id", "key", "content_type", "size", "status"},
+					rows: [][]driver.Value{
+						{mediaId.Bytes(), int64(2), post, "media/1.png", "image/png", int64(len(content)), pgmodel.MediaStatusReady},
+					},
+				}, nil
+			case strings.Contains(query, `FROM "posts"`):
+				return fakeResult{
+					columns: []string{"id", "author_id", "visibility"},
+					rows:    [][]driver.Value{{postId.Bytes(), int64(2), tt.visibility}},
+				}, nil
+			case strings.Contains(query, "FROM users"):
+				var pictures int64
+				if tt.picture {
+					pictures = 1
+				}
+				return fakeResult{columns: []string{"count"}, rows: [][]driver.Value{{pictures}}}, nil
+			}
+			return fakeResult{}, nil
+		})
+		log := logrus.New()
+		log.SetOutput(io.Discard)
+		s := &Server{logger: log, pgDB: db, storage: store}
+		e := gin.New()
+		e.GET("/media/:id", s.apiV1GetMedia)
+
+		req := httptest.NewRequest(http.MethodGet, "/media/"+mediaId.String(), nil)
+		if tt.viewer != "" {
+			req.Header.Set(ViewerHeader, tt.viewer)
+		}
+		w := httptest.NewRecorder()
+		e.ServeHTTP(w, req)
+		if w.Code != tt.want {
+			t.Errorf("%s: got status %d, want %d", tt.name, w.Code, tt.want)
+			continue
+		}
+		if got := w.Header().Get("Cache-Control"); got != tt.cache {
+			t.Errorf("%s: got Cache-Control %q, want %q", tt.name, got, tt.cache)
+		}
+
+		for _, s := range fake.statements {
+			if strings.Contains(s.query, `FROM "posts"`) &&
+				(!strings.Contains(s.query, "deleted = false") || !strings.Contains(s.query, notSuspendedAuthor)) {
+				t.Errorf("%s: deleted posts or suspended authors aren't filtered: %s", tt.name, s.query)
+			}
+		}
+	}
+}
@@ -0,0 +1,163 @@
+package server
+
+import (
+	"fmt"
+	"github.com/denysvitali/social/backend/pkg/models/api"
+	pgmodel "github.com/denysvitali/social/backend/pkg/models/postgres"
+	v1requests "github.com/denysvitali/social/backend/pkg/requests/v1"
+	"github.com/denysvitali/social/backend/pkg/wordfilter"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// MaxMutedWords is the maximum number of active muted words of a user
+const MaxMutedWords = 100
+
+// MaxMutedWordLength is the maximum length of a muted word, in Unicode code points
+const MaxMutedWordLength = 100
+
+// apiV1GetMutedWords lists the muted words of the viewer that haven't expired, most recent first
+func (s *Server) apiV1GetMutedWords(c *gin.Context) {
+	viewer, ok := s.requireViewer(c)
+	if !ok {
+		return
+	}
+
+	var mutedWords []pgmodel.MutedWord
+	err := s.activeMutedWords(viewer).
+		Order("id DESC").
+		Find(&mutedWords).Error
+	if err != nil {
+		s.internalServerError(c, "unable to get muted words of user %d: %v", viewer, err)
+		return
+	}
+
+	response := api.MutedWordsResponse{MutedWords: []api.MutedWord{}}
+	for _, w := range mutedWords {
+		response.MutedWords = append(response.MutedWords, getApiMutedWord(w))
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+func (s *Server) apiV1CreateMutedWord(c *gin.Context) {
+	viewer, ok := s.requireViewer(c)
+	if !ok {
+		return
+	}
+
+	var req v1requests.CreateMutedWord
+	err := c.BindJSON(&req)
+	if err != nil {
+		s.badRequest(c,
+			fmt.Sprintf("unable to bind JSON: %v", err),
+			"unable to parse JSON",
+		)
+		return
+	}
+
+	phrase := strings.TrimSpace(req.Phrase)
+	if utf8.RuneCountInString(phrase) > MaxMutedWordLength {
+		s.badRequest(c, "muted word too long",
+			fmt.Sprintf("phrase cannot be longer than %d characters", MaxMutedWordLength),
+		)
+		return
+	}
+	_, err = wordfilter.Parse(phrase)
+	if err != nil {
+		s.badRequest(c, fmt.Sprintf("invalid muted word %q: %v", phrase, err), err.Error())
+		return
+	}
+
+	if req.Context == "" {
+		req.Context = pgmodel.FilterContextEverywhere
+	}
+	if req.Context != pgmodel.FilterContextHome && req.Context != pgmodel.FilterContextEverywhere {
+		s.badRequest(c, fmt.Sprintf("invalid filter context %q", req.Context), "context must be one of home, everywhere")
+		return
+	}
+	if req.Action == "" {
+		req.Action = pgmodel.FilterActionHide
+	}
+	if req.Action != pgmodel.FilterActionHide && req.Action != pgmodel.FilterActionWarn {
+		s.badRequest(c, fmt.Sprintf("invalid filter action %q", req.Action), "action must be one of hide, warn")
+		return
+	}
+	if req.ExpiresIn < 0 {
+		s.badRequest(c, fmt.Sprintf("invalid expiresIn %d", req.ExpiresIn), "expiresIn cannot be negative")
+		return
+	}
+
+	var count int64
+	err = s.activeMutedWords(viewer).Model(&pgmodel.MutedWord{}).Count(&count).Error
+	if err != nil {
+		s.internalServerError(c, "unable to count muted words of user %d: %v", viewer, err)
+		return
+	}
+	if count >= MaxMutedWords {
+		s.badRequest(c,
+			fmt.Sprintf("user %d has too many muted words", viewer),
+			fmt.Sprintf("you cannot have more than %d muted words", MaxMutedWords),
+		)
+		return
+	}
+
+	now := time.Now()
+	mutedWord := pgmodel.MutedWord{
+		UserID:    viewer,
+		Phrase:    phrase,
+		Context:   req.Context,
+		Action:    req.Action,
+		CreatedAt: now,
+	}
+	if req.ExpiresIn > 0 {
+		expiresAt := now.Add(time.Duration(req.ExpiresIn) * time.Second)
+		mutedWord.ExpiresAt = &expiresAt
+	}
+	err = s.pgDB.Create(&mutedWord).Error
+	if err != nil {
+		s.internalServerError(c, "unable to create muted word: %v", err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, getApiMutedWord(mutedWord))
+}
+
+func (s *Server) apiV1DeleteMutedWord(c *gin.Context) {
+	viewer, ok := s.requireViewer(c)
+	if !ok {
+		return
+	}
+
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		s.badRequest(c, fmt.Sprintf("invalid id: %v", err), "invalid id")
+		return
+	}
+
+	tx := s.pgDB.Delete(&pgmodel.MutedWord{}, "id = ? AND user_id = ?", id, viewer)
+	if tx.Error != nil {
+		s.internalServerError(c, "unable to delete muted word %d: %v", id, tx.Error)
+		return
+	}
+	if tx.RowsAffected == 0 {
+		s.notFound(c, "muted word %d of user %d not found", id, viewer)
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+func getApiMutedWord(w pgmodel.MutedWord) api.MutedWord {
+	return api.MutedWord{
+		ID:        w.ID,
+		Phrase:    w.Phrase,
+		Context:   w.Context,
+		Action:    w.Action,
+		ExpiresAt: w.ExpiresAt,
+		CreatedAt: w.CreatedAt,
+	}
+}
@@ -0,0 +1,306 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"github.com/denysvitali/social/backend/pkg/models/api"
+	pgmodel "github.com/denysvitali/social/backend/pkg/models/postgres"
+	v1requests "github.com/denysvitali/social/backend/pkg/requests/v1"
+	"github.com/gin-gonic/gin"
+	"github.com/oklog/ulid/v2"
+	"io"
+	"net/http"
+	"time"
+)
+
+// apiV1GetNotifications lists the notification groups of the viewer, most recently active first
+func (s *Server) apiV1GetNotifications(c *gin.Context) {
+	viewer, ok := s.requireViewer(c)
+	if !ok {
+		return
+	}
+
+	page, err := parsePage(c)
+	if err != nil {
+		s.badRequest(c, fmt.Sprintf("invalid pagination: %v", err), err.Error())
+		return
+	}
+
+	var groups []struct {
+		GroupKey   string
+		Type       string
+		LatestID   []byte
+		ActorCount int64
+		Unread     bool
+		CreatedAt  time.Time
+	}
+	// Each group is represented by its latest notification. IDs are bytea, which has no MAX() before
+	// PostgreSQL 18, hence the window functions.
+	vars := map[string]any{
+		"viewer": viewer,
+		"limit":  page.Limit,
+	}
+	pagination := ""
+	if page.Cursor != nil {
+		pagination = "AND latest_id < @cursor"
+		vars["cursor"] = page.Cursor
+	}
+	tx := s.pgDB.Raw(`
+		SELECT group_key, type, latest_id, actor_count, unread, created_at FROM (
+			SELECT group_key, type, id AS latest_id, created_at,
+				COUNT(*) OVER (PARTITION BY group_key) AS actor_count,
+				BOOL_OR(read_at IS NULL) OVER (PARTITION BY group_key) AS unread,
+				ROW_NUMBER() OVER (PARTITION BY group_key ORDER BY id DESC) AS rank
+			FROM notifications
+			WHERE user_id = @viewer
+		) grouped
+		WHERE rank = 1 `+pagination+`
+		ORDER BY latest_id DESC
+		LIMIT @limit`,
+		vars,
+	).Scan(&groups)
+	if tx.Error != nil {
+		s.internalServerError(c, "unable to get notifications of user %d: %v", viewer, tx.Error)
+		return
+	}
+
+	response := api.NotificationsResponse{
+		Notifications: []api.NotificationGroup{},
+		Users:         []api.User{},
+		Posts:         []api.Post{},
+	}
+	if len(groups) == 0 {
+		c.JSON(http.StatusOK, response)
+		return
+	}
+	response.NextCursor = page.nextCursor(len(groups), groups[len(groups)-1].LatestID)
+
+	var groupKeys []string
+	for _, g := range groups {
+		groupKeys = append(groupKeys, g.GroupKey)
+	}
+
+	// The most recent actors of each group
+	var recent []struct {
+		GroupKey string
+		ActorID  uint64
+		PostID   []byte
+		ReportID []byte
+	}
+	tx = s.pgDB.Raw(`
+		SELECT group_key, actor_id, post_id, report_id FROM (
+			SELECT group_key, actor_id, post_id, report_id, ROW_NUMBER() OVER (PARTITION BY group_key ORDER BY id DESC) AS rank
+			FROM notifications
+			WHERE user_id = ? AND group_key IN ?
+		) ranked
+		WHERE rank <= ?
+		ORDER BY group_key, rank`,
+		viewer, groupKeys, maxNotificationActors,
+	).Scan(&recent)
+	if tx.Error != nil {
+		s.internalServerError(c, "unable to get notification actors of user %d: %v", viewer, tx.Error)
+		return
+	}
+
+	actorsByGroup := map[string][]uint64{}
+	postByGroup := map[string][]byte{}
+	actorIds := map[uint64]bool{}
+	postIds := map[ulid.ULID][]byte{}
+	reportByGroup := map[string][]byte{}
+	var reportIds [][]byte
+	for _, r := range recent {
+		if r.ReportID != nil {
+			reportByGroup[r.GroupKey] = r.ReportID
+			reportIds = append(reportIds, r.ReportID)
+			continue
+		}
+		actorsByGroup[r.GroupKey] = append(actorsByGroup[r.GroupKey], r.ActorID)
+		actorIds[r.ActorID] = true
+		if r.PostID != nil {
+			postByGroup[r.GroupKey] = r.PostID
+			postIds[bytesToUlid(r.PostID)] = r.PostID
+		}
+	}
+
+	// Sideloaded posts and users
+	var postIdList [][]byte
+	for _, id := range postIds {
+		postIdList = append(postIdList, id)
+	}
+	var posts []pgmodel.Post
+	if len(postIdList) > 0 {
+		tx = excludeSuspendedAuthors(s.pgDB).Where("id IN ? AND deleted = false", postIdList).Order("id DESC").Find(&posts)
+		if tx.Error != nil {
+			s.internalServerError(c, "unable to get notification posts: %v", tx.Error)
+			return
+		}
+	}
+	postsResponse, err := s.postsResponse(viewer, posts)
+	if err != nil {
+		s.internalServerError(c, "unable to build posts response: %v", err)
+		return
+	}
+	filter, err := s.loadPostFilter(viewer, pgmodel.FilterContextEverywhere)
+	if err != nil {
+		s.internalServerError(c, "unable to get muted words of user %d: %v", viewer, err)
+		return
+	}
+	response.Posts = filter.apply(postsResponse.Posts)
+	response.Users = postsResponse.Users
+
+	existingPosts := map[string]bool{}
+	for _, p := range response.Posts {
+		existingPosts[p.ID] = true
+	}
+	sideloadedUsers := map[uint64]bool{}
+	for _, u := range response.Users {
+		sideloadedUsers[u.ID] = true
+	}
+
+	var actorIdList []uint64
+	for id := range actorIds {
+		actorIdList = append(actorIdList, id)
+	}
+	var actors []pgmodel.User
+	tx = s.pgDB.Where("id IN ?", actorIdList).Find(&actors)
+	if tx.Error != nil {
+		s.internalServerError(c, "unable to get notification actors: %v", tx.Error)
+		return
+	}
+	// Users the viewer blocked or muted since they were notified are hidden too
+	hidden, err := s.hiddenUserIds(c.Request.Context(), viewer, true)
+	if err != nil {
+		s.internalServerError(c, "unable to get users hidden from user %d: %v", viewer, err)
+		return
+	}
+	isHidden := map[uint64]bool{}
+	for _, id := range hidden {
+		isHidden[id] = true
+	}
+	activeActors := map[uint64]bool{}
+	for _, u := range actors {
+		if userUnavailable(u) || isHidden[u.ID] {
+			continue
+		}
+		activeActors[u.ID] = true
+		if !sideloadedUsers[u.ID] {
+			response.Users = append(response.Users, getApiUser(u))
+		}
+	}
+
+	outcomes := map[string]*api.ReportOutcome{}
+	if len(reportIds) > 0 {
+		var reports []pgmodel.Report
+		tx = s.pgDB.Where("id IN ?", reportIds).Find(&reports)
+		if tx.Error != nil {
+			s.internalServerError(c, "unable to get notification reports: %v", tx.Error)
+			return
+		}
+		for _, r := range reports {
+			outcomes[string(r.ID)] = getApiReportOutcome(r)
+		}
+	}
+
+	for _, g := range groups {
+		group := api.NotificationGroup{
+			ID:         bytesToUlid(g.LatestID).String(),
+			Type:       g.Type,
+			Actors:     []uint64{},
+			ActorCount: g.ActorCount,
+			Unread:     g.Unread,
+			CreatedAt:  g.CreatedAt,
+		}
+		if isModerationNotification(g.Type) {
+			if reportId, ok := reportByGroup[g.GroupKey]; ok {
+				group.Report = outcomes[string(reportId)]
+			}
+			response.Notifications = append(response.Notifications, group)
+			continue
+		}
+		if postId, ok := postByGroup[g.GroupKey]; ok {
+			group.Post = bytesToUlid(postId).String()
+			// The post was deleted since, or is hidden by a muted word
+			if !existingPosts[group.Post] {
+				continue
+			}
+		}
+		for _, id := range actorsByGroup[g.GroupKey] {
+			if activeActors[id] {
+				group.Actors = append(group.Actors, id)
+			}
+		}
+		if len(group.Actors) == 0 {
+			continue
+		}
+		response.Notifications = append(response.Notifications, group)
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+func (s *Server) apiV1GetUnreadNotificationsCount(c *gin.Context) {
+	viewer, ok := s.requireViewer(c)
+	if !ok {
+		return
+	}
+
+	count, err := s.unreadNotificationsCount(viewer)
+	if err != nil {
+		s.internalServerError(c, "unable to count unread notifications of user %d: %v", viewer, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, api.UnreadCount{Count: count})
+}
+
+// unreadNotificationsCount returns the number of notification groups of userId with unread notifications
+func (s *Server) unreadNotificationsCount(userId uint64) (int64, error) {
+	var count int64
+	tx := s.pgDB.
+		Model(&pgmodel.Notification{}).
+		Select("COUNT(DISTINCT group_key)").
+		Where("user_id = ? AND read_at IS NULL", userId).
+		Scan(&count)
+	return count, tx.Error
+}
+
+// apiV1MarkNotificationsRead marks the notifications of the viewer up to the cursor as read
+func (s *Server) apiV1MarkNotificationsRead(c *gin.Context) {
+	viewer, ok := s.requireViewer(c)
+	if !ok {
+		return
+	}
+
+	var req v1requests.MarkNotificationsRead
+	err := c.ShouldBindJSON(&req)
+	if err != nil && !errors.Is(err, io.EOF) {
+		s.badRequest(c,
+			fmt.Sprintf("unable to bind JSON: %v", err),
+			"unable to parse JSON",
+		)
+		return
+	}
+
+	tx := s.pgDB.
+		Model(&pgmodel.Notification{}).
+		Where("user_id = ? AND read_at IS NULL", viewer)
+	if req.Cursor != "" {
+		cursor, err := ulid.Parse(req.Cursor)
+		if err != nil {
+			s.badRequest(c, fmt.Sprintf("invalid cursor %q: %v", req.Cursor, err), "invalid cursor")
+			return
+		}
+		tx = tx.Where("id <= ?", cursor)
+	}
+	tx = tx.UpdateColumn("read_at", time.Now())
+	if tx.Error != nil {
+		s.internalServerError(c, "unable to mark notifications of user %d as read: %v", viewer, tx.Error)
+		return
+	}
+	if tx.RowsAffected > 0 {
+		// Keeps the other clients of the viewer in sync
+		s.publishUnreadCount(viewer)
+	}
+
+	c.Status(http.StatusNoContent)
+}
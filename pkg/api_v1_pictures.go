@@ -0,0 +1,192 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"github.com/denysvitali/social/backend/pkg/imaging"
+	pgmodel "github.com/denysvitali/social/backend/pkg/models/postgres"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// pictureMaxAge is how long clients can cache the picture of a user. It is short, as the URL of the
+// picture stays the same when the user uploads a new one.
+const pictureMaxAge = 5 * time.Minute
+
+// pictureKind describes the profile pictures or the biography pictures of users
+type pictureKind struct {
+	table string
+	// variant is the variant served by default, the original is served for sizes larger than it
+	variant imaging.Variant
+	newRow  func(userId uint64, mediaId []byte, at time.Time) any
+}
+
+var profilePictures = pictureKind{
+	table:   "profile_pictures",
+	variant: imaging.Avatar,
+	newRow: func(userId uint64, mediaId []byte, at time.Time) any {
+		return &pgmodel.ProfilePicture{UserId: uint(userId), LastUpdated: &at, MediaID: &mediaId}
+	},
+}
+
+var bioPictures = pictureKind{
+	table:   "bio_pictures",
+	variant: imaging.Banner,
+	newRow: func(userId uint64, mediaId []byte, at time.Time) any {
+		return &pgmodel.BioPicture{UserId: uint(userId), LastUpdated: &at, MediaID: &mediaId}
+	},
+}
+
+func (s *Server) apiV1UploadProfilePicture(c *gin.Context) {
+	s.uploadPicture(c, profilePictures)
+}
+
+func (s *Server) apiV1UploadBioPicture(c *gin.Context) {
+	s.uploadPicture(c, bioPictures)
+}
+
+// uploadPicture replaces the picture of the user with the uploaded image. The previous picture is served
+// until the new one has been processed.
+func (s *Server) uploadPicture(c *gin.Context, kind pictureKind) {
+	viewer, ok := s.requireViewer(c)
+	if !ok {
+		return
+	}
+
+	username := c.Param("username")
+	var user pgmodel.User
+	tx := s.pgDB.Select("id", "deleted").Take(&user, "username = ?", username)
+	if tx.Error != nil {
+		if errors.Is(tx.Error, gorm.ErrRecordNotFound) {
+			s.notFound(c, "user not found")
+			return
+		}
+		s.internalServerError(c, "unable to get user by username: %v", tx.Error)
+		return
+	}
+	if user.Deleted {
+		s.notFound(c, "user doesn't exist anymore")
+		return
+	}
+	if user.ID != viewer {
+		s.forbidden(c, "user %d tried to change the %s of user %d", viewer, kind.table, user.ID)
+		return
+	}
+
+	media, ok := s.storeUpload(c, viewer)
+	if !ok {
+		return
+	}
+
+	tx = s.pgDB.Create(kind.newRow(viewer, media.ID, time.Now()))
+	if tx.Error != nil {
+		s.internalServerError(c, "unable to create %s row: %v", kind.table, tx.Error)
+		return
+	}
+
+	c.JSON(http.StatusAccepted, s.getApiMedia(media))
+}
+
+// servePicture serves the latest picture of the user in the "username" parameter. Uploaded pictures are
+// served in the smallest size at least as wide as the "size" parameter, in pixels, and external ones
+// through the image proxy.
+func (s *Server) servePicture(c *gin.Context, kind pictureKind) {
+	username := c.Param("username")
+	if username == "" {
+		s.badRequest(c,
+			"user provided an invalid parameter username",
+			"invalid parameter username",
+		)
+		return
+	}
+
+	size := 0
+	if v := c.Query("size"); v != "" {
+		var err error
+		size, err = strconv.Atoi(v)
+		if err != nil || size <= 0 || size > imaging.MaxDimension {
+			s.badRequest(c,
+				fmt.Sprintf("invalid size %q", v),
+				fmt.Sprintf("size must be between 1 and %d", imaging.MaxDimension),
+			)
+			return
+		}
+	}
+
+	// Pictures still being processed are skipped
+	var picture struct {
+		Url     string
+		MediaID []byte
+	}
+	tx := s.pgDB.
+		Table(kind.table).
+		Select(kind.table+".url, "+kind.table+".media_id").
+		Joins("INNER JOIN users ON users.id = "+kind.table+".user_id").
+		Joins("LEFT JOIN media ON media.id = "+kind.table+".media_id").
+		Where("users.username = ? AND users.deleted = false AND users.suspended_at IS NULL", username).
+		Where("("+kind.table+".media_id IS NULL OR media.status = ?)", pgmodel.MediaStatusReady).
+		Order(kind.table + ".last_updated DESC NULLS LAST, " + kind.table + ".id DESC").
+		Take(&picture)
+	if tx.Error != nil {
+		if errors.Is(tx.Error, gorm.ErrRecordNotFound) {
+			s.notFound(c, "%s of %s not found", kind.table, username)
+			return
+		}
+		s.internalServerError(c, "unable to find %s: %v", kind.table, tx.Error)
+		return
+	}
+
+	if picture.MediaID == nil {
+		s.serveProxiedImage(c, picture.Url)
+		return
+	}
+
+	var media pgmodel.Media
+	tx = s.pgDB.Preload("Variants").Take(&media, "id = ?", picture.MediaID)
+	if tx.Error != nil {
+		s.internalServerError(c, "unable to get media of %s: %v", kind.table, tx.Error)
+		return
+	}
+
+	key, fileSize, contentType, name := media.Key, media.Size, media.ContentType, "original"
+	for _, v := range media.Variants {
+		if v.Name == kind.variant.Name && v.Width >= size {
+			key, fileSize, contentType, name = v.Key, v.Size, v.ContentType, v.Name
+		}
+	}
+
+	etag := fmt.Sprintf(`"%s-%s"`, bytesToUlid(media.ID), name)
+	headers := map[string]string{
+		"Cache-Control": fmt.Sprintf("public, max-age=%d", int(pictureMaxAge.Seconds())),
+		"ETag":          etag,
+	}
+	if c.GetHeader("If-None-Match") == etag {
+		for k, v := range headers {
+			c.Header(k, v)
+		}
+		c.Status(http.StatusNotModified)
+		return
+	}
+
+	s.serveStoredFile(c, key, fileSize, contentType, headers)
+}
+
+// serveProxiedImage serves the image at the external url through the image proxy, so that the viewer
+// doesn't connect to the upstream
+func (s *Server) serveProxiedImage(c *gin.Context, url string) {
+	img, err := s.imageProxy.Open(c.Request.Context(), url)
+	if err != nil {
+		s.badGateway(c, "unable to proxy image %s: %v", url, err)
+		return
+	}
+	defer img.Close()
+
+	c.Header("Cache-Control", fmt.Sprintf("public, max-age=%d", int(pictureMaxAge.Seconds())))
+	c.Header("ETag", img.ETag)
+	c.Header("Content-Type", img.ContentType)
+	// Handles If-None-Match and range requests
+	http.ServeContent(c.Writer, c.Request, "", img.FetchedAt, img.File)
+}
@@ -0,0 +1,137 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	pgmodel "github.com/denysvitali/social/backend/pkg/models/postgres"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"net/http"
+)
+
+// MaxPinnedPosts is the number of posts a user can pin on their profile at the same time
+const MaxPinnedPosts = 3
+
+var errTooManyPinnedPosts = fmt.Errorf("too many pinned posts")
+
+func (s *Server) apiV1PinPost(c *gin.Context) {
+	viewer, ok := s.requireViewer(c)
+	if !ok {
+		return
+	}
+
+	postId, err := parsePostId(c)
+	if err != nil {
+		s.badRequest(c, fmt.Sprintf("unable to parse post id: %v", err), "invalid post id")
+		return
+	}
+
+	var post pgmodel.Post
+	tx := s.pgDB.
+		Select("id", "author_id").
+		Where("id = ? AND deleted = false", postId).
+		Take(&post)
+	if tx.Error != nil {
+		if errors.Is(tx.Error, gorm.ErrRecordNotFound) {
+			s.notFound(c, "post not found")
+			return
+		}
+		s.internalServerError(c, "unable to fetch post: %v", tx.Error)
+		return
+	}
+
+	if post.AuthorID != viewer {
+		s.forbidden(c, "user %d tried to pin a post of user %d", viewer, post.AuthorID)
+		return
+	}
+
+	err = s.pgDB.Transaction(func(tx *gorm.DB) error {
+		// Serializes the pins of the user, so that concurrent requests can't exceed the limit
+		err := tx.
+			Clauses(clause.Locking{Strength: "UPDATE"}).
+			Select("id").
+			Take(&pgmodel.User{}, "id = ?", viewer).Error
+		if err != nil {
+			return err
+		}
+
+		var pins []pgmodel.PinnedPost
+		err = tx.
+			Where("user_id = ?", viewer).
+			Find(&pins).Error
+		if err != nil {
+			return err
+		}
+
+		for _, p := range pins {
+			if bytesToUlid(p.PostID) == *postId {
+				// Already pinned
+				return nil
+			}
+		}
+		if len(pins) >= MaxPinnedPosts {
+			return errTooManyPinnedPosts
+		}
+
+		return tx.Create(&pgmodel.PinnedPost{
+			UserID: viewer,
+			PostID: post.ID,
+		}).Error
+	})
+	if err != nil {
+		if errors.Is(err, errTooManyPinnedPosts) {
+			s.badRequest(c,
+				fmt.Sprintf("user %d already has %d pinned posts", viewer, MaxPinnedPosts),
+				fmt.Sprintf("you cannot pin more than %d posts", MaxPinnedPosts),
+			)
+			return
+		}
+		s.internalServerError(c, "unable to pin post: %v", err)
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+func (s *Server) apiV1UnpinPost(c *gin.Context) {
+	viewer, ok := s.requireViewer(c)
+	if !ok {
+		return
+	}
+
+	postId, err := parsePostId(c)
+	if err != nil {
+		s.badRequest(c, fmt.Sprintf("unable to parse post id: %v", err), "invalid post id")
+		return
+	}
+
+	tx := s.pgDB.
+		Where("user_id = ? AND post_id = ?", viewer, postId).
+		Delete(&pgmodel.PinnedPost{})
+	if tx.Error != nil {
+		s.internalServerError(c, "unable to unpin post: %v", tx.Error)
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// pinnedPosts returns the posts pinned by userId that viewer can see, most recently pinned first
+func (s *Server) pinnedPosts(ctx context.Context, viewer uint64, userId uint64) ([]pgmodel.Post, error) {
+	visibilities, err := s.visibleTo(ctx, viewer, userId)
+	if err != nil {
+		return nil, err
+	}
+
+	var posts []pgmodel.Post
+	tx := s.pgDB.
+		Model(&pgmodel.Post{}).
+		Joins("JOIN pinned_posts ON pinned_posts.post_id = posts.id").
+		Where("pinned_posts.user_id = ? AND posts.author_id = ? AND posts.deleted = false AND posts.visibility IN ?",
+			userId, userId, visibilities).
+		Order("pinned_posts.created_at DESC").
+		Find(&posts)
+	return posts, tx.Error
+}
@@ -5,10 +5,13 @@ import (
 	"fmt"
 	"github.com/denysvitali/social/backend/pkg/models/api"
 	pgmodel "github.com/denysvitali/social/backend/pkg/models/postgres"
+	v1requests "github.com/denysvitali/social/backend/pkg/requests/v1"
 	"github.com/gin-gonic/gin"
 	"github.com/oklog/ulid/v2"
 	"gorm.io/gorm"
 	"net/http"
+	"strings"
+	"unicode/utf8"
 )
 
 func parsePostId(c *gin.Context) (*ulid.ULID, error) {
@@ -20,6 +23,138 @@ func parsePostId(c *gin.Context) (*ulid.ULID, error) {
 	return &u, nil
 }
 
+func (s *Server) apiV1CreatePost(c *gin.Context) {
+	viewer, ok := s.requireViewer(c)
+	if !ok {
+		return
+	}
+
+	var req v1requests.CreatePost
+	err := c.ShouldBindJSON(&req)
+	if err != nil {
+		s.badRequest(c,
+			fmt.Sprintf("unable to bind JSON: %v", err),
+			"unable to parse JSON",
+		)
+		return
+	}
+
+	content := strings.TrimSpace(req.Content)
+	if content == "" && len(req.Media) == 0 {
+		s.paramCantBeEmpty(c, "content")
+		return
+	}
+	if utf8.RuneCountInString(content) > MaxPostLength {
+		s.badRequest(c,
+			"post content is too long",
+			fmt.Sprintf("content cannot be longer than %d characters", MaxPostLength),
+		)
+		return
+	}
+
+	var author pgmodel.User
+	tx := s.pgDB.Select("id", "deleted").Take(&author, "id = ?", viewer)
+	if tx.Error != nil {
+		if errors.Is(tx.Error, gorm.ErrRecordNotFound) {
+			s.unauthorized(c, "user %d doesn't exist", viewer)
+			return
+		}
+		s.internalServerError(c, "unable to fetch author: %v", tx.Error)
+		return
+	}
+	if author.Deleted {
+		s.unauthorized(c, "user %d doesn't exist anymore", viewer)
+		return
+	}
+
+	language := strings.ToLower(req.Language)
+	if language == "" {
+		language = defaultSearchLanguage
+	}
+	if _, ok := searchLanguages[language]; !ok && len(language) != 2 {
+		s.badRequest(c, fmt.Sprintf("invalid language %q", req.Language), "invalid language")
+		return
+	}
+
+	visibility := req.Visibility
+	switch visibility {
+	case "":
+		visibility = pgmodel.VisibilityPublic
+	case pgmodel.VisibilityPublic, pgmodel.VisibilityUnlisted, pgmodel.VisibilityFollowers:
+	default:
+		s.badRequest(c, fmt.Sprintf("invalid visibility %q", req.Visibility), "invalid visibility")
+		return
+	}
+
+	post := pgmodel.Post{
+		ID:         ulid.Make().Bytes(),
+		Content:    content,
+		Language:   language,
+		Visibility: visibility,
+		AuthorID:   viewer,
+	}
+
+	var parentAuthorId uint64
+	if req.ParentPostID != nil {
+		parentId, err := ulid.Parse(*req.ParentPostID)
+		if err != nil {
+			s.badRequest(c, fmt.Sprintf("unable to parse parent post id: %v", err), "invalid parentPostId")
+			return
+		}
+		var parent pgmodel.Post
+		tx = excludeSuspendedAuthors(s.pgDB.Select("id", "author_id", "visibility")).
+			Where("id = ? AND deleted = false", parentId).
+			Take(&parent)
+		if tx.Error != nil {
+			if errors.Is(tx.Error, gorm.ErrRecordNotFound) {
+				s.notFound(c, "parent post not found")
+				return
+			}
+			s.internalServerError(c, "unable to fetch parent post: %v", tx.Error)
+			return
+		}
+		visible, err := s.postVisibleTo(c.Request.Context(), viewer, parent)
+		if err != nil {
+			s.internalServerError(c, "unable to get visibility of parent post: %v", err)
+			return
+		}
+		if !visible {
+			s.notFound(c, "parent post not visible to user %d", viewer)
+			return
+		}
+		post.ParentPostID = &parent.ID
+		parentAuthorId = parent.AuthorID
+	}
+
+	media, ok := s.findAttachableMedia(c, viewer, req.Media)
+	if !ok {
+		return
+	}
+
+	err = s.createPost(&post, media)
+	if err != nil {
+		if errors.Is(err, errMediaAttached) {
+			s.badRequest(c, fmt.Sprintf("unable to create post: %v", err), "media cannot be attached")
+			return
+		}
+		s.internalServerError(c, "unable to create post: %v", err)
+		return
+	}
+	s.notifyPostCreated(c.Request.Context(), post, parentAuthorId)
+	s.publishPost(post)
+	s.emitWebhookEvent(pgmodel.WebhookEventPostCreated, func() (any, error) {
+		return s.postCreatedEvent(post)
+	})
+
+	postsResponse, err := s.postsResponse(viewer, []pgmodel.Post{post})
+	if err != nil {
+		s.internalServerError(c, "unable to build posts response: %v", err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, postsResponse)
+}
+
 func (s *Server) apiV1GetSinglePost(c *gin.Context) {
 	postId, err := parsePostId(c)
 	if err != nil {
@@ -39,17 +174,38 @@ func (s *Server) apiV1GetSinglePost(c *gin.Context) {
 		return
 	}
 
-	if tx.RowsAffected == 0 {
+	if tx.RowsAffected == 0 || post.Deleted {
 		s.notFound(c, "post not found")
 		return
 	}
+	if post.Author != nil && post.Author.SuspendedAt != nil {
+		s.notFound(c, "author %d of post is suspended", post.AuthorID)
+		return
+	}
+
+	viewer, _ := s.viewerId(c)
+	visible, err := s.postVisibleTo(c.Request.Context(), viewer, post)
+	if err != nil {
+		s.internalServerError(c, "unable to get visibility of post: %v", err)
+		return
+	}
+	if !visible {
+		s.notFound(c, "post not visible to user %d", viewer)
+		return
+	}
 
-	// Get Author
-	a := s.getAuthor(post)
-	postsResponse := api.PostsResponse{
-		Posts: []api.Post{getApiPost(post)},
-		Users: []api.User{a},
+	postsResponse, err := s.postsResponse(viewer, []pgmodel.Post{post})
+	if err != nil {
+		s.internalServerError(c, "unable to build posts response: %v", err)
+		return
+	}
+	filter, err := s.loadPostFilter(viewer, pgmodel.FilterContextEverywhere)
+	if err != nil {
+		s.internalServerError(c, "unable to get muted words of user %d: %v", viewer, err)
+		return
 	}
+	filter.mark(postsResponse.Posts)
+	s.recordImpressions(c, postsResponse.Posts)
 
 	c.JSON(http.StatusOK, postsResponse)
 }
@@ -61,27 +217,84 @@ func (s *Server) apiV1PostsByAuthorUsername(c *gin.Context) {
 		return
 	}
 
-	var posts []pgmodel.Post
+	var user pgmodel.User
 	tx := s.pgDB.
-		Model(&pgmodel.Post{}).
-		Joins("JOIN users ON posts.author_id = users.id").
-		Where("users.username = ?", username).
-		Find(&posts)
+		Select("id", "deleted", "suspended_at").
+		Take(&user, "username = ?", username)
 	if tx.Error != nil {
 		if errors.Is(tx.Error, gorm.ErrRecordNotFound) {
-			s.notFound(c, "post not found")
+			s.notFound(c, "user not found")
 			return
 		}
+		s.internalServerError(c, "unable to get user with username %s: %v", username, tx.Error)
+		return
+	}
+	if userUnavailable(user) {
+		s.notFound(c, "user doesn't exist anymore")
+		return
+	}
+
+	viewer, _ := s.viewerId(c)
+	blocked, err := s.isBlocked(c.Request.Context(), viewer, user.ID)
+	if err != nil {
+		s.internalServerError(c, "unable to check blocks between users %d and %d: %v", viewer, user.ID, err)
+		return
+	}
+	if blocked {
+		s.notFound(c, "posts of %s hidden from user %d", username, viewer)
+		return
+	}
+
+	pinned, err := s.pinnedPosts(c.Request.Context(), viewer, user.ID)
+	if err != nil {
+		s.internalServerError(c, "unable to get pinned posts of %s: %v", username, err)
+		return
+	}
+
+	visibilities, err := s.visibleTo(c.Request.Context(), viewer, user.ID)
+	if err != nil {
+		s.internalServerError(c, "unable to get visibility of the posts of %s: %v", username, err)
+		return
+	}
+
+	// Pinned posts are shown first, and not repeated in the chronological list
+	tx = s.pgDB.
+		Model(&pgmodel.Post{}).
+		Where("posts.author_id = ? AND posts.deleted = false AND posts.visibility IN ?", user.ID, visibilities)
+	if len(pinned) > 0 {
+		var pinnedIds [][]byte
+		for _, p := range pinned {
+			pinnedIds = append(pinnedIds, p.ID)
+		}
+		tx = tx.Where("posts.id NOT IN ?", pinnedIds)
+	}
+
+	var posts []pgmodel.Post
+	tx = tx.
+		Order("posts.id DESC").
+		Find(&posts)
+	if tx.Error != nil {
 		s.internalServerError(c, "unable to get post with username %s: %v", username, tx.Error)
 		return
 	}
 
-	var apiPosts []api.Post
-	for _, v := range posts {
-		apiPosts = append(apiPosts, getApiPost(v))
+	postsResponse, err := s.postsResponse(viewer, append(pinned, posts...))
+	if err != nil {
+		s.internalServerError(c, "unable to build posts response: %v", err)
+		return
+	}
+	for i := range pinned {
+		postsResponse.Posts[i].Pinned = true
+	}
+	filter, err := s.loadPostFilter(viewer, pgmodel.FilterContextEverywhere)
+	if err != nil {
+		s.internalServerError(c, "unable to get muted words of user %d: %v", viewer, err)
+		return
 	}
+	postsResponse.Posts = filter.apply(postsResponse.Posts)
+	s.recordImpressions(c, postsResponse.Posts)
 
-	c.JSON(http.StatusOK, apiPosts)
+	c.JSON(http.StatusOK, postsResponse.Posts)
 }
 
 func (s *Server) apiV1PostsByAuthorId(c *gin.Context) {
@@ -0,0 +1,82 @@
+package server
+
+import (
+	"fmt"
+	"github.com/denysvitali/social/backend/pkg/models/api"
+	pgmodel "github.com/denysvitali/social/backend/pkg/models/postgres"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const defaultAnalyticsDays = 30
+const maxAnalyticsDays = 90
+
+func (s *Server) apiV1PostAnalytics(c *gin.Context) {
+	viewer, ok := s.requireViewer(c)
+	if !ok {
+		return
+	}
+
+	days := defaultAnalyticsDays
+	if v := c.Query("days"); v != "" {
+		d, err := strconv.Atoi(v)
+		if err != nil || d <= 0 || d > maxAnalyticsDays {
+			s.badRequest(c,
+				fmt.Sprintf("invalid days parameter %q", v),
+				fmt.Sprintf("days must be between 1 and %d", maxAnalyticsDays),
+			)
+			return
+		}
+		days = d
+	}
+
+	post, ok := s.findPostForAction(c)
+	if !ok {
+		return
+	}
+
+	if post.AuthorID != viewer {
+		s.forbidden(c, "user %d tried to access the analytics of a post of user %d", viewer, post.AuthorID)
+		return
+	}
+
+	today := time.Now().UTC().Truncate(24 * time.Hour)
+	since := today.AddDate(0, 0, -(days - 1))
+
+	var stats []pgmodel.PostDailyStat
+	tx := s.pgDB.
+		Where("post_id = ? AND day >= ?", post.ID, statDay(since)).
+		Order("day ASC").
+		Find(&stats)
+	if tx.Error != nil {
+		s.internalServerError(c, "unable to fetch post stats: %v", tx.Error)
+		return
+	}
+
+	statsByDay := map[string]pgmodel.PostDailyStat{}
+	for _, v := range stats {
+		statsByDay[statDay(v.Day)] = v
+	}
+
+	analytics := api.PostAnalytics{
+		PostID: bytesToUlid(post.ID).String(),
+		Days:   []api.PostDailyStats{},
+	}
+	for d := since; !d.After(today); d = d.AddDate(0, 0, 1) {
+		day := statDay(d)
+		v := statsByDay[day]
+		analytics.Days = append(analytics.Days, api.PostDailyStats{
+			Day:      day,
+			Views:    v.Views,
+			Likes:    v.Likes,
+			Reshares: v.Reshares,
+		})
+		analytics.Views += v.Views
+		analytics.Likes += v.Likes
+		analytics.Reshares += v.Reshares
+	}
+
+	c.JSON(http.StatusOK, analytics)
+}
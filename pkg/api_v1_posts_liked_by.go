@@ -1,24 +1,43 @@
 package server
 
 import (
-	"fmt"
 	pgmodel "github.com/denysvitali/social/backend/pkg/models/postgres"
 	"github.com/gin-gonic/gin"
 	"net/http"
 )
 
+// apiV1PostLikedBy lists the users who liked a post, leaving out the ones hidden from the viewer
 func (s *Server) apiV1PostLikedBy(c *gin.Context) {
-	postId, err := parsePostId(c)
+	post, ok := s.findPostForAction(c)
+	if !ok {
+		return
+	}
+	viewer, _ := s.viewerId(c)
+	visible, err := s.postVisibleTo(c.Request.Context(), viewer, post)
 	if err != nil {
-		s.badRequest(c, fmt.Sprintf("unable to parse post id: %v", err), "invalid post id")
+		s.internalServerError(c, "unable to get visibility of post: %v", err)
+		return
+	}
+	if !visible {
+		s.notFound(c, "post not visible to user %d", viewer)
+		return
+	}
+
+	hidden, err := s.hiddenUserIds(c.Request.Context(), viewer, true)
+	if err != nil {
+		s.internalServerError(c, "unable to get users hidden from user %d: %v", viewer, err)
 		return
 	}
-	var users []pgmodel.User
 	tx := s.pgDB.
 		Model(pgmodel.User{}).
 		Joins("JOIN user_likes ON user_likes.user_id = users.id").
+		Where("user_likes.post_id = ? AND users.deleted = false AND users.suspended_at IS NULL", post.ID)
+	if len(hidden) > 0 {
+		tx = tx.Where("users.id NOT IN ?", hidden)
+	}
+	var users []pgmodel.User
+	tx = tx.
 		Limit(50).
-		Where("user_likes.post_id = ?", postId).
 		Find(&users)
 	if tx.Error != nil {
 		s.internalServerError(c, "unable to find likes by post: %v", tx.Error)
@@ -0,0 +1,70 @@
+package server
+
+import (
+	"database/sql/driver"
+	"github.com/gin-gonic/gin"
+	"github.com/oklog/ulid/v2"
+	"github.com/sirupsen/logrus"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestPostLikedBy(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	for _, tt := range []struct {
+		visibility string
+		viewer     string
+		want       int
+	}{
+		{"public", "", http.StatusOK},
+		{"followers", "", http.StatusNotFound},
+		{"followers", "3", http.StatusNotFound},
+		// The author sees the likers of their own posts
+		{"followers", "2", http.StatusOK},
+	} {
+		postId := ulid.Make()
+		db, fake := newFakeDB(t, func(query string, args []driver.Value) (fakeResult, error) {
+			switch {
+			case strings.Contains(query, `FROM "posts"`):
+				return fakeResult{
+					columns: []string{"id", "author_id", "visibility", "deleted"},
+					rows:    [][]driver.Value{{postId.Bytes(), int64(2), tt.visibility, false}},
+				}, nil
+			case strings.Contains(query, `FROM "users"`):
+				return fakeResult{
+					columns: []string{"id", "username"},
+					rows:    [][]driver.Value{{int64(4), "alice"}},
+				}, nil
+			}
+			return fakeResult{}, nil
+		})
+		log := logrus.New()
+		log.SetOutput(io.Discard)
+		s := &Server{logger: log, pgDB: db}
+		e := gin.New()
+		e.GET("/posts/:id/liked_by", s.apiV1PostLikedBy)
+
+		req := httptest.NewRequest(http.MethodGet, "/posts/"+postId.String()+"/liked_by", nil)
+		if tt.viewer != "" {
+			req.Header.Set(ViewerHeader, tt.viewer)
+		}
+		w := httptest.NewRecorder()
+		e.ServeHTTP(w, req)
+		if w.Code != tt.want {
+			t.Errorf("%s post, viewer %q: got status %d, want %d", tt.visibility, tt.viewer, w.Code, tt.want)
+		}
+
+		if tt.want != http.StatusOK {
+			continue
+		}
+		for _, s := range fake.statements {
+			if strings.Contains(s.query, `FROM "users"`) &&
+				(!strings.Contains(s.query, "users.suspended_at IS NULL") || !strings.Contains(s.query, "users.deleted = false")) {
+				t.Errorf("likers aren't filtered: %s", s.query)
+			}
+		}
+	}
+}
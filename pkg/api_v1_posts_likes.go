@@ -0,0 +1,138 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"github.com/denysvitali/social/backend/pkg/models/api"
+	pgmodel "github.com/denysvitali/social/backend/pkg/models/postgres"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"net/http"
+	"time"
+)
+
+func (s *Server) apiV1LikePost(c *gin.Context) {
+	viewer, ok := s.requireViewer(c)
+	if !ok {
+		return
+	}
+
+	post, ok := s.findPostForAction(c)
+	if !ok {
+		return
+	}
+	visible, err := s.postVisibleTo(c.Request.Context(), viewer, post)
+	if err != nil {
+		s.internalServerError(c, "unable to get visibility of post: %v", err)
+		return
+	}
+	if !visible {
+		s.notFound(c, "post not visible to user %d", viewer)
+		return
+	}
+
+	liked := false
+	err = s.pgDB.Transaction(func(tx *gorm.DB) error {
+		res := tx.Exec(
+			// post.ID can't be the first value, see incrementPostStat
+			"INSERT INTO user_likes (user_id, post_id) VALUES (?, ?) ON CONFLICT DO NOTHING",
+			viewer, post.ID,
+		)
+		if res.Error != nil {
+			return res.Error
+		}
+		if res.RowsAffected == 0 {
+			// Already liked
+			return nil
+		}
+		liked = true
+
+		err := tx.Model(&pgmodel.Post{}).
+			Where("id = ?", post.ID).
+			UpdateColumn("likes", gorm.Expr("likes + 1")).Error
+		if err != nil {
+			return err
+		}
+		return incrementPostStat(tx, post.ID, time.Now(), statLikes)
+	})
+	if err != nil {
+		s.internalServerError(c, "unable to like post: %v", err)
+		return
+	}
+	if liked {
+		s.notify(post.AuthorID, pgmodel.NotificationLike, viewer, post.ID)
+		s.publishCounters(post)
+		s.emitWebhookEvent(pgmodel.WebhookEventPostLiked, func() (any, error) {
+			return api.PostLikedEvent{
+				Post:   bytesToUlid(post.ID).String(),
+				Author: post.AuthorID,
+				User:   viewer,
+			}, nil
+		})
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+func (s *Server) apiV1UnlikePost(c *gin.Context) {
+	viewer, ok := s.requireViewer(c)
+	if !ok {
+		return
+	}
+
+	post, ok := s.findPostForAction(c)
+	if !ok {
+		return
+	}
+
+	// Daily stats count like events: removing a like doesn't rewrite history
+	unliked := false
+	err := s.pgDB.Transaction(func(tx *gorm.DB) error {
+		res := tx.Exec("DELETE FROM user_likes WHERE post_id = ? AND user_id = ?", post.ID, viewer)
+		if res.Error != nil {
+			return res.Error
+		}
+		if res.RowsAffected == 0 {
+			return nil
+		}
+		unliked = true
+		return tx.Model(&pgmodel.Post{}).
+			Where("id = ?", post.ID).
+			UpdateColumn("likes", gorm.Expr("GREATEST(likes, 1) - 1")).Error
+	})
+	if err != nil {
+		s.internalServerError(c, "unable to unlike post: %v", err)
+		return
+	}
+	if unliked {
+		s.unnotify(post.AuthorID, pgmodel.NotificationLike, viewer, post.ID)
+		s.publishCounters(post)
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// findPostForAction fetches the (non-deleted) post identified by the "id" parameter.
+// When the post can't be fetched, it replies to the request and returns false.
+func (s *Server) findPostForAction(c *gin.Context) (pgmodel.Post, bool) {
+	var post pgmodel.Post
+
+	postId, err := parsePostId(c)
+	if err != nil {
+		s.badRequest(c, fmt.Sprintf("unable to parse post id: %v", err), "invalid post id")
+		return post, false
+	}
+
+	tx := excludeSuspendedAuthors(s.pgDB).
+		Where("id = ? AND deleted = false", postId).
+		Take(&post)
+	if tx.Error != nil {
+		if errors.Is(tx.Error, gorm.ErrRecordNotFound) {
+			s.notFound(c, "post not found")
+			return post, false
+		}
+		s.internalServerError(c, "unable to fetch post: %v", tx.Error)
+		return post, false
+	}
+	return post, true
+}
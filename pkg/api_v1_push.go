@@ -0,0 +1,140 @@
+package server
+
+import (
+	"fmt"
+	"github.com/denysvitali/social/backend/pkg/models/api"
+	pgmodel "github.com/denysvitali/social/backend/pkg/models/postgres"
+	v1requests "github.com/denysvitali/social/backend/pkg/requests/v1"
+	"github.com/denysvitali/social/backend/pkg/webpush"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm/clause"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxUserAgentLength is the length the user agent of a push subscription is truncated to
+const maxUserAgentLength = 256
+
+func (s *Server) apiV1VAPIDPublicKey(c *gin.Context) {
+	if s.push == nil {
+		s.notFound(c, "web push is not configured")
+		return
+	}
+	c.JSON(http.StatusOK, api.VAPIDPublicKey{Key: s.push.Key.PublicKey()})
+}
+
+func (s *Server) apiV1GetPushSubscriptions(c *gin.Context) {
+	viewer, ok := s.requireViewer(c)
+	if !ok {
+		return
+	}
+
+	var subscriptions []pgmodel.PushSubscription
+	err := s.pgDB.
+		Where("user_id = ?", viewer).
+		Order("id ASC").
+		Find(&subscriptions).Error
+	if err != nil {
+		s.internalServerError(c, "unable to get push subscriptions of user %d: %v", viewer, err)
+		return
+	}
+
+	response := api.PushSubscriptionsResponse{Subscriptions: []api.PushSubscription{}}
+	for _, sub := range subscriptions {
+		response.Subscriptions = append(response.Subscriptions, getApiPushSubscription(sub))
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// apiV1CreatePushSubscription registers a device of the viewer. Subscribing again with the same endpoint,
+// e.g. after the browser rotated its keys or another user logged in, replaces the subscription.
+func (s *Server) apiV1CreatePushSubscription(c *gin.Context) {
+	viewer, ok := s.requireViewer(c)
+	if !ok {
+		return
+	}
+	if s.push == nil {
+		s.notFound(c, "web push is not configured")
+		return
+	}
+
+	var req v1requests.CreatePushSubscription
+	err := c.BindJSON(&req)
+	if err != nil {
+		s.badRequest(c,
+			fmt.Sprintf("unable to bind JSON: %v", err),
+			"unable to parse JSON",
+		)
+		return
+	}
+	err = webpush.Subscription{
+		Endpoint: req.Endpoint,
+		P256dh:   req.Keys.P256dh,
+		Auth:     req.Keys.Auth,
+	}.Validate(s.pushAllowHTTP)
+	if err != nil {
+		s.badRequest(c, fmt.Sprintf("invalid push subscription: %v", err), err.Error())
+		return
+	}
+
+	userAgent := c.Request.UserAgent()
+	if len(userAgent) > maxUserAgentLength {
+		userAgent = strings.ToValidUTF8(userAgent[:maxUserAgentLength], "")
+	}
+	sub := pgmodel.PushSubscription{
+		UserID:    viewer,
+		Endpoint:  req.Endpoint,
+		P256dh:    req.Keys.P256dh,
+		Auth:      req.Keys.Auth,
+		UserAgent: userAgent,
+		CreatedAt: time.Now(),
+	}
+	err = s.pgDB.
+		Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "endpoint"}},
+			DoUpdates: clause.AssignmentColumns([]string{"user_id", "p256dh", "auth", "user_agent", "created_at"}),
+		}).
+		Create(&sub).Error
+	if err != nil {
+		s.internalServerError(c, "unable to create push subscription: %v", err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, getApiPushSubscription(sub))
+}
+
+func (s *Server) apiV1DeletePushSubscription(c *gin.Context) {
+	viewer, ok := s.requireViewer(c)
+	if !ok {
+		return
+	}
+
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		s.badRequest(c, fmt.Sprintf("invalid id: %v", err), "invalid id")
+		return
+	}
+
+	tx := s.pgDB.Delete(&pgmodel.PushSubscription{}, "id = ? AND user_id = ?", id, viewer)
+	if tx.Error != nil {
+		s.internalServerError(c, "unable to delete push subscription %d: %v", id, tx.Error)
+		return
+	}
+	if tx.RowsAffected == 0 {
+		s.notFound(c, "push subscription %d of user %d not found", id, viewer)
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+func getApiPushSubscription(sub pgmodel.PushSubscription) api.PushSubscription {
+	return api.PushSubscription{
+		ID:        sub.ID,
+		Endpoint:  sub.Endpoint,
+		UserAgent: sub.UserAgent,
+		CreatedAt: sub.CreatedAt,
+	}
+}
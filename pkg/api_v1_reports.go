@@ -0,0 +1,235 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"github.com/denysvitali/social/backend/pkg/models/api"
+	pgmodel "github.com/denysvitali/social/backend/pkg/models/postgres"
+	v1requests "github.com/denysvitali/social/backend/pkg/requests/v1"
+	"github.com/gin-gonic/gin"
+	"github.com/oklog/ulid/v2"
+	"gorm.io/gorm"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// MaxReportCommentLength is the maximum length of the comment of a report, in Unicode code points
+const MaxReportCommentLength = 1000
+
+// ReportReasons are the reasons a post or a user can be reported for
+var ReportReasons = []string{
+	pgmodel.ReportReasonSpam,
+	pgmodel.ReportReasonHarassment,
+	pgmodel.ReportReasonHate,
+	pgmodel.ReportReasonViolence,
+	pgmodel.ReportReasonSexual,
+	pgmodel.ReportReasonMisinformation,
+	pgmodel.ReportReasonOther,
+}
+
+// apiV1CreateReport reports a post or a user to the moderators. Reporting the same target again while
+// the first report is still open returns the first report.
+func (s *Server) apiV1CreateReport(c *gin.Context) {
+	viewer, ok := s.requireViewer(c)
+	if !ok {
+		return
+	}
+
+	var req v1requests.CreateReport
+	err := c.BindJSON(&req)
+	if err != nil {
+		s.badRequest(c,
+			fmt.Sprintf("unable to bind JSON: %v", err),
+			"unable to parse JSON",
+		)
+		return
+	}
+	if (req.Post == "") == (req.User == 0) {
+		s.badRequest(c, "report without a single target", "either post or user must be set")
+		return
+	}
+	if !isReportReason(req.Reason) {
+		s.badRequest(c,
+			fmt.Sprintf("invalid report reason %q", req.Reason),
+			"reason must be one of "+strings.Join(ReportReasons, ", "),
+		)
+		return
+	}
+	comment := strings.TrimSpace(req.Comment)
+	if utf8.RuneCountInString(comment) > MaxReportCommentLength {
+		s.badRequest(c, "comment too long",
+			fmt.Sprintf("comment cannot be longer than %d characters", MaxReportCommentLength),
+		)
+		return
+	}
+
+	report := pgmodel.Report{
+		ID:         ulid.Make().Bytes(),
+		ReporterID: viewer,
+		Reason:     req.Reason,
+		Comment:    comment,
+		Status:     pgmodel.ReportOpen,
+		CreatedAt:  time.Now(),
+	}
+	if req.Post != "" {
+		postId, err := ulid.Parse(req.Post)
+		if err != nil {
+			s.badRequest(c, fmt.Sprintf("invalid post id: %v", err), "invalid post id")
+			return
+		}
+		var post pgmodel.Post
+		tx := excludeSuspendedAuthors(s.pgDB).Take(&post, "id = ? AND deleted = false", postId)
+		if tx.Error != nil {
+			if errors.Is(tx.Error, gorm.ErrRecordNotFound) {
+				s.notFound(c, "post %s not found", postId)
+				return
+			}
+			s.internalServerError(c, "unable to get post %s: %v", postId, tx.Error)
+			return
+		}
+		visible, err := s.postVisibleTo(c.Request.Context(), viewer, post)
+		if err != nil {
+			s.internalServerError(c, "unable to check visibility of post %s: %v", postId, err)
+			return
+		}
+		if !visible {
+			s.notFound(c, "post %s not visible to user %d", postId, viewer)
+			return
+		}
+		if post.AuthorID == viewer {
+			s.badRequest(c, fmt.Sprintf("user %d tried to report their own post", viewer), "you cannot report your own post")
+			return
+		}
+		report.TargetType = pgmodel.ReportTargetPost
+		report.TargetKey = pgmodel.ReportTargetPost + ":" + postId.String()
+		report.TargetPostID = &post.ID
+		report.TargetUserID = post.AuthorID
+	} else {
+		if req.User == viewer {
+			s.badRequest(c, fmt.Sprintf("user %d tried to report themselves", viewer), "you cannot report yourself")
+			return
+		}
+		var user pgmodel.User
+		tx := s.pgDB.Select("id", "deleted", "suspended_at").Take(&user, "id = ?", req.User)
+		if tx.Error != nil {
+			if errors.Is(tx.Error, gorm.ErrRecordNotFound) {
+				s.notFound(c, "user %d not found", req.User)
+				return
+			}
+			s.internalServerError(c, "unable to get user %d: %v", req.User, tx.Error)
+			return
+		}
+		if userUnavailable(user) {
+			s.notFound(c, "user %d doesn't exist anymore", req.User)
+			return
+		}
+		report.TargetType = pgmodel.ReportTargetUser
+		report.TargetKey = pgmodel.ReportTargetUser + ":" + strconv.FormatUint(user.ID, 10)
+		report.TargetUserID = user.ID
+	}
+
+	var existing pgmodel.Report
+	tx := s.pgDB.
+		Where("reporter_id = ? AND target_key = ? AND status = ?", viewer, report.TargetKey, pgmodel.ReportOpen).
+		Limit(1).
+		Find(&existing)
+	if tx.Error != nil {
+		s.internalServerError(c, "unable to get reports of user %d: %v", viewer, tx.Error)
+		return
+	}
+	if tx.RowsAffected > 0 {
+		c.JSON(http.StatusOK, getApiReport(existing))
+		return
+	}
+
+	tx = s.pgDB.Create(&report)
+	if tx.Error != nil {
+		s.internalServerError(c, "unable to create report: %v", tx.Error)
+		return
+	}
+	c.JSON(http.StatusCreated, getApiReport(report))
+}
+
+// apiV1GetWarnings lists the warnings the moderators gave to the viewer, newest first
+func (s *Server) apiV1GetWarnings(c *gin.Context) {
+	viewer, ok := s.requireViewer(c)
+	if !ok {
+		return
+	}
+
+	p, err := parsePage(c)
+	if err != nil {
+		s.badRequest(c, fmt.Sprintf("invalid pagination: %v", err), err.Error())
+		return
+	}
+
+	tx := s.pgDB.Where("user_id = ?", viewer)
+	if p.Cursor != nil {
+		tx = tx.Where("id < ?", p.Cursor)
+	}
+	var warnings []pgmodel.Warning
+	tx = tx.
+		Order("id DESC").
+		Limit(p.Limit).
+		Find(&warnings)
+	if tx.Error != nil {
+		s.internalServerError(c, "unable to get warnings of user %d: %v", viewer, tx.Error)
+		return
+	}
+
+	response := api.WarningsResponse{Warnings: []api.Warning{}}
+	for _, w := range warnings {
+		response.Warnings = append(response.Warnings, api.Warning{
+			ID:        bytesToUlid(w.ID).String(),
+			Reason:    w.Reason,
+			Message:   w.Message,
+			CreatedAt: w.CreatedAt,
+		})
+	}
+	if len(warnings) > 0 {
+		response.NextCursor = p.nextCursor(len(warnings), warnings[len(warnings)-1].ID)
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+func isReportReason(reason string) bool {
+	for _, r := range ReportReasons {
+		if r == reason {
+			return true
+		}
+	}
+	return false
+}
+
+func getApiReport(r pgmodel.Report) api.Report {
+	report := api.Report{
+		ID:         bytesToUlid(r.ID).String(),
+		TargetType: r.TargetType,
+		User:       r.TargetUserID,
+		Reason:     r.Reason,
+		Comment:    r.Comment,
+		Status:     r.Status,
+		Action:     r.Action,
+		CreatedAt:  r.CreatedAt,
+		ResolvedAt: r.ResolvedAt,
+	}
+	if r.TargetPostID != nil {
+		report.Post = bytesToUlid(*r.TargetPostID).String()
+	}
+	return report
+}
+
+func getApiReportOutcome(r pgmodel.Report) *api.ReportOutcome {
+	report := getApiReport(r)
+	return &api.ReportOutcome{
+		ID:         report.ID,
+		TargetType: report.TargetType,
+		Post:       report.Post,
+		User:       report.User,
+		Reason:     report.Reason,
+		Action:     report.Action,
+	}
+}
@@ -0,0 +1,304 @@
+package server
+
+import (
+	"fmt"
+	"github.com/denysvitali/social/backend/pkg/models/api"
+	pgmodel "github.com/denysvitali/social/backend/pkg/models/postgres"
+	"github.com/denysvitali/social/backend/pkg/searchquery"
+	"github.com/gin-gonic/gin"
+	"github.com/oklog/ulid/v2"
+	"gorm.io/gorm"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const maxSearchQueryLength = 256
+
+const defaultUserSearchLimit = 10
+const maxUserSearchLimit = 50
+
+// Boosts added to the similarity score of users, which is between 0 and 1
+const (
+	userSearchPrefixBoost      = 1.0
+	userSearchWordPrefixBoost  = 0.6
+	userSearchFollowedBoost    = 0.5
+	userSearchVerifiedBoost    = 0.3
+	userSearchFollowGraphBoost = 0.2
+)
+
+// Sort orders of post search results
+const (
+	searchSortRelevance = "relevance"
+	searchSortBlended   = "blended"
+	searchSortRecent    = "recent"
+)
+
+// searchCursor is the position of the last result of a page of search results
+type searchCursor struct {
+	Score float64 `json:"s"`
+	ID    string  `json:"i"`
+	// Reference is the time (unix ms) the age of posts is computed against, so that scores
+	// blended with recency don't drift between pages
+	Reference int64 `json:"r,omitempty"`
+}
+
+// apiV1SearchPosts runs a full-text search over public posts, see package searchquery for the syntax of q
+func (s *Server) apiV1SearchPosts(c *gin.Context) {
+	q := strings.TrimSpace(c.Query("q"))
+	if q == "" {
+		s.paramCantBeEmpty(c, "q")
+		return
+	}
+	if len(q) > maxSearchQueryLength {
+		s.badRequest(c, "search query is too long", fmt.Sprintf("q cannot be longer than %d bytes", maxSearchQueryLength))
+		return
+	}
+
+	var compiled searchquery.Compiled
+	query, err := searchquery.Parse(q)
+	if err == nil {
+		compiled, err = searchquery.Compile(query)
+	}
+	if err != nil {
+		s.badRequest(c, fmt.Sprintf("invalid search query %q: %v", q, err), err.Error())
+		return
+	}
+	if compiled.Text == "" && compiled.Where == "" {
+		s.badRequest(c, fmt.Sprintf("empty search query %q", q), "search query has no words or operators")
+		return
+	}
+
+	sortOrder := c.DefaultQuery("sort", searchSortRelevance)
+	switch sortOrder {
+	case searchSortRelevance, searchSortBlended, searchSortRecent:
+	default:
+		s.badRequest(c, fmt.Sprintf("invalid sort %q", sortOrder), "sort must be one of relevance, blended, recent")
+		return
+	}
+
+	limit, err := parseLimit(c)
+	if err != nil {
+		s.badRequest(c, fmt.Sprintf("invalid pagination: %v", err), err.Error())
+		return
+	}
+
+	cursor := searchCursor{Reference: time.Now().UnixMilli()}
+	var cursorId *ulid.ULID
+	if v := c.Query("cursor"); v != "" {
+		err = decodeCursor(v, &cursor)
+		if err == nil {
+			var u ulid.ULID
+			u, err = ulid.Parse(cursor.ID)
+			cursorId = &u
+		}
+		if err != nil {
+			s.badRequest(c, fmt.Sprintf("invalid search cursor %q: %v", v, err), "invalid cursor")
+			return
+		}
+	}
+
+	language := c.DefaultQuery("lang", defaultSearchLanguage)
+	vars := map[string]any{
+		"config":    searchConfig(language),
+		"q":         compiled.Text,
+		"reference": time.UnixMilli(cursor.Reference),
+		"half_life": searchHalfLife,
+		"limit":     limit,
+	}
+
+	from := "posts"
+	where := "posts.deleted = false AND posts.visibility = 'public' AND " + notSuspendedAuthor
+	// Normalized rank, between 0 and 1. Queries made only of filters have no rank, and are sorted by date.
+	score := "0"
+	if compiled.Text != "" {
+		from += ", websearch_to_tsquery(@config::regconfig, @q) query"
+		where += " AND posts.search_vector @@ query"
+		score = "ts_rank_cd(posts.search_vector, query, 32)"
+		if sortOrder == searchSortBlended {
+			score += " * power(0.5, GREATEST(EXTRACT(EPOCH FROM (@reference - posts.created_at)), 0) / @half_life)"
+		}
+	}
+	viewer, _ := s.viewerId(c)
+	hidden, err := s.hiddenUserIds(c.Request.Context(), viewer, true)
+	if err != nil {
+		s.internalServerError(c, "unable to get users hidden from user %d: %v", viewer, err)
+		return
+	}
+	if len(hidden) > 0 {
+		where += " AND posts.author_id NOT IN @hidden"
+		vars["hidden"] = hidden
+	}
+	if compiled.Where != "" {
+		where += " AND @filters"
+		vars["filters"] = gorm.Expr(compiled.Where, compiled.Args...)
+	}
+
+	pagination := ""
+	order := "ranked.score DESC, ranked.id DESC"
+	if sortOrder == searchSortRecent {
+		order = "ranked.id DESC"
+		if cursorId != nil {
+			pagination = "WHERE ranked.id < @cursor_id"
+		}
+	} else if cursorId != nil {
+		pagination = "WHERE (ranked.score < @cursor_score OR (ranked.score = @cursor_score AND ranked.id < @cursor_id))"
+	}
+	if cursorId != nil {
+		vars["cursor_id"] = cursorId
+		vars["cursor_score"] = cursor.Score
+	}
+
+	var results []struct {
+		pgmodel.Post
+		Score float64
+	}
+	tx := s.pgDB.Raw(`
+		SELECT * FROM (
+			SELECT posts.*, `+score+` AS score
+			FROM `+from+`
+			WHERE `+where+`
+		) ranked
+		`+pagination+`
+		ORDER BY `+order+`
+		LIMIT @limit`,
+		vars,
+	).Scan(&results)
+	if tx.Error != nil {
+		s.internalServerError(c, "unable to search posts: %v", tx.Error)
+		return
+	}
+
+	var posts []pgmodel.Post
+	for _, r := range results {
+		posts = append(posts, r.Post)
+	}
+
+	postsResponse, err := s.postsResponse(viewer, posts)
+	if err != nil {
+		s.internalServerError(c, "unable to build posts response: %v", err)
+		return
+	}
+
+	if len(results) == limit {
+		last := results[len(results)-1]
+		postsResponse.NextCursor = encodeCursor(searchCursor{
+			Score:     last.Score,
+			ID:        bytesToUlid(last.ID).String(),
+			Reference: cursor.Reference,
+		})
+	}
+	filter, err := s.loadPostFilter(viewer, pgmodel.FilterContextEverywhere)
+	if err != nil {
+		s.internalServerError(c, "unable to get muted words of user %d: %v", viewer, err)
+		return
+	}
+	postsResponse.Posts = filter.apply(postsResponse.Posts)
+
+	c.JSON(http.StatusOK, postsResponse)
+}
+
+// apiV1SearchUsers finds users by username or display name, tolerating typos. It also backs the
+// mention autocomplete of the composer, so a leading @ is ignored and prefixes rank first.
+func (s *Server) apiV1SearchUsers(c *gin.Context) {
+	q := strings.ToLower(strings.TrimPrefix(strings.TrimSpace(c.Query("q")), "@"))
+	if q == "" {
+		s.paramCantBeEmpty(c, "q")
+		return
+	}
+	if len(q) > maxSearchQueryLength {
+		s.badRequest(c, "search query is too long", fmt.Sprintf("q cannot be longer than %d bytes", maxSearchQueryLength))
+		return
+	}
+
+	limit := defaultUserSearchLimit
+	if v := c.Query("limit"); v != "" {
+		l, err := strconv.Atoi(v)
+		if err != nil || l <= 0 || l > maxUserSearchLimit {
+			s.badRequest(c,
+				fmt.Sprintf("invalid limit %q", v),
+				fmt.Sprintf("limit must be between 1 and %d", maxUserSearchLimit),
+			)
+			return
+		}
+		limit = l
+	}
+
+	viewer, _ := s.viewerId(c)
+	hidden, err := s.hiddenUserIds(c.Request.Context(), viewer, true)
+	if err != nil {
+		s.internalServerError(c, "unable to get users hidden from user %d: %v", viewer, err)
+		return
+	}
+	hiddenFilter := ""
+	if len(hidden) > 0 {
+		hiddenFilter = "AND users.id NOT IN @hidden"
+	}
+
+	// Accounts close to the viewer in the follow graph rank higher
+	var followed, followedByFollowed []uint64
+	if viewer != 0 && s.arangoDB != nil {
+		distances, err := s.followDistances(c.Request.Context(), viewer, 2)
+		if err != nil {
+			s.internalServerError(c, "unable to get follow graph of user %d: %v", viewer, err)
+			return
+		}
+		for id, depth := range distances {
+			if depth == 1 {
+				followed = append(followed, id)
+			} else {
+				followedByFollowed = append(followedByFollowed, id)
+			}
+		}
+	}
+
+	var users []pgmodel.User
+	tx := s.pgDB.Raw(`
+		SELECT users.* FROM (
+			SELECT users.*,
+				GREATEST(similarity(lower(users.username), @q), word_similarity(@q, lower(users.display_name)))
+				+ CASE WHEN lower(users.username) LIKE @prefix ESCAPE '\' THEN @prefix_boost ELSE 0 END
+				+ CASE WHEN lower(users.display_name) LIKE @prefix ESCAPE '\'
+					OR lower(users.display_name) LIKE @word_prefix ESCAPE '\' THEN @word_prefix_boost ELSE 0 END
+				+ CASE WHEN users.verified THEN @verified_boost ELSE 0 END
+				+ CASE WHEN users.id IN @followed THEN @followed_boost ELSE 0 END
+				+ CASE WHEN users.id IN @followed_by_followed THEN @follow_graph_boost ELSE 0 END
+				AS score
+			FROM users
+			WHERE users.deleted = false AND users.suspended_at IS NULL `+hiddenFilter+` AND (
+				lower(users.username) LIKE @prefix ESCAPE '\'
+				OR lower(users.display_name) LIKE @word_prefix ESCAPE '\'
+				OR lower(users.username) % @q
+				OR lower(users.display_name) % @q
+			)
+		) users
+		ORDER BY users.score DESC, users.followers_count DESC, users.id ASC
+		LIMIT @limit`,
+		map[string]any{
+			"q":                    q,
+			"prefix":               escapeLike(q) + "%",
+			"word_prefix":          "% " + escapeLike(q) + "%",
+			"prefix_boost":         userSearchPrefixBoost,
+			"word_prefix_boost":    userSearchWordPrefixBoost,
+			"verified_boost":       userSearchVerifiedBoost,
+			"followed":             followed,
+			"followed_boost":       userSearchFollowedBoost,
+			"followed_by_followed": followedByFollowed,
+			"follow_graph_boost":   userSearchFollowGraphBoost,
+			"hidden":               hidden,
+			"limit":                limit,
+		},
+	).Scan(&users)
+	if tx.Error != nil {
+		s.internalServerError(c, "unable to search users: %v", tx.Error)
+		return
+	}
+
+	usersResponse := api.UsersResponse{Users: []api.User{}}
+	for _, u := range users {
+		usersResponse.Users = append(usersResponse.Users, getApiUser(u))
+	}
+
+	c.JSON(http.StatusOK, usersResponse)
+}
@@ -0,0 +1,210 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/denysvitali/social/backend/pkg/models/api"
+	pgmodel "github.com/denysvitali/social/backend/pkg/models/postgres"
+	"github.com/denysvitali/social/backend/pkg/pubsub"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// streamHeartbeatInterval is the interval between the comments sent to keep idle streams open through
+// proxies, and to detect disconnected clients
+const streamHeartbeatInterval = 25 * time.Second
+
+const (
+	// streamHome receives the posts of the home timeline of the viewer, and their notifications
+	streamHome = "home"
+	// streamPublic receives all the public posts, and the notifications of the viewer if there is one
+	streamPublic = "public"
+)
+
+// apiV1Streaming streams events to the client as server-sent events. Clients resume a stream by
+// reconnecting with the Last-Event-ID header (sent automatically by EventSource) or the lastEventId
+// parameter; a "reset" event is sent first when the events since then can't be replayed.
+//
+// The followed users and tags of the home stream, and the blocked and muted users and the muted words
+// whose posts are filtered out, are resolved when the stream starts: clients reconnect to pick up changes.
+func (s *Server) apiV1Streaming(c *gin.Context) {
+	stream := c.DefaultQuery("stream", streamHome)
+
+	var viewer uint64
+	var topics []string
+	switch stream {
+	case streamHome:
+		var ok bool
+		viewer, ok = s.requireViewer(c)
+		if !ok {
+			return
+		}
+		var err error
+		topics, err = s.homeTopics(c, viewer)
+		if err != nil {
+			s.internalServerError(c, "unable to get home topics of user %d: %v", viewer, err)
+			return
+		}
+	case streamPublic:
+		topics = []string{publicTopic}
+		if v, ok := s.viewerId(c); ok {
+			viewer = v
+			topics = append(topics, userTopic(viewer))
+		}
+	default:
+		s.badRequest(c, fmt.Sprintf("invalid stream %q", stream), "stream must be one of home, public")
+		return
+	}
+
+	lastEventID, err := parseLastEventID(c)
+	if err != nil {
+		s.badRequest(c, fmt.Sprintf("invalid last event ID: %v", err), "invalid last event ID")
+		return
+	}
+
+	hidden, err := s.hiddenUserIds(c.Request.Context(), viewer, true)
+	if err != nil {
+		s.internalServerError(c, "unable to get users hidden from user %d: %v", viewer, err)
+		return
+	}
+	// Posts are published on the topic of their author
+	hiddenTopics := map[string]bool{}
+	for _, id := range hidden {
+		hiddenTopics[authorTopic(id)] = true
+	}
+	filterContext := pgmodel.FilterContextEverywhere
+	if stream == streamHome {
+		filterContext = pgmodel.FilterContextHome
+	}
+	filter, err := s.loadPostFilter(viewer, filterContext)
+	if err != nil {
+		s.internalServerError(c, "unable to get muted words of user %d: %v", viewer, err)
+		return
+	}
+
+	sub := s.broker.Subscribe(topics, lastEventID)
+	defer sub.Close()
+
+	h := c.Writer.Header()
+	h.Set("Content-Type", "text/event-stream")
+	h.Set("Cache-Control", "no-cache")
+	h.Set("Connection", "keep-alive")
+	// Disables the response buffering of nginx
+	h.Set("X-Accel-Buffering", "no")
+	c.Status(http.StatusOK)
+
+	if sub.Missed {
+		_, err = fmt.Fprintf(c.Writer, "event: %s\ndata: {}\n\n", streamEventReset)
+		if err != nil {
+			return
+		}
+	}
+	c.Writer.Flush()
+
+	heartbeat := time.NewTicker(streamHeartbeatInterval)
+	defer heartbeat.Stop()
+
+	ctx := c.Request.Context()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case e, ok := <-sub.C:
+			if !ok {
+				// Dropped by the broker: the client reconnects and resumes from the last event
+				return
+			}
+			if publishedOnAny(e.Topics, hiddenTopics) {
+				continue
+			}
+			if e.Type == streamEventPost {
+				if e.Data, ok = s.filterStreamedPost(e, filter); !ok {
+					continue
+				}
+			}
+			_, err = fmt.Fprintf(c.Writer, "id: %d\nevent: %s\ndata: %s\n\n", e.ID, e.Type, e.Data)
+		case <-heartbeat.C:
+			_, err = fmt.Fprint(c.Writer, ": heartbeat\n\n")
+		}
+		if err != nil {
+			return
+		}
+		c.Writer.Flush()
+	}
+}
+
+// homeTopics returns the topics of the home stream of viewer: their notifications, their posts, and the
+// posts of the users and tags they follow
+func (s *Server) homeTopics(c *gin.Context, viewer uint64) ([]string, error) {
+	topics := []string{userTopic(viewer), authorTopic(viewer)}
+
+	ctx := c.Request.Context()
+	followedUsers, err := s.outboundIds(ctx, RelationFollows, userVertex(viewer), UsersCollection)
+	if errors.Is(err, errGraphUnavailable) {
+		return topics, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	for _, u := range followedUsers {
+		topics = append(topics, authorTopic(u))
+	}
+
+	followedTags, err := s.outboundIds(ctx, RelationFollows, userVertex(viewer), TagsCollection)
+	if err != nil {
+		return nil, err
+	}
+	for _, t := range followedTags {
+		topics = append(topics, tagTopic(t))
+	}
+	return topics, nil
+}
+
+// parseLastEventID returns the ID of the last event received by the client, 0 for new streams
+func parseLastEventID(c *gin.Context) (uint64, error) {
+	v := c.GetHeader("Last-Event-ID")
+	if v == "" {
+		v = c.Query("lastEventId")
+	}
+	if v == "" {
+		return 0, nil
+	}
+	return strconv.ParseUint(v, 10, 64)
+}
+
+func publishedOnAny(eventTopics []string, topics map[string]bool) bool {
+	for _, t := range eventTopics {
+		if topics[t] {
+			return true
+		}
+	}
+	return false
+}
+
+// filterStreamedPost applies filter to a post event. It returns the data to send, or false when the post
+// is hidden.
+func (s *Server) filterStreamedPost(e pubsub.Event, filter *postFilter) (json.RawMessage, bool) {
+	if len(filter.rules) == 0 {
+		return e.Data, true
+	}
+
+	var postsResponse api.PostsResponse
+	err := json.Unmarshal(e.Data, &postsResponse)
+	if err != nil {
+		s.logger.Warnf("unable to decode streamed post of event %d: %v", e.ID, err)
+		return e.Data, true
+	}
+	postsResponse.Posts = filter.apply(postsResponse.Posts)
+	if len(postsResponse.Posts) == 0 {
+		return nil, false
+	}
+	data, err := json.Marshal(postsResponse)
+	if err != nil {
+		s.logger.Warnf("unable to encode streamed post of event %d: %v", e.ID, err)
+		return e.Data, true
+	}
+	return data, true
+}
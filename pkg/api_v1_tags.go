@@ -2,12 +2,27 @@ package server
 
 import (
 	"errors"
+	"fmt"
+	"github.com/denysvitali/social/backend/pkg/entities"
+	"github.com/denysvitali/social/backend/pkg/models/api"
 	pgmodel "github.com/denysvitali/social/backend/pkg/models/postgres"
 	"github.com/gin-gonic/gin"
 	"gorm.io/gorm"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
 )
 
+const defaultTagStatsDays = 30
+const maxTagStatsDays = 365
+
+const defaultTagSuggestions = 10
+const maxTagSuggestions = 25
+
+// tagSuggestionsRecency is the period over which tag usage is measured to rank suggestions
+const tagSuggestionsRecency = 7 * 24 * time.Hour
+
 func (s *Server) apiV1TagsByText(c *gin.Context) {
 	text := c.Param("text")
 	if text == "" {
@@ -15,19 +30,86 @@ func (s *Server) apiV1TagsByText(c *gin.Context) {
 		return
 	}
 
-	var p pgmodel.Tag
-	tx := s.pgDB.
-		First(&p, "text = ?", text)
-	if tx.Error != nil {
-		if errors.Is(tx.Error, gorm.ErrRecordNotFound) {
-			s.notFound(c, "post not found")
+	days := defaultTagStatsDays
+	if v := c.Query("days"); v != "" {
+		d, err := strconv.Atoi(v)
+		if err != nil || d <= 0 || d > maxTagStatsDays {
+			s.badRequest(c,
+				fmt.Sprintf("invalid days parameter %q", v),
+				fmt.Sprintf("days must be between 1 and %d", maxTagStatsDays),
+			)
 			return
 		}
-		s.internalServerError(c, "unable to get tags with text %s: %v", text, tx.Error)
+		days = d
+	}
+
+	p, err := s.findTagByText(text)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			s.notFound(c, "tag not found")
+			return
+		}
+		s.internalServerError(c, "unable to get tags with text %s: %v", text, err)
 		return
 	}
 
-	c.JSON(http.StatusOK, p)
+	detail := api.TagDetail{
+		ID:    p.ID,
+		Text:  p.Text,
+		Daily: []api.TagDailyUsage{},
+	}
+
+	var totals struct {
+		Posts     int64
+		Authors   int64
+		FirstUsed *time.Time
+	}
+	tx := s.pgDB.
+		Table("post_tags").
+		Select("COUNT(*) AS posts, COUNT(DISTINCT posts.author_id) AS authors, MIN(posts.created_at) AS first_used").
+		Joins("INNER JOIN posts ON posts.id = post_tags.post_id").
+		Where("post_tags.tag_id = ? AND posts.deleted = false", p.ID).
+		Scan(&totals)
+	if tx.Error != nil {
+		s.internalServerError(c, "unable to get statistics of tag %d: %v", p.ID, tx.Error)
+		return
+	}
+	detail.PostCount = totals.Posts
+	detail.Authors = totals.Authors
+	detail.FirstUsedAt = totals.FirstUsed
+
+	today := time.Now().UTC().Truncate(24 * time.Hour)
+	since := today.AddDate(0, 0, -(days - 1))
+
+	var daily []struct {
+		Day   time.Time
+		Posts int64
+	}
+	tx = s.pgDB.
+		Table("post_tags").
+		Select("date_trunc('day', posts.created_at AT TIME ZONE 'UTC') AS day, COUNT(*) AS posts").
+		Joins("INNER JOIN posts ON posts.id = post_tags.post_id").
+		Where("post_tags.tag_id = ? AND posts.deleted = false AND posts.created_at >= ?", p.ID, since).
+		Group("day").
+		Scan(&daily)
+	if tx.Error != nil {
+		s.internalServerError(c, "unable to get daily usage of tag %d: %v", p.ID, tx.Error)
+		return
+	}
+
+	postsByDay := map[string]int64{}
+	for _, v := range daily {
+		postsByDay[statDay(v.Day)] = v.Posts
+	}
+	for d := since; !d.After(today); d = d.AddDate(0, 0, 1) {
+		day := statDay(d)
+		detail.Daily = append(detail.Daily, api.TagDailyUsage{
+			Day:   day,
+			Posts: postsByDay[day],
+		})
+	}
+
+	c.JSON(http.StatusOK, detail)
 }
 
 func (s *Server) apiV1TagsGetPosts(c *gin.Context) {
@@ -37,27 +119,195 @@ func (s *Server) apiV1TagsGetPosts(c *gin.Context) {
 		return
 	}
 
+	page, err := parsePage(c)
+	if err != nil {
+		s.badRequest(c, fmt.Sprintf("invalid pagination: %v", err), err.Error())
+		return
+	}
+
+	tag, err := s.findTagByText(text)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			s.notFound(c, "tag not found")
+			return
+		}
+		s.internalServerError(c, "unable to get tags with text %s: %v", text, err)
+		return
+	}
+
+	viewer, _ := s.viewerId(c)
+	hidden, err := s.hiddenUserIds(c.Request.Context(), viewer, true)
+	if err != nil {
+		s.internalServerError(c, "unable to get users hidden from user %d: %v", viewer, err)
+		return
+	}
+
+	tx := excludeAuthors(excludeSuspendedAuthors(s.pgDB.Model(&pgmodel.Post{})), hidden).
+		Joins("INNER JOIN post_tags ON post_tags.post_id = posts.id").
+		Where("post_tags.tag_id = ? AND posts.deleted = false AND posts.visibility = ?", tag.ID, pgmodel.VisibilityPublic)
+	if page.Cursor != nil {
+		tx = tx.Where("posts.id < ?", page.Cursor)
+	}
+
 	var p []pgmodel.Post
+	tx = tx.
+		Order("posts.id DESC").
+		Limit(page.Limit).
+		Find(&p)
+	if tx.Error != nil {
+		s.internalServerError(c, "unable to get posts of tag %s: %v", text, tx.Error)
+		return
+	}
+
+	postsResponse, err := s.postsResponse(viewer, p)
+	if err != nil {
+		s.internalServerError(c, "unable to build posts response: %v", err)
+		return
+	}
+	if len(p) > 0 {
+		postsResponse.NextCursor = page.nextCursor(len(p), p[len(p)-1].ID)
+	}
+	filter, err := s.loadPostFilter(viewer, pgmodel.FilterContextEverywhere)
+	if err != nil {
+		s.internalServerError(c, "unable to get muted words of user %d: %v", viewer, err)
+		return
+	}
+	postsResponse.Posts = filter.apply(postsResponse.Posts)
+
+	c.JSON(http.StatusOK, postsResponse)
+}
+
+// apiV1TagsAutocomplete suggests the tags starting with the "q" parameter, most used recently first
+func (s *Server) apiV1TagsAutocomplete(c *gin.Context) {
+	prefix := strings.TrimPrefix(strings.TrimSpace(c.Query("q")), "#")
+	if prefix == "" {
+		s.paramCantBeEmpty(c, "q")
+		return
+	}
+
+	limit := defaultTagSuggestions
+	if v := c.Query("limit"); v != "" {
+		l, err := strconv.Atoi(v)
+		if err != nil || l <= 0 || l > maxTagSuggestions {
+			s.badRequest(c,
+				fmt.Sprintf("invalid limit %q", v),
+				fmt.Sprintf("limit must be between 1 and %d", maxTagSuggestions),
+			)
+			return
+		}
+		limit = l
+	}
+
+	var suggestions []api.TagSuggestion
+	tx := s.pgDB.
+		Table("tags").
+		Select(`tags.id, tags.text,
+			COUNT(posts.id) FILTER (WHERE posts.created_at >= ?) AS recent_uses,
+			COUNT(posts.id) AS uses`, time.Now().Add(-tagSuggestionsRecency)).
+		Joins("LEFT JOIN post_tags ON post_tags.tag_id = tags.id").
+		Joins("LEFT JOIN posts ON posts.id = post_tags.post_id AND posts.deleted = false").
+		Where(`tags.canonical LIKE ? ESCAPE '\'`, escapeLike(entities.CanonicalTag(prefix))+"%").
+		Group("tags.id").
+		Order("recent_uses DESC, uses DESC, tags.text ASC").
+		Limit(limit).
+		Scan(&suggestions)
+	if tx.Error != nil {
+		s.internalServerError(c, "unable to autocomplete tags with prefix %s: %v", prefix, tx.Error)
+		return
+	}
+	if suggestions == nil {
+		suggestions = []api.TagSuggestion{}
+	}
+
+	c.JSON(http.StatusOK, suggestions)
+}
+
+// escapeLike escapes the wildcards of a LIKE pattern
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(s)
+}
+
+func (s *Server) apiV1TrendingTags(c *gin.Context) {
+	limit := 10
+	if v := c.Query("limit"); v != "" {
+		l, err := strconv.Atoi(v)
+		if err != nil || l <= 0 || l > maxTrendingTags {
+			s.badRequest(c,
+				fmt.Sprintf("invalid limit %q", v),
+				fmt.Sprintf("limit must be between 1 and %d", maxTrendingTags),
+			)
+			return
+		}
+		limit = l
+	}
+
+	var trending []pgmodel.TrendingTag
 	tx := s.pgDB.
-		Raw(`
-		SELECT * FROM posts WHERE posts.id IN (
-		SELECT DISTINCT s1.post_id FROM 
-		(
-			SELECT posts.*, post_tags.* FROM "posts" 
-		    INNER JOIN post_tags ON post_tags.post_id = posts.id
-		) as s1 
-		INNER JOIN tags on tags.id = s1.tag_id 
-		WHERE tags.text = ?
-		LIMIT ?
-		)`, text, 100).Preload("Tags").Find(&p)
+		Preload("Tag").
+		Order("rank ASC").
+		Limit(limit).
+		Find(&trending)
 	if tx.Error != nil {
-		if errors.Is(tx.Error, gorm.ErrRecordNotFound) {
-			s.notFound(c, "posts not found")
+		s.internalServerError(c, "unable to get trending tags: %v", tx.Error)
+		return
+	}
+
+	apiTags := []api.TrendingTag{}
+	for _, v := range trending {
+		if v.Tag == nil {
+			continue
+		}
+		apiTags = append(apiTags, api.TrendingTag{
+			ID:      v.TagID,
+			Text:    v.Tag.Text,
+			Score:   v.Score,
+			Uses:    v.Uses,
+			Authors: v.Authors,
+		})
+	}
+
+	c.JSON(http.StatusOK, apiTags)
+}
+
+func (s *Server) apiV1FollowTag(c *gin.Context) {
+	s.setTagFollow(c, true)
+}
+
+func (s *Server) apiV1UnfollowTag(c *gin.Context) {
+	s.setTagFollow(c, false)
+}
+
+func (s *Server) setTagFollow(c *gin.Context, follow bool) {
+	viewer, ok := s.requireViewer(c)
+	if !ok {
+		return
+	}
+
+	text := c.Param("text")
+	if text == "" {
+		s.badRequest(c, "text is empty", "text cannot be empty")
+		return
+	}
+
+	tag, err := s.findTagByText(text)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			s.notFound(c, "tag not found")
 			return
 		}
-		s.internalServerError(c, "unable to get tags with text %s: %v", text, tx.Error)
+		s.internalServerError(c, "unable to get tag with text %s: %v", text, err)
+		return
+	}
+
+	if follow {
+		_, err = s.addRelation(c.Request.Context(), RelationFollows, userVertex(viewer), tagVertex(tag.ID))
+	} else {
+		_, err = s.removeRelation(c.Request.Context(), RelationFollows, userVertex(viewer), tagVertex(tag.ID))
+	}
+	if err != nil {
+		s.internalServerError(c, "unable to update follow of tag %d: %v", tag.ID, err)
 		return
 	}
 
-	c.JSON(http.StatusOK, p)
+	c.Status(http.StatusNoContent)
 }
@@ -0,0 +1,152 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"github.com/denysvitali/social/backend/pkg/models/api"
+	pgmodel "github.com/denysvitali/social/backend/pkg/models/postgres"
+	"github.com/gin-gonic/gin"
+	"net/http"
+)
+
+// apiV1HomeTimeline returns the posts of the accounts followed by the viewer (and their own),
+// merged with the posts carrying one of the tags they follow.
+func (s *Server) apiV1HomeTimeline(c *gin.Context) {
+	viewer, ok := s.requireViewer(c)
+	if !ok {
+		return
+	}
+
+	p, err := parsePage(c)
+	if err != nil {
+		s.badRequest(c, fmt.Sprintf("invalid pagination: %v", err), err.Error())
+		return
+	}
+
+	// Without the graph database, the timeline only holds the posts of the viewer
+	ctx := c.Request.Context()
+	followedUsers, err := s.outboundIds(ctx, RelationFollows, userVertex(viewer), UsersCollection)
+	if err != nil && !errors.Is(err, errGraphUnavailable) {
+		s.internalServerError(c, "unable to get followed users: %v", err)
+		return
+	}
+	followedTags, err := s.outboundIds(ctx, RelationFollows, userVertex(viewer), TagsCollection)
+	if err != nil && !errors.Is(err, errGraphUnavailable) {
+		s.internalServerError(c, "unable to get followed tags: %v", err)
+		return
+	}
+
+	hidden, err := s.hiddenUserIds(ctx, viewer, true)
+	if err != nil {
+		s.internalServerError(c, "unable to get users hidden from user %d: %v", viewer, err)
+		return
+	}
+
+	authors := append(followedUsers, viewer)
+	tx := excludeAuthors(excludeSuspendedAuthors(s.pgDB.Model(&pgmodel.Post{})), hidden).
+		Where("posts.deleted = false")
+	if len(followedTags) > 0 {
+		tx = tx.Where(
+			s.pgDB.
+				Where("posts.author_id IN ?", authors).
+				Or("posts.visibility = ? AND posts.id IN (SELECT post_tags.post_id FROM post_tags WHERE post_tags.tag_id IN ?)",
+					pgmodel.VisibilityPublic, followedTags),
+		)
+	} else {
+		tx = tx.Where("posts.author_id IN ?", authors)
+	}
+	if p.Cursor != nil {
+		tx = tx.Where("posts.id < ?", p.Cursor)
+	}
+
+	var posts []pgmodel.Post
+	tx = tx.
+		Order("posts.id DESC").
+		Limit(p.Limit).
+		Find(&posts)
+	if tx.Error != nil {
+		s.internalServerError(c, "unable to fetch home timeline: %v", tx.Error)
+		return
+	}
+
+	postsResponse, err := s.postsResponse(viewer, posts)
+	if err != nil {
+		s.internalServerError(c, "unable to build posts response: %v", err)
+		return
+	}
+
+	reasons, err := s.followedTagReasons(posts, authors, followedTags)
+	if err != nil {
+		s.internalServerError(c, "unable to get timeline reasons: %v", err)
+		return
+	}
+	for i := range postsResponse.Posts {
+		postsResponse.Posts[i].Reason = reasons[postsResponse.Posts[i].ID]
+	}
+
+	filter, err := s.loadPostFilter(viewer, pgmodel.FilterContextHome)
+	if err != nil {
+		s.internalServerError(c, "unable to get muted words of user %d: %v", viewer, err)
+		return
+	}
+	postsResponse.Posts = filter.apply(postsResponse.Posts)
+
+	if len(posts) > 0 {
+		postsResponse.NextCursor = p.nextCursor(len(posts), posts[len(posts)-1].ID)
+	}
+	s.recordImpressions(c, postsResponse.Posts)
+
+	c.JSON(http.StatusOK, postsResponse)
+}
+
+// followedTagReasons returns, for each post not authored by one of authors, the followed tag it appears
+// in the timeline for.
+func (s *Server) followedTagReasons(posts []pgmodel.Post, authors []uint64, followedTags []uint64) (map[string]*api.TimelineReason, error) {
+	result := map[string]*api.TimelineReason{}
+	if len(followedTags) == 0 {
+		return result, nil
+	}
+
+	isAuthor := map[uint64]bool{}
+	for _, v := range authors {
+		isAuthor[v] = true
+	}
+	var postIds [][]byte
+	for _, p := range posts {
+		if !isAuthor[p.AuthorID] {
+			postIds = append(postIds, p.ID)
+		}
+	}
+	if len(postIds) == 0 {
+		return result, nil
+	}
+
+	var refs []struct {
+		PostID []byte
+		TagID  uint64
+		Text   string
+	}
+	tx := s.pgDB.
+		Table("post_tags").
+		Select("post_tags.post_id, post_tags.tag_id, tags.text").
+		Joins("JOIN tags ON tags.id = post_tags.tag_id").
+		Where("post_tags.post_id IN ? AND post_tags.tag_id IN ?", postIds, followedTags).
+		Order("tags.text ASC").
+		Scan(&refs)
+	if tx.Error != nil {
+		return nil, tx.Error
+	}
+
+	for _, v := range refs {
+		postId := bytesToUlid(v.PostID).String()
+		if _, ok := result[postId]; ok {
+			continue
+		}
+		result[postId] = &api.TimelineReason{
+			Type:  api.TimelineReasonFollowedTag,
+			TagID: v.TagID,
+			Tag:   v.Text,
+		}
+	}
+	return result, nil
+}
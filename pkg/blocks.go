@@ -0,0 +1,60 @@
+package server
+
+import (
+	"context"
+	"gorm.io/gorm"
+)
+
+// hiddenUserIds returns the users whose content is hidden from viewer: the users blocking them or blocked
+// by them and, with withMutes, the users they mute. Nothing is hidden from anonymous viewers, nor when the
+// graph database isn't configured.
+func (s *Server) hiddenUserIds(ctx context.Context, viewer uint64, withMutes bool) ([]uint64, error) {
+	if viewer == 0 || s.arangoDB == nil {
+		return nil, nil
+	}
+	return s.queryIds(ctx, `
+		FOR v, e IN 1..1 ANY @vertex GRAPH @graph
+			FILTER e.type == @blocks OR (@with_mutes AND e.type == @mutes AND e._from == @vertex)
+			FILTER IS_SAME_COLLECTION(@collection, v)
+			RETURN DISTINCT v._id`,
+		map[string]any{
+			"vertex":     userVertex(viewer),
+			"graph":      SocialNetworkGraph,
+			"blocks":     RelationBlocks,
+			"mutes":      RelationMutes,
+			"with_mutes": withMutes,
+			"collection": UsersCollection,
+		})
+}
+
+// isBlocked returns whether a blocks b, or b blocks a
+func (s *Server) isBlocked(ctx context.Context, a uint64, b uint64) (bool, error) {
+	if s.arangoDB == nil || a == 0 || b == 0 || a == b {
+		return false, nil
+	}
+	for _, pair := range [][2]uint64{{a, b}, {b, a}} {
+		blocks, err := s.hasRelation(ctx, RelationBlocks, userVertex(pair[0]), userVertex(pair[1]))
+		if err != nil || blocks {
+			return blocks, err
+		}
+	}
+	return false, nil
+}
+
+// ignoresActor returns whether userId mustn't be notified of the actions of actorId: when one of them
+// blocks the other, or when userId mutes actorId
+func (s *Server) ignoresActor(ctx context.Context, userId uint64, actorId uint64) (bool, error) {
+	blocked, err := s.isBlocked(ctx, userId, actorId)
+	if err != nil || blocked || s.arangoDB == nil {
+		return blocked, err
+	}
+	return s.hasRelation(ctx, RelationMutes, userVertex(userId), userVertex(actorId))
+}
+
+// excludeAuthors filters the posts of authors out of tx
+func excludeAuthors(tx *gorm.DB, authors []uint64) *gorm.DB {
+	if len(authors) == 0 {
+		return tx
+	}
+	return tx.Where("posts.author_id NOT IN ?", authors)
+}
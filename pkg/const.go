@@ -1,5 +1,10 @@
 package server
 
 const UsersCollection string = "users"
+const TagsCollection string = "tags"
 const SocialNetworkGraph string = "social_network"
 const SocialNetworkRelations string = "social_network_relations"
+
+// ViewerHeader is the request header carrying the ID of the user performing the request.
+// There is no authentication layer yet, so its value is trusted as-is.
+const ViewerHeader string = "X-User-Id"
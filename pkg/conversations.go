@@ -0,0 +1,301 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"github.com/denysvitali/social/backend/pkg/models/api"
+	pg_model "github.com/denysvitali/social/backend/pkg/models/postgres"
+	"github.com/oklog/ulid/v2"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// MaxConversationMembers is the maximum number of members of a group, including its creator
+const MaxConversationMembers = 8
+
+// MaxMessageLength is the maximum length of a message, in Unicode code points
+const MaxMessageLength = 2000
+
+// MaxConversationNameLength is the maximum length of the name of a group, in Unicode code points
+const MaxConversationNameLength = 100
+
+// Types of the streaming events about conversations
+const (
+	// streamEventMessage carries an api.MessageEvent
+	streamEventMessage = "message"
+	// streamEventConversationRead carries an api.ConversationRead
+	streamEventConversationRead = "conversation_read"
+)
+
+// directKey returns the pg_model.Conversation DirectKey of the 1:1 conversation between a and b
+func directKey(a uint64, b uint64) string {
+	if a > b {
+		a, b = b, a
+	}
+	return fmt.Sprintf("%d:%d", a, b)
+}
+
+// parseDirectKey returns the two members of the 1:1 conversation of a key returned by directKey
+func parseDirectKey(key string) (uint64, uint64, error) {
+	rawA, rawB, _ := strings.Cut(key, ":")
+	a, err := strconv.ParseUint(rawA, 10, 64)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid direct key %q", key)
+	}
+	b, err := strconv.ParseUint(rawB, 10, 64)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid direct key %q", key)
+	}
+	return a, b, nil
+}
+
+// membershipStatus returns the status of the membership of recipient in a conversation started by
+// sender: conversations started by someone the recipient doesn't follow are requests
+func (s *Server) membershipStatus(ctx context.Context, recipient uint64, sender uint64) (string, error) {
+	if s.arangoDB == nil {
+		return pg_model.MembershipRequest, nil
+	}
+	follows, err := s.hasRelation(ctx, RelationFollows, userVertex(recipient), userVertex(sender))
+	if err != nil {
+		return "", err
+	}
+	if follows {
+		return pg_model.MembershipAccepted, nil
+	}
+	return pg_model.MembershipRequest, nil
+}
+
+// missingDirectMembers returns the memberships to add back to the 1:1 conversation conv before sender
+// writes to it, as both members may have left it. The sender joins with an accepted membership, the
+// recipient with membershipStatus.
+func (s *Server) missingDirectMembers(ctx context.Context, conv pg_model.Conversation, sender uint64, now time.Time) ([]pg_model.ConversationMember, error) {
+	var members []uint64
+	err := s.pgDB.Model(&pg_model.ConversationMember{}).
+		Where("conversation_id = ?", conv.ID).
+		Pluck("user_id", &members).Error
+	if err != nil {
+		return nil, err
+	}
+	a, b, err := parseDirectKey(*conv.DirectKey)
+	if err != nil {
+		return nil, err
+	}
+
+	present := map[uint64]bool{}
+	for _, id := range members {
+		present[id] = true
+	}
+	var missing []pg_model.ConversationMember
+	for _, id := range []uint64{a, b} {
+		if present[id] {
+			continue
+		}
+		status := pg_model.MembershipAccepted
+		if id != sender {
+			status, err = s.membershipStatus(ctx, id, sender)
+			if err != nil {
+				return nil, err
+			}
+		}
+		missing = append(missing, pg_model.ConversationMember{
+			ConversationID: conv.ID,
+			UserID:         id,
+			Status:         status,
+			JoinedAt:       now,
+		})
+	}
+	return missing, nil
+}
+
+// sendMessage adds a message of sender to conv. Sending a message accepts the conversation, and marks it
+// as read, for the sender. The members of a 1:1 conversation who left it join it again.
+func (s *Server) sendMessage(ctx context.Context, conv pg_model.Conversation, sender uint64, content string) (pg_model.Message, error) {
+	msg := pg_model.Message{
+		ID:             ulid.Make().Bytes(),
+		ConversationID: conv.ID,
+		SenderID:       sender,
+		Content:        content,
+		CreatedAt:      time.Now(),
+	}
+
+	var rejoining []pg_model.ConversationMember
+	if !conv.IsGroup {
+		var err error
+		rejoining, err = s.missingDirectMembers(ctx, conv, sender, msg.CreatedAt)
+		if err != nil {
+			return msg, err
+		}
+	}
+
+	err := s.pgDB.Transaction(func(tx *gorm.DB) error {
+		if len(rejoining) > 0 {
+			err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&rejoining).Error
+			if err != nil {
+				return err
+			}
+		}
+		err := tx.Create(&msg).Error
+		if err != nil {
+			return err
+		}
+		err = tx.Model(&pg_model.Conversation{}).
+			Where("id = ?", conv.ID).
+			UpdateColumn("last_message_id", msg.ID).Error
+		if err != nil {
+			return err
+		}
+		return tx.Model(&pg_model.ConversationMember{}).
+			Where("conversation_id = ? AND user_id = ?", conv.ID, sender).
+			Updates(map[string]any{
+				"status":               pg_model.MembershipAccepted,
+				"last_read_message_id": msg.ID,
+			}).Error
+	})
+	if err != nil {
+		return msg, err
+	}
+
+	s.publishToMembers(conv.ID, streamEventMessage, func(m pg_model.ConversationMember) any {
+		return api.MessageEvent{Message: getApiMessage(msg), Muted: m.Muted}
+	})
+	return msg, nil
+}
+
+// publishToMembers streams an event to each member of a conversation, data returning its payload for
+// the member
+func (s *Server) publishToMembers(conversationId []byte, eventType string, data func(m pg_model.ConversationMember) any) {
+	var members []pg_model.ConversationMember
+	err := s.pgDB.Where("conversation_id = ?", conversationId).Find(&members).Error
+	if err != nil {
+		s.logger.Warnf("unable to get members of conversation %s: %v", bytesToUlid(conversationId), err)
+		return
+	}
+	for _, m := range members {
+		s.publish([]string{userTopic(m.UserID)}, eventType, data(m))
+	}
+}
+
+// conversationsResponse renders convs for viewer, sideloading their members
+func (s *Server) conversationsResponse(viewer uint64, convs []pg_model.Conversation) (api.ConversationsResponse, error) {
+	response := api.ConversationsResponse{
+		Conversations: []api.Conversation{},
+		Users:         []api.User{},
+	}
+	if len(convs) == 0 {
+		return response, nil
+	}
+
+	var ids, lastMessageIds [][]byte
+	for _, c := range convs {
+		ids = append(ids, c.ID)
+		if c.LastMessageID != nil {
+			lastMessageIds = append(lastMessageIds, *c.LastMessageID)
+		}
+	}
+
+	var members []pg_model.ConversationMember
+	err := s.pgDB.
+		Where("conversation_id IN ?", ids).
+		Order("joined_at ASC, user_id ASC").
+		Find(&members).Error
+	if err != nil {
+		return response, err
+	}
+	membersByConv := map[string][]pg_model.ConversationMember{}
+	userIds := map[uint64]bool{}
+	for _, m := range members {
+		key := string(m.ConversationID)
+		membersByConv[key] = append(membersByConv[key], m)
+		userIds[m.UserID] = true
+	}
+
+	lastMessages := map[string]pg_model.Message{}
+	if len(lastMessageIds) > 0 {
+		var messages []pg_model.Message
+		err = s.pgDB.Where("id IN ?", lastMessageIds).Find(&messages).Error
+		if err != nil {
+			return response, err
+		}
+		for _, m := range messages {
+			lastMessages[string(m.ID)] = m
+		}
+	}
+
+	var unread []struct {
+		ConversationID []byte
+		Count          int64
+	}
+	err = s.pgDB.Raw(`
+		SELECT m.conversation_id, COUNT(messages.id) AS count
+		FROM conversation_members m
+		INNER JOIN messages ON messages.conversation_id = m.conversation_id
+			AND messages.sender_id <> m.user_id
+			AND (m.last_read_message_id IS NULL OR messages.id > m.last_read_message_id)
+		WHERE m.user_id = ? AND m.conversation_id IN ?
+		GROUP BY m.conversation_id`,
+		viewer, ids,
+	).Scan(&unread).Error
+	if err != nil {
+		return response, err
+	}
+	unreadByConv := map[string]int64{}
+	for _, u := range unread {
+		unreadByConv[string(u.ConversationID)] = u.Count
+	}
+
+	for _, c := range convs {
+		conv := api.Conversation{
+			ID:          bytesToUlid(c.ID).String(),
+			Name:        c.Name,
+			IsGroup:     c.IsGroup,
+			Members:     []api.ConversationMember{},
+			UnreadCount: unreadByConv[string(c.ID)],
+			CreatedAt:   c.CreatedAt,
+		}
+		for _, m := range membersByConv[string(c.ID)] {
+			member := api.ConversationMember{User: m.UserID}
+			if m.LastReadMessageID != nil {
+				member.LastReadMessage = bytesToUlid(*m.LastReadMessageID).String()
+			}
+			conv.Members = append(conv.Members, member)
+			if m.UserID == viewer {
+				conv.Muted = m.Muted
+				conv.Request = m.Status == pg_model.MembershipRequest
+			}
+		}
+		if c.LastMessageID != nil {
+			if last, ok := lastMessages[string(*c.LastMessageID)]; ok {
+				message := getApiMessage(last)
+				conv.LastMessage = &message
+			}
+		}
+		response.Conversations = append(response.Conversations, conv)
+	}
+
+	var ulist []uint64
+	for id := range userIds {
+		ulist = append(ulist, id)
+	}
+	var users []pg_model.User
+	err = s.pgDB.Where("id IN ?", ulist).Order("id ASC").Find(&users).Error
+	if err != nil {
+		return response, err
+	}
+	for _, u := range users {
+		response.Users = append(response.Users, getApiUser(u))
+	}
+	return response, nil
+}
+
+func getApiMessage(m pg_model.Message) api.Message {
+	return api.Message{
+		ID:           bytesToUlid(m.ID).String(),
+		Conversation: bytesToUlid(m.ConversationID).String(),
+		Sender:       m.SenderID,
+		Content:      m.Content,
+		CreatedAt:    m.CreatedAt,
+	}
+}
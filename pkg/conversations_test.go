@@ -0,0 +1,149 @@
+package server
+
+import (
+	"context"
+	"database/sql/driver"
+	pg_model "github.com/denysvitali/social/backend/pkg/models/postgres"
+	"github.com/denysvitali/social/backend/pkg/pubsub"
+	"github.com/gin-gonic/gin"
+	"github.com/oklog/ulid/v2"
+	"github.com/sirupsen/logrus"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newConversationServer returns a server whose database holds the 1:1 conversation of users 1 and 2, with
+// the members present only. The members added by the statements run against it are taken into account.
+func newConversationServer(t *testing.T, conv pg_model.Conversation, present []uint64) (*Server, *fakeDB) {
+	t.Helper()
+	var fake *fakeDB
+	db, fake := newFakeDB(t, func(query string, args []driver.Value) (fakeResult, error) {
+		switch {
+		case strings.Contains(query, "suspended_at IS NOT NULL"):
+			return fakeResult{columns: []string{"count"}, rows: [][]driver.Value{{int64(0)}}}, nil
+		case strings.HasPrefix(query, `SELECT count(*) FROM "users"`):
+			return fakeResult{columns: []string{"count"}, rows: [][]driver.Value{{int64(1)}}}, nil
+		case strings.HasPrefix(query, `SELECT "user_id" FROM "conversation_members"`):
+			res := fakeResult{columns: []string{"user_id"}}
+			for _, id := range present {
+				res.rows = append(res.rows, []driver.Value{int64(id)})
+			}
+			for _, id := range addedMembers(fake) {
+				res.rows = append(res.rows, []driver.Value{int64(id)})
+			}
+			return res, nil
+		case strings.Contains(query, `FROM "conversations"`):
+			return fakeResult{
+				columns: []string{"id", "created_at", "creator_id", "is_group", "name", "direct_key"},
+				rows:    [][]driver.Value{{conv.ID, conv.CreatedAt, int64(conv.CreatorID), false, "", *conv.DirectKey}},
+			}, nil
+		}
+		return fakeResult{}, nil
+	})
+
+	log := logrus.New()
+	log.SetOutput(io.Discard)
+	return &Server{logger: log, pgDB: db, broker: pubsub.NewMemory(0)}, fake
+}
+
+// addedMembers returns the users added to conversations, in order
+func addedMembers(fake *fakeDB) []uint64 {
+	var added []uint64
+	for _, s := range fake.execs() {
+		if !strings.HasPrefix(s.query, `INSERT INTO "conversation_members"`) {
+			continue
+		}
+		// Each row is (conversation_id, user_id, status, ...)
+		for i := 1; i < len(s.args); i += 6 {
+			added = append(added, uint64(s.args[i].(int64)))
+		}
+	}
+	return added
+}
+
+// addedStatuses returns the statuses of the memberships added to conversations, by user
+func addedStatuses(fake *fakeDB) map[uint64]string {
+	statuses := map[uint64]string{}
+	for _, s := range fake.execs() {
+		if !strings.HasPrefix(s.query, `INSERT INTO "conversation_members"`) {
+			continue
+		}
+		for i := 1; i+1 < len(s.args); i += 6 {
+			statuses[uint64(s.args[i].(int64))] = s.args[i+1].(string)
+		}
+	}
+	return statuses
+}
+
+func directConversation() pg_model.Conversation {
+	key := directKey(1, 2)
+	return pg_model.Conversation{
+		ID:        ulid.Make().Bytes(),
+		CreatedAt: time.Now(),
+		CreatorID: 2,
+		DirectKey: &key,
+	}
+}
+
+func TestSendMessageRejoinsMembers(t *testing.T) {
+	for _, tt := range []struct {
+		name    string
+		present []uint64
+		// want are the statuses of the memberships added back
+		want map[uint64]string
+	}{
+		{"both present", []uint64{1, 2}, map[uint64]string{}},
+		{"recipient left", []uint64{1}, map[uint64]string{2: pg_model.MembershipRequest}},
+		{"sender left", []uint64{2}, map[uint64]string{1: pg_model.MembershipAccepted}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			conv := directConversation()
+			s, fake := newConversationServer(t, conv, tt.present)
+
+			_, err := s.sendMessage(context.Background(), conv, 1, "hi")
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := addedStatuses(fake)
+			if len(got) != len(tt.want) {
+				t.Fatalf("added members %v, want %v", got, tt.want)
+			}
+			for id, status := range tt.want {
+				if got[id] != status {
+					t.Errorf("added user %d with status %q, want %q", id, got[id], status)
+				}
+			}
+		})
+	}
+}
+
+// A left their 1:1 conversation with B, and starts it again
+func TestCreateConversationRejoinsViewer(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	for _, body := range []string{`{"members":[2]}`, `{"members":[2],"content":"hi again"}`} {
+		conv := directConversation()
+		s, fake := newConversationServer(t, conv, []uint64{2})
+		e := gin.New()
+		e.POST("/conversations", s.apiV1CreateConversation)
+
+		req := httptest.NewRequest(http.MethodPost, "/conversations", strings.NewReader(body))
+		req.Header.Set(ViewerHeader, "1")
+		w := httptest.NewRecorder()
+		e.ServeHTTP(w, req)
+		if w.Code != http.StatusOK {
+			t.Fatalf("%s: got status %d: %s", body, w.Code, w.Body)
+		}
+
+		added := addedMembers(fake)
+		if len(added) != 1 || added[0] != 1 {
+			t.Errorf("%s: added members %v, want the viewer only", body, added)
+		}
+		if status := addedStatuses(fake)[1]; status != pg_model.MembershipAccepted {
+			t.Errorf("%s: viewer added with status %q", body, status)
+		}
+	}
+}
@@ -173,15 +173,16 @@ func (s *Server) createDemoPosts() error {
 			likedBy = append(likedBy, pg_model.User{ID: id})
 		}
 
-		tx := s.pgDB.Create(&pg_model.Post{
-			ID:       postUlid.Bytes(),
-			AuthorID: p.Author,
-			Content:  p.Content,
-			LikedBy:  likedBy,
-			Likes:    uint64(len(likedBy)),
-		})
-
-		if tx.Error != nil {
+		err = s.createPost(&pg_model.Post{
+			ID:         postUlid.Bytes(),
+			AuthorID:   p.Author,
+			Content:    p.Content,
+			Language:   defaultSearchLanguage,
+			Visibility: pg_model.VisibilityPublic,
+			LikedBy:    likedBy,
+			Likes:      uint64(len(likedBy)),
+		}, nil)
+		if err != nil {
 			return err
 		}
 	}
@@ -0,0 +1,445 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"embed"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"github.com/denysvitali/social/backend/pkg/mail"
+	"github.com/denysvitali/social/backend/pkg/models/api"
+	pg_model "github.com/denysvitali/social/backend/pkg/models/postgres"
+	htmltemplate "html/template"
+	"net/url"
+	"strconv"
+	"strings"
+	texttemplate "text/template"
+	"time"
+)
+
+const emailQueueSize = 1024
+const emailTimeout = 30 * time.Second
+
+// digestCheckInterval is how often the users due for a digest are looked for
+const digestCheckInterval = 15 * time.Minute
+
+// digestSize is the number of posts of a digest
+const digestSize = 10
+
+// emailConfirmationLifetime is how long the links confirming a new email address are valid
+const emailConfirmationLifetime = 24 * time.Hour
+
+// Kinds of emails users can unsubscribe from
+const (
+	unsubscribeNotifications = "notifications"
+	unsubscribeDigest        = "digest"
+)
+
+//go:embed templates/email
+var emailTemplatesFS embed.FS
+
+var (
+	emailHTMLTemplates = htmltemplate.Must(htmltemplate.ParseFS(emailTemplatesFS, "templates/email/*.html.tmpl"))
+	emailTextTemplates = texttemplate.Must(texttemplate.ParseFS(emailTemplatesFS, "templates/email/*.txt.tmpl"))
+)
+
+type EmailConfig struct {
+	// SMTP configures the mail server, emails are disabled when its host is empty
+	SMTP mail.Config
+	// BaseURL is the public URL of the web client, which serves the API under /api/v1. Links in emails
+	// point to it.
+	BaseURL string
+	// Secret signs the unsubscribe and confirmation links
+	Secret string
+}
+
+type emailJob struct {
+	UserID           uint64
+	NotificationType string
+	ActorID          uint64
+	PostID           []byte
+}
+
+// emailPost is a post shown in an email
+type emailPost struct {
+	Author  api.User
+	Content string
+	Likes   uint64
+	URL     string
+}
+
+// emailData is the data of the email templates
+type emailData struct {
+	Subject   string
+	Recipient pg_model.User
+	// Reason tells why the recipient got the email, next to the unsubscribe link
+	Reason         string
+	UnsubscribeURL string
+
+	// Confirmation emails
+	ConfirmURL string
+
+	// Notification emails
+	Summary  string
+	Actor    api.User
+	ActorURL string
+	Post     *emailPost
+
+	// Digests
+	Period string
+	Posts  []emailPost
+}
+
+func setupMailer(config EmailConfig) (mail.Mailer, error) {
+	if config.SMTP.Host == "" {
+		return nil, nil
+	}
+	if config.Secret == "" {
+		return nil, fmt.Errorf("a secret is required to sign the links in emails")
+	}
+	if config.BaseURL == "" {
+		return nil, fmt.Errorf("a base URL is required for the links in emails")
+	}
+	return mail.NewSMTP(config.SMTP)
+}
+
+// unsubscribeToken returns the token of the link unsubscribing userId from kind emails. Tokens don't
+// expire, as unsubscribe links must keep working in old emails.
+func (s *Server) unsubscribeToken(userId uint64, kind string) string {
+	payload := strconv.FormatUint(userId, 10) + "." + kind
+	return payload + "." + s.signUnsubscribe(payload)
+}
+
+// parseUnsubscribeToken returns the user and the kind of emails of a token returned by unsubscribeToken
+func (s *Server) parseUnsubscribeToken(token string) (uint64, string, error) {
+	if s.emailSecret == "" {
+		return 0, "", fmt.Errorf("emails are disabled")
+	}
+	i := strings.LastIndex(token, ".")
+	if i < 0 {
+		return 0, "", fmt.Errorf("malformed token")
+	}
+	payload, signature := token[:i], token[i+1:]
+	if !hmac.Equal([]byte(signature), []byte(s.signUnsubscribe(payload))) {
+		return 0, "", fmt.Errorf("invalid signature")
+	}
+
+	rawUserId, kind, _ := strings.Cut(payload, ".")
+	userId, err := strconv.ParseUint(rawUserId, 10, 64)
+	if err != nil {
+		return 0, "", fmt.Errorf("invalid user ID: %v", err)
+	}
+	if kind != unsubscribeNotifications && kind != unsubscribeDigest {
+		return 0, "", fmt.Errorf("invalid kind %q", kind)
+	}
+	return userId, kind, nil
+}
+
+func (s *Server) signUnsubscribe(payload string) string {
+	return s.signEmailLink("unsubscribe", payload)
+}
+
+// signEmailLink signs the payload of a link for purpose, so that the links of a purpose can't be used for another
+func (s *Server) signEmailLink(purpose string, payload string) string {
+	mac := hmac.New(sha256.New, []byte(s.emailSecret))
+	mac.Write([]byte(purpose + ":" + payload))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// emailConfirmationToken returns the token of the link confirming that userId owns email
+func (s *Server) emailConfirmationToken(userId uint64, email string, now time.Time) string {
+	payload := strconv.FormatUint(userId, 10) + "." +
+		strconv.FormatInt(now.Add(emailConfirmationLifetime).Unix(), 10) + "." +
+		base64.RawURLEncoding.EncodeToString([]byte(email))
+	return payload + "." + s.signEmailLink("confirm", payload)
+}
+
+// parseEmailConfirmationToken returns the user and the email address of a token returned by
+// emailConfirmationToken, unless it expired
+func (s *Server) parseEmailConfirmationToken(token string, now time.Time) (uint64, string, error) {
+	if s.emailSecret == "" {
+		return 0, "", fmt.Errorf("emails are disabled")
+	}
+	i := strings.LastIndex(token, ".")
+	if i < 0 {
+		return 0, "", fmt.Errorf("malformed token")
+	}
+	payload, signature := token[:i], token[i+1:]
+	if !hmac.Equal([]byte(signature), []byte(s.signEmailLink("confirm", payload))) {
+		return 0, "", fmt.Errorf("invalid signature")
+	}
+
+	fields := strings.Split(payload, ".")
+	if len(fields) != 3 {
+		return 0, "", fmt.Errorf("malformed token")
+	}
+	userId, err := strconv.ParseUint(fields[0], 10, 64)
+	if err != nil {
+		return 0, "", fmt.Errorf("invalid user ID: %v", err)
+	}
+	expiresAt, err := strconv.ParseInt(fields[1], 10, 64)
+	if err != nil {
+		return 0, "", fmt.Errorf("invalid expiration: %v", err)
+	}
+	if now.Unix() > expiresAt {
+		return 0, "", fmt.Errorf("token expired")
+	}
+	email, err := base64.RawURLEncoding.DecodeString(fields[2])
+	if err != nil {
+		return 0, "", fmt.Errorf("invalid email: %v", err)
+	}
+	return userId, string(email), nil
+}
+
+func (s *Server) unsubscribeURL(userId uint64, kind string) string {
+	return s.emailBaseURL + "/api/v1/email/unsubscribe?token=" + url.QueryEscape(s.unsubscribeToken(userId, kind))
+}
+
+func (s *Server) postURL(postId []byte) string {
+	return s.emailBaseURL + "/posts/" + bytesToUlid(postId).String()
+}
+
+func (s *Server) profileURL(username string) string {
+	return s.emailBaseURL + "/@" + url.PathEscape(username)
+}
+
+// sendEmail renders the template name, in HTML and plain text, and sends it to the recipient of data.
+// Emails that users can't unsubscribe from, such as confirmations, have an empty unsubscribeKind.
+func (s *Server) sendEmail(name string, data emailData, unsubscribeKind string) error {
+	var headers map[string]string
+	if unsubscribeKind != "" {
+		data.UnsubscribeURL = s.unsubscribeURL(data.Recipient.ID, unsubscribeKind)
+		headers = map[string]string{
+			// One-click unsubscribe (RFC 8058)
+			"List-Unsubscribe":      "<" + data.UnsubscribeURL + ">",
+			"List-Unsubscribe-Post": "List-Unsubscribe=One-Click",
+		}
+	}
+
+	var html, text bytes.Buffer
+	err := emailHTMLTemplates.ExecuteTemplate(&html, name+".html.tmpl", data)
+	if err != nil {
+		return fmt.Errorf("unable to render HTML: %v", err)
+	}
+	err = emailTextTemplates.ExecuteTemplate(&text, name+".txt.tmpl", data)
+	if err != nil {
+		return fmt.Errorf("unable to render text: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), emailTimeout)
+	defer cancel()
+	return s.mailer.Send(ctx, mail.Message{
+		To:      data.Recipient.Email,
+		Subject: data.Subject,
+		Text:    text.String(),
+		HTML:    html.String(),
+		Headers: headers,
+	})
+}
+
+// sendEmailConfirmation sends the link confirming a new email address of u to that address
+func (s *Server) sendEmailConfirmation(u pg_model.User, email string) error {
+	u.Email = email
+	return s.sendEmail("confirmation", emailData{
+		Subject:    "Confirm your email address",
+		Recipient:  u,
+		Reason:     "You received this email because this address was added to an account. If it wasn't you, ignore it.",
+		ConfirmURL: s.emailBaseURL + "/api/v1/email/confirm?token=" + url.QueryEscape(s.emailConfirmationToken(u.ID, email, time.Now())),
+	}, "")
+}
+
+// emailNotification queues an email about a notification. Likes aren't emailed: they are too frequent to
+// be worth an email each. Neither are the notifications of the moderators, only shown in the app.
+func (s *Server) emailNotification(userId uint64, notificationType string, actorId uint64, postId []byte) {
+	if s.mailer == nil || notificationType == pg_model.NotificationLike || isModerationNotification(notificationType) {
+		return
+	}
+	job := emailJob{
+		UserID:           userId,
+		NotificationType: notificationType,
+		ActorID:          actorId,
+		PostID:           postId,
+	}
+	select {
+	case s.emailQueue <- job:
+	default:
+		s.logger.Debugf("email queue full, dropping notification email to user %d", userId)
+	}
+}
+
+func (s *Server) runEmailWorker() {
+	for job := range s.emailQueue {
+		err := s.sendNotificationEmail(job)
+		if err != nil {
+			s.logger.Warnf("unable to email notification to user %d: %v", job.UserID, err)
+		}
+	}
+}
+
+func (s *Server) sendNotificationEmail(job emailJob) error {
+	var recipient pg_model.User
+	err := s.pgDB.Take(&recipient, "id = ? AND deleted = false", job.UserID).Error
+	if err != nil {
+		return err
+	}
+	if recipient.Email == "" || !recipient.EmailNotifications {
+		return nil
+	}
+
+	var actor pg_model.User
+	err = s.pgDB.Take(&actor, "id = ?", job.ActorID).Error
+	if err != nil {
+		return err
+	}
+	data := emailData{
+		Recipient: recipient,
+		Reason:    "You received this email because email notifications are enabled.",
+		Actor:     getApiUser(actor),
+		ActorURL:  s.profileURL(actor.Username),
+	}
+	switch job.NotificationType {
+	case pg_model.NotificationFollow:
+		data.Summary = actor.DisplayName + " followed you."
+	case pg_model.NotificationMention:
+		data.Summary = actor.DisplayName + " mentioned you."
+	case pg_model.NotificationReply:
+		data.Summary = actor.DisplayName + " replied to your post."
+	default:
+		return nil
+	}
+	data.Subject = data.Summary
+
+	if job.PostID != nil {
+		var post pg_model.Post
+		err = s.pgDB.Take(&post, "id = ? AND deleted = false", job.PostID).Error
+		if err != nil {
+			return err
+		}
+		data.Post = &emailPost{
+			Author:  data.Actor,
+			Content: post.Content,
+			URL:     s.postURL(post.ID),
+		}
+	}
+
+	return s.sendEmail("notification", data, unsubscribeNotifications)
+}
+
+// runDigestWorker sends the digests that are due
+func (s *Server) runDigestWorker() {
+	for {
+		err := s.sendDueDigests(time.Now())
+		if err != nil {
+			s.logger.Errorf("unable to send digests: %v", err)
+		}
+		time.Sleep(digestCheckInterval)
+	}
+}
+
+func (s *Server) sendDueDigests(now time.Time) error {
+	if s.arangoDB == nil {
+		// Digests are made of the posts of the followed accounts
+		return nil
+	}
+
+	for _, frequency := range []struct {
+		name   string
+		period time.Duration
+	}{
+		{pg_model.DigestDaily, 24 * time.Hour},
+		{pg_model.DigestWeekly, 7 * 24 * time.Hour},
+	} {
+		var users []pg_model.User
+		err := s.pgDB.
+			Where("deleted = false AND email <> '' AND digest_frequency = ?", frequency.name).
+			Where("last_digest_at IS NULL OR last_digest_at <= ?", now.Add(-frequency.period)).
+			Find(&users).Error
+		if err != nil {
+			return err
+		}
+
+		for _, u := range users {
+			err = s.sendDigest(u, frequency.name, frequency.period, now)
+			if err != nil {
+				s.logger.Warnf("unable to send %s digest to user %d: %v", frequency.name, u.ID, err)
+			}
+		}
+	}
+	return nil
+}
+
+// sendDigest emails the top posts of the accounts followed by u over the last period. The digest is
+// claimed first, so that it is only sent once when several instances are running.
+func (s *Server) sendDigest(u pg_model.User, frequency string, period time.Duration, now time.Time) error {
+	claim := s.pgDB.Model(&pg_model.User{}).Where("id = ?", u.ID)
+	if u.LastDigestAt == nil {
+		claim = claim.Where("last_digest_at IS NULL")
+	} else {
+		claim = claim.Where("last_digest_at = ?", *u.LastDigestAt)
+	}
+	claim = claim.UpdateColumn("last_digest_at", now)
+	if claim.Error != nil {
+		return claim.Error
+	}
+	if claim.RowsAffected == 0 {
+		return nil
+	}
+
+	err := s.sendDigestPosts(u, frequency, now.Add(-period))
+	if err != nil {
+		// Released, to be retried at the next check
+		releaseErr := s.pgDB.Model(&pg_model.User{}).
+			Where("id = ?", u.ID).
+			UpdateColumn("last_digest_at", u.LastDigestAt).Error
+		if releaseErr != nil {
+			s.logger.Warnf("unable to release digest of user %d: %v", u.ID, releaseErr)
+		}
+	}
+	return err
+}
+
+func (s *Server) sendDigestPosts(u pg_model.User, frequency string, since time.Time) error {
+	followed, err := s.outboundIds(context.Background(), RelationFollows, userVertex(u.ID), UsersCollection)
+	if errors.Is(err, errGraphUnavailable) || (err == nil && len(followed) == 0) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	var posts []pg_model.Post
+	err = excludeSuspendedAuthors(s.pgDB).
+		Preload("Author").
+		Where("author_id IN ? AND deleted = false AND created_at >= ?", followed, since).
+		Order("likes DESC, id DESC").
+		Limit(digestSize).
+		Find(&posts).Error
+	if err != nil {
+		return err
+	}
+	if len(posts) == 0 {
+		return nil
+	}
+
+	data := emailData{
+		Recipient: u,
+		Reason:    "You received this email because you subscribed to the " + frequency + " digest.",
+		Period:    "day",
+	}
+	if frequency == pg_model.DigestWeekly {
+		data.Period = "week"
+	}
+	data.Subject = "Top posts of the past " + data.Period
+	for _, p := range posts {
+		data.Posts = append(data.Posts, emailPost{
+			Author:  s.getAuthor(p),
+			Content: p.Content,
+			Likes:   p.Likes,
+			URL:     s.postURL(p.ID),
+		})
+	}
+	return s.sendEmail("digest", data, unsubscribeDigest)
+}
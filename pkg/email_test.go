@@ -0,0 +1,202 @@
+package server
+
+import (
+	"context"
+	"database/sql/driver"
+	"github.com/denysvitali/social/backend/pkg/mail"
+	pg_model "github.com/denysvitali/social/backend/pkg/models/postgres"
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeMailer records the messages instead of sending them
+type fakeMailer struct {
+	messages []mail.Message
+}
+
+func (m *fakeMailer) Send(_ context.Context, msg mail.Message) error {
+	m.messages = append(m.messages, msg)
+	return nil
+}
+
+func TestUnsubscribeToken(t *testing.T) {
+	s := &Server{emailSecret: "secret", emailBaseURL: "https://example.com"}
+
+	for _, kind := range []string{unsubscribeNotifications, unsubscribeDigest} {
+		userId, gotKind, err := s.parseUnsubscribeToken(s.unsubscribeToken(42, kind))
+		if err != nil || userId != 42 || gotKind != kind {
+			t.Errorf("got user %d, kind %q, error %v, want user 42, kind %q", userId, gotKind, err, kind)
+		}
+	}
+
+	token := s.unsubscribeToken(42, unsubscribeDigest)
+	signature := token[strings.LastIndex(token, ".")+1:]
+	other := &Server{emailSecret: "other secret"}
+	for name, tampered := range map[string]string{
+		"other user":        "43." + unsubscribeDigest + "." + signature,
+		"other kind":        "42." + unsubscribeNotifications + "." + signature,
+		"altered signature": token[:len(token)-1] + string(token[len(token)-1]^1),
+		"no signature":      "42." + unsubscribeDigest,
+		"empty":             "",
+		"other secret":      other.unsubscribeToken(42, unsubscribeDigest),
+		// Valid signatures of invalid payloads
+		"unknown kind": s.unsubscribeToken(42, "marketing"),
+		"invalid user": "alice." + unsubscribeDigest + "." + s.signUnsubscribe("alice."+unsubscribeDigest),
+	} {
+		if _, _, err := s.parseUnsubscribeToken(tampered); err == nil {
+			t.Errorf("%s: token %q accepted", name, tampered)
+		}
+	}
+
+	disabled := &Server{}
+	if _, _, err := disabled.parseUnsubscribeToken(disabled.unsubscribeToken(42, unsubscribeDigest)); err == nil {
+		t.Errorf("token accepted with emails disabled")
+	}
+}
+
+func TestSendEmail(t *testing.T) {
+	mailer := &fakeMailer{}
+	s := &Server{mailer: mailer, emailSecret: "secret", emailBaseURL: "https://example.com"}
+
+	err := s.sendEmail("notification", emailData{
+		Subject:   "Mallory followed you.",
+		Recipient: pg_model.User{ID: 42, Username: "alice", DisplayName: "Alice", Email: "alice@example.org"},
+		Summary:   "<script>alert(1)</script> followed you.",
+		ActorURL:  "https://example.com/@mallory",
+	}, unsubscribeNotifications)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(mailer.messages) != 1 {
+		t.Fatalf("sent %d emails, want 1", len(mailer.messages))
+	}
+	msg := mailer.messages[0]
+	if msg.To != "alice@example.org" || msg.Subject != "Mallory followed you." {
+		t.Errorf("got recipient %q, subject %q", msg.To, msg.Subject)
+	}
+
+	unsubscribeURL := strings.TrimSuffix(strings.TrimPrefix(msg.Headers["List-Unsubscribe"], "<"), ">")
+	u, err := url.Parse(unsubscribeURL)
+	if err != nil || u.Host != "example.com" || u.Path != "/api/v1/email/unsubscribe" {
+		t.Fatalf("invalid unsubscribe URL %q: %v", unsubscribeURL, err)
+	}
+	userId, kind, err := s.parseUnsubscribeToken(u.Query().Get("token"))
+	if err != nil || userId != 42 || kind != unsubscribeNotifications {
+		t.Errorf("unsubscribe link for user %d, kind %q, error %v", userId, kind, err)
+	}
+	if msg.Headers["List-Unsubscribe-Post"] != "List-Unsubscribe=One-Click" {
+		t.Errorf("got List-Unsubscribe-Post %q", msg.Headers["List-Unsubscribe-Post"])
+	}
+
+	if strings.Contains(msg.HTML, "<script>") {
+		t.Errorf("HTML isn't escaped: %s", msg.HTML)
+	}
+	if !strings.Contains(msg.Text, unsubscribeURL) {
+		t.Errorf("text doesn't contain the unsubscribe link: %s", msg.Text)
+	}
+}
+
+func TestEmailConfirmationToken(t *testing.T) {
+	s := &Server{emailSecret: "secret"}
+	now := time.Now()
+
+	token := s.emailConfirmationToken(42, "alice@example.org", now)
+	userId, email, err := s.parseEmailConfirmationToken(token, now.Add(time.Hour))
+	if err != nil || userId != 42 || email != "alice@example.org" {
+		t.Errorf("got user %d, email %q, error %v", userId, email, err)
+	}
+
+	if _, _, err := s.parseEmailConfirmationToken(token, now.Add(emailConfirmationLifetime+time.Minute)); err == nil {
+		t.Errorf("expired token accepted")
+	}
+	other := s.emailConfirmationToken(42, "mallory@example.org", now)
+	signature := token[strings.LastIndex(token, ".")+1:]
+	for name, invalid := range map[string]string{
+		"other address":     other[:strings.LastIndex(other, ".")+1] + signature,
+		"altered signature": token[:len(token)-1] + string(token[len(token)-1]^1),
+		"unsubscribe token": s.unsubscribeToken(42, unsubscribeNotifications),
+		"other secret":      (&Server{emailSecret: "other secret"}).emailConfirmationToken(42, "alice@example.org", now),
+	} {
+		if _, _, err := s.parseEmailConfirmationToken(invalid, now); err == nil {
+			t.Errorf("%s: token %q accepted", name, invalid)
+		}
+	}
+}
+
+func TestUpdateEmailRequiresConfirmation(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	db, fake := newFakeDB(t, func(query string, args []driver.Value) (fakeResult, error) {
+		if strings.HasPrefix(query, "SELECT count(*)") {
+			return fakeResult{columns: []string{"count"}, rows: [][]driver.Value{{int64(0)}}}, nil
+		}
+		return fakeResult{
+			columns: []string{"id", "username", "display_name", "email", "pending_email"},
+			rows:    [][]driver.Value{{int64(42), "alice", "Alice", "alice@example.org", ""}},
+		}, nil
+	})
+	mailer := &fakeMailer{}
+	log := logrus.New()
+	log.SetOutput(io.Discard)
+	s := &Server{logger: log, pgDB: db, mailer: mailer, emailSecret: "secret", emailBaseURL: "https://example.com"}
+	e := gin.New()
+	e.PUT("/settings/email", s.apiV1UpdateEmailSettings)
+	e.POST("/email/confirm", s.apiV1ConfirmEmail)
+
+	req := httptest.NewRequest(http.MethodPut, "/settings/email", strings.NewReader(`{"email":"mallory@example.org"}`))
+	req.Header.Set(ViewerHeader, "42")
+	w := httptest.NewRecorder()
+	e.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("got status %d: %s", w.Code, w.Body)
+	}
+
+	execs := fake.execs()
+	if len(execs) != 1 || !strings.Contains(execs[0].query, `"pending_email"=`) || strings.Contains(execs[0].query, `"email"=`) {
+		t.Fatalf("got statements %v, want the address to be pending only", execs)
+	}
+	if len(mailer.messages) != 1 || mailer.messages[0].To != "mallory@example.org" {
+		t.Fatalf("got emails %v, want a confirmation sent to the new address", mailer.messages)
+	}
+	msg := mailer.messages[0]
+	if _, ok := msg.Headers["List-Unsubscribe"]; ok {
+		t.Errorf("confirmation email has an unsubscribe link")
+	}
+
+	i := strings.Index(msg.Text, "https://example.com/api/v1/email/confirm?token=")
+	if i < 0 {
+		t.Fatalf("no confirmation link in %s", msg.Text)
+	}
+	link, err := url.Parse(strings.Fields(msg.Text[i:])[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	req = httptest.NewRequest(http.MethodPost, "/email/confirm?"+link.RawQuery, nil)
+	w = httptest.NewRecorder()
+	e.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("got status %d: %s", w.Code, w.Body)
+	}
+
+	execs = fake.execs()
+	if len(execs) != 2 {
+		t.Fatalf("got statements %v, want the confirmation", execs)
+	}
+	confirm := execs[1]
+	if !strings.Contains(confirm.query, `"email"=`) || !strings.Contains(confirm.query, "pending_email = ") {
+		t.Errorf("got statement %v, want the pending address to replace the email", confirm)
+	}
+	found := false
+	for _, arg := range confirm.args {
+		found = found || arg == "mallory@example.org"
+	}
+	if !found {
+		t.Errorf("got arguments %v, want the confirmed address", confirm.args)
+	}
+}
@@ -0,0 +1,14 @@
+package entities
+
+import (
+	"golang.org/x/text/cases"
+	"golang.org/x/text/unicode/norm"
+)
+
+// CanonicalTag returns the form under which a hashtag is stored and looked up, so that
+// #Music, #music and #ｍｕｓｉｃ are the same tag: compatibility characters are normalized
+// (NFKC) and case is folded.
+func CanonicalTag(text string) string {
+	// Case folding can produce non-normalized strings, hence the second pass
+	return norm.NFKC.String(cases.Fold().String(norm.NFKC.String(text)))
+}
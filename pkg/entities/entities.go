@@ -0,0 +1,198 @@
+// Package entities finds hashtags, mentions and links in the content of a post.
+//
+// It is the single source of truth for what counts as an entity: the same ranges are used to
+// link posts to their tags and mentioned users, and returned to clients for highlighting.
+package entities
+
+import (
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+type Type string
+
+const (
+	Hashtag Type = "hashtag"
+	Mention Type = "mention"
+	URL     Type = "url"
+)
+
+// MaxUsernameLength is the maximum length of a username that can be mentioned
+const MaxUsernameLength = 30
+
+// MaxHashtagLength is the maximum length of a hashtag, without the leading #
+const MaxHashtagLength = 100
+
+// Entity is a range of the content of a post. Offsets are expressed in Unicode code points
+// (not bytes), Start is inclusive and End is exclusive.
+type Entity struct {
+	Type  Type
+	Start int
+	End   int
+
+	// Text is the hashtag or username without its leading # or @, or the URL
+	Text string
+}
+
+var urlRegexp = regexp.MustCompile(`https?://[^\s<>"]+`)
+
+// Parse returns the entities of content, in order of appearance
+func Parse(content string) []Entity {
+	var result []Entity
+
+	// Links first: hashtags and mentions inside URLs (fragments, paths...) aren't entities
+	var urlRanges [][]int
+	for _, loc := range urlRegexp.FindAllStringIndex(content, -1) {
+		u := trimURL(content[loc[0]:loc[1]])
+		if len(u) <= len("https://") {
+			continue
+		}
+		end := loc[0] + len(u)
+		urlRanges = append(urlRanges, []int{loc[0], end})
+	}
+
+	runeIdx := 0
+	urlIdx := 0
+	var prev rune
+	for i := 0; i < len(content); {
+		if urlIdx < len(urlRanges) && i == urlRanges[urlIdx][0] {
+			end := urlRanges[urlIdx][1]
+			u := content[i:end]
+			n := utf8.RuneCountInString(u)
+			result = append(result, Entity{Type: URL, Start: runeIdx, End: runeIdx + n, Text: u})
+			runeIdx += n
+			i = end
+			prev, _ = utf8.DecodeLastRuneInString(u)
+			urlIdx++
+			continue
+		}
+
+		r, size := utf8.DecodeRuneInString(content[i:])
+		if (r == '#' || r == '@') && !IsWordRune(prev) && prev != '#' && prev != '@' {
+			limit := len(content)
+			if urlIdx < len(urlRanges) {
+				limit = urlRanges[urlIdx][0]
+			}
+
+			var text string
+			if r == '#' {
+				text = scanHashtag(content[i+size : limit])
+			} else {
+				text = scanMention(content[i+size : limit])
+			}
+
+			if text != "" {
+				n := utf8.RuneCountInString(text) + 1
+				t := Hashtag
+				if r == '@' {
+					t = Mention
+				}
+				result = append(result, Entity{Type: t, Start: runeIdx, End: runeIdx + n, Text: text})
+				runeIdx += n
+				i += size + len(text)
+				prev, _ = utf8.DecodeLastRuneInString(text)
+				continue
+			}
+		}
+
+		prev = r
+		runeIdx++
+		i += size
+	}
+
+	return result
+}
+
+// Texts returns the (de-duplicated) text of the entities of type t
+func Texts(entities []Entity, t Type) []string {
+	seen := map[string]bool{}
+	var result []string
+	for _, e := range entities {
+		if e.Type != t || seen[e.Text] {
+			continue
+		}
+		seen[e.Text] = true
+		result = append(result, e.Text)
+	}
+	return result
+}
+
+// DisplayURL returns a shortened version of u, suitable to be shown in place of the link
+func DisplayURL(u string) string {
+	const maxLength = 30
+	d := strings.TrimPrefix(strings.TrimPrefix(u, "https://"), "http://")
+	d = strings.TrimPrefix(d, "www.")
+	r := []rune(d)
+	if len(r) > maxLength {
+		return string(r[:maxLength-1]) + "…"
+	}
+	return d
+}
+
+// trimURL removes the punctuation following a link, which most likely belongs to the sentence.
+// Closing parentheses are kept when balanced, e.g. for Wikipedia links.
+func trimURL(u string) string {
+	for len(u) > 0 {
+		last := u[len(u)-1]
+		switch last {
+		case '.', ',', ';', ':', '!', '?', '\'', ']', '}':
+			u = u[:len(u)-1]
+		case ')':
+			if strings.Count(u, "(") >= strings.Count(u, ")") {
+				return u
+			}
+			u = u[:len(u)-1]
+		default:
+			return u
+		}
+	}
+	return u
+}
+
+func scanHashtag(s string) string {
+	end := 0
+	length := 0
+	hasNonDigit := false
+	for i, r := range s {
+		if !IsWordRune(r) {
+			break
+		}
+		if !unicode.IsDigit(r) {
+			hasNonDigit = true
+		}
+		length++
+		end = i + utf8.RuneLen(r)
+	}
+	// Tags made only of digits (#1) are usually not hashtags
+	if !hasNonDigit || length > MaxHashtagLength {
+		return ""
+	}
+	return s[:end]
+}
+
+func scanMention(s string) string {
+	end := 0
+	for i, r := range s {
+		if r > unicode.MaxASCII || !(r == '_' || unicode.IsLetter(r) || unicode.IsDigit(r)) {
+			break
+		}
+		end = i + 1
+	}
+	if end > MaxUsernameLength {
+		return ""
+	}
+	// e-mail addresses and the like: the mention must not be directly followed by another word
+	if end < len(s) {
+		if r, _ := utf8.DecodeRuneInString(s[end:]); r == '@' || IsWordRune(r) {
+			return ""
+		}
+	}
+	return s[:end]
+}
+
+// IsWordRune returns whether r can be part of a word, and therefore of a hashtag
+func IsWordRune(r rune) bool {
+	return r == '_' || unicode.IsLetter(r) || unicode.IsDigit(r) || unicode.Is(unicode.Mn, r)
+}
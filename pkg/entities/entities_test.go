@@ -0,0 +1,88 @@
+package entities
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	for _, tc := range []struct {
+		content string
+		want    []Entity
+	}{
+		// Offsets are in code points
+		{"héllo #tag", []Entity{{Hashtag, 6, 10, "tag"}}},
+		{"😀 #go @bob", []Entity{{Hashtag, 2, 5, "go"}, {Mention, 6, 10, "bob"}}},
+		{"日本語 #東京 です", []Entity{{Hashtag, 4, 7, "東京"}}},
+		{"👩‍💻 https://example.com", []Entity{{URL, 4, 23, "https://example.com"}}},
+
+		// Trailing punctuation
+		{"see https://example.com/a.", []Entity{{URL, 4, 25, "https://example.com/a"}}},
+		{"(see https://example.com/a?), ok", []Entity{{URL, 5, 26, "https://example.com/a"}}},
+		{"https://en.wikipedia.org/wiki/Go_(language)", []Entity{{URL, 0, 43, "https://en.wikipedia.org/wiki/Go_(language)"}}},
+		{"#go, #rust! #c.", []Entity{{Hashtag, 0, 3, "go"}, {Hashtag, 5, 10, "rust"}, {Hashtag, 12, 14, "c"}}},
+		{"hi @alice.", []Entity{{Mention, 3, 9, "alice"}}},
+
+		// Links hide the hashtags and mentions they contain
+		{"https://example.com/#frag @bob", []Entity{{URL, 0, 25, "https://example.com/#frag"}, {Mention, 26, 30, "bob"}}},
+		{"https://example.com/@bob", []Entity{{URL, 0, 24, "https://example.com/@bob"}}},
+
+		// E-mail addresses aren't mentions
+		{"mail bob@example.com", nil},
+		{"mail @bob@example.com", nil},
+
+		// # and @ inside words
+		{"a#b c@d", nil},
+		{"C# and F#", nil},
+		{"##go @@bob", nil},
+
+		// Tags made only of digits
+		{"#1 #2a", []Entity{{Hashtag, 3, 6, "2a"}}},
+		{"", nil},
+	} {
+		got := Parse(tc.content)
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("Parse(%q) = %+v, want %+v", tc.content, got, tc.want)
+		}
+	}
+}
+
+func TestCanonicalTag(t *testing.T) {
+	for _, tc := range []struct {
+		text string
+		want string
+	}{
+		{"music", "music"},
+		{"Music", "music"},
+		{"ＭＵＳＩＣ", "music"},
+		{"ﬁsh", "fish"},
+		{"Straße", "strasse"},
+		{"Ωmega", "ωmega"},
+		// Composed and decomposed forms
+		{"Café", "café"},
+		{"東京", "東京"},
+	} {
+		if got := CanonicalTag(tc.text); got != tc.want {
+			t.Errorf("CanonicalTag(%q) = %q, want %q", tc.text, got, tc.want)
+		}
+	}
+}
+
+func TestDisplayURL(t *testing.T) {
+	for _, tc := range []struct {
+		url  string
+		want string
+	}{
+		{"https://example.com", "example.com"},
+		{"http://www.example.com/a", "example.com/a"},
+		{"https://example.com/a-path-longer-than-thirty", "example.com/a-path-longer-tha…"},
+		{"https://example.com/abcdefghijklmnopqr", "example.com/abcdefghijklmnopqr"},
+		// Truncated on code points
+		{"https://例え.jp/" + strings.Repeat("日本語", 9), "例え.jp/" + strings.Repeat("日本語", 7) + "日本…"},
+	} {
+		if got := DisplayURL(tc.url); got != tc.want {
+			t.Errorf("DisplayURL(%q) = %q, want %q", tc.url, got, tc.want)
+		}
+	}
+}
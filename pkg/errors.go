@@ -1,11 +1,22 @@
 package server
 
 import (
+	"errors"
 	"fmt"
 	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgconn"
 	"net/http"
 )
 
+// pgUniqueViolation is the SQLSTATE of the errors raised when a row conflicts with a unique constraint
+const pgUniqueViolation = "23505"
+
+// isUniqueViolation returns whether err was raised by PostgreSQL because of a unique constraint
+func isUniqueViolation(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == pgUniqueViolation
+}
+
 func (s *Server) internalServerError(c *gin.Context, format string, args ...any) {
 	s.logger.Errorf(format, args...)
 	c.JSON(http.StatusInternalServerError, gin.H{
@@ -33,3 +44,24 @@ func (s *Server) paramCantBeEmpty(c *gin.Context, param string) {
 		fmt.Sprintf("key \"%s\" cannot be empty", param),
 	)
 }
+
+func (s *Server) unauthorized(c *gin.Context, message string, args ...any) {
+	s.logger.Warnf(message, args...)
+	c.JSON(http.StatusUnauthorized, gin.H{
+		"error": "unauthorized",
+	})
+}
+
+func (s *Server) forbidden(c *gin.Context, message string, args ...any) {
+	s.logger.Warnf(message, args...)
+	c.JSON(http.StatusForbidden, gin.H{
+		"error": "forbidden",
+	})
+}
+
+func (s *Server) badGateway(c *gin.Context, message string, args ...any) {
+	s.logger.Warnf(message, args...)
+	c.JSON(http.StatusBadGateway, gin.H{
+		"error": "bad gateway",
+	})
+}
@@ -0,0 +1,150 @@
+package server
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+	"io"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+// fakeResult is what fakeDB answers to a query
+type fakeResult struct {
+	columns []string
+	rows    [][]driver.Value
+}
+
+// fakeStatement is a statement run against fakeDB
+type fakeStatement struct {
+	query string
+	args  []driver.Value
+	// rows is whether the statement was run as a query, returning rows
+	rows bool
+}
+
+// fakeDB is a database/sql driver answering queries with a function, and recording the statements.
+// It lets tests check the SQL issued by handlers without a PostgreSQL server.
+type fakeDB struct {
+	query func(query string, args []driver.Value) (fakeResult, error)
+
+	mu         sync.Mutex
+	statements []fakeStatement
+}
+
+var fakeDBs sync.Map
+var fakeDBCount int64
+
+func init() {
+	sql.Register("fakedb", fakeDriver{})
+}
+
+// newFakeDB returns a gorm connection to a new fakeDB
+func newFakeDB(t *testing.T, query func(query string, args []driver.Value) (fakeResult, error)) (*gorm.DB, *fakeDB) {
+	t.Helper()
+	f := &fakeDB{query: query}
+	name := fmt.Sprintf("fakedb-%d", atomic.AddInt64(&fakeDBCount, 1))
+	fakeDBs.Store(name, f)
+	t.Cleanup(func() { fakeDBs.Delete(name) })
+
+	sqlDB, err := sql.Open("fakedb", name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { sqlDB.Close() })
+	db, err := gorm.Open(postgres.New(postgres.Config{Conn: sqlDB}), &gorm.Config{
+		Logger: logger.Default.LogMode(logger.Silent),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return db, f
+}
+
+// execs returns the statements that didn't return rows
+func (f *fakeDB) execs() []fakeStatement {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var execs []fakeStatement
+	for _, s := range f.statements {
+		if !s.rows {
+			execs = append(execs, s)
+		}
+	}
+	return execs
+}
+
+func (f *fakeDB) record(query string, args []driver.NamedValue, rows bool) []driver.Value {
+	values := make([]driver.Value, len(args))
+	for i, a := range args {
+		values[i] = a.Value
+	}
+	f.mu.Lock()
+	f.statements = append(f.statements, fakeStatement{query: query, args: values, rows: rows})
+	f.mu.Unlock()
+	return values
+}
+
+type fakeDriver struct{}
+
+func (fakeDriver) Open(name string) (driver.Conn, error) {
+	f, ok := fakeDBs.Load(name)
+	if !ok {
+		return nil, fmt.Errorf("unknown fake database %s", name)
+	}
+	return &fakeConn{db: f.(*fakeDB)}, nil
+}
+
+type fakeConn struct {
+	db *fakeDB
+}
+
+func (c *fakeConn) Prepare(string) (driver.Stmt, error) {
+	return nil, fmt.Errorf("prepared statements aren't supported")
+}
+
+func (c *fakeConn) Close() error { return nil }
+
+func (c *fakeConn) Begin() (driver.Tx, error) { return fakeTx{}, nil }
+
+func (c *fakeConn) QueryContext(_ context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	values := c.db.record(query, args, true)
+	res, err := c.db.query(query, values)
+	if err != nil {
+		return nil, err
+	}
+	return &fakeRows{result: res}, nil
+}
+
+func (c *fakeConn) ExecContext(_ context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	c.db.record(query, args, false)
+	return driver.RowsAffected(1), nil
+}
+
+type fakeTx struct{}
+
+func (fakeTx) Commit() error   { return nil }
+func (fakeTx) Rollback() error { return nil }
+
+type fakeRows struct {
+	result fakeResult
+	next   int
+}
+
+func (r *fakeRows) Columns() []string { return r.result.columns }
+
+func (r *fakeRows) Close() error { return nil }
+
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if r.next >= len(r.result.rows) {
+		return io.EOF
+	}
+	copy(dest, r.result.rows[r.next])
+	r.next++
+	return nil
+}
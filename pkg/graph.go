@@ -0,0 +1,319 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"github.com/arangodb/go-driver"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Edge types of SocialNetworkRelations
+const (
+	RelationFollows = "follows"
+	// RelationBlocks hides the content of each user from the other, and prevents them from following each
+	// other
+	RelationBlocks = "blocks"
+	// RelationMutes hides the content of the muted user from the timelines, notifications and searches of
+	// the muter only
+	RelationMutes = "mutes"
+)
+
+const graphTimeout = 5 * time.Second
+
+var errGraphUnavailable = fmt.Errorf("graph database not configured")
+
+// relation is an edge of SocialNetworkRelations. Its key is derived from its type and
+// endpoints, so that an edge can't be created twice.
+type relation struct {
+	Key       string    `json:"_key"`
+	From      string    `json:"_from"`
+	To        string    `json:"_to"`
+	Type      string    `json:"type"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+func userVertex(id uint64) string {
+	return fmt.Sprintf("%s/%d", UsersCollection, id)
+}
+
+func tagVertex(id uint64) string {
+	return fmt.Sprintf("%s/%d", TagsCollection, id)
+}
+
+func relationKey(relType string, from string, to string) string {
+	return strings.ReplaceAll(relType+"-"+from+"-"+to, "/", ":")
+}
+
+// initArango creates the collections and the graph, if they don't exist yet
+func (s *Server) initArango() error {
+	ctx, cancel := context.WithTimeout(context.Background(), graphTimeout)
+	defer cancel()
+
+	for _, v := range []struct {
+		name string
+		t    driver.CollectionType
+	}{
+		{UsersCollection, driver.CollectionTypeDocument},
+		{TagsCollection, driver.CollectionTypeDocument},
+		{SocialNetworkRelations, driver.CollectionTypeEdge},
+	} {
+		exists, err := s.arangoDB.CollectionExists(ctx, v.name)
+		if err != nil {
+			return fmt.Errorf("unable to check collection %s: %v", v.name, err)
+		}
+		if exists {
+			continue
+		}
+		_, err = s.arangoDB.CreateCollection(ctx, v.name, &driver.CreateCollectionOptions{Type: v.t})
+		if err != nil {
+			return fmt.Errorf("unable to create collection %s: %v", v.name, err)
+		}
+	}
+
+	relations, err := s.arangoDB.Collection(ctx, SocialNetworkRelations)
+	if err != nil {
+		return err
+	}
+	_, _, err = relations.EnsurePersistentIndex(ctx, []string{"_from", "type"}, nil)
+	if err != nil {
+		return fmt.Errorf("unable to create relations index: %v", err)
+	}
+	_, _, err = relations.EnsurePersistentIndex(ctx, []string{"_to", "type"}, nil)
+	if err != nil {
+		return fmt.Errorf("unable to create relations index: %v", err)
+	}
+
+	exists, err := s.arangoDB.GraphExists(ctx, SocialNetworkGraph)
+	if err != nil {
+		return fmt.Errorf("unable to check graph: %v", err)
+	}
+	if !exists {
+		_, err = s.arangoDB.CreateGraph(ctx, SocialNetworkGraph, &driver.CreateGraphOptions{
+			EdgeDefinitions: []driver.EdgeDefinition{
+				{
+					Collection: SocialNetworkRelations,
+					From:       []string{UsersCollection},
+					To:         []string{UsersCollection, TagsCollection},
+				},
+			},
+		})
+		if err != nil {
+			return fmt.Errorf("unable to create graph: %v", err)
+		}
+	}
+
+	// Edges created before addRelation ensured their vertices exist are invisible to traversals
+	for _, collection := range []string{UsersCollection, TagsCollection} {
+		cursor, err := s.arangoDB.Query(ctx, `
+			FOR e IN @@relations
+				FOR id IN [e._from, e._to]
+					FILTER IS_SAME_COLLECTION(@collection, id) AND DOCUMENT(id) == null
+					COLLECT key = PARSE_IDENTIFIER(id).key
+					INSERT {_key: key} INTO @@vertices OPTIONS {ignoreErrors: true}`,
+			map[string]any{
+				"@relations": SocialNetworkRelations,
+				"collection": collection,
+				"@vertices":  collection,
+			})
+		if err != nil {
+			return fmt.Errorf("unable to create missing %s vertices: %v", collection, err)
+		}
+		cursor.Close()
+	}
+	return nil
+}
+
+// addRelation creates an edge of type relType between two vertices. It returns false if the edge already existed.
+func (s *Server) addRelation(ctx context.Context, relType string, from string, to string) (bool, error) {
+	if s.arangoDB == nil {
+		return false, errGraphUnavailable
+	}
+	// Traversals can only walk through existing vertices
+	for _, v := range []string{from, to} {
+		err := s.ensureVertex(ctx, v)
+		if err != nil {
+			return false, err
+		}
+	}
+
+	col, err := s.arangoDB.Collection(ctx, SocialNetworkRelations)
+	if err != nil {
+		return false, err
+	}
+
+	_, err = col.CreateDocument(ctx, relation{
+		Key:       relationKey(relType, from, to),
+		From:      from,
+		To:        to,
+		Type:      relType,
+		CreatedAt: time.Now(),
+	})
+	if err != nil {
+		if driver.IsConflict(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+// ensureVertex creates the (empty) vertex document with the given ID, if it doesn't exist
+func (s *Server) ensureVertex(ctx context.Context, id string) error {
+	collection, key, ok := strings.Cut(id, "/")
+	if !ok {
+		return fmt.Errorf("invalid vertex %s", id)
+	}
+	col, err := s.arangoDB.Collection(ctx, collection)
+	if err != nil {
+		return err
+	}
+	_, err = col.CreateDocument(ctx, map[string]string{"_key": key})
+	if err != nil && !driver.IsConflict(err) {
+		return err
+	}
+	return nil
+}
+
+// removeRelation removes the edge of type relType between two vertices. It returns false if there was no such edge.
+func (s *Server) removeRelation(ctx context.Context, relType string, from string, to string) (bool, error) {
+	if s.arangoDB == nil {
+		return false, errGraphUnavailable
+	}
+	col, err := s.arangoDB.Collection(ctx, SocialNetworkRelations)
+	if err != nil {
+		return false, err
+	}
+
+	_, err = col.RemoveDocument(ctx, relationKey(relType, from, to))
+	if err != nil {
+		if driver.IsNotFound(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+// hasRelation returns whether there's an edge of type relType between two vertices
+func (s *Server) hasRelation(ctx context.Context, relType string, from string, to string) (bool, error) {
+	if s.arangoDB == nil {
+		return false, errGraphUnavailable
+	}
+	col, err := s.arangoDB.Collection(ctx, SocialNetworkRelations)
+	if err != nil {
+		return false, err
+	}
+	return col.DocumentExists(ctx, relationKey(relType, from, to))
+}
+
+// outboundIds returns the IDs of the vertices of collection that from points to, with edges of type relType
+func (s *Server) outboundIds(ctx context.Context, relType string, from string, collection string) ([]uint64, error) {
+	return s.relatedIds(ctx, `
+		FOR v, e IN 1..1 OUTBOUND @vertex GRAPH @graph
+			FILTER e.type == @type AND IS_SAME_COLLECTION(@collection, e._to)
+			RETURN e._to`, relType, from, collection)
+}
+
+// inboundIds returns the IDs of the vertices of collection pointing to to, with edges of type relType
+func (s *Server) inboundIds(ctx context.Context, relType string, to string, collection string) ([]uint64, error) {
+	return s.relatedIds(ctx, `
+		FOR v, e IN 1..1 INBOUND @vertex GRAPH @graph
+			FILTER e.type == @type AND IS_SAME_COLLECTION(@collection, e._from)
+			RETURN e._from`, relType, to, collection)
+}
+
+// followDistances returns the users reachable from userId by following up to maxDepth follows,
+// mapped to the number of follows separating them from userId.
+// PRUNE is also evaluated on the start vertex, whose edge is null. The filter on the types of the edges of
+// the path is applied while traversing, so that blocks and mutes are never followed: otherwise, with
+// global uniqueness, a user first reached through one of them would be marked as visited and get no
+// distance.
+func (s *Server) followDistances(ctx context.Context, userId uint64, maxDepth int) (map[uint64]int, error) {
+	if s.arangoDB == nil {
+		return nil, errGraphUnavailable
+	}
+
+	cursor, err := s.arangoDB.Query(ctx, `
+		FOR v, e, p IN 1..@depth OUTBOUND @vertex GRAPH @graph
+			PRUNE e != null AND e.type != @type
+			OPTIONS {order: "bfs", uniqueVertices: "global"}
+			FILTER p.edges[*].type ALL == @type
+			FILTER IS_SAME_COLLECTION(@collection, v)
+			RETURN {key: v._key, depth: LENGTH(p.edges)}`,
+		map[string]any{
+			"depth":      maxDepth,
+			"vertex":     userVertex(userId),
+			"graph":      SocialNetworkGraph,
+			"type":       RelationFollows,
+			"collection": UsersCollection,
+		})
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close()
+
+	distances := map[uint64]int{}
+	for {
+		var doc struct {
+			Key   string `json:"key"`
+			Depth int    `json:"depth"`
+		}
+		_, err = cursor.ReadDocument(ctx, &doc)
+		if driver.IsNoMoreDocuments(err) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		id, err := strconv.ParseUint(doc.Key, 10, 64)
+		if err != nil || id == userId {
+			continue
+		}
+		distances[id] = doc.Depth
+	}
+	return distances, nil
+}
+
+func (s *Server) relatedIds(ctx context.Context, query string, relType string, vertex string, collection string) ([]uint64, error) {
+	return s.queryIds(ctx, query, map[string]any{
+		"vertex":     vertex,
+		"graph":      SocialNetworkGraph,
+		"type":       relType,
+		"collection": collection,
+	})
+}
+
+// queryIds runs an AQL query returning vertex IDs, and returns their keys
+func (s *Server) queryIds(ctx context.Context, query string, bindVars map[string]any) ([]uint64, error) {
+	if s.arangoDB == nil {
+		return nil, errGraphUnavailable
+	}
+
+	cursor, err := s.arangoDB.Query(ctx, query, bindVars)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close()
+
+	var ids []uint64
+	for {
+		var docId string
+		_, err = cursor.ReadDocument(ctx, &docId)
+		if driver.IsNoMoreDocuments(err) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		_, key, _ := strings.Cut(docId, "/")
+		id, err := strconv.ParseUint(key, 10, 64)
+		if err != nil {
+			s.logger.Warnf("unexpected vertex %s in query result", docId)
+			continue
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
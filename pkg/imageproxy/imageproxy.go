@@ -0,0 +1,351 @@
+// Package imageproxy fetches external images on behalf of clients and caches them on disk, so that
+// viewers don't connect to third parties and slow upstreams are only hit once.
+//
+// The cache is bounded in size: the least recently used images are evicted first.
+package imageproxy
+
+import (
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultMaxObjectSize is the maximum size of a cached image, in bytes
+const DefaultMaxObjectSize = 10 << 20
+
+// DefaultMaxAge is how long a cached image is served before being fetched again
+const DefaultMaxAge = 7 * 24 * time.Hour
+
+const metaSuffix = ".json"
+
+// ErrNotImage is returned when the upstream doesn't serve an image
+var ErrNotImage = errors.New("upstream response is not an image")
+
+// Proxy is a disk-backed cache of external images
+type Proxy struct {
+	client *http.Client
+	dir    string
+
+	// MaxBytes is the total size of the cached images
+	MaxBytes int64
+	// MaxObjectSize is the maximum size of an image, larger images aren't served
+	MaxObjectSize int64
+	// MaxAge is how long a cached image is fresh. Stale images are served when the upstream fails.
+	MaxAge time.Duration
+
+	mu      sync.Mutex
+	lru     *list.List // of *entry, most recently used first
+	entries map[string]*list.Element
+	size    int64
+	calls   map[string]*call
+}
+
+type entry struct {
+	// name is the file name of the image in the cache directory, derived from the URL
+	name string
+	meta Meta
+}
+
+// Meta describes a cached image
+type Meta struct {
+	URL         string    `json:"url"`
+	ContentType string    `json:"contentType"`
+	Size        int64     `json:"size"`
+	ETag        string    `json:"etag"`
+	FetchedAt   time.Time `json:"fetchedAt"`
+}
+
+// Image is an open cached image
+type Image struct {
+	*os.File
+	Meta
+}
+
+// call is a fetch in progress, shared by the concurrent requests of the same URL
+type call struct {
+	done chan struct{}
+	err  error
+}
+
+// New returns a Proxy caching at most maxBytes of images in dir, indexing the images already there
+func New(client *http.Client, dir string, maxBytes int64) (*Proxy, error) {
+	err := os.MkdirAll(dir, 0o755)
+	if err != nil {
+		return nil, err
+	}
+	p := &Proxy{
+		client:        client,
+		dir:           dir,
+		MaxBytes:      maxBytes,
+		MaxObjectSize: DefaultMaxObjectSize,
+		MaxAge:        DefaultMaxAge,
+		lru:           list.New(),
+		entries:       map[string]*list.Element{},
+		calls:         map[string]*call{},
+	}
+	err = p.load()
+	if err != nil {
+		return nil, fmt.Errorf("unable to load cache index: %v", err)
+	}
+	return p, nil
+}
+
+// load indexes the cached images, the least recently used being the least recently modified
+func (p *Proxy) load() error {
+	files, err := os.ReadDir(p.dir)
+	if err != nil {
+		return err
+	}
+
+	type loaded struct {
+		entry   *entry
+		modTime time.Time
+	}
+	var all []loaded
+	for _, f := range files {
+		if !strings.HasSuffix(f.Name(), metaSuffix) {
+			continue
+		}
+		name := strings.TrimSuffix(f.Name(), metaSuffix)
+		e, err := p.readMeta(name)
+		if err != nil {
+			// Incomplete entry, e.g. after a crash
+			p.removeFiles(name)
+			continue
+		}
+		info, err := os.Stat(filepath.Join(p.dir, name))
+		if err != nil || info.Size() != e.meta.Size {
+			p.removeFiles(name)
+			continue
+		}
+		all = append(all, loaded{entry: e, modTime: info.ModTime()})
+	}
+	sort.Slice(all, func(i, j int) bool {
+		return all[i].modTime.After(all[j].modTime)
+	})
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, l := range all {
+		p.entries[l.entry.name] = p.lru.PushBack(l.entry)
+		p.size += l.entry.meta.Size
+	}
+	p.evict()
+	return nil
+}
+
+func (p *Proxy) readMeta(name string) (*entry, error) {
+	data, err := os.ReadFile(filepath.Join(p.dir, name+metaSuffix))
+	if err != nil {
+		return nil, err
+	}
+	e := entry{name: name}
+	err = json.Unmarshal(data, &e.meta)
+	if err != nil {
+		return nil, err
+	}
+	return &e, nil
+}
+
+// Open returns the cached copy of the image at url, fetching it if needed. The caller must close it.
+func (p *Proxy) Open(ctx context.Context, url string) (*Image, error) {
+	name := cacheName(url)
+
+	img, fresh := p.openCached(name)
+	if fresh {
+		return img, nil
+	}
+
+	err := p.fetchOnce(ctx, name, url)
+	if err != nil {
+		if img != nil {
+			// Better stale than nothing
+			return img, nil
+		}
+		return nil, err
+	}
+	if img != nil {
+		img.Close()
+	}
+
+	img, _ = p.openCached(name)
+	if img == nil {
+		return nil, fmt.Errorf("image %s was evicted right after being fetched", url)
+	}
+	return img, nil
+}
+
+// openCached opens the cached image name, if any, and reports whether it's fresh
+func (p *Proxy) openCached(name string) (*Image, bool) {
+	p.mu.Lock()
+	el, ok := p.entries[name]
+	if !ok {
+		p.mu.Unlock()
+		return nil, false
+	}
+	p.lru.MoveToFront(el)
+	meta := el.Value.(*entry).meta
+	p.mu.Unlock()
+
+	// An evicted file stays readable once opened
+	f, err := os.Open(filepath.Join(p.dir, name))
+	if err != nil {
+		return nil, false
+	}
+	// Persist the recency of the entry, for when the index is loaded again
+	now := time.Now()
+	_ = os.Chtimes(f.Name(), now, now)
+
+	return &Image{File: f, Meta: meta}, time.Since(meta.FetchedAt) < p.MaxAge
+}
+
+// fetchOnce fetches url, unless it's already being fetched in which case it waits for that fetch
+func (p *Proxy) fetchOnce(ctx context.Context, name string, url string) error {
+	p.mu.Lock()
+	if c, ok := p.calls[name]; ok {
+		p.mu.Unlock()
+		select {
+		case <-c.done:
+			return c.err
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	c := &call{done: make(chan struct{})}
+	p.calls[name] = c
+	p.mu.Unlock()
+
+	// The fetch is shared, so it isn't canceled with the request that started it
+	c.err = p.fetch(context.Background(), name, url)
+
+	p.mu.Lock()
+	delete(p.calls, name)
+	p.mu.Unlock()
+	close(c.done)
+	return c.err
+}
+
+func (p *Proxy) fetch(ctx context.Context, name string, url string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "image/*")
+
+	res, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status %d from %s", res.StatusCode, url)
+	}
+
+	f, err := os.CreateTemp(p.dir, ".fetch-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	h := sha256.New()
+	n, err := io.Copy(io.MultiWriter(f, h), io.LimitReader(res.Body, p.MaxObjectSize+1))
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	if n > p.MaxObjectSize {
+		return fmt.Errorf("image %s is larger than %d bytes", url, p.MaxObjectSize)
+	}
+
+	// The declared content type isn't trusted, as it is served back to clients
+	head := make([]byte, 512)
+	hf, err := os.Open(f.Name())
+	if err != nil {
+		return err
+	}
+	hn, _ := io.ReadFull(hf, head)
+	hf.Close()
+	contentType := http.DetectContentType(head[:hn])
+	if !strings.HasPrefix(contentType, "image/") {
+		return ErrNotImage
+	}
+
+	e := &entry{
+		name: name,
+		meta: Meta{
+			URL:         url,
+			ContentType: contentType,
+			Size:        n,
+			ETag:        `"` + hex.EncodeToString(h.Sum(nil)[:16]) + `"`,
+			FetchedAt:   time.Now(),
+		},
+	}
+	meta, err := json.Marshal(e.meta)
+	if err != nil {
+		return err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if old, ok := p.entries[name]; ok {
+		p.size -= old.Value.(*entry).meta.Size
+		p.lru.Remove(old)
+		delete(p.entries, name)
+	}
+	err = os.Rename(f.Name(), filepath.Join(p.dir, name))
+	if err != nil {
+		return err
+	}
+	err = os.WriteFile(filepath.Join(p.dir, name+metaSuffix), meta, 0o644)
+	if err != nil {
+		p.removeFiles(name)
+		return err
+	}
+	p.entries[name] = p.lru.PushFront(e)
+	p.size += n
+	p.evict()
+	return nil
+}
+
+// evict removes the least recently used images until the cache fits in MaxBytes. p.mu must be held.
+func (p *Proxy) evict() {
+	for p.size > p.MaxBytes && p.lru.Len() > 0 {
+		el := p.lru.Back()
+		e := el.Value.(*entry)
+		p.lru.Remove(el)
+		delete(p.entries, e.name)
+		p.size -= e.meta.Size
+		p.removeFiles(e.name)
+	}
+}
+
+func (p *Proxy) removeFiles(name string) {
+	_ = os.Remove(filepath.Join(p.dir, name+metaSuffix))
+	_ = os.Remove(filepath.Join(p.dir, name))
+}
+
+// Size returns the total size of the cached images
+func (p *Proxy) Size() int64 {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.size
+}
+
+func cacheName(url string) string {
+	h := sha256.Sum256([]byte(url))
+	return hex.EncodeToString(h[:])
+}
@@ -0,0 +1,367 @@
+package imageproxy
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+const pngSignature = "\x89PNG\r\n\x1a\n"
+
+// fakePNG returns size bytes sniffed as a PNG image, their content depending on seed
+func fakePNG(seed string, size int) []byte {
+	data := []byte(pngSignature + seed)
+	for len(data) < size {
+		data = append(data, '.')
+	}
+	return data[:size]
+}
+
+// upstream serves the images of its map, counting the requests of each path
+type upstream struct {
+	mu       sync.Mutex
+	images   map[string][]byte
+	requests map[string]int
+	failing  bool
+}
+
+func newUpstream() (*upstream, *httptest.Server) {
+	u := &upstream{images: map[string][]byte{}, requests: map[string]int{}}
+	return u, httptest.NewServer(u)
+}
+
+func (u *upstream) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	u.mu.Lock()
+	u.requests[r.URL.Path]++
+	data, ok := u.images[r.URL.Path]
+	failing := u.failing
+	u.mu.Unlock()
+
+	if failing {
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+		return
+	}
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	// The declared type is ignored by the proxy
+	w.Header().Set("Content-Type", "application/octet-stream")
+	_, _ = w.Write(data)
+}
+
+func (u *upstream) set(path string, data []byte) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.images[path] = data
+}
+
+func (u *upstream) count(path string) int {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	return u.requests[path]
+}
+
+func open(t *testing.T, p *Proxy, url string) ([]byte, Meta) {
+	t.Helper()
+	img, err := p.Open(context.Background(), url)
+	if err != nil {
+		t.Fatalf("unable to open %s: %v", url, err)
+	}
+	defer img.Close()
+	data, err := io.ReadAll(img)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data, img.Meta
+}
+
+func TestOpenCaches(t *testing.T) {
+	up, srv := newUpstream()
+	defer srv.Close()
+	up.set("/a.png", fakePNG("a", 100))
+
+	p, err := New(srv.Client(), t.TempDir(), 1<<20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		data, meta := open(t, p, srv.URL+"/a.png")
+		if string(data) != string(fakePNG("a", 100)) {
+			t.Errorf("unexpected content %q", data)
+		}
+		if meta.ContentType != "image/png" || meta.Size != 100 || meta.URL != srv.URL+"/a.png" {
+			t.Errorf("unexpected meta %+v", meta)
+		}
+	}
+	if n := up.count("/a.png"); n != 1 {
+		t.Errorf("upstream was requested %d times, want 1", n)
+	}
+}
+
+func TestEvictsLeastRecentlyUsed(t *testing.T) {
+	up, srv := newUpstream()
+	defer srv.Close()
+	for _, name := range []string{"a", "b", "c"} {
+		up.set("/"+name+".png", fakePNG(name, 100))
+	}
+
+	dir := t.TempDir()
+	p, err := New(srv.Client(), dir, 250)
+	if err != nil {
+		t.Fatal(err)
+	}
+	open(t, p, srv.URL+"/a.png")
+	open(t, p, srv.URL+"/b.png")
+	// a becomes the most recently used, so b is evicted to make room for c
+	open(t, p, srv.URL+"/a.png")
+	open(t, p, srv.URL+"/c.png")
+
+	if size := p.Size(); size != 200 {
+		t.Errorf("cache size is %d, want 200", size)
+	}
+	open(t, p, srv.URL+"/a.png")
+	open(t, p, srv.URL+"/c.png")
+	if up.count("/a.png") != 1 || up.count("/c.png") != 1 {
+		t.Errorf("a or c was evicted")
+	}
+	open(t, p, srv.URL+"/b.png")
+	if n := up.count("/b.png"); n != 2 {
+		t.Errorf("b was requested %d times, want 2", n)
+	}
+
+	// Evicted images are removed from the disk, and the index is rebuilt from it
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 4 {
+		t.Errorf("found %d files in the cache directory, want 2 images and their metadata", len(files))
+	}
+	reloaded, err := New(srv.Client(), dir, 250)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if size := reloaded.Size(); size != 200 {
+		t.Errorf("reloaded cache size is %d, want 200", size)
+	}
+}
+
+func TestIgnoresIncompleteEntries(t *testing.T) {
+	dir := t.TempDir()
+	name := cacheName("https://example.com/a.png")
+	err := os.WriteFile(filepath.Join(dir, name), []byte(pngSignature), 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.WriteFile(filepath.Join(dir, name+metaSuffix), []byte(`{"size": 1000}`), 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p, err := New(http.DefaultClient, dir, 1<<20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Size() != 0 {
+		t.Errorf("an entry whose size doesn't match was loaded")
+	}
+	if _, err := os.Stat(filepath.Join(dir, name)); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("the incomplete entry wasn't removed")
+	}
+}
+
+func TestETag(t *testing.T) {
+	up, srv := newUpstream()
+	defer srv.Close()
+	up.set("/a.png", fakePNG("a", 100))
+	up.set("/copy-of-a.png", fakePNG("a", 100))
+	up.set("/b.png", fakePNG("b", 100))
+
+	p, err := New(srv.Client(), t.TempDir(), 1<<20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, a := open(t, p, srv.URL+"/a.png")
+	_, copyOfA := open(t, p, srv.URL+"/copy-of-a.png")
+	_, b := open(t, p, srv.URL+"/b.png")
+	if a.ETag == "" || a.ETag != copyOfA.ETag || a.ETag == b.ETag {
+		t.Errorf("ETags don't identify contents: %s, %s, %s", a.ETag, copyOfA.ETag, b.ETag)
+	}
+	if !strings.HasPrefix(a.ETag, `"`) || !strings.HasSuffix(a.ETag, `"`) {
+		t.Errorf("ETag %s isn't quoted", a.ETag)
+	}
+
+	// Cached images are served like the server does, with http.ServeContent
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		img, err := p.Open(r.Context(), srv.URL+"/a.png")
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+		defer img.Close()
+		w.Header().Set("ETag", img.ETag)
+		w.Header().Set("Content-Type", img.ContentType)
+		http.ServeContent(w, r, "", img.FetchedAt, img.File)
+	}))
+	defer proxy.Close()
+
+	for _, tc := range []struct {
+		ifNoneMatch string
+		status      int
+	}{
+		{"", http.StatusOK},
+		{a.ETag, http.StatusNotModified},
+		{b.ETag, http.StatusOK},
+	} {
+		req, err := http.NewRequest(http.MethodGet, proxy.URL, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if tc.ifNoneMatch != "" {
+			req.Header.Set("If-None-Match", tc.ifNoneMatch)
+		}
+		res, err := proxy.Client().Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_ = res.Body.Close()
+		if res.StatusCode != tc.status {
+			t.Errorf("If-None-Match %q: got status %d, want %d", tc.ifNoneMatch, res.StatusCode, tc.status)
+		}
+		if res.Header.Get("ETag") != a.ETag {
+			t.Errorf("If-None-Match %q: got ETag %q", tc.ifNoneMatch, res.Header.Get("ETag"))
+		}
+	}
+}
+
+func TestServesStaleCopyWhenUpstreamFails(t *testing.T) {
+	up, srv := newUpstream()
+	defer srv.Close()
+	up.set("/a.png", fakePNG("a", 100))
+
+	p, err := New(srv.Client(), t.TempDir(), 1<<20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.MaxAge = time.Nanosecond
+	open(t, p, srv.URL+"/a.png")
+
+	// Stale images are refreshed
+	up.set("/a.png", fakePNG("new a", 100))
+	data, _ := open(t, p, srv.URL+"/a.png")
+	if string(data) != string(fakePNG("new a", 100)) {
+		t.Errorf("the stale image wasn't refreshed")
+	}
+
+	up.mu.Lock()
+	up.failing = true
+	up.mu.Unlock()
+	data, _ = open(t, p, srv.URL+"/a.png")
+	if string(data) != string(fakePNG("new a", 100)) {
+		t.Errorf("unexpected stale content %q", data)
+	}
+	if n := up.count("/a.png"); n != 3 {
+		t.Errorf("upstream was requested %d times, want 3", n)
+	}
+
+	_, err = p.Open(context.Background(), srv.URL+"/never-cached.png")
+	if err == nil {
+		t.Errorf("expected an error when the upstream fails and nothing is cached")
+	}
+}
+
+func TestFetchesOnce(t *testing.T) {
+	release := make(chan struct{})
+	var requests int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		<-release
+		_, _ = w.Write(fakePNG("a", 100))
+	}))
+	defer srv.Close()
+
+	p, err := New(srv.Client(), t.TempDir(), 1<<20)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const concurrency = 10
+	var wg sync.WaitGroup
+	errs := make(chan error, concurrency)
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			img, err := p.Open(context.Background(), srv.URL+"/a.png")
+			if err != nil {
+				errs <- err
+				return
+			}
+			img.Close()
+		}()
+	}
+
+	// Let the requests pile up on the pending fetch
+	for atomic.LoadInt32(&requests) == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	time.Sleep(20 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		t.Errorf("unable to open image: %v", err)
+	}
+	if n := atomic.LoadInt32(&requests); n != 1 {
+		t.Errorf("upstream was requested %d times, want 1", n)
+	}
+}
+
+func TestRejectsNonImages(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/page":
+			// A declared image type isn't trusted either
+			w.Header().Set("Content-Type", "image/png")
+			_, _ = w.Write([]byte("<!doctype html><html><script>alert(1)</script></html>"))
+		case "/large.png":
+			_, _ = w.Write(fakePNG("large", 2000))
+		}
+	}))
+	defer srv.Close()
+
+	dir := t.TempDir()
+	p, err := New(srv.Client(), dir, 1<<20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.MaxObjectSize = 1000
+
+	_, err = p.Open(context.Background(), srv.URL+"/page")
+	if !errors.Is(err, ErrNotImage) {
+		t.Errorf("got error %v for an HTML page, want ErrNotImage", err)
+	}
+	_, err = p.Open(context.Background(), srv.URL+"/large.png")
+	if err == nil {
+		t.Errorf("an image larger than MaxObjectSize was served")
+	}
+
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 0 || p.Size() != 0 {
+		t.Errorf("rejected responses were cached: %v", files)
+	}
+}
@@ -0,0 +1,81 @@
+package imaging
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+var errTruncatedGIF = errors.New("gif: truncated file")
+
+// gifFrames walks the blocks of a GIF file, without decoding its frames, and returns its number of frames and
+// the sum of their areas in pixels
+func gifFrames(data []byte) (int, int64, error) {
+	const (
+		headerSize           = 6
+		screenDescriptorSize = 7
+		imageDescriptorSize  = 9
+		colorTableFlag       = 0x80
+		extensionIntroducer  = 0x21
+		imageSeparator       = 0x2c
+		trailer              = 0x3b
+	)
+
+	i := headerSize + screenDescriptorSize
+	if len(data) < i {
+		return 0, 0, errTruncatedGIF
+	}
+	if flags := data[i-3]; flags&colorTableFlag != 0 {
+		i += colorTableSize(flags)
+	}
+
+	frames := 0
+	var pixels int64
+	for {
+		if i >= len(data) {
+			return 0, 0, errTruncatedGIF
+		}
+		introducer := data[i]
+		i++
+
+		switch introducer {
+		case trailer:
+			return frames, pixels, nil
+		case extensionIntroducer:
+			// Label
+			i++
+		case imageSeparator:
+			if i+imageDescriptorSize > len(data) {
+				return 0, 0, errTruncatedGIF
+			}
+			width := binary.LittleEndian.Uint16(data[i+4:])
+			height := binary.LittleEndian.Uint16(data[i+6:])
+			flags := data[i+8]
+			i += imageDescriptorSize
+			if flags&colorTableFlag != 0 {
+				i += colorTableSize(flags)
+			}
+			// LZW minimum code size
+			i++
+			frames++
+			pixels += int64(width) * int64(height)
+		default:
+			return 0, 0, errors.New("gif: unknown block type")
+		}
+
+		// Data sub-blocks, up to the empty one
+		for {
+			if i >= len(data) {
+				return 0, 0, errTruncatedGIF
+			}
+			size := int(data[i])
+			i += 1 + size
+			if size == 0 {
+				break
+			}
+		}
+	}
+}
+
+func colorTableSize(flags byte) int {
+	return 3 << (flags&0x07 + 1)
+}
@@ -0,0 +1,68 @@
+package imaging
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/gif"
+	"testing"
+)
+
+func encodeGIF(t *testing.T, frames int, width int, height int) []byte {
+	t.Helper()
+	palette := color.Palette{color.Black, color.White}
+	g := &gif.GIF{Config: image.Config{ColorModel: palette, Width: width, Height: height}}
+	for i := 0; i < frames; i++ {
+		frame := image.NewPaletted(image.Rect(0, 0, width, height), palette)
+		frame.SetColorIndex(i%width, 0, 1)
+		g.Image = append(g.Image, frame)
+		g.Delay = append(g.Delay, 10)
+	}
+	var buf bytes.Buffer
+	err := gif.EncodeAll(&buf, g)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestGIFFrames(t *testing.T) {
+	for _, tc := range []struct {
+		frames, width, height int
+	}{
+		{1, 1, 1},
+		{1, 320, 200},
+		{12, 64, 48},
+	} {
+		frames, pixels, err := gifFrames(encodeGIF(t, tc.frames, tc.width, tc.height))
+		if err != nil {
+			t.Errorf("%+v: unexpected error %v", tc, err)
+			continue
+		}
+		if frames != tc.frames || pixels != int64(tc.frames*tc.width*tc.height) {
+			t.Errorf("%+v: got %d frames and %d pixels", tc, frames, pixels)
+		}
+	}
+
+	data := encodeGIF(t, 3, 16, 16)
+	for _, n := range []int{0, 5, 13, len(data) / 2, len(data) - 1} {
+		_, _, err := gifFrames(data[:n])
+		if err == nil {
+			t.Errorf("no error for a GIF truncated to %d bytes", n)
+		}
+	}
+}
+
+func TestCheckDimensionsRejectsLargeAnimations(t *testing.T) {
+	// A few kilobytes of compressed frames that would take hundreds of megabytes once decoded
+	data := encodeGIF(t, 40, 2048, 1024)
+	_, err := CheckDimensions(data)
+	if err == nil {
+		t.Errorf("an animation of %d pixels was accepted", 40*2048*1024)
+	}
+
+	_, err = CheckDimensions(encodeGIF(t, 4, 256, 256))
+	if err != nil {
+		t.Errorf("a small animation was rejected: %v", err)
+	}
+}
@@ -0,0 +1,242 @@
+// Package imaging validates uploaded images and derives the files served to clients from them:
+// a copy of the original stripped of its metadata (EXIF, GPS...), resized variants and a blurhash.
+package imaging
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/draw"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"net/http"
+)
+
+// AvatarSize is the width and height of profile pictures, in pixels
+const AvatarSize = 200
+
+// BannerWidth is the width of biography pictures, in pixels
+const BannerWidth = 800
+
+// MaxDimension is the maximum width and height of the images accepted, in pixels
+const MaxDimension = 8192
+
+// MaxAnimationPixels is the maximum number of pixels of all the frames of an animated GIF, which are
+// all decoded in memory at once
+const MaxAnimationPixels = 64 << 20
+
+const jpegQuality = 85
+
+// Content types of the supported formats
+const (
+	JPEG = "image/jpeg"
+	PNG  = "image/png"
+	GIF  = "image/gif"
+)
+
+// Extensions maps the supported content types to their file extension
+var Extensions = map[string]string{
+	JPEG: ".jpg",
+	PNG:  ".png",
+	GIF:  ".gif",
+}
+
+// ErrUnsupportedFormat is returned for files that aren't JPEG, PNG or GIF images
+var ErrUnsupportedFormat = errors.New("unsupported image format")
+
+// Variant is a resized version of an image
+type Variant struct {
+	Name   string
+	Width  int
+	Height int
+	// Crop makes the image cover Width x Height, cropping it around its center. Otherwise Height is
+	// ignored and the aspect ratio is kept.
+	Crop bool
+}
+
+var (
+	Avatar = Variant{Name: "avatar", Width: AvatarSize, Height: AvatarSize, Crop: true}
+	Banner = Variant{Name: "banner", Width: BannerWidth}
+)
+
+// Variants are the variants generated for every image
+var Variants = []Variant{Avatar, Banner}
+
+// Image is an encoded image
+type Image struct {
+	Data        []byte
+	ContentType string
+	Width       int
+	Height      int
+}
+
+// Result is the outcome of Process
+type Result struct {
+	// Original is the uploaded image, re-encoded without its metadata and with its orientation applied
+	Original Image
+	Variants map[string]Image
+	Blurhash string
+}
+
+// Sniff returns the content type of an image from its first bytes, ignoring any declared type
+func Sniff(head []byte) (string, error) {
+	contentType := http.DetectContentType(head)
+	if _, ok := Extensions[contentType]; !ok {
+		return "", ErrUnsupportedFormat
+	}
+	return contentType, nil
+}
+
+// CheckDimensions reads the dimensions of an image without decoding it, checking that they are within MaxDimension,
+// and that the frames of animations don't add up to more than MaxAnimationPixels
+func CheckDimensions(data []byte) (image.Config, error) {
+	cfg, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return cfg, err
+	}
+	if cfg.Width <= 0 || cfg.Height <= 0 || cfg.Width > MaxDimension || cfg.Height > MaxDimension {
+		return cfg, fmt.Errorf("image is %dx%d pixels, the maximum is %dx%d", cfg.Width, cfg.Height, MaxDimension, MaxDimension)
+	}
+	if format == "gif" {
+		frames, pixels, err := gifFrames(data)
+		if err != nil {
+			return cfg, err
+		}
+		if pixels > MaxAnimationPixels {
+			return cfg, fmt.Errorf("animation has %d frames totalling %d pixels, the maximum is %d", frames, pixels, MaxAnimationPixels)
+		}
+	}
+	return cfg, nil
+}
+
+// Process decodes data and derives the stripped original, the variants and the blurhash from it
+func Process(data []byte) (*Result, error) {
+	contentType, err := Sniff(data)
+	if err != nil {
+		return nil, err
+	}
+	_, err = CheckDimensions(data)
+	if err != nil {
+		return nil, err
+	}
+
+	var result Result
+	var img image.Image
+	switch contentType {
+	case GIF:
+		// Re-encoding keeps the frames and timing of animations, but drops comments and extensions
+		g, err := gif.DecodeAll(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		var buf bytes.Buffer
+		err = gif.EncodeAll(&buf, g)
+		if err != nil {
+			return nil, err
+		}
+		img = g.Image[0]
+		result.Original = Image{Data: buf.Bytes(), ContentType: GIF, Width: g.Config.Width, Height: g.Config.Height}
+	case JPEG:
+		img, err = jpeg.Decode(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		// The orientation is lost with the EXIF metadata, so it is applied to the pixels
+		img = orient(img, jpegOrientation(data))
+		result.Original, err = encode(img, JPEG)
+		if err != nil {
+			return nil, err
+		}
+	case PNG:
+		img, err = png.Decode(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		result.Original, err = encode(img, PNG)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// Variants are JPEGs, which have no transparency
+	rgba := flatten(img)
+	result.Variants = map[string]Image{}
+	for _, v := range Variants {
+		result.Variants[v.Name], err = encode(v.apply(rgba), JPEG)
+		if err != nil {
+			return nil, fmt.Errorf("unable to encode variant %s: %v", v.Name, err)
+		}
+	}
+
+	result.Blurhash = Blurhash(rgba, 4, 3)
+	return &result, nil
+}
+
+// apply resizes img to v, never upscaling it
+func (v Variant) apply(img *image.RGBA) *image.RGBA {
+	b := img.Bounds()
+	if v.Crop {
+		side := b.Dx()
+		if b.Dy() < side {
+			side = b.Dy()
+		}
+		x := b.Min.X + (b.Dx()-side)/2
+		y := b.Min.Y + (b.Dy()-side)/2
+		img = img.SubImage(image.Rect(x, y, x+side, y+side)).(*image.RGBA)
+		b = img.Bounds()
+
+		size := v.Width
+		if side < size {
+			size = side
+		}
+		return Resize(img, size, size)
+	}
+
+	if b.Dx() <= v.Width {
+		return img
+	}
+	h := b.Dy() * v.Width / b.Dx()
+	if h < 1 {
+		h = 1
+	}
+	return Resize(img, v.Width, h)
+}
+
+func encode(img image.Image, contentType string) (Image, error) {
+	var buf bytes.Buffer
+	var err error
+	switch contentType {
+	case JPEG:
+		err = jpeg.Encode(&buf, img, &jpeg.Options{Quality: jpegQuality})
+	case PNG:
+		err = png.Encode(&buf, img)
+	default:
+		err = ErrUnsupportedFormat
+	}
+	if err != nil {
+		return Image{}, err
+	}
+	b := img.Bounds()
+	return Image{Data: buf.Bytes(), ContentType: contentType, Width: b.Dx(), Height: b.Dy()}, nil
+}
+
+// flatten draws img over a white background
+func flatten(img image.Image) *image.RGBA {
+	b := img.Bounds()
+	rgba := image.NewRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(rgba, rgba.Bounds(), image.White, image.Point{}, draw.Src)
+	draw.Draw(rgba, rgba.Bounds(), img, b.Min, draw.Over)
+	return rgba
+}
+
+func toRGBA(img image.Image) *image.RGBA {
+	if rgba, ok := img.(*image.RGBA); ok {
+		return rgba
+	}
+	b := img.Bounds()
+	rgba := image.NewRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(rgba, rgba.Bounds(), img, b.Min, draw.Src)
+	return rgba
+}
@@ -0,0 +1,213 @@
+package imaging
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"testing"
+)
+
+func encodeJPEG(t *testing.T, img image.Image) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: 95})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// withExif inserts an APP1 segment after the SOI marker of a JPEG, with an orientation tag and a GPS IFD
+// holding a latitude
+func withExif(data []byte, orientation uint16) []byte {
+	order := binary.LittleEndian
+	tiff := []byte("II*\x00")
+	tiff = order.AppendUint32(tiff, 8)
+
+	// IFD0: orientation and pointer to the GPS IFD, which follows it
+	tiff = order.AppendUint16(tiff, 2)
+	tiff = order.AppendUint16(tiff, exifOrientationTag)
+	tiff = order.AppendUint16(tiff, 3) // SHORT
+	tiff = order.AppendUint32(tiff, 1)
+	tiff = order.AppendUint16(tiff, orientation)
+	tiff = order.AppendUint16(tiff, 0)
+	tiff = order.AppendUint16(tiff, 0x8825) // GPSInfo
+	tiff = order.AppendUint16(tiff, 4)      // LONG
+	tiff = order.AppendUint32(tiff, 1)
+	tiff = order.AppendUint32(tiff, uint32(len(tiff)+8))
+	tiff = order.AppendUint32(tiff, 0)
+
+	// GPS IFD: GPSLatitudeRef
+	tiff = order.AppendUint16(tiff, 1)
+	tiff = order.AppendUint16(tiff, 0x0001)
+	tiff = order.AppendUint16(tiff, 2) // ASCII
+	tiff = order.AppendUint32(tiff, 2)
+	tiff = append(tiff, 'N', 0, 0, 0)
+	tiff = order.AppendUint32(tiff, 0)
+
+	segment := append([]byte("Exif\x00\x00"), tiff...)
+	app1 := []byte{0xFF, 0xE1}
+	app1 = binary.BigEndian.AppendUint16(app1, uint16(len(segment)+2))
+	app1 = append(app1, segment...)
+
+	out := append([]byte{}, data[:2]...)
+	out = append(out, app1...)
+	return append(out, data[2:]...)
+}
+
+// jpegMarkers returns the markers of the segments of a JPEG before its image data
+func jpegMarkers(data []byte) []byte {
+	var markers []byte
+	for i := 2; i+4 <= len(data) && data[i] == 0xFF; {
+		markers = append(markers, data[i+1])
+		if data[i+1] == 0xDA {
+			break
+		}
+		i += 2 + int(binary.BigEndian.Uint16(data[i+2:]))
+	}
+	return markers
+}
+
+// halves returns a w x h image whose left half is left and whose right half is right
+func halves(w, h int, left, right color.Color) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			c := left
+			if x >= w/2 {
+				c = right
+			}
+			img.Set(x, y, c)
+		}
+	}
+	return img
+}
+
+func TestProcessStripsExif(t *testing.T) {
+	data := withExif(encodeJPEG(t, halves(64, 32, color.White, color.Black)), 1)
+	if m := jpegMarkers(data); len(m) == 0 || m[0] != 0xE1 {
+		t.Fatalf("fixture starts with segments %x", m)
+	}
+
+	result, err := Process(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, img := range map[string]Image{"original": result.Original, "avatar": result.Variants[Avatar.Name], "banner": result.Variants[Banner.Name]} {
+		for _, m := range jpegMarkers(img.Data) {
+			if m == 0xE1 {
+				t.Errorf("%s has an APP1 segment", name)
+			}
+		}
+		if bytes.Contains(img.Data, []byte("Exif")) {
+			t.Errorf("%s contains EXIF data", name)
+		}
+	}
+}
+
+func TestProcessAppliesOrientation(t *testing.T) {
+	red := color.RGBA{R: 255, A: 255}
+	blue := color.RGBA{B: 255, A: 255}
+	data := withExif(encodeJPEG(t, halves(64, 32, red, blue)), 6)
+	if jpegOrientation(data) != 6 {
+		t.Fatal("fixture doesn't have orientation 6")
+	}
+
+	result, err := Process(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Original.Width != 32 || result.Original.Height != 64 {
+		t.Fatalf("got a %dx%d image, want 32x64", result.Original.Width, result.Original.Height)
+	}
+	img, err := jpeg.Decode(bytes.NewReader(result.Original.Data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Rotated by 90° clockwise, the left half is at the top
+	for _, tc := range []struct {
+		x, y int
+		want string
+	}{
+		{16, 8, "red"},
+		{16, 56, "blue"},
+	} {
+		r, _, b, _ := img.At(tc.x, tc.y).RGBA()
+		got := "blue"
+		if r > b {
+			got = "red"
+		}
+		if got != tc.want {
+			t.Errorf("pixel %d,%d is %s, want %s", tc.x, tc.y, got, tc.want)
+		}
+	}
+}
+
+func TestProcessVariantSizes(t *testing.T) {
+	for _, tc := range []struct {
+		width, height int
+		avatar        [2]int
+		banner        [2]int
+	}{
+		{300, 200, [2]int{AvatarSize, AvatarSize}, [2]int{300, 200}},
+		{1600, 400, [2]int{AvatarSize, AvatarSize}, [2]int{BannerWidth, 200}},
+		{400, 1600, [2]int{AvatarSize, AvatarSize}, [2]int{400, 1600}},
+		// Small images aren't upscaled
+		{100, 50, [2]int{50, 50}, [2]int{100, 50}},
+	} {
+		result, err := Process(encodeJPEG(t, halves(tc.width, tc.height, color.White, color.Black)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, v := range []struct {
+			variant Variant
+			want    [2]int
+		}{
+			{Avatar, tc.avatar},
+			{Banner, tc.banner},
+		} {
+			img := result.Variants[v.variant.Name]
+			cfg, err := jpeg.DecodeConfig(bytes.NewReader(img.Data))
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := [2]int{cfg.Width, cfg.Height}
+			if got != v.want || img.Width != cfg.Width || img.Height != cfg.Height {
+				t.Errorf("%dx%d: %s is %v (%dx%d declared), want %v", tc.width, tc.height, v.variant.Name, got, img.Width, img.Height, v.want)
+			}
+			if img.ContentType != JPEG {
+				t.Errorf("%dx%d: %s is %s", tc.width, tc.height, v.variant.Name, img.ContentType)
+			}
+		}
+	}
+}
+
+func TestBlurhash(t *testing.T) {
+	white := image.NewRGBA(image.Rect(0, 0, 8, 8))
+	for i := range white.Pix {
+		white.Pix[i] = 255
+	}
+	gradient := image.NewRGBA(image.Rect(0, 0, 32, 16))
+	for y := 0; y < 16; y++ {
+		for x := 0; x < 32; x++ {
+			gradient.Set(x, y, color.RGBA{R: uint8(x * 8), G: uint8(y * 16), B: uint8(255 - x*4 - y*4), A: 255})
+		}
+	}
+
+	// Computed with a straight port of the reference C encoder (github.com/woltapp/blurhash)
+	for _, tc := range []struct {
+		name string
+		img  *image.RGBA
+		want string
+	}{
+		{"white", white, "LfTSUA~qfQ~q~qt7fQt7fQfQfQfQ"},
+		{"gradient", gradient, "LxH2Ts2yw#XAqVWGjuaigMfkfQfk"},
+	} {
+		got := Blurhash(tc.img, 4, 3)
+		if got != tc.want {
+			t.Errorf("%s: got %s, want %s", tc.name, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,79 @@
+package imaging
+
+import (
+	"image"
+	"math"
+)
+
+// Resize scales img to w x h by averaging the source pixels covered by each destination pixel.
+// It is meant for downscaling, where it avoids the aliasing of nearest-neighbor sampling.
+func Resize(img *image.RGBA, w, h int) *image.RGBA {
+	b := img.Bounds()
+	if b.Dx() == w && b.Dy() == h {
+		return img
+	}
+
+	// Horizontal pass: b.Dy() rows of w pixels, then vertical pass
+	tmp := make([]float64, w*b.Dy()*4)
+	xWeights := coverage(b.Dx(), w)
+	for y := 0; y < b.Dy(); y++ {
+		row := img.Pix[img.PixOffset(b.Min.X, b.Min.Y+y):]
+		for x, weights := range xWeights {
+			var acc [4]float64
+			for _, wt := range weights {
+				p := row[wt.src*4 : wt.src*4+4]
+				for c := 0; c < 4; c++ {
+					acc[c] += float64(p[c]) * wt.weight
+				}
+			}
+			copy(tmp[(y*w+x)*4:], acc[:])
+		}
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+	yWeights := coverage(b.Dy(), h)
+	for y, weights := range yWeights {
+		for x := 0; x < w; x++ {
+			var acc [4]float64
+			for _, wt := range weights {
+				p := tmp[(wt.src*w+x)*4:]
+				for c := 0; c < 4; c++ {
+					acc[c] += p[c] * wt.weight
+				}
+			}
+			o := dst.PixOffset(x, y)
+			for c := 0; c < 4; c++ {
+				dst.Pix[o+c] = uint8(math.Min(math.Round(acc[c]), 255))
+			}
+		}
+	}
+	return dst
+}
+
+type sourceWeight struct {
+	src    int
+	weight float64
+}
+
+// coverage returns, for each of the dstLen destination pixels, the source pixels it covers and their
+// share of it. When upscaling, each destination pixel is the source pixel under its center.
+func coverage(srcLen, dstLen int) [][]sourceWeight {
+	scale := float64(srcLen) / float64(dstLen)
+	result := make([][]sourceWeight, dstLen)
+	for i := range result {
+		if scale <= 1 {
+			src := int((float64(i) + 0.5) * scale)
+			result[i] = []sourceWeight{{src: src, weight: 1}}
+			continue
+		}
+		start := float64(i) * scale
+		end := start + scale
+		for s := int(start); s < srcLen && float64(s) < end; s++ {
+			covered := math.Min(end, float64(s+1)) - math.Max(start, float64(s))
+			if covered > 0 {
+				result[i] = append(result[i], sourceWeight{src: s, weight: covered / scale})
+			}
+		}
+	}
+	return result
+}
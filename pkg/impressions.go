@@ -0,0 +1,182 @@
+package server
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"github.com/denysvitali/social/backend/pkg/models/api"
+	pg_model "github.com/denysvitali/social/backend/pkg/models/postgres"
+	"github.com/gin-gonic/gin"
+	"github.com/oklog/ulid/v2"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"time"
+)
+
+// ImpressionWindow is the period during which repeated impressions of a post by the same viewer
+// are counted as a single view
+const ImpressionWindow = 30 * time.Minute
+
+const impressionQueueSize = 4096
+const impressionBatchSize = 256
+const impressionFlushInterval = 5 * time.Second
+
+// impressionPruneInterval is the interval between the removals of the impressions of past windows,
+// which can't de-duplicate anything anymore
+const impressionPruneInterval = time.Hour
+
+// Columns of pg_model.PostDailyStat that can be incremented
+const (
+	statViews = "views"
+	statLikes = "likes"
+	// statReshares counts the replies to a post, which are the only way to share it with one's followers
+	statReshares = "reshares"
+)
+
+type impression struct {
+	PostID    []byte
+	ViewerKey string
+	At        time.Time
+}
+
+// recordImpressions queues an impression of every post for the viewer of the request.
+// It never blocks: impressions are dropped when the queue is full, so that read endpoints
+// aren't slowed down by analytics.
+func (s *Server) recordImpressions(c *gin.Context, posts []api.Post) {
+	viewer, _ := s.viewerId(c)
+	viewerKey := impressionViewerKey(c, viewer)
+	now := time.Now()
+
+	for _, p := range posts {
+		// Authors looking at their own posts don't count
+		if viewer != 0 && p.Author == viewer {
+			continue
+		}
+		postId, err := ulid.Parse(p.ID)
+		if err != nil {
+			continue
+		}
+
+		select {
+		case s.impressions <- impression{PostID: postId.Bytes(), ViewerKey: viewerKey, At: now}:
+		default:
+			s.logger.Debugf("impression queue full, dropping impression of %s", p.ID)
+		}
+	}
+}
+
+// impressionViewerKey identifies the viewer for de-duplication purposes. Anonymous viewers
+// are identified by a hash of their IP address and user agent, which is never stored in clear.
+func impressionViewerKey(c *gin.Context, viewer uint64) string {
+	if viewer != 0 {
+		return fmt.Sprintf("u:%d", viewer)
+	}
+	h := sha256.Sum256([]byte(c.ClientIP() + "|" + c.Request.UserAgent()))
+	return "a:" + hex.EncodeToString(h[:12])
+}
+
+// runImpressionWorker persists queued impressions in batches
+func (s *Server) runImpressionWorker() {
+	// Recently persisted impressions, to avoid hitting the DB for repeated views
+	seen := map[string]time.Time{}
+	var batch []impression
+
+	ticker := time.NewTicker(impressionFlushInterval)
+	defer ticker.Stop()
+	prune := time.NewTicker(impressionPruneInterval)
+	defer prune.Stop()
+
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+		err := s.persistImpressions(batch)
+		if err != nil {
+			s.logger.Errorf("unable to persist %d impressions: %v", len(batch), err)
+		}
+		batch = batch[:0]
+	}
+
+	for {
+		select {
+		case i := <-s.impressions:
+			window := i.At.Truncate(ImpressionWindow)
+			key := fmt.Sprintf("%x|%s|%d", i.PostID, i.ViewerKey, window.Unix())
+			if _, ok := seen[key]; ok {
+				continue
+			}
+			seen[key] = window.Add(ImpressionWindow)
+			batch = append(batch, i)
+			if len(batch) >= impressionBatchSize {
+				flush()
+			}
+		case now := <-ticker.C:
+			flush()
+			for k, expiry := range seen {
+				if now.After(expiry) {
+					delete(seen, k)
+				}
+			}
+		case <-prune.C:
+			s.pruneImpressions()
+		}
+	}
+}
+
+// pruneImpressions removes the impressions of the windows that ended. The impressions of the previous
+// window are kept too, for the ones still queued when it ended.
+func (s *Server) pruneImpressions() {
+	before := time.Now().Truncate(ImpressionWindow).Add(-ImpressionWindow)
+	err := s.pgDB.
+		Where(`"window" < ?`, before.Unix()).
+		Delete(&pg_model.PostImpression{}).Error
+	if err != nil {
+		s.logger.Warnf("unable to prune post impressions: %v", err)
+	}
+}
+
+func (s *Server) persistImpressions(batch []impression) error {
+	return s.pgDB.Transaction(func(tx *gorm.DB) error {
+		for _, i := range batch {
+			res := tx.
+				Clauses(clause.OnConflict{DoNothing: true}).
+				Create(&pg_model.PostImpression{
+					PostID:    i.PostID,
+					ViewerKey: i.ViewerKey,
+					Window:    i.At.Truncate(ImpressionWindow).Unix(),
+					CreatedAt: i.At,
+				})
+			if res.Error != nil {
+				return res.Error
+			}
+			if res.RowsAffected == 0 {
+				// Already seen by this viewer within the window
+				continue
+			}
+			err := incrementPostStat(tx, i.PostID, i.At, statViews)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// incrementPostStat increments column of the daily stats of postId for the day containing at
+func incrementPostStat(db *gorm.DB, postId []byte, at time.Time, column string) error {
+	switch column {
+	case statViews, statLikes, statReshares:
+	default:
+		return fmt.Errorf("unknown post stat %q", column)
+	}
+
+	// gorm expands a slice following an opening parenthesis into a list, so postId can't be the first value
+	return db.Exec(fmt.Sprintf(`INSERT INTO post_daily_stats (day, post_id, %[1]s) VALUES (?, ?, 1)
+		ON CONFLICT (post_id, day) DO UPDATE SET %[1]s = post_daily_stats.%[1]s + 1`, column),
+		statDay(at), postId,
+	).Error
+}
+
+func statDay(t time.Time) string {
+	return t.UTC().Format("2006-01-02")
+}
@@ -0,0 +1,149 @@
+package server
+
+import (
+	"context"
+	"github.com/denysvitali/social/backend/pkg/entities"
+	"github.com/denysvitali/social/backend/pkg/models/api"
+	pg_model "github.com/denysvitali/social/backend/pkg/models/postgres"
+	"gorm.io/gorm/clause"
+	"sync"
+	"time"
+)
+
+const linkCardWorkers = 2
+const linkCardQueueSize = 256
+const linkCardFetchTimeout = 10 * time.Second
+
+// linkCardTTL is how long a successfully fetched card is used before being refreshed
+const linkCardTTL = 24 * time.Hour
+
+// linkCardRetryAfter is how long a failed fetch is cached before being retried
+const linkCardRetryAfter = time.Hour
+
+const maxLinkCardURLLength = 2048
+
+// firstURL returns the first link in content, if any
+func firstURL(content string) string {
+	for _, e := range entities.Parse(content) {
+		if e.Type == entities.URL && len(e.Text) <= maxLinkCardURLLength {
+			return e.Text
+		}
+	}
+	return ""
+}
+
+type linkCardQueue struct {
+	ch chan string
+
+	mu       sync.Mutex
+	inFlight map[string]bool
+}
+
+func newLinkCardQueue() *linkCardQueue {
+	return &linkCardQueue{
+		ch:       make(chan string, linkCardQueueSize),
+		inFlight: map[string]bool{},
+	}
+}
+
+// enqueue schedules url for unfurling, unless it's already scheduled. It never blocks.
+func (q *linkCardQueue) enqueue(url string) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.inFlight[url] {
+		return
+	}
+	select {
+	case q.ch <- url:
+		q.inFlight[url] = true
+	default:
+	}
+}
+
+func (q *linkCardQueue) done(url string) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	delete(q.inFlight, url)
+}
+
+func (s *Server) runLinkCardWorker() {
+	for url := range s.linkCards.ch {
+		s.unfurlLinkCard(url)
+		s.linkCards.done(url)
+	}
+}
+
+func (s *Server) unfurlLinkCard(url string) {
+	ctx, cancel := context.WithTimeout(context.Background(), linkCardFetchTimeout)
+	defer cancel()
+
+	linkCard := pg_model.LinkCard{
+		URL:       url,
+		FetchedAt: time.Now(),
+	}
+
+	card, err := s.unfurler.Fetch(ctx, url)
+	if err != nil {
+		s.logger.Debugf("unable to unfurl %s: %v", url, err)
+		linkCard.Error = err.Error()
+	} else {
+		linkCard.Title = card.Title
+		linkCard.Description = card.Description
+		linkCard.ImageURL = card.ImageURL
+		linkCard.SiteName = card.SiteName
+		if card.Empty() {
+			linkCard.Error = "no metadata"
+		}
+	}
+
+	tx := s.pgDB.
+		Clauses(clause.OnConflict{UpdateAll: true}).
+		Create(&linkCard)
+	if tx.Error != nil {
+		s.logger.Errorf("unable to save link card of %s: %v", url, tx.Error)
+	}
+}
+
+// linkCardsByURL returns the cached cards of the given URLs. URLs that weren't unfurled yet,
+// or whose card is stale, are scheduled to be fetched in the background.
+func (s *Server) linkCardsByURL(urls []string) (map[string]*api.Card, error) {
+	result := map[string]*api.Card{}
+	if len(urls) == 0 {
+		return result, nil
+	}
+
+	var cards []pg_model.LinkCard
+	tx := s.pgDB.Where("url IN ?", urls).Find(&cards)
+	if tx.Error != nil {
+		return nil, tx.Error
+	}
+
+	cached := map[string]pg_model.LinkCard{}
+	for _, v := range cards {
+		cached[v.URL] = v
+	}
+
+	for _, u := range urls {
+		v, ok := cached[u]
+		switch {
+		case !ok:
+			s.linkCards.enqueue(u)
+		case v.Error != "":
+			if time.Since(v.FetchedAt) > linkCardRetryAfter {
+				s.linkCards.enqueue(u)
+			}
+		default:
+			if time.Since(v.FetchedAt) > linkCardTTL {
+				s.linkCards.enqueue(u)
+			}
+			result[u] = &api.Card{
+				URL:         v.URL,
+				Title:       v.Title,
+				Description: v.Description,
+				Image:       v.ImageURL,
+				SiteName:    v.SiteName,
+			}
+		}
+	}
+	return result, nil
+}
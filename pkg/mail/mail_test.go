@@ -0,0 +1,256 @@
+package mail
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net"
+	"net/mail"
+	"net/textproto"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestSMTP(t *testing.T, config Config) *SMTP {
+	t.Helper()
+	if config.Host == "" {
+		config.Host = "localhost"
+	}
+	if config.From == "" {
+		config.From = "OpenDolphin <noreply@example.com>"
+	}
+	s, err := NewSMTP(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+// parts returns the content of the parts of a multipart/alternative message, by content type
+func parts(t *testing.T, msg *mail.Message) map[string]string {
+	t.Helper()
+	mediaType, params, err := mime.ParseMediaType(msg.Header.Get("Content-Type"))
+	if err != nil || mediaType != "multipart/alternative" {
+		t.Fatalf("invalid content type %q: %v", msg.Header.Get("Content-Type"), err)
+	}
+	contents := map[string]string{}
+	r := multipart.NewReader(msg.Body, params["boundary"])
+	for {
+		p, err := r.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		// The reader decodes quoted-printable parts
+		content, err := io.ReadAll(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		contents[p.Header.Get("Content-Type")] = string(content)
+	}
+	return contents
+}
+
+func TestEncode(t *testing.T) {
+	s := newTestSMTP(t, Config{})
+	to, err := mail.ParseAddress("Zoë <zoe@example.org>")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	longLine := strings.Repeat("é", 100)
+	body, err := s.encode(Message{
+		To:      to.String(),
+		Subject: "Zoë a aimé votre post",
+		Text:    "Bonjour Zoë\n" + longLine,
+		HTML:    "<p>Bonjour Zoë</p>",
+		Headers: map[string]string{"List-Unsubscribe": "<https://example.com/unsubscribe?token=a.b>"},
+	}, to)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i, line := range strings.Split(string(body), "\r\n") {
+		if len(line) > 998 {
+			t.Errorf("line %d is %d bytes long", i, len(line))
+		}
+		for _, b := range []byte(line) {
+			if b >= 0x80 {
+				t.Fatalf("line %d contains non-ASCII bytes: %q", i, line)
+			}
+		}
+	}
+
+	msg, err := mail.ReadMessage(strings.NewReader(string(body)))
+	if err != nil {
+		t.Fatalf("unable to parse message: %v", err)
+	}
+	var dec mime.WordDecoder
+	subject, err := dec.DecodeHeader(msg.Header.Get("Subject"))
+	if err != nil || subject != "Zoë a aimé votre post" {
+		t.Errorf("got subject %q (%v)", subject, err)
+	}
+	addresses, err := msg.Header.AddressList("To")
+	if err != nil || len(addresses) != 1 || addresses[0].Name != "Zoë" || addresses[0].Address != "zoe@example.org" {
+		t.Errorf("got recipients %v (%v)", addresses, err)
+	}
+	if msg.Header.Get("List-Unsubscribe") != "<https://example.com/unsubscribe?token=a.b>" {
+		t.Errorf("got List-Unsubscribe %q", msg.Header.Get("List-Unsubscribe"))
+	}
+	if !strings.HasSuffix(msg.Header.Get("Message-Id"), "@example.com>") {
+		t.Errorf("got Message-ID %q", msg.Header.Get("Message-Id"))
+	}
+	if _, err := msg.Header.Date(); err != nil {
+		t.Errorf("invalid date: %v", err)
+	}
+
+	// Line breaks are sent as CRLF
+	contents := parts(t, msg)
+	if contents["text/plain; charset=utf-8"] != "Bonjour Zoë\r\n"+longLine {
+		t.Errorf("got text %q", contents["text/plain; charset=utf-8"])
+	}
+	if contents["text/html; charset=utf-8"] != "<p>Bonjour Zoë</p>" {
+		t.Errorf("got HTML %q", contents["text/html; charset=utf-8"])
+	}
+}
+
+func TestEncodeRejectsHeaderInjection(t *testing.T) {
+	s := newTestSMTP(t, Config{})
+	to, err := mail.ParseAddress("user@example.org")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, value := range []string{"a\r\nBcc: victim@example.org", "a\nBcc: victim@example.org", "a\rb"} {
+		_, err := s.encode(Message{
+			To:      to.String(),
+			Subject: "Hello",
+			Headers: map[string]string{"List-Unsubscribe": value},
+		}, to)
+		if err == nil {
+			t.Errorf("header value %q accepted", value)
+		}
+	}
+
+	// Subjects are encoded, so that line breaks can't start a new header
+	body, err := s.encode(Message{To: to.String(), Subject: "Hello\r\nBcc: victim@example.org"}, to)
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg, err := mail.ReadMessage(strings.NewReader(string(body)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg.Header.Get("Bcc") != "" {
+		t.Errorf("the subject added a Bcc header")
+	}
+}
+
+func TestSendRejectsInvalidRecipients(t *testing.T) {
+	s := newTestSMTP(t, Config{Port: 1})
+	for _, to := range []string{"", "not an address", "user@example.org\r\nBcc: victim@example.org"} {
+		err := s.Send(context.Background(), Message{To: to, Subject: "Hello"})
+		if err == nil || !strings.Contains(err.Error(), "invalid recipient") {
+			t.Errorf("recipient %q: got error %v", to, err)
+		}
+	}
+}
+
+func TestNewSMTP(t *testing.T) {
+	if _, err := NewSMTP(Config{From: "noreply@example.com"}); err == nil {
+		t.Errorf("empty host accepted")
+	}
+	if _, err := NewSMTP(Config{Host: "localhost", From: "noreply"}); err == nil {
+		t.Errorf("invalid sender accepted")
+	}
+	s, err := NewSMTP(Config{Host: "localhost", From: "noreply@example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.config.Port != 25 {
+		t.Errorf("got default port %d, want 25", s.config.Port)
+	}
+}
+
+// fakeSMTPServer accepts a single message, and returns the envelope and the data received
+func fakeSMTPServer(l net.Listener) <-chan []string {
+	received := make(chan []string, 1)
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		_ = conn.SetDeadline(time.Now().Add(5 * time.Second))
+		tp := textproto.NewConn(conn)
+
+		var envelope []string
+		_ = tp.PrintfLine("220 localhost ESMTP")
+		for {
+			line, err := tp.ReadLine()
+			if err != nil {
+				return
+			}
+			cmd := strings.ToUpper(strings.SplitN(line, " ", 2)[0])
+			switch cmd {
+			case "EHLO", "HELO":
+				_ = tp.PrintfLine("250 localhost")
+			case "MAIL", "RCPT":
+				envelope = append(envelope, line)
+				_ = tp.PrintfLine("250 OK")
+			case "DATA":
+				_ = tp.PrintfLine("354 Go ahead")
+				data, err := tp.ReadDotBytes()
+				if err != nil {
+					return
+				}
+				received <- append(envelope, string(data))
+				_ = tp.PrintfLine("250 OK")
+			case "QUIT":
+				_ = tp.PrintfLine("221 Bye")
+				return
+			default:
+				_ = tp.PrintfLine("502 Unsupported")
+			}
+		}
+	}()
+	return received
+}
+
+func TestSend(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	received := fakeSMTPServer(l)
+
+	s := newTestSMTP(t, Config{Host: "127.0.0.1", Port: l.Addr().(*net.TCPAddr).Port})
+	err = s.Send(context.Background(), Message{
+		To:      "Zoë <zoe@example.org>",
+		Subject: "Hello",
+		Text:    "Hello\n.\nWorld",
+		HTML:    "<p>Hello</p>",
+	})
+	if err != nil {
+		t.Fatalf("unable to send: %v", err)
+	}
+
+	got := <-received
+	if len(got) != 3 || got[0] != "MAIL FROM:<noreply@example.com>" || got[1] != "RCPT TO:<zoe@example.org>" {
+		t.Fatalf("got envelope %q", got)
+	}
+	msg, err := mail.ReadMessage(bufio.NewReader(strings.NewReader(got[2])))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The server reads the lines of the message, including the one made of a single dot, without CR
+	if text := parts(t, msg)["text/plain; charset=utf-8"]; text != "Hello\n.\nWorld" {
+		t.Errorf("got text %q", text)
+	}
+}
@@ -0,0 +1,131 @@
+package server
+
+import (
+	"github.com/denysvitali/social/backend/pkg/imageproxy"
+	"github.com/denysvitali/social/backend/pkg/models/api"
+	pg_model "github.com/denysvitali/social/backend/pkg/models/postgres"
+	"github.com/denysvitali/social/backend/pkg/safehttp"
+	"github.com/denysvitali/social/backend/pkg/storage"
+	"gorm.io/gorm"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// MaxPostMedia is the maximum number of attachments of a post
+const MaxPostMedia = 4
+
+// MaxMediaSize is the maximum size of an upload, in bytes
+const MaxMediaSize = 10 << 20
+
+// MaxAltTextLength is the maximum length of the description of a media, in Unicode code points
+const MaxAltTextLength = 1500
+
+const defaultMediaDir = "media"
+
+const defaultImageCacheDir = "image-cache"
+const defaultImageCacheSize = 512 << 20
+const imageProxyFetchTimeout = 10 * time.Second
+
+// storageTimeout bounds the requests to the S3 storage. It includes streaming media to the clients they
+// are served to, so it leaves room for slow connections.
+const storageTimeout = 2 * time.Minute
+
+type MediaConfig struct {
+	// Dir is the directory uploads are stored in, when S3 isn't configured
+	Dir string
+	S3  storage.S3Config
+
+	// PublicURL is the base URL media are served from, e.g. a CDN in front of the bucket.
+	// When empty, media are served by the API.
+	PublicURL string
+}
+
+type ImageCacheConfig struct {
+	// Dir is the directory the image proxy caches external images in
+	Dir string
+	// MaxBytes is the maximum size of the cache
+	MaxBytes int64
+}
+
+func setupImageProxy(config ImageCacheConfig) (*imageproxy.Proxy, error) {
+	if config.Dir == "" {
+		config.Dir = defaultImageCacheDir
+	}
+	if config.MaxBytes <= 0 {
+		config.MaxBytes = defaultImageCacheSize
+	}
+	return imageproxy.New(safehttp.NewClient(imageProxyFetchTimeout), config.Dir, config.MaxBytes)
+}
+
+func setupStorage(config MediaConfig) (storage.Storage, error) {
+	if config.S3.Bucket != "" {
+		return storage.NewS3(config.S3, &http.Client{Timeout: storageTimeout})
+	}
+	if config.Dir == "" {
+		config.Dir = defaultMediaDir
+	}
+	return storage.NewLocal(config.Dir)
+}
+
+// mediaURL returns the URL of the file stored under key, which is the media itself or one of its
+// variants when variant isn't empty
+func (s *Server) mediaURL(m pg_model.Media, key string, variant string) string {
+	if s.mediaPublicURL != "" {
+		return strings.TrimSuffix(s.mediaPublicURL, "/") + "/" + key
+	}
+	u := "/api/v1/media/" + bytesToUlid(m.ID).String()
+	if variant != "" {
+		u += "?variant=" + url.QueryEscape(variant)
+	}
+	return u
+}
+
+func (s *Server) getApiMedia(m pg_model.Media) api.Media {
+	apiMedia := api.Media{
+		ID:          bytesToUlid(m.ID).String(),
+		Status:      m.Status,
+		ContentType: m.ContentType,
+		Width:       m.Width,
+		Height:      m.Height,
+		AltText:     m.AltText,
+		Blurhash:    m.Blurhash,
+	}
+	if m.Status != pg_model.MediaStatusReady {
+		return apiMedia
+	}
+
+	apiMedia.URL = s.mediaURL(m, m.Key, "")
+	for _, v := range m.Variants {
+		apiMedia.Variants = append(apiMedia.Variants, api.MediaVariant{
+			Name:   v.Name,
+			URL:    s.mediaURL(m, v.Key, v.Name),
+			Width:  v.Width,
+			Height: v.Height,
+		})
+	}
+	return apiMedia
+}
+
+// postMedia returns the attachments of posts by post ULID, in order
+func (s *Server) postMedia(postIds [][]byte) (map[string][]api.Media, error) {
+	var media []pg_model.Media
+	tx := s.pgDB.
+		Preload("Variants", func(db *gorm.DB) *gorm.DB {
+			return db.Order("width ASC")
+		}).
+		Where("post_id IN ?", postIds).
+		Order("position ASC").
+		Find(&media)
+	if tx.Error != nil {
+		return nil, tx.Error
+	}
+
+	result := map[string][]api.Media{}
+	for _, m := range media {
+		postId := bytesToUlid(*m.PostID).String()
+		result[postId] = append(result[postId], s.getApiMedia(m))
+	}
+	return result, nil
+}
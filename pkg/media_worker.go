@@ -0,0 +1,202 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"github.com/denysvitali/social/backend/pkg/imaging"
+	pg_model "github.com/denysvitali/social/backend/pkg/models/postgres"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"io"
+	"time"
+)
+
+const mediaWorkers = 2
+const mediaQueueSize = 256
+
+// mediaSweepInterval is how often the media that couldn't be queued, or whose processing failed
+// temporarily, are looked for
+const mediaSweepInterval = time.Minute
+
+// mediaProcessingTimeout is how long a media can be processed before being considered abandoned, e.g.
+// because the server stopped
+const mediaProcessingTimeout = 10 * time.Minute
+
+// enqueueMedia schedules the processing of a media. It never blocks: when the queue is full, the media is
+// picked up by the next sweep.
+func (s *Server) enqueueMedia(id []byte) {
+	select {
+	case s.mediaQueue <- id:
+	default:
+	}
+}
+
+func (s *Server) runMediaWorker() {
+	ticker := time.NewTicker(mediaSweepInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case id := <-s.mediaQueue:
+			s.processMedia(id)
+		case <-ticker.C:
+			s.sweepMedia()
+		}
+	}
+}
+
+func (s *Server) sweepMedia() {
+	tx := s.pgDB.Model(&pg_model.Media{}).
+		Where("status = ? AND status_updated_at < ?", pg_model.MediaStatusProcessing, time.Now().Add(-mediaProcessingTimeout)).
+		Updates(map[string]any{
+			"status":            pg_model.MediaStatusPending,
+			"status_updated_at": time.Now(),
+		})
+	if tx.Error != nil {
+		s.logger.Warnf("unable to reset abandoned media: %v", tx.Error)
+		return
+	}
+
+	// Recent media are still in the queue
+	var ids [][]byte
+	tx = s.pgDB.Model(&pg_model.Media{}).
+		Where("status = ? AND status_updated_at < ?", pg_model.MediaStatusPending, time.Now().Add(-mediaSweepInterval)).
+		Order("id ASC").
+		Limit(mediaQueueSize).
+		Pluck("id", &ids)
+	if tx.Error != nil {
+		s.logger.Warnf("unable to get pending media: %v", tx.Error)
+		return
+	}
+	for _, id := range ids {
+		s.processMedia(id)
+	}
+}
+
+// processMedia generates the variants of a pending media
+func (s *Server) processMedia(id []byte) {
+	// Claim the media, as another worker may have picked it up in a sweep
+	tx := s.pgDB.Model(&pg_model.Media{}).
+		Where("id = ? AND status = ?", id, pg_model.MediaStatusPending).
+		Updates(map[string]any{
+			"status":            pg_model.MediaStatusProcessing,
+			"status_updated_at": time.Now(),
+		})
+	if tx.Error != nil {
+		s.logger.Warnf("unable to claim media %s: %v", bytesToUlid(id), tx.Error)
+		return
+	}
+	if tx.RowsAffected == 0 {
+		return
+	}
+
+	status := pg_model.MediaStatusPending
+	err := s.processClaimedMedia(id)
+	if err == nil {
+		return
+	}
+	if _, ok := err.(imagingError); ok {
+		// Processing the same file again won't help
+		status = pg_model.MediaStatusFailed
+	}
+	s.logger.Warnf("unable to process media %s: %v", bytesToUlid(id), err)
+
+	tx = s.pgDB.Model(&pg_model.Media{}).
+		Where("id = ?", id).
+		Updates(map[string]any{
+			"status":            status,
+			"status_updated_at": time.Now(),
+			"error":             err.Error(),
+		})
+	if tx.Error != nil {
+		s.logger.Warnf("unable to update status of media %s: %v", bytesToUlid(id), tx.Error)
+	}
+}
+
+// imagingError wraps the errors caused by the content of a media, rather than by the infrastructure
+type imagingError struct {
+	error
+}
+
+func (s *Server) processClaimedMedia(id []byte) error {
+	ctx, cancel := context.WithTimeout(context.Background(), mediaProcessingTimeout)
+	defer cancel()
+
+	var media pg_model.Media
+	tx := s.pgDB.Take(&media, "id = ?", id)
+	if tx.Error != nil {
+		return tx.Error
+	}
+
+	r, err := s.storage.Get(ctx, media.SourceKey)
+	if err != nil {
+		return fmt.Errorf("unable to get %s: %v", media.SourceKey, err)
+	}
+	data, err := io.ReadAll(io.LimitReader(r, MaxMediaSize+1))
+	r.Close()
+	if err != nil {
+		return fmt.Errorf("unable to read %s: %v", media.SourceKey, err)
+	}
+
+	result, err := imaging.Process(data)
+	if err != nil {
+		return imagingError{err}
+	}
+
+	err = s.storage.Put(ctx, media.Key, bytes.NewReader(result.Original.Data), int64(len(result.Original.Data)), result.Original.ContentType)
+	if err != nil {
+		return fmt.Errorf("unable to store %s: %v", media.Key, err)
+	}
+
+	var variants []pg_model.MediaVariant
+	for _, v := range imaging.Variants {
+		img := result.Variants[v.Name]
+		key := fmt.Sprintf("media/%s/%s%s", bytesToUlid(media.ID), v.Name, imaging.Extensions[img.ContentType])
+		err = s.storage.Put(ctx, key, bytes.NewReader(img.Data), int64(len(img.Data)), img.ContentType)
+		if err != nil {
+			return fmt.Errorf("unable to store %s: %v", key, err)
+		}
+		variants = append(variants, pg_model.MediaVariant{
+			MediaID:     media.ID,
+			Name:        v.Name,
+			Key:         key,
+			ContentType: img.ContentType,
+			Size:        int64(len(img.Data)),
+			Width:       img.Width,
+			Height:      img.Height,
+		})
+	}
+
+	err = s.pgDB.Transaction(func(tx *gorm.DB) error {
+		err := tx.Clauses(clause.OnConflict{UpdateAll: true}).Create(&variants).Error
+		if err != nil {
+			return err
+		}
+		return tx.Model(&pg_model.Media{}).
+			Where("id = ?", media.ID).
+			Updates(map[string]any{
+				"content_type":      result.Original.ContentType,
+				"size":              len(result.Original.Data),
+				"width":             result.Original.Width,
+				"height":            result.Original.Height,
+				"blurhash":          result.Blurhash,
+				"source_key":        "",
+				"error":             "",
+				"status":            pg_model.MediaStatusReady,
+				"status_updated_at": time.Now(),
+			}).Error
+	})
+	if err != nil {
+		return err
+	}
+
+	// Media uploaded before processing existed were stored directly under their key
+	if media.SourceKey != media.Key {
+		err = s.storage.Delete(ctx, media.SourceKey)
+		if err != nil {
+			s.logger.Warnf("unable to delete source of media %s: %v", bytesToUlid(media.ID), err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,20 @@
+package api
+
+type PostDailyStats struct {
+	// Day is formatted as YYYY-MM-DD, in UTC
+	Day      string `json:"day"`
+	Views    uint64 `json:"views"`
+	Likes    uint64 `json:"likes"`
+	Reshares uint64 `json:"reshares"`
+}
+
+type PostAnalytics struct {
+	PostID string `json:"postId"`
+
+	// Totals over the requested period
+	Views    uint64 `json:"views"`
+	Likes    uint64 `json:"likes"`
+	Reshares uint64 `json:"reshares"`
+
+	Days []PostDailyStats `json:"days"`
+}
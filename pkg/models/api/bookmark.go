@@ -0,0 +1,9 @@
+package api
+
+import "time"
+
+type BookmarkCollection struct {
+	ID        uint64    `json:"id"`
+	Name      string    `json:"name"`
+	CreatedAt time.Time `json:"createdAt"`
+}
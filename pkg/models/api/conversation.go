@@ -0,0 +1,64 @@
+package api
+
+import "time"
+
+type Message struct {
+	ID           string    `json:"id"`
+	Conversation string    `json:"conversation"`
+	Sender       uint64    `json:"sender"`
+	Content      string    `json:"content"`
+	CreatedAt    time.Time `json:"createdAt"`
+}
+
+type ConversationMember struct {
+	User uint64 `json:"user"`
+	// LastReadMessage is the ULID of the most recent message the member read, for read receipts
+	LastReadMessage string `json:"lastReadMessage,omitempty"`
+}
+
+type Conversation struct {
+	ID      string `json:"id"`
+	Name    string `json:"name,omitempty"`
+	IsGroup bool   `json:"isGroup"`
+	// Members are all the members, including the viewer, see ConversationsResponse.Users
+	Members     []ConversationMember `json:"members"`
+	LastMessage *Message             `json:"lastMessage,omitempty"`
+	// UnreadCount is the number of messages of the other members the viewer hasn't read
+	UnreadCount int64 `json:"unreadCount"`
+	Muted       bool  `json:"muted"`
+	// Request is true when the conversation is in the requests inbox of the viewer
+	Request   bool      `json:"request"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+type ConversationsResponse struct {
+	Conversations []Conversation `json:"conversations"`
+	Users         []User         `json:"users"`
+
+	NextCursor string `json:"nextCursor,omitempty"`
+}
+
+type MessagesResponse struct {
+	Messages []Message `json:"messages"`
+
+	NextCursor string `json:"nextCursor,omitempty"`
+}
+
+// MessageEvent is streamed to the members of a conversation when a message is sent
+type MessageEvent struct {
+	Message Message `json:"message"`
+	// Muted is true when the member muted the conversation: the message shouldn't alert them
+	Muted bool `json:"muted"`
+}
+
+// ConversationRead is streamed to the members of a conversation when a member reads it
+type ConversationRead struct {
+	Conversation    string `json:"conversation"`
+	User            uint64 `json:"user"`
+	LastReadMessage string `json:"lastReadMessage"`
+}
+
+type UnreadConversationsCount struct {
+	// Count is the number of conversations of the inbox, except the muted ones, with unread messages
+	Count int64 `json:"count"`
+}
@@ -0,0 +1,12 @@
+package api
+
+type EmailSettings struct {
+	Email string `json:"email"`
+	// PendingEmail is the new address waiting for a confirmation, emails are sent to Email until then
+	PendingEmail string `json:"pendingEmail,omitempty"`
+	// Notifications is whether notifications are also sent by email
+	Notifications bool `json:"notifications"`
+	// Digest is the frequency of the digest of the top posts of the followed accounts: "off", "daily"
+	// or "weekly"
+	Digest string `json:"digest"`
+}
@@ -0,0 +1,21 @@
+package api
+
+// Entity is a typed range of the content of a post. Offsets are expressed in Unicode
+// code points: Start is inclusive, End is exclusive.
+type Entity struct {
+	Type  string `json:"type"`
+	Start int    `json:"start"`
+	End   int    `json:"end"`
+
+	// Set for hashtags
+	TagID *uint64 `json:"tagId,omitempty"`
+	Tag   string  `json:"tag,omitempty"`
+
+	// Set for mentions
+	UserID   *uint64 `json:"userId,omitempty"`
+	Username string  `json:"username,omitempty"`
+
+	// Set for URLs
+	DisplayURL  string `json:"displayUrl,omitempty"`
+	ExpandedURL string `json:"expandedUrl,omitempty"`
+}
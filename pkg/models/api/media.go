@@ -0,0 +1,22 @@
+package api
+
+type Media struct {
+	ID string `json:"id"`
+	// Status is "pending" or "processing" until the variants of the media are ready, then "ready" or "failed"
+	Status      string `json:"status"`
+	URL         string `json:"url"`
+	ContentType string `json:"contentType"`
+	Width       int    `json:"width"`
+	Height      int    `json:"height"`
+	AltText     string `json:"altText,omitempty"`
+	// Blurhash is a compact placeholder to show while the media loads, see https://blurha.sh
+	Blurhash string         `json:"blurhash,omitempty"`
+	Variants []MediaVariant `json:"variants,omitempty"`
+}
+
+type MediaVariant struct {
+	Name   string `json:"name"`
+	URL    string `json:"url"`
+	Width  int    `json:"width"`
+	Height int    `json:"height"`
+}
@@ -0,0 +1,19 @@
+package api
+
+import "time"
+
+type MutedWord struct {
+	ID     uint64 `json:"id"`
+	Phrase string `json:"phrase"`
+	// Context is "home" or "everywhere"
+	Context string `json:"context"`
+	// Action is "hide" or "warn"
+	Action string `json:"action"`
+	// ExpiresAt is null for permanent rules
+	ExpiresAt *time.Time `json:"expiresAt"`
+	CreatedAt time.Time  `json:"createdAt"`
+}
+
+type MutedWordsResponse struct {
+	MutedWords []MutedWord `json:"mutedWords"`
+}
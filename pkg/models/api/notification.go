@@ -0,0 +1,44 @@
+package api
+
+import "time"
+
+// NotificationGroup is a set of notifications of the same type about the same post, e.g.
+// "jdoe and 3 others liked your post"
+type NotificationGroup struct {
+	// ID is the ULID of the most recent notification of the group
+	ID   string `json:"id"`
+	Type string `json:"type"`
+	// Post is the ULID of the post the notifications are about, see NotificationsResponse.Posts
+	Post string `json:"post,omitempty"`
+	// Actors are the most recent users of the group, see NotificationsResponse.Users
+	Actors []uint64 `json:"actors"`
+	// Report is the outcome of the report of report_resolved notifications
+	Report     *ReportOutcome `json:"report,omitempty"`
+	ActorCount int64          `json:"actorCount"`
+	Unread     bool           `json:"unread"`
+	CreatedAt  time.Time      `json:"createdAt"`
+}
+
+type NotificationsResponse struct {
+	Notifications []NotificationGroup `json:"notifications"`
+	Users         []User              `json:"users"`
+	Posts         []Post              `json:"posts"`
+
+	NextCursor string `json:"nextCursor,omitempty"`
+}
+
+type UnreadCount struct {
+	// Count is the number of notification groups with unread notifications
+	Count int64 `json:"count"`
+}
+
+// NotificationEvent is streamed when a user is notified, see NotificationGroup
+type NotificationEvent struct {
+	// ID is the ULID of the notification
+	ID    string `json:"id"`
+	Type  string `json:"type"`
+	Actor uint64 `json:"actor"`
+	Post  string `json:"post,omitempty"`
+	// Report is the ULID of the resolved report of report_resolved notifications
+	Report string `json:"report,omitempty"`
+}
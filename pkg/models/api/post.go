@@ -9,9 +9,35 @@ type Post struct {
 	Replies   []Post    `json:"replies"`
 	Author    uint64    `json:"author"`
 	CreatedAt time.Time `json:"createdAt"`
+	Pinned    bool      `json:"pinned"`
+
+	Entities []Entity `json:"entities"`
+
+	// Reason is set in timelines, for posts that aren't there because of their author
+	Reason *TimelineReason `json:"reason,omitempty"`
+
+	// Card is the preview of the first link in Content, once it has been fetched
+	Card *Card `json:"card,omitempty"`
+
+	Media []Media `json:"media,omitempty"`
+
+	// Viewer-specific flags, always false for anonymous requests
+	BookmarkedByMe bool `json:"bookmarkedByMe"`
+	// Filtered is set when the post matches one of the muted words of the viewer with the "warn" action, or
+	// with any action when the post is opened
+	Filtered bool `json:"filtered,omitempty"`
 }
 
 type PostsResponse struct {
 	Posts []Post `json:"posts"`
 	Users []User `json:"users"`
+
+	// NextCursor is the cursor of the next page, empty when there are no more results
+	NextCursor string `json:"nextCursor,omitempty"`
+}
+
+// PostCounters is streamed when the counters of a post change
+type PostCounters struct {
+	Post  string `json:"post"`
+	Likes uint64 `json:"likes"`
 }
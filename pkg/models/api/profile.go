@@ -0,0 +1,17 @@
+package api
+
+import "time"
+
+type Profile struct {
+	ID             uint64    `json:"id"`
+	CreatedAt      time.Time `json:"createdAt"`
+	Username       string    `json:"username"`
+	DisplayName    string    `json:"displayName"`
+	Biography      string    `json:"biography"`
+	Location       string    `json:"location"`
+	FollowersCount int       `json:"followersCount"`
+	FollowingCount int       `json:"followingCount"`
+	Verified       bool      `json:"verified"`
+
+	PinnedPosts []Post `json:"pinnedPosts"`
+}
@@ -0,0 +1,30 @@
+package api
+
+import "time"
+
+type PushSubscription struct {
+	ID        uint64    `json:"id"`
+	Endpoint  string    `json:"endpoint"`
+	UserAgent string    `json:"userAgent"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+type PushSubscriptionsResponse struct {
+	Subscriptions []PushSubscription `json:"subscriptions"`
+}
+
+type VAPIDPublicKey struct {
+	// Key is the applicationServerKey to pass to PushManager.subscribe
+	Key string `json:"key"`
+}
+
+// PushMessage is the payload of the push messages, sent when a user is notified. Unlike in
+// NotificationEvent, the actor is embedded, as the service worker displays the notification without
+// calling the API. Notifications from the moderators have no actor.
+type PushMessage struct {
+	ID     string `json:"id"`
+	Type   string `json:"type"`
+	Actor  *User  `json:"actor,omitempty"`
+	Post   string `json:"post,omitempty"`
+	Report string `json:"report,omitempty"`
+}
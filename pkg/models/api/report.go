@@ -0,0 +1,77 @@
+package api
+
+import "time"
+
+type Report struct {
+	ID         string `json:"id"`
+	TargetType string `json:"targetType"`
+	// Post is the ULID of the reported post
+	Post string `json:"post,omitempty"`
+	// User is the reported user, or the author of the reported post
+	User    uint64 `json:"user"`
+	Reason  string `json:"reason"`
+	Comment string `json:"comment,omitempty"`
+	Status  string `json:"status"`
+	// Action is the action the report was resolved with
+	Action     string     `json:"action,omitempty"`
+	CreatedAt  time.Time  `json:"createdAt"`
+	ResolvedAt *time.Time `json:"resolvedAt,omitempty"`
+}
+
+// ReportWithReporter is a Report seen by a moderator
+type ReportWithReporter struct {
+	Report
+	Reporter uint64 `json:"reporter"`
+}
+
+// ReportGroup gathers the reports about the same post or user
+type ReportGroup struct {
+	// ID is the ULID of the most recent report of the group, moderators resolve the group through it
+	ID         string `json:"id"`
+	TargetType string `json:"targetType"`
+	Post       string `json:"post,omitempty"`
+	User       uint64 `json:"user"`
+	Count      int64  `json:"count"`
+	// Reasons counts the reports of each reason
+	Reasons map[string]int64 `json:"reasons"`
+	// Reports are the most recent reports of the group
+	Reports         []ReportWithReporter `json:"reports"`
+	FirstReportedAt time.Time            `json:"firstReportedAt"`
+	LastReportedAt  time.Time            `json:"lastReportedAt"`
+}
+
+type ReportGroupsResponse struct {
+	Groups []ReportGroup `json:"groups"`
+	Users  []User        `json:"users"`
+	Posts  []Post        `json:"posts"`
+
+	NextCursor string `json:"nextCursor,omitempty"`
+}
+
+type ReportsResolved struct {
+	// Count is the number of reports resolved by the action
+	Count int64 `json:"count"`
+}
+
+// ReportOutcome tells a reporter how their report was resolved
+type ReportOutcome struct {
+	ID         string `json:"id"`
+	TargetType string `json:"targetType"`
+	Post       string `json:"post,omitempty"`
+	User       uint64 `json:"user"`
+	Reason     string `json:"reason"`
+	Action     string `json:"action"`
+}
+
+type Warning struct {
+	ID        string    `json:"id"`
+	Reason    string    `json:"reason"`
+	Message   string    `json:"message"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+type WarningsResponse struct {
+	Warnings []Warning `json:"warnings"`
+
+	NextCursor string `json:"nextCursor,omitempty"`
+}
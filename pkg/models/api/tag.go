@@ -0,0 +1,34 @@
+package api
+
+import "time"
+
+type TrendingTag struct {
+	ID      uint64  `json:"id"`
+	Text    string  `json:"text"`
+	Score   float64 `json:"score"`
+	Uses    int64   `json:"uses"`
+	Authors int64   `json:"authors"`
+}
+
+type TagSuggestion struct {
+	ID         uint64 `json:"id"`
+	Text       string `json:"text"`
+	RecentUses int64  `json:"recentUses"`
+	Uses       int64  `json:"uses"`
+}
+
+type TagDailyUsage struct {
+	// Day is formatted as YYYY-MM-DD, in UTC
+	Day   string `json:"day"`
+	Posts int64  `json:"posts"`
+}
+
+type TagDetail struct {
+	ID          uint64     `json:"id"`
+	Text        string     `json:"text"`
+	PostCount   int64      `json:"postCount"`
+	Authors     int64      `json:"authors"`
+	FirstUsedAt *time.Time `json:"firstUsedAt"`
+
+	Daily []TagDailyUsage `json:"daily"`
+}
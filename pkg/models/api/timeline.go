@@ -0,0 +1,13 @@
+package api
+
+const TimelineReasonFollowedTag = "followed_tag"
+
+// TimelineReason explains why a post appears in a timeline, when it's not
+// authored by an account the viewer follows
+type TimelineReason struct {
+	Type string `json:"type"`
+
+	// Set for TimelineReasonFollowedTag
+	TagID uint64 `json:"tagId,omitempty"`
+	Tag   string `json:"tag,omitempty"`
+}
@@ -6,3 +6,7 @@ type User struct {
 	Username    string `json:"username"`
 	Verified    bool   `json:"verified"`
 }
+
+type UsersResponse struct {
+	Users []User `json:"users"`
+}
@@ -0,0 +1,69 @@
+package api
+
+import (
+	"encoding/json"
+	"time"
+)
+
+type Webhook struct {
+	ID          uint64    `json:"id"`
+	URL         string    `json:"url"`
+	Description string    `json:"description"`
+	Events      []string  `json:"events"`
+	Active      bool      `json:"active"`
+	CreatedAt   time.Time `json:"createdAt"`
+	// Secret is only returned when the webhook is created
+	Secret string `json:"secret,omitempty"`
+}
+
+type WebhooksResponse struct {
+	Webhooks []Webhook `json:"webhooks"`
+}
+
+type WebhookDelivery struct {
+	ID      string          `json:"id"`
+	EventID string          `json:"eventId"`
+	Event   string          `json:"event"`
+	Payload json.RawMessage `json:"payload"`
+	// Status is one of "pending", "succeeded" or "dead"
+	Status         string     `json:"status"`
+	Attempts       int        `json:"attempts"`
+	NextAttemptAt  *time.Time `json:"nextAttemptAt,omitempty"`
+	LastAttemptAt  *time.Time `json:"lastAttemptAt,omitempty"`
+	ResponseStatus int        `json:"responseStatus,omitempty"`
+	Error          string     `json:"error,omitempty"`
+	CreatedAt      time.Time  `json:"createdAt"`
+}
+
+type WebhookDeliveriesResponse struct {
+	Deliveries []WebhookDelivery `json:"deliveries"`
+	NextCursor string            `json:"nextCursor,omitempty"`
+}
+
+// WebhookPayload is the body of the requests sent to webhooks
+type WebhookPayload struct {
+	// ID is the ULID of the event, redeliveries have the same ID
+	ID        string    `json:"id"`
+	Event     string    `json:"event"`
+	CreatedAt time.Time `json:"createdAt"`
+	Data      any       `json:"data"`
+}
+
+// PostCreatedEvent is the data of post.created events
+type PostCreatedEvent struct {
+	Post   Post `json:"post"`
+	Author User `json:"author"`
+}
+
+// UserFollowedEvent is the data of user.followed events
+type UserFollowedEvent struct {
+	Follower uint64 `json:"follower"`
+	Followed uint64 `json:"followed"`
+}
+
+// PostLikedEvent is the data of post.liked events
+type PostLikedEvent struct {
+	Post   string `json:"post"`
+	Author uint64 `json:"author"`
+	User   uint64 `json:"user"`
+}
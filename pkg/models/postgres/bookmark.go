@@ -0,0 +1,23 @@
+package pg_model
+
+import "time"
+
+// Bookmark is a post privately saved by a user, optionally filed into one of their collections
+type Bookmark struct {
+	// ID is an ULID, bookmarks are paginated in the order they were created
+	ID     []byte `gorm:"primaryKey;type:bytea" json:"id"`
+	UserID uint64 `gorm:"uniqueIndex:idx_bookmarks_user_post" json:"userId"`
+	PostID []byte `gorm:"type:bytea;uniqueIndex:idx_bookmarks_user_post" json:"postId"`
+
+	CollectionID *uint64             `gorm:"index" json:"collectionId,omitempty"`
+	Collection   *BookmarkCollection `gorm:"constraint:OnDelete:SET NULL" json:"-"`
+
+	Post *Post `gorm:"foreignKey:PostID" json:"post,omitempty"`
+}
+
+type BookmarkCollection struct {
+	ID        uint64    `gorm:"primaryKey" json:"id"`
+	CreatedAt time.Time `json:"createdAt"`
+	UserID    uint64    `gorm:"uniqueIndex:idx_bookmark_collections_user_name" json:"userId"`
+	Name      string    `gorm:"uniqueIndex:idx_bookmark_collections_user_name" json:"name"`
+}
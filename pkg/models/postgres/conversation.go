@@ -0,0 +1,51 @@
+package pg_model
+
+import "time"
+
+// Statuses of the membership of a conversation
+const (
+	// MembershipAccepted conversations are in the inbox of the member
+	MembershipAccepted = "accepted"
+	// MembershipRequest conversations are in the requests inbox of the member, as they were started by
+	// someone the member doesn't follow. They are accepted by replying.
+	MembershipRequest = "request"
+)
+
+// Conversation is a private conversation between two users, or a small group
+type Conversation struct {
+	// ID is an ULID
+	ID        []byte    `gorm:"primaryKey;type:bytea" json:"id"`
+	CreatedAt time.Time `json:"createdAt"`
+	CreatorID uint64    `gorm:"not null" json:"creatorId"`
+	IsGroup   bool      `gorm:"not null;default:false" json:"isGroup"`
+	// Name is the optional name of a group
+	Name string `json:"name"`
+	// DirectKey identifies the two members of a 1:1 conversation, so that there is only one between them.
+	// It is NULL for groups.
+	DirectKey *string `gorm:"uniqueIndex" json:"-"`
+
+	// LastMessageID is the ULID of the most recent message, conversations are listed in its order
+	LastMessageID *[]byte `gorm:"type:bytea;index" json:"lastMessageId,omitempty"`
+
+	Members []ConversationMember `gorm:"constraint:OnDelete:CASCADE" json:"members,omitempty"`
+}
+
+type ConversationMember struct {
+	ConversationID []byte `gorm:"primaryKey;type:bytea" json:"conversationId"`
+	UserID         uint64 `gorm:"primaryKey;index" json:"userId"`
+	Status         string `gorm:"not null;default:accepted" json:"status"`
+	Muted          bool   `gorm:"not null;default:false" json:"muted"`
+	// LastReadMessageID is the ULID of the most recent message read by the member
+	LastReadMessageID *[]byte   `gorm:"type:bytea" json:"lastReadMessageId,omitempty"`
+	JoinedAt          time.Time `json:"joinedAt"`
+}
+
+type Message struct {
+	// ID is an ULID, messages are paginated in the order they were sent
+	ID             []byte        `gorm:"primaryKey;type:bytea" json:"id"`
+	ConversationID []byte        `gorm:"type:bytea;not null;index" json:"conversationId"`
+	Conversation   *Conversation `gorm:"constraint:OnDelete:CASCADE" json:"-"`
+	SenderID       uint64        `gorm:"not null" json:"senderId"`
+	Content        string        `gorm:"not null" json:"content"`
+	CreatedAt      time.Time     `json:"createdAt"`
+}
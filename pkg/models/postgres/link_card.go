@@ -0,0 +1,16 @@
+package pg_model
+
+import "time"
+
+// LinkCard caches the preview card of a URL found in posts
+type LinkCard struct {
+	URL         string    `gorm:"primaryKey" json:"url"`
+	Title       string    `json:"title"`
+	Description string    `json:"description"`
+	ImageURL    string    `json:"imageUrl"`
+	SiteName    string    `json:"siteName"`
+	FetchedAt   time.Time `json:"fetchedAt"`
+
+	// Error is set when the URL couldn't be unfurled, the fetch is retried later
+	Error string `json:"-"`
+}
@@ -0,0 +1,57 @@
+package pg_model
+
+import "time"
+
+// Processing status of a media
+const (
+	// MediaStatusPending media have been uploaded, but their variants haven't been generated yet
+	MediaStatusPending = "pending"
+	// MediaStatusProcessing media are being processed by a worker
+	MediaStatusProcessing = "processing"
+	MediaStatusReady      = "ready"
+	// MediaStatusFailed media couldn't be processed, see Media.Error
+	MediaStatusFailed = "failed"
+)
+
+// Media is a file uploaded by a user, to be attached to one of their posts
+type Media struct {
+	// ID is an ULID
+	ID      []byte `gorm:"primaryKey,type:bytea" json:"id"`
+	OwnerID uint64 `gorm:"index" json:"ownerId"`
+
+	// PostID is nil until the media is attached to a post
+	PostID *[]byte `gorm:"index" json:"postId"`
+	// Position is the order of the media among the attachments of its post
+	Position int `json:"position"`
+
+	// SourceKey is the key of the file as uploaded, it is deleted once the media has been processed
+	SourceKey string `json:"-"`
+	// Key is the key of the processed file in the storage
+	Key         string `json:"key"`
+	ContentType string `json:"contentType"`
+	Size        int64  `json:"size"`
+	Width       int    `json:"width"`
+	Height      int    `json:"height"`
+	AltText     string `json:"altText"`
+
+	Status string `gorm:"not null;default:pending;index" json:"status"`
+	// StatusUpdatedAt allows finding the media whose processing was interrupted
+	StatusUpdatedAt time.Time `json:"statusUpdatedAt"`
+	Error           string    `json:"-"`
+	Blurhash        string    `json:"blurhash"`
+
+	Variants []MediaVariant `gorm:"constraint:OnDelete:CASCADE" json:"variants,omitempty"`
+
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// MediaVariant is a resized version of a media, see imaging.Variants
+type MediaVariant struct {
+	MediaID     []byte `gorm:"primaryKey;type:bytea" json:"mediaId"`
+	Name        string `gorm:"primaryKey" json:"name"`
+	Key         string `json:"key"`
+	ContentType string `json:"contentType"`
+	Size        int64  `json:"size"`
+	Width       int    `json:"width"`
+	Height      int    `json:"height"`
+}
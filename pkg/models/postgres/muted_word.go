@@ -0,0 +1,33 @@
+package pg_model
+
+import "time"
+
+// Contexts muted words apply to
+const (
+	// FilterContextHome only filters the home timeline
+	FilterContextHome       = "home"
+	FilterContextEverywhere = "everywhere"
+)
+
+// Actions taken on the posts matching a muted word
+const (
+	// FilterActionHide drops the posts from the responses
+	FilterActionHide = "hide"
+	// FilterActionWarn keeps the posts, marked as filtered, so that clients can collapse them
+	FilterActionWarn = "warn"
+)
+
+// MutedWord filters the posts containing a word, a phrase or a hashtag out of what a user sees
+type MutedWord struct {
+	ID     uint64 `gorm:"primaryKey" json:"id"`
+	UserID uint64 `gorm:"not null;index" json:"userId"`
+
+	// Phrase is the rule, as written by the user, see package wordfilter
+	Phrase  string `gorm:"not null" json:"phrase"`
+	Context string `gorm:"not null;default:everywhere" json:"context"`
+	Action  string `gorm:"not null;default:hide" json:"action"`
+
+	// ExpiresAt is nil for permanent rules
+	ExpiresAt *time.Time `json:"expiresAt"`
+	CreatedAt time.Time  `json:"createdAt"`
+}
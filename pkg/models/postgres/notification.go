@@ -0,0 +1,35 @@
+package pg_model
+
+import "time"
+
+// Types of notifications
+const (
+	NotificationLike    = "like"
+	NotificationFollow  = "follow"
+	NotificationMention = "mention"
+	NotificationReply   = "reply"
+
+	// NotificationReportResolved tells a reporter that their report was resolved. It has no actor.
+	NotificationReportResolved = "report_resolved"
+	// NotificationWarning tells a user that the moderators warned them. It has no actor.
+	NotificationWarning = "warning"
+)
+
+// Notification tells a user about an action of another user. Notifications sharing a GroupKey,
+// such as the likes of a post, are shown together.
+type Notification struct {
+	// ID is an ULID
+	ID     []byte `gorm:"primaryKey;type:bytea" json:"id"`
+	UserID uint64 `gorm:"index:idx_notifications_user_group,priority:1;uniqueIndex:idx_notifications_unique,priority:1" json:"userId"`
+
+	Type     string `gorm:"not null" json:"type"`
+	GroupKey string `gorm:"not null;index:idx_notifications_user_group,priority:2;uniqueIndex:idx_notifications_unique,priority:2" json:"groupKey"`
+	ActorID  uint64 `gorm:"uniqueIndex:idx_notifications_unique,priority:3" json:"actorId"`
+	// PostID is the liked post, or the post that mentions or replies to the user
+	PostID *[]byte `gorm:"type:bytea" json:"postId,omitempty"`
+	// ReportID is the resolved report of NotificationReportResolved notifications
+	ReportID *[]byte `gorm:"type:bytea" json:"reportId,omitempty"`
+
+	ReadAt    *time.Time `json:"readAt,omitempty"`
+	CreatedAt time.Time  `json:"createdAt"`
+}
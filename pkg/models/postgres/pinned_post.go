@@ -0,0 +1,12 @@
+package pg_model
+
+import "time"
+
+// PinnedPost is a post its author chose to show at the top of their profile
+type PinnedPost struct {
+	UserID    uint64    `gorm:"primaryKey" json:"userId"`
+	PostID    []byte    `gorm:"primaryKey;type:bytea" json:"postId"`
+	CreatedAt time.Time `json:"createdAt"`
+
+	Post *Post `gorm:"foreignKey:PostID" json:"post,omitempty"`
+}
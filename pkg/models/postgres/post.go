@@ -1,10 +1,29 @@
 package pg_model
 
+import "time"
+
+// Visibility of a post
+const (
+	// VisibilityPublic posts are shown everywhere, including search and tag pages
+	VisibilityPublic = "public"
+	// VisibilityUnlisted posts are visible to anyone with the link, but aren't listed publicly
+	VisibilityUnlisted = "unlisted"
+	// VisibilityFollowers posts are only shown to the followers of their author
+	VisibilityFollowers = "followers"
+)
+
 type Post struct {
 	// ID is an ULID that contains the post creation date and some randomness
 	ID      []byte `gorm:"primaryKey,type:bytea" json:"id"`
 	Content string `json:"content"`
 
+	// Language is the ISO 639-1 code of the language of Content, it drives full-text search stemming
+	Language   string `gorm:"not null;default:en" json:"language"`
+	Visibility string `gorm:"not null;default:public;index" json:"visibility"`
+
+	// CreatedAt mirrors the timestamp of ID, so that posts can be filtered by date in SQL
+	CreatedAt time.Time `gorm:"index" json:"createdAt"`
+
 	UserMention []User `gorm:"many2many:user_mention;" json:"userMention"`
 	Tags        []Tag  `gorm:"many2many:post_tags;" json:"tags"`
 
@@ -0,0 +1,21 @@
+package pg_model
+
+import "time"
+
+// PostImpression records that a viewer was served a post during a given de-duplication window
+type PostImpression struct {
+	PostID    []byte `gorm:"primaryKey;type:bytea"`
+	ViewerKey string `gorm:"primaryKey"`
+	// Window is the unix timestamp of the start of the de-duplication window
+	Window    int64 `gorm:"primaryKey;index"`
+	CreatedAt time.Time
+}
+
+// PostDailyStat holds the counters of a post for a single (UTC) day
+type PostDailyStat struct {
+	PostID   []byte    `gorm:"primaryKey;type:bytea" json:"postId"`
+	Day      time.Time `gorm:"primaryKey;type:date" json:"day"`
+	Views    uint64    `gorm:"not null;default:0" json:"views"`
+	Likes    uint64    `gorm:"not null;default:0" json:"likes"`
+	Reshares uint64    `gorm:"not null;default:0" json:"reshares"`
+}
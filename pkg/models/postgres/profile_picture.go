@@ -6,5 +6,8 @@ type ProfilePicture struct {
 	ID          uint       `gorm:"primaryKey" json:"id"`
 	UserId      uint       `json:"user_id"`
 	LastUpdated *time.Time `json:"last_updated,omitempty"`
-	Url         string     `json:"url"`
+	// Url is the external URL of the picture, for the pictures that aren't stored as a media
+	Url string `json:"url"`
+	// MediaID is the ULID of the uploaded picture, see Media
+	MediaID *[]byte `gorm:"type:bytea" json:"mediaId,omitempty"`
 }
@@ -0,0 +1,18 @@
+package pg_model
+
+import "time"
+
+// PushSubscription is a Web Push subscription of one of the devices of a user
+type PushSubscription struct {
+	ID     uint64 `gorm:"primaryKey" json:"id"`
+	UserID uint64 `gorm:"not null;index" json:"userId"`
+
+	// Endpoint is the URL of the push service, which identifies the subscription
+	Endpoint string `gorm:"not null;uniqueIndex" json:"endpoint"`
+	P256dh   string `gorm:"not null" json:"p256dh"`
+	Auth     string `gorm:"not null" json:"auth"`
+
+	// UserAgent helps users tell their devices apart
+	UserAgent string    `json:"userAgent"`
+	CreatedAt time.Time `json:"createdAt"`
+}
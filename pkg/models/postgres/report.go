@@ -0,0 +1,69 @@
+package pg_model
+
+import "time"
+
+// Types of report targets
+const (
+	ReportTargetPost = "post"
+	ReportTargetUser = "user"
+)
+
+// Reasons of reports
+const (
+	ReportReasonSpam           = "spam"
+	ReportReasonHarassment     = "harassment"
+	ReportReasonHate           = "hate"
+	ReportReasonViolence       = "violence"
+	ReportReasonSexual         = "sexual"
+	ReportReasonMisinformation = "misinformation"
+	ReportReasonOther          = "other"
+)
+
+// Statuses of reports
+const (
+	ReportOpen     = "open"
+	ReportResolved = "resolved"
+)
+
+// Actions moderators resolve reports with
+const (
+	ModerationDismiss     = "dismiss"
+	ModerationDeletePost  = "delete_post"
+	ModerationSuspendUser = "suspend_user"
+	ModerationWarn        = "warn"
+)
+
+// Report flags a post or a user to the moderators
+type Report struct {
+	// ID is an ULID
+	ID         []byte `gorm:"primaryKey;type:bytea" json:"id"`
+	ReporterID uint64 `gorm:"not null;index" json:"reporterId"`
+
+	// TargetKey identifies the target, "post:<ULID>" or "user:<ID>": the moderation queue groups reports by it
+	TargetKey    string  `gorm:"not null;index" json:"targetKey"`
+	TargetType   string  `gorm:"not null" json:"targetType"`
+	TargetPostID *[]byte `gorm:"type:bytea" json:"targetPostId,omitempty"`
+	// TargetUserID is the reported user, or the author of the reported post
+	TargetUserID uint64 `gorm:"not null;index" json:"targetUserId"`
+
+	Reason  string `gorm:"not null" json:"reason"`
+	Comment string `json:"comment"`
+
+	Status string `gorm:"not null;default:open;index" json:"status"`
+	// Action is the action the report was resolved with
+	Action     string     `json:"action,omitempty"`
+	ResolvedAt *time.Time `json:"resolvedAt,omitempty"`
+	CreatedAt  time.Time  `json:"createdAt"`
+}
+
+// Warning is a warning given by the moderators to a user
+type Warning struct {
+	// ID is an ULID
+	ID     []byte `gorm:"primaryKey;type:bytea" json:"id"`
+	UserID uint64 `gorm:"not null;index" json:"userId"`
+	// ReportID is the report the warning was given for
+	ReportID  *[]byte   `gorm:"type:bytea" json:"reportId,omitempty"`
+	Reason    string    `json:"reason"`
+	Message   string    `json:"message"`
+	CreatedAt time.Time `json:"createdAt"`
+}
@@ -1,8 +1,21 @@
 package pg_model
 
+import "time"
+
 type Tag struct {
-	ID   uint64 `gorm:"primaryKey" json:"id"`
-	Text string `gorm:"index,unique" json:"text"`
+	ID uint64 `gorm:"primaryKey" json:"id"`
+	// Text is the tag as it was first written, it is only used for display
+	Text string `json:"text"`
+	// Canonical is the normalized form of Text, see entities.CanonicalTag
+	Canonical string `gorm:"uniqueIndex" json:"-"`
 
 	Posts []Post `gorm:"many2many:post_tags;" json:"posts,omitempty"`
 }
+
+// TagAlias makes a canonical form resolve to another tag, e.g. after two tags have been merged
+type TagAlias struct {
+	Canonical string    `gorm:"primaryKey" json:"canonical"`
+	TagID     uint64    `gorm:"index" json:"tagId"`
+	Tag       *Tag      `gorm:"constraint:OnDelete:CASCADE" json:"-"`
+	CreatedAt time.Time `json:"createdAt"`
+}
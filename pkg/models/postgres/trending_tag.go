@@ -0,0 +1,22 @@
+package pg_model
+
+import "time"
+
+// TrendingTag is a precomputed entry of the trending tags ranking
+type TrendingTag struct {
+	TagID uint64 `gorm:"primaryKey;autoIncrement:false" json:"tagId"`
+	Tag   *Tag   `json:"tag,omitempty"`
+
+	Rank  int     `gorm:"index" json:"rank"`
+	Score float64 `json:"score"`
+
+	// Usage within the trending window
+	Uses    int64 `json:"uses"`
+	Authors int64 `json:"authors"`
+
+	// BaselineAuthors is the number of distinct authors expected within the trending window,
+	// according to the longer baseline period
+	BaselineAuthors float64 `json:"baselineAuthors"`
+
+	ComputedAt time.Time `json:"computedAt"`
+}
@@ -2,6 +2,13 @@ package pg_model
 
 import "time"
 
+// Frequencies of the email digest
+const (
+	DigestOff    = "off"
+	DigestDaily  = "daily"
+	DigestWeekly = "weekly"
+)
+
 type User struct {
 	ID                uint64           `gorm:"primaryKey" json:"id"`
 	CreatedAt         time.Time        `json:"createdAt"`
@@ -15,6 +22,17 @@ type User struct {
 	ProfilePictures   []ProfilePicture `json:"profilePictures,omitempty"`
 	Verified          bool             `json:"verified"`
 	Deleted           bool             `json:"-"`
+	// SuspendedAt is when the moderators suspended the user, nil unless suspended. Suspended users can't act
+	// as viewers.
+	SuspendedAt *time.Time `json:"-"`
+
+	// Email is the address emails are sent to, users without one don't receive emails
+	Email string `json:"-"`
+	// PendingEmail is a new address, which replaces Email once confirmed with the link sent to it
+	PendingEmail       string     `json:"-"`
+	EmailNotifications bool       `gorm:"not null;default:false" json:"-"`
+	DigestFrequency    string     `gorm:"not null;default:off" json:"-"`
+	LastDigestAt       *time.Time `json:"-"`
 
 	MentionedIn []Post `gorm:"many2many:user_mention;" json:"mentionedIn"`
 
@@ -0,0 +1,64 @@
+package pg_model
+
+import "time"
+
+// Types of the events sent to webhooks
+const (
+	WebhookEventPostCreated  = "post.created"
+	WebhookEventUserFollowed = "user.followed"
+	WebhookEventPostLiked    = "post.liked"
+)
+
+// Statuses of a webhook delivery
+const (
+	// WebhookDeliveryPending deliveries are waiting for their next attempt
+	WebhookDeliveryPending = "pending"
+	// WebhookDeliverySucceeded deliveries got a 2xx response
+	WebhookDeliverySucceeded = "succeeded"
+	// WebhookDeliveryDead deliveries failed too many times, and aren't retried anymore
+	WebhookDeliveryDead = "dead"
+)
+
+// Webhook is an endpoint registered by an integration, to which the events it subscribed to are posted
+type Webhook struct {
+	ID          uint64    `gorm:"primaryKey" json:"id"`
+	CreatedAt   time.Time `json:"createdAt"`
+	URL         string    `gorm:"not null" json:"url"`
+	Description string    `json:"description"`
+	// Secret is the key of the HMAC signature of the deliveries
+	Secret string `gorm:"not null" json:"-"`
+	Active bool   `gorm:"not null;default:true" json:"active"`
+
+	Events []WebhookEvent `gorm:"constraint:OnDelete:CASCADE" json:"events"`
+}
+
+// WebhookEvent is a type of event a webhook is subscribed to
+type WebhookEvent struct {
+	WebhookID uint64 `gorm:"primaryKey;autoIncrement:false" json:"-"`
+	Event     string `gorm:"primaryKey" json:"event"`
+}
+
+// WebhookDelivery is an event to be posted, or posted, to a webhook
+type WebhookDelivery struct {
+	// ID is an ULID
+	ID        []byte   `gorm:"primaryKey;type:bytea" json:"id"`
+	WebhookID uint64   `gorm:"not null;index" json:"webhookId"`
+	Webhook   *Webhook `gorm:"constraint:OnDelete:CASCADE" json:"-"`
+	// EventID is the ULID of the event, shared by its redeliveries so that receivers can deduplicate them
+	EventID []byte `gorm:"type:bytea;not null" json:"eventId"`
+	Event   string `gorm:"not null" json:"event"`
+	// Payload is the JSON body of the requests
+	Payload string `gorm:"not null" json:"payload"`
+
+	Status   string `gorm:"not null;default:pending" json:"status"`
+	Attempts int    `gorm:"not null;default:0" json:"attempts"`
+	// NextAttemptAt is when the delivery is attempted, or attempted again. It is also pushed forward while
+	// the delivery is in progress, so that it is retried if the server stops in the meantime.
+	NextAttemptAt time.Time  `gorm:"not null;index" json:"nextAttemptAt"`
+	LastAttemptAt *time.Time `json:"lastAttemptAt,omitempty"`
+	// ResponseStatus is the HTTP status of the last attempt, 0 when no response was received
+	ResponseStatus int    `json:"responseStatus"`
+	Error          string `json:"error,omitempty"`
+
+	CreatedAt time.Time `json:"createdAt"`
+}
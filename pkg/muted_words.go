@@ -0,0 +1,109 @@
+package server
+
+import (
+	"github.com/denysvitali/social/backend/pkg/models/api"
+	pg_model "github.com/denysvitali/social/backend/pkg/models/postgres"
+	"github.com/denysvitali/social/backend/pkg/wordfilter"
+	"gorm.io/gorm"
+	"time"
+)
+
+// postFilter applies the muted words of a viewer to posts
+type postFilter struct {
+	rules []mutedRule
+}
+
+type mutedRule struct {
+	rule      wordfilter.Rule
+	action    string
+	expiresAt *time.Time
+}
+
+// activeMutedWords selects the muted words of userId that haven't expired
+func (s *Server) activeMutedWords(userId uint64) *gorm.DB {
+	return s.pgDB.Where("user_id = ? AND (expires_at IS NULL OR expires_at > ?)", userId, time.Now())
+}
+
+// loadPostFilter returns the filter of the muted words of viewer applying to context. The home timeline
+// is filtered by the rules of both contexts, the other listings by the "everywhere" rules only.
+// Anonymous viewers get a filter keeping all the posts.
+func (s *Server) loadPostFilter(viewer uint64, context string) (*postFilter, error) {
+	f := &postFilter{}
+	if viewer == 0 {
+		return f, nil
+	}
+
+	contexts := []string{pg_model.FilterContextEverywhere}
+	if context == pg_model.FilterContextHome {
+		contexts = append(contexts, pg_model.FilterContextHome)
+	}
+	var mutedWords []pg_model.MutedWord
+	err := s.activeMutedWords(viewer).
+		Where("context IN ?", contexts).
+		Find(&mutedWords).Error
+	if err != nil {
+		return nil, err
+	}
+
+	for _, w := range mutedWords {
+		rule, err := wordfilter.Parse(w.Phrase)
+		if err != nil {
+			s.logger.Warnf("invalid muted word %d: %v", w.ID, err)
+			continue
+		}
+		f.rules = append(f.rules, mutedRule{rule: rule, action: w.Action, expiresAt: w.ExpiresAt})
+	}
+	return f, nil
+}
+
+// apply drops the posts matching a "hide" rule, and marks the ones matching a "warn" rule as filtered.
+// Rules are checked for expiration, as filters outlive requests in streams.
+func (f *postFilter) apply(posts []api.Post) []api.Post {
+	if len(f.rules) == 0 {
+		return posts
+	}
+
+	now := time.Now()
+	kept := posts[:0]
+	for _, p := range posts {
+		matched, hidden := f.match(p, now)
+		if hidden {
+			continue
+		}
+		p.Filtered = p.Filtered || matched
+		kept = append(kept, p)
+	}
+	return kept
+}
+
+// mark marks the posts matching any rule as filtered, without dropping them: posts that the viewer opens
+// are collapsed rather than not found
+func (f *postFilter) mark(posts []api.Post) {
+	now := time.Now()
+	for i := range posts {
+		matched, _ := f.match(posts[i], now)
+		posts[i].Filtered = posts[i].Filtered || matched
+	}
+}
+
+// match returns whether a rule matches p, and whether one of the matching rules hides it
+func (f *postFilter) match(p api.Post, now time.Time) (bool, bool) {
+	if len(f.rules) == 0 {
+		return false, false
+	}
+	text := wordfilter.NewText(p.Content)
+	matched := false
+	for _, r := range f.rules {
+		if r.expiresAt != nil && !r.expiresAt.After(now) {
+			continue
+		}
+		if !r.rule.Matches(text) {
+			continue
+		}
+		if r.action == pg_model.FilterActionHide {
+			return true, true
+		}
+		matched = true
+	}
+	return matched, false
+}
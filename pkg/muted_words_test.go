@@ -0,0 +1,75 @@
+package server
+
+import (
+	"github.com/denysvitali/social/backend/pkg/models/api"
+	pg_model "github.com/denysvitali/social/backend/pkg/models/postgres"
+	"github.com/denysvitali/social/backend/pkg/wordfilter"
+	"testing"
+	"time"
+)
+
+func newTestPostFilter(t *testing.T, rules map[string]string) *postFilter {
+	t.Helper()
+	f := &postFilter{}
+	for phrase, action := range rules {
+		rule, err := wordfilter.Parse(phrase)
+		if err != nil {
+			t.Fatal(err)
+		}
+		f.rules = append(f.rules, mutedRule{rule: rule, action: action})
+	}
+	return f
+}
+
+func TestPostFilter(t *testing.T) {
+	expired := time.Now().Add(-time.Minute)
+	f := newTestPostFilter(t, map[string]string{
+		"spoiler": pg_model.FilterActionWarn,
+		"#crypto": pg_model.FilterActionHide,
+	})
+	rule, err := wordfilter.Parse("election")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.rules = append(f.rules, mutedRule{rule: rule, action: pg_model.FilterActionHide, expiresAt: &expired})
+
+	posts := func() []api.Post {
+		return []api.Post{
+			{ID: "plain", Content: "hello"},
+			{ID: "warned", Content: "Spoiler: it was the butler"},
+			{ID: "hidden", Content: "buy now #Crypto"},
+			{ID: "both", Content: "spoiler #crypto"},
+			{ID: "expired", Content: "election results"},
+		}
+	}
+
+	got := map[string]bool{}
+	for _, p := range f.apply(posts()) {
+		got[p.ID] = p.Filtered
+	}
+	want := map[string]bool{"plain": false, "warned": true, "expired": false}
+	if len(got) != len(want) {
+		t.Errorf("apply kept %v, want %v", got, want)
+	}
+	for id, filtered := range want {
+		if f, ok := got[id]; !ok || f != filtered {
+			t.Errorf("apply: post %s kept %v with filtered %v, want filtered %v", id, ok, f, filtered)
+		}
+	}
+
+	// Opened posts are collapsed, whatever the action
+	marked := posts()
+	f.mark(marked)
+	want = map[string]bool{"plain": false, "warned": true, "hidden": true, "both": true, "expired": false}
+	for _, p := range marked {
+		if p.Filtered != want[p.ID] {
+			t.Errorf("mark: post %s has filtered %v, want %v", p.ID, p.Filtered, want[p.ID])
+		}
+	}
+
+	// Anonymous viewers have no rules
+	empty := &postFilter{}
+	if kept := empty.apply(posts()); len(kept) != 5 {
+		t.Errorf("empty filter kept %d posts", len(kept))
+	}
+}
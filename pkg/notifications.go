@@ -0,0 +1,147 @@
+package server
+
+import (
+	"context"
+	"github.com/denysvitali/social/backend/pkg/models/api"
+	pg_model "github.com/denysvitali/social/backend/pkg/models/postgres"
+	"github.com/oklog/ulid/v2"
+	"gorm.io/gorm/clause"
+	"time"
+)
+
+// maxNotificationActors is the number of actors listed in a notification group, the others are only counted
+const maxNotificationActors = 3
+
+// notificationGroupKey returns the key under which notifications are grouped: the likes of a post are
+// grouped, as are follows, whereas each mention and reply is shown on its own
+func notificationGroupKey(notificationType string, postId []byte) string {
+	if postId == nil {
+		return notificationType
+	}
+	return notificationType + ":" + bytesToUlid(postId).String()
+}
+
+// notify notifies userId of an action of actorId. Users aren't notified of their own actions.
+// Notifications are best-effort: failures are logged, as they must not fail the action itself.
+func (s *Server) notify(userId uint64, notificationType string, actorId uint64, postId []byte) {
+	if userId == actorId {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), graphTimeout)
+	defer cancel()
+	ignored, err := s.ignoresActor(ctx, userId, actorId)
+	if err != nil {
+		s.logger.Warnf("unable to check whether user %d ignores %d: %v", userId, actorId, err)
+		return
+	}
+	if ignored {
+		return
+	}
+
+	n := pg_model.Notification{
+		ID:        ulid.Make().Bytes(),
+		UserID:    userId,
+		Type:      notificationType,
+		GroupKey:  notificationGroupKey(notificationType, postId),
+		ActorID:   actorId,
+		CreatedAt: time.Now(),
+	}
+	if postId != nil {
+		n.PostID = &postId
+	}
+	s.createNotification(n)
+}
+
+// notifyModeration notifies userId of a decision of the moderators: the resolution of their report, or a
+// warning, identified by id. Such notifications have no actor, and are never grouped.
+func (s *Server) notifyModeration(userId uint64, notificationType string, id []byte) {
+	n := pg_model.Notification{
+		ID:        ulid.Make().Bytes(),
+		UserID:    userId,
+		Type:      notificationType,
+		GroupKey:  notificationGroupKey(notificationType, id),
+		CreatedAt: time.Now(),
+	}
+	if notificationType == pg_model.NotificationReportResolved {
+		n.ReportID = &id
+	}
+	s.createNotification(n)
+}
+
+// isModerationNotification returns whether notifications of notificationType come from the moderators
+func isModerationNotification(notificationType string) bool {
+	return notificationType == pg_model.NotificationReportResolved || notificationType == pg_model.NotificationWarning
+}
+
+// createNotification stores n, then sends it to its user
+func (s *Server) createNotification(n pg_model.Notification) {
+	// Repeating an action, e.g. liking a post again, doesn't notify again
+	tx := s.pgDB.Clauses(clause.OnConflict{DoNothing: true}).Create(&n)
+	if tx.Error != nil {
+		s.logger.Warnf("unable to notify user %d of %s by %d: %v", n.UserID, n.Type, n.ActorID, tx.Error)
+		return
+	}
+	if tx.RowsAffected == 0 {
+		return
+	}
+
+	event := api.NotificationEvent{
+		ID:    bytesToUlid(n.ID).String(),
+		Type:  n.Type,
+		Actor: n.ActorID,
+	}
+	var postId []byte
+	if n.PostID != nil {
+		postId = *n.PostID
+		event.Post = bytesToUlid(postId).String()
+	}
+	if n.ReportID != nil {
+		event.Report = bytesToUlid(*n.ReportID).String()
+	}
+	s.publish([]string{userTopic(n.UserID)}, streamEventNotification, event)
+	s.publishUnreadCount(n.UserID)
+	s.pushNotification(n.UserID, event)
+	s.emailNotification(n.UserID, n.Type, n.ActorID, postId)
+}
+
+// unnotify removes the unread notification of an action that was undone, e.g. a like
+func (s *Server) unnotify(userId uint64, notificationType string, actorId uint64, postId []byte) {
+	tx := s.pgDB.
+		Where("user_id = ? AND group_key = ? AND actor_id = ? AND read_at IS NULL",
+			userId, notificationGroupKey(notificationType, postId), actorId).
+		Delete(&pg_model.Notification{})
+	if tx.Error != nil {
+		s.logger.Warnf("unable to remove %s notification of user %d by %d: %v", notificationType, userId, actorId, tx.Error)
+		return
+	}
+	if tx.RowsAffected > 0 {
+		s.publishUnreadCount(userId)
+	}
+}
+
+// notifyPostCreated notifies the author of the parent post of a reply, and the users mentioned in post
+// who can see it
+func (s *Server) notifyPostCreated(ctx context.Context, post pg_model.Post, parentAuthorId uint64) {
+	if parentAuthorId != 0 {
+		s.notifyIfVisible(ctx, parentAuthorId, pg_model.NotificationReply, post)
+	}
+	for _, u := range post.UserMention {
+		// The reply notification is enough
+		if u.ID == parentAuthorId {
+			continue
+		}
+		s.notifyIfVisible(ctx, u.ID, pg_model.NotificationMention, post)
+	}
+}
+
+func (s *Server) notifyIfVisible(ctx context.Context, userId uint64, notificationType string, post pg_model.Post) {
+	visible, err := s.postVisibleTo(ctx, userId, post)
+	if err != nil {
+		s.logger.Warnf("unable to check visibility of post for user %d: %v", userId, err)
+		return
+	}
+	if visible {
+		s.notify(userId, notificationType, post.AuthorID, post.ID)
+	}
+}
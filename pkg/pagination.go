@@ -0,0 +1,91 @@
+package server
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/oklog/ulid/v2"
+	"strconv"
+)
+
+const defaultPageSize = 20
+const maxPageSize = 100
+
+// page describes a cursor-paginated request over ULID-keyed rows, newest first.
+type page struct {
+	// Cursor is the ID of the last item of the previous page, nil for the first page
+	Cursor *ulid.ULID
+	Limit  int
+}
+
+func parsePage(c *gin.Context) (page, error) {
+	p := page{Limit: defaultPageSize}
+
+	if v := c.Query("cursor"); v != "" {
+		u, err := ulid.Parse(v)
+		if err != nil {
+			return p, fmt.Errorf("invalid cursor")
+		}
+		p.Cursor = &u
+	}
+
+	limit, err := parseLimit(c)
+	if err != nil {
+		return p, err
+	}
+	p.Limit = limit
+	return p, nil
+}
+
+// parseLimit parses the "limit" query parameter, capped to maxPageSize
+func parseLimit(c *gin.Context) (int, error) {
+	v := c.Query("limit")
+	if v == "" {
+		return defaultPageSize, nil
+	}
+	limit, err := strconv.Atoi(v)
+	if err != nil || limit <= 0 {
+		return 0, fmt.Errorf("invalid limit")
+	}
+	if limit > maxPageSize {
+		limit = maxPageSize
+	}
+	return limit, nil
+}
+
+// encodeCursor returns an opaque cursor holding v, for results that aren't sorted by ID
+func encodeCursor(v any) string {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return ""
+	}
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func decodeCursor(cursor string, v any) error {
+	b, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return fmt.Errorf("invalid cursor")
+	}
+	err = json.Unmarshal(b, v)
+	if err != nil {
+		return fmt.Errorf("invalid cursor")
+	}
+	return nil
+}
+
+// nextCursor returns the cursor for the page following the one ending with lastId,
+// or an empty string if the current page wasn't full.
+func (p page) nextCursor(count int, lastId []byte) string {
+	if count < p.Limit || len(lastId) < 16 {
+		return ""
+	}
+	return bytesToUlid(lastId).String()
+}
+
+func bytesToUlid(b []byte) ulid.ULID {
+	var ulidBytes [16]byte
+	copy(ulidBytes[:], b[:16])
+	return ulid.ULID(ulidBytes)
+}
@@ -0,0 +1,263 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"github.com/denysvitali/social/backend/pkg/entities"
+	"github.com/denysvitali/social/backend/pkg/models/api"
+	pg_model "github.com/denysvitali/social/backend/pkg/models/postgres"
+	"github.com/oklog/ulid/v2"
+	"gorm.io/gorm"
+)
+
+// MaxPostLength is the maximum length of a post, in Unicode code points
+const MaxPostLength = 500
+
+// errMediaAttached is returned when a media was attached to another post concurrently
+var errMediaAttached = errors.New("media is already attached to a post")
+
+// createPost stores post, linking it to the tags and the users referenced in its content, and
+// attaching media to it in order. A reply counts as a reshare of its parent in the stats of the parent.
+func (s *Server) createPost(post *pg_model.Post, media []pg_model.Media) error {
+	parsed := entities.Parse(post.Content)
+	post.CreatedAt = ulid.Time(bytesToUlid(post.ID).Time())
+
+	return s.pgDB.Transaction(func(tx *gorm.DB) error {
+		tags, err := findOrCreateTags(tx, entities.Texts(parsed, entities.Hashtag))
+		if err != nil {
+			return err
+		}
+		post.Tags = tags
+
+		usernames := entities.Texts(parsed, entities.Mention)
+		if len(usernames) > 0 {
+			var mentioned []pg_model.User
+			err = tx.
+				Select("id", "username").
+				Where("username IN ? AND deleted = false AND suspended_at IS NULL", usernames).
+				Find(&mentioned).Error
+			if err != nil {
+				return err
+			}
+			post.UserMention = mentioned
+		}
+
+		// Only create the join table rows, the referenced rows already exist
+		err = tx.
+			Omit("Tags.*", "UserMention.*", "LikedBy.*").
+			Create(post).Error
+		if err != nil {
+			return err
+		}
+
+		for i, m := range media {
+			res := tx.Model(&pg_model.Media{}).
+				Where("id = ? AND post_id IS NULL", m.ID).
+				Updates(map[string]any{
+					"post_id":  post.ID,
+					"position": i,
+					"alt_text": m.AltText,
+				})
+			if res.Error != nil {
+				return res.Error
+			}
+			if res.RowsAffected == 0 {
+				return errMediaAttached
+			}
+		}
+
+		if post.ParentPostID != nil {
+			return incrementPostStat(tx, *post.ParentPostID, post.CreatedAt, statReshares)
+		}
+		return nil
+	})
+}
+
+// visibleTo returns the visibilities of the posts of author that viewer can see on their profile
+func (s *Server) visibleTo(ctx context.Context, viewer uint64, author uint64) ([]string, error) {
+	visibilities := []string{pg_model.VisibilityPublic, pg_model.VisibilityUnlisted}
+	if viewer == 0 {
+		return visibilities, nil
+	}
+	if viewer == author {
+		return append(visibilities, pg_model.VisibilityFollowers), nil
+	}
+
+	if s.arangoDB == nil {
+		return visibilities, nil
+	}
+	follows, err := s.hasRelation(ctx, RelationFollows, userVertex(viewer), userVertex(author))
+	if err != nil {
+		return nil, err
+	}
+	if follows {
+		visibilities = append(visibilities, pg_model.VisibilityFollowers)
+	}
+	return visibilities, nil
+}
+
+// postVisibleTo returns whether viewer can see post, according to its visibility and the blocks between
+// viewer and its author
+func (s *Server) postVisibleTo(ctx context.Context, viewer uint64, post pg_model.Post) (bool, error) {
+	blocked, err := s.isBlocked(ctx, viewer, post.AuthorID)
+	if err != nil || blocked {
+		return false, err
+	}
+	if post.Visibility != pg_model.VisibilityFollowers {
+		return true, nil
+	}
+	visibilities, err := s.visibleTo(ctx, viewer, post.AuthorID)
+	if err != nil {
+		return false, err
+	}
+	for _, v := range visibilities {
+		if v == pg_model.VisibilityFollowers {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// visiblePosts returns the posts that viewer can see, see postVisibleTo
+func (s *Server) visiblePosts(ctx context.Context, viewer uint64, posts []pg_model.Post) ([]pg_model.Post, error) {
+	// Visibility only depends on the author, and on whether the post is restricted to followers
+	type visibilityKey struct {
+		author    uint64
+		followers bool
+	}
+	checked := map[visibilityKey]bool{}
+
+	var visible []pg_model.Post
+	for _, p := range posts {
+		key := visibilityKey{author: p.AuthorID, followers: p.Visibility == pg_model.VisibilityFollowers}
+		ok, found := checked[key]
+		if !found {
+			var err error
+			ok, err = s.postVisibleTo(ctx, viewer, p)
+			if err != nil {
+				return nil, err
+			}
+			checked[key] = ok
+		}
+		if ok {
+			visible = append(visible, p)
+		}
+	}
+	return visible, nil
+}
+
+// postEntityRefs holds, for each post, the tags and users referenced by its entities
+type postEntityRefs struct {
+	// tags maps post ULID -> canonical tag -> tag ID
+	tags map[string]map[string]uint64
+	// mentions maps post ULID -> username -> user ID
+	mentions map[string]map[string]uint64
+}
+
+func (s *Server) getPostEntityRefs(postIds [][]byte) (postEntityRefs, error) {
+	refs := postEntityRefs{
+		tags:     map[string]map[string]uint64{},
+		mentions: map[string]map[string]uint64{},
+	}
+
+	type ref struct {
+		PostID []byte
+		ID     uint64
+		Text   string
+	}
+
+	var tagRefs []ref
+	tx := s.pgDB.
+		Table("post_tags").
+		Select("post_tags.post_id, tags.id, tags.canonical AS text").
+		Joins("JOIN tags ON tags.id = post_tags.tag_id").
+		Where("post_tags.post_id IN ?", postIds).
+		Scan(&tagRefs)
+	if tx.Error != nil {
+		return refs, tx.Error
+	}
+	// Posts tagged through an alias are linked to the tag the alias points to
+	var tagIds []uint64
+	for _, v := range tagRefs {
+		tagIds = append(tagIds, v.ID)
+	}
+	aliasesByTag := map[uint64][]string{}
+	if len(tagIds) > 0 {
+		var aliases []pg_model.TagAlias
+		tx = s.pgDB.Where("tag_id IN ?", tagIds).Find(&aliases)
+		if tx.Error != nil {
+			return refs, tx.Error
+		}
+		for _, a := range aliases {
+			aliasesByTag[a.TagID] = append(aliasesByTag[a.TagID], a.Canonical)
+		}
+	}
+
+	for _, v := range tagRefs {
+		postId := bytesToUlid(v.PostID).String()
+		if refs.tags[postId] == nil {
+			refs.tags[postId] = map[string]uint64{}
+		}
+		refs.tags[postId][v.Text] = v.ID
+		for _, alias := range aliasesByTag[v.ID] {
+			refs.tags[postId][alias] = v.ID
+		}
+	}
+
+	var mentionRefs []ref
+	tx = s.pgDB.
+		Table("user_mention").
+		Select("user_mention.post_id, users.id, users.username AS text").
+		Joins("JOIN users ON users.id = user_mention.user_id").
+		Where("user_mention.post_id IN ?", postIds).
+		Scan(&mentionRefs)
+	if tx.Error != nil {
+		return refs, tx.Error
+	}
+	for _, v := range mentionRefs {
+		postId := bytesToUlid(v.PostID).String()
+		if refs.mentions[postId] == nil {
+			refs.mentions[postId] = map[string]uint64{}
+		}
+		refs.mentions[postId][v.Text] = v.ID
+	}
+
+	return refs, nil
+}
+
+// getApiEntities returns the entities of a post. Mentions of users that don't exist
+// are plain text, and thus omitted.
+func getApiEntities(content string, tags map[string]uint64, mentions map[string]uint64) []api.Entity {
+	result := []api.Entity{}
+	for _, e := range entities.Parse(content) {
+		apiEntity := api.Entity{
+			Type:  string(e.Type),
+			Start: e.Start,
+			End:   e.End,
+		}
+
+		switch e.Type {
+		case entities.Hashtag:
+			id, ok := tags[entities.CanonicalTag(e.Text)]
+			if !ok {
+				continue
+			}
+			apiEntity.TagID = &id
+			// As written by the author
+			apiEntity.Tag = e.Text
+		case entities.Mention:
+			id, ok := mentions[e.Text]
+			if !ok {
+				continue
+			}
+			apiEntity.UserID = &id
+			apiEntity.Username = e.Text
+		case entities.URL:
+			apiEntity.DisplayURL = entities.DisplayURL(e.Text)
+			apiEntity.ExpandedURL = e.Text
+		}
+
+		result = append(result, apiEntity)
+	}
+	return result
+}
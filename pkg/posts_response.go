@@ -0,0 +1,93 @@
+package server
+
+import (
+	"github.com/denysvitali/social/backend/pkg/models/api"
+	pg_model "github.com/denysvitali/social/backend/pkg/models/postgres"
+)
+
+// postsResponse converts posts to their API representation, sideloading their authors and
+// setting the flags that depend on the viewer. A zero viewer denotes an anonymous request.
+func (s *Server) postsResponse(viewer uint64, posts []pg_model.Post) (api.PostsResponse, error) {
+	postsResponse := api.PostsResponse{
+		Posts: []api.Post{},
+		Users: []api.User{},
+	}
+	if len(posts) == 0 {
+		return postsResponse, nil
+	}
+
+	authorsMap := map[uint64]bool{}
+	var postIds [][]byte
+	var urls []string
+	for _, p := range posts {
+		postsResponse.Posts = append(postsResponse.Posts, getApiPost(p))
+		authorsMap[p.AuthorID] = true
+		postIds = append(postIds, p.ID)
+		if u := firstURL(p.Content); u != "" {
+			urls = append(urls, u)
+		}
+	}
+
+	cards, err := s.linkCardsByURL(urls)
+	if err != nil {
+		return postsResponse, err
+	}
+	for i := range postsResponse.Posts {
+		postsResponse.Posts[i].Card = cards[firstURL(postsResponse.Posts[i].Content)]
+	}
+
+	// Fetch Authors
+	var authorIds []uint64
+	for k := range authorsMap {
+		authorIds = append(authorIds, k)
+	}
+
+	var authors []pg_model.User
+	tx := s.pgDB.Where("id IN ?", authorIds).Find(&authors)
+	if tx.Error != nil {
+		return postsResponse, tx.Error
+	}
+	for _, u := range authors {
+		postsResponse.Users = append(postsResponse.Users, getApiUser(u))
+	}
+
+	refs, err := s.getPostEntityRefs(postIds)
+	if err != nil {
+		return postsResponse, err
+	}
+	for i := range postsResponse.Posts {
+		p := &postsResponse.Posts[i]
+		p.Entities = getApiEntities(p.Content, refs.tags[p.ID], refs.mentions[p.ID])
+	}
+
+	media, err := s.postMedia(postIds)
+	if err != nil {
+		return postsResponse, err
+	}
+	for i := range postsResponse.Posts {
+		postsResponse.Posts[i].Media = media[postsResponse.Posts[i].ID]
+	}
+
+	if viewer == 0 {
+		return postsResponse, nil
+	}
+
+	bookmarked, err := s.bookmarkedPostIds(viewer, postIds)
+	if err != nil {
+		return postsResponse, err
+	}
+	for i := range postsResponse.Posts {
+		postsResponse.Posts[i].BookmarkedByMe = bookmarked[postsResponse.Posts[i].ID]
+	}
+
+	return postsResponse, nil
+}
+
+func getApiUser(u pg_model.User) api.User {
+	return api.User{
+		ID:          u.ID,
+		DisplayName: u.DisplayName,
+		Username:    u.Username,
+		Verified:    u.Verified,
+	}
+}
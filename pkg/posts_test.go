@@ -0,0 +1,53 @@
+package server
+
+import (
+	"bytes"
+	"database/sql/driver"
+	pg_model "github.com/denysvitali/social/backend/pkg/models/postgres"
+	"github.com/oklog/ulid/v2"
+	"github.com/sirupsen/logrus"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestCreatePostCountsReplies(t *testing.T) {
+	db, fake := newFakeDB(t, func(query string, args []driver.Value) (fakeResult, error) {
+		return fakeResult{}, nil
+	})
+	log := logrus.New()
+	log.SetOutput(io.Discard)
+	s := &Server{logger: log, pgDB: db}
+
+	parentId := ulid.Make().Bytes()
+	for _, parent := range [][]byte{nil, parentId} {
+		post := pg_model.Post{ID: ulid.Make().Bytes(), Content: "hello", AuthorID: 2}
+		if parent != nil {
+			post.ParentPostID = &parent
+		}
+		before := len(fake.execs())
+		err := s.createPost(&post, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var reshares []fakeStatement
+		for _, st := range fake.execs()[before:] {
+			if strings.Contains(st.query, "post_daily_stats") {
+				reshares = append(reshares, st)
+			}
+		}
+		if parent == nil {
+			if len(reshares) != 0 {
+				t.Errorf("post without parent incremented stats: %v", reshares)
+			}
+			continue
+		}
+		if len(reshares) != 1 || !strings.Contains(reshares[0].query, "reshares") {
+			t.Fatalf("reply didn't increment the reshares of its parent: %v", reshares)
+		}
+		if args := reshares[0].args; len(args) != 2 || !bytes.Equal(args[1].([]byte), parentId) {
+			t.Errorf("got reshare with %v, want parent %v", args, parentId)
+		}
+	}
+}
@@ -0,0 +1,182 @@
+package pubsub
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+)
+
+// DefaultHistorySize is the number of events kept to be replayed
+const DefaultHistorySize = 4096
+
+// subscriberBuffer is the number of events a subscriber can lag behind before being dropped
+const subscriberBuffer = 64
+
+// Memory is an in-process Broker
+type Memory struct {
+	mu     sync.Mutex
+	lastID uint64
+	closed bool
+
+	// history is a ring buffer of the last events, next is the position of the next event
+	history []Event
+	next    int
+	full    bool
+
+	subscribers map[*subscriber]bool
+}
+
+type subscriber struct {
+	topics map[string]bool
+	ch     chan Event
+	closed bool
+}
+
+var _ Broker = (*Memory)(nil)
+
+// NewMemory returns a Memory broker replaying up to historySize events
+func NewMemory(historySize int) *Memory {
+	if historySize <= 0 {
+		historySize = DefaultHistorySize
+	}
+	return &Memory{
+		// IDs start from the current time so that they keep increasing across restarts, and events
+		// that were lost with the previous process are reported as missed
+		lastID:      uint64(time.Now().UnixMicro()),
+		history:     make([]Event, historySize),
+		subscribers: map[*subscriber]bool{},
+	}
+}
+
+func (m *Memory) Publish(topics []string, eventType string, data any) error {
+	raw, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.closed {
+		return ErrClosed
+	}
+
+	m.lastID++
+	e := Event{ID: m.lastID, Topics: topics, Type: eventType, Data: raw}
+	m.history[m.next] = e
+	m.next = (m.next + 1) % len(m.history)
+	if m.next == 0 {
+		m.full = true
+	}
+
+	for sub := range m.subscribers {
+		if !sub.matches(e) {
+			continue
+		}
+		select {
+		case sub.ch <- e:
+		default:
+			// Slow subscriber: it resumes from its last event once it reconnects
+			m.remove(sub)
+		}
+	}
+	return nil
+}
+
+func (m *Memory) Subscribe(topics []string, lastEventID uint64) *Subscription {
+	sub := &subscriber{
+		topics: map[string]bool{},
+		ch:     make(chan Event, subscriberBuffer),
+	}
+	for _, t := range topics {
+		sub.topics[t] = true
+	}
+	subscription := &Subscription{
+		C: sub.ch,
+		close: func() {
+			m.mu.Lock()
+			defer m.mu.Unlock()
+			m.remove(sub)
+		},
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.closed {
+		close(sub.ch)
+		return subscription
+	}
+
+	if lastEventID != 0 {
+		replay, missed := m.since(lastEventID, sub)
+		subscription.Missed = missed
+		if len(replay) > cap(sub.ch) {
+			// Too far behind to be replayed
+			replay = nil
+			subscription.Missed = true
+		}
+		for _, e := range replay {
+			sub.ch <- e
+		}
+	}
+
+	m.subscribers[sub] = true
+	return subscription
+}
+
+// since returns the events for sub published after id, and whether some events after id are gone
+// from the history. m.mu must be held.
+func (m *Memory) since(id uint64, sub *subscriber) ([]Event, bool) {
+	if id >= m.lastID {
+		return nil, id > m.lastID
+	}
+
+	oldest := 0
+	count := m.next
+	if m.full {
+		oldest = m.next
+		count = len(m.history)
+	}
+	if count == 0 || m.history[oldest].ID > id+1 {
+		// The event following id was overwritten, or published by another process
+		return nil, true
+	}
+
+	var events []Event
+	for i := 0; i < count; i++ {
+		e := m.history[(oldest+i)%len(m.history)]
+		if e.ID > id && sub.matches(e) {
+			events = append(events, e)
+		}
+	}
+	return events, false
+}
+
+func (sub *subscriber) matches(e Event) bool {
+	for _, t := range e.Topics {
+		if sub.topics[t] {
+			return true
+		}
+	}
+	return false
+}
+
+// remove unregisters sub and closes its channel. m.mu must be held.
+func (m *Memory) remove(sub *subscriber) {
+	if sub.closed {
+		return
+	}
+	sub.closed = true
+	delete(m.subscribers, sub)
+	close(sub.ch)
+}
+
+// Close ends all the subscriptions
+func (m *Memory) Close() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.closed = true
+	for sub := range m.subscribers {
+		m.remove(sub)
+	}
+	return nil
+}
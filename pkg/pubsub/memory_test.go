@@ -0,0 +1,182 @@
+package pubsub
+
+import "testing"
+
+// receive returns the events buffered for s, without waiting for more
+func receive(s *Subscription) []Event {
+	var events []Event
+	for {
+		select {
+		case e, ok := <-s.C:
+			if !ok {
+				return events
+			}
+			events = append(events, e)
+		default:
+			return events
+		}
+	}
+}
+
+func publish(t *testing.T, b Broker, n int, topics ...string) []Event {
+	t.Helper()
+	sub := b.Subscribe(topics, 0)
+	defer sub.Close()
+	for i := 0; i < n; i++ {
+		err := b.Publish(topics, "test", i)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	events := receive(sub)
+	if len(events) != n {
+		t.Fatalf("published %d events, received %d", n, len(events))
+	}
+	return events
+}
+
+func TestMemoryResume(t *testing.T) {
+	m := NewMemory(16)
+	defer m.Close()
+	events := publish(t, m, 10, "a")
+	for i := 1; i < len(events); i++ {
+		if events[i].ID <= events[i-1].ID {
+			t.Fatalf("event IDs don't increase: %d then %d", events[i-1].ID, events[i].ID)
+		}
+	}
+
+	for _, n := range []int{0, 3, 9} {
+		sub := m.Subscribe([]string{"a"}, events[n].ID)
+		got := receive(sub)
+		sub.Close()
+		if sub.Missed {
+			t.Errorf("resuming after event %d: events reported as missed", n)
+		}
+		if len(got) != len(events)-n-1 {
+			t.Errorf("resuming after event %d: got %d events, want %d", n, len(got), len(events)-n-1)
+			continue
+		}
+		for i, e := range got {
+			if e.ID != events[n+1+i].ID {
+				t.Errorf("resuming after event %d: got event %d, want %d", n, e.ID, events[n+1+i].ID)
+			}
+		}
+	}
+
+	// Events of other topics aren't replayed
+	sub := m.Subscribe([]string{"b"}, events[0].ID)
+	if got := receive(sub); len(got) != 0 || sub.Missed {
+		t.Errorf("got %d events of another topic, missed: %v", len(got), sub.Missed)
+	}
+	sub.Close()
+}
+
+func TestMemoryResumeAfterOverwrite(t *testing.T) {
+	m := NewMemory(4)
+	defer m.Close()
+	events := publish(t, m, 10, "a")
+
+	// The first 6 events were overwritten
+	sub := m.Subscribe([]string{"a"}, events[0].ID)
+	if !sub.Missed {
+		t.Error("overwritten events aren't reported as missed")
+	}
+	if got := receive(sub); len(got) != 0 {
+		t.Errorf("got %d events after overwritten ones", len(got))
+	}
+	sub.Close()
+
+	// The event following events[5] is the oldest one of the history
+	sub = m.Subscribe([]string{"a"}, events[5].ID)
+	if sub.Missed {
+		t.Error("events still in the history reported as missed")
+	}
+	if got := receive(sub); len(got) != 4 {
+		t.Errorf("got %d events, want 4", len(got))
+	}
+	sub.Close()
+
+	// IDs of another process
+	sub = m.Subscribe([]string{"a"}, events[9].ID+100)
+	if !sub.Missed {
+		t.Error("an unknown event ID isn't reported as missed")
+	}
+	sub.Close()
+}
+
+func TestMemorySeveralTopics(t *testing.T) {
+	m := NewMemory(0)
+	defer m.Close()
+	sub := m.Subscribe([]string{"a", "b"}, 0)
+	defer sub.Close()
+
+	for _, topics := range [][]string{{"a", "b"}, {"b", "a", "c"}, {"c"}, {"a"}} {
+		err := m.Publish(topics, "test", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Events published on both topics are received once, and those of other topics not at all
+	got := receive(sub)
+	if len(got) != 3 {
+		t.Fatalf("got %d events, want 3", len(got))
+	}
+
+	// Replays don't duplicate them either
+	resumed := m.Subscribe([]string{"a", "b"}, got[0].ID)
+	defer resumed.Close()
+	if replayed := receive(resumed); len(replayed) != 2 {
+		t.Errorf("got %d replayed events, want 2", len(replayed))
+	}
+}
+
+func TestMemorySlowSubscriber(t *testing.T) {
+	m := NewMemory(0)
+	defer m.Close()
+	slow := m.Subscribe([]string{"a"}, 0)
+	fast := m.Subscribe([]string{"a"}, 0)
+	defer fast.Close()
+
+	var last uint64
+	for i := 0; i < subscriberBuffer+1; i++ {
+		err := m.Publish([]string{"a"}, "test", i)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, e := range receive(fast) {
+			last = e.ID
+		}
+	}
+
+	var received int
+	for range slow.C {
+		received++
+	}
+	if received != subscriberBuffer {
+		t.Errorf("slow subscriber received %d events before its channel was closed, want %d", received, subscriberBuffer)
+	}
+	// Closing it again is harmless
+	slow.Close()
+
+	if err := m.Publish([]string{"a"}, "test", nil); err != nil {
+		t.Fatal(err)
+	}
+	if got := receive(fast); len(got) != 1 || got[0].ID != last+1 {
+		t.Errorf("fast subscriber got %v after the slow one was dropped", got)
+	}
+}
+
+func TestMemoryClose(t *testing.T) {
+	m := NewMemory(0)
+	sub := m.Subscribe([]string{"a"}, 0)
+	m.Close()
+	if _, ok := <-sub.C; ok {
+		t.Error("subscription still open after the broker was closed")
+	}
+	if err := m.Publish([]string{"a"}, "test", nil); err != ErrClosed {
+		t.Errorf("publishing to a closed broker returned %v", err)
+	}
+	if _, ok := <-m.Subscribe([]string{"a"}, 0).C; ok {
+		t.Error("subscription to a closed broker is open")
+	}
+}
@@ -0,0 +1,51 @@
+// Package pubsub distributes events to the clients connected to the streaming API.
+//
+// Events are published on topics, such as the notifications of a user, and carry increasing IDs so that
+// clients can resume a stream after a disconnection. The Broker interface is implemented in memory, which
+// is enough for a single instance; several instances need a shared implementation, e.g. on top of
+// PostgreSQL LISTEN/NOTIFY.
+package pubsub
+
+import (
+	"encoding/json"
+	"errors"
+)
+
+// ErrClosed is returned when publishing to a closed broker
+var ErrClosed = errors.New("broker is closed")
+
+// Event is a message published on a topic
+type Event struct {
+	// ID increases with each event published on the broker
+	ID uint64
+	// Topics are the topics the event was published on
+	Topics []string
+	Type   string
+	Data   json.RawMessage
+}
+
+type Broker interface {
+	// Publish sends an event of type eventType, carrying data encoded as JSON, to the subscribers of any of
+	// topics. Subscribers of several of them receive the event once.
+	Publish(topics []string, eventType string, data any) error
+	// Subscribe starts receiving the events published on topics. When lastEventID isn't zero, the events
+	// published after it are replayed first.
+	Subscribe(topics []string, lastEventID uint64) *Subscription
+	Close() error
+}
+
+// Subscription receives the events of some topics
+type Subscription struct {
+	// C receives the events. It is closed when the subscription ends, either because it was closed or
+	// because the subscriber didn't keep up: it can then subscribe again from the last event it received.
+	C <-chan Event
+	// Missed is true when some of the events following the last event ID couldn't be replayed
+	Missed bool
+
+	close func()
+}
+
+// Close ends the subscription
+func (s *Subscription) Close() {
+	s.close()
+}
@@ -0,0 +1,144 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/denysvitali/social/backend/pkg/models/api"
+	pg_model "github.com/denysvitali/social/backend/pkg/models/postgres"
+	"github.com/denysvitali/social/backend/pkg/safehttp"
+	"github.com/denysvitali/social/backend/pkg/webpush"
+	"net/http"
+	"time"
+)
+
+const pushWorkers = 2
+const pushQueueSize = 1024
+const pushTimeout = 10 * time.Second
+
+// pushTTL is how long push services keep the messages of offline devices: older notifications aren't
+// worth showing, users see them in the app
+const pushTTL = 24 * time.Hour
+
+type WebPushConfig struct {
+	// VAPIDPrivateKey is the private key identifying the server to push services, as printed by
+	// --generate-vapid-key. Web Push is disabled when empty.
+	VAPIDPrivateKey string
+	// Subject is a mailto: or https: URL that push services can use to contact the operator
+	Subject string
+	// AllowPrivateEndpoints allows push services on private addresses and over plain HTTP, such as
+	// cmd/fake-push-service
+	AllowPrivateEndpoints bool
+}
+
+type pushJob struct {
+	UserID  uint64
+	Payload []byte
+}
+
+func setupWebPush(config WebPushConfig) (*webpush.Client, error) {
+	if config.VAPIDPrivateKey == "" {
+		return nil, nil
+	}
+	key, err := webpush.ParseVAPIDKey(config.VAPIDPrivateKey)
+	if err != nil {
+		return nil, err
+	}
+
+	client := safehttp.NewClient(pushTimeout)
+	if config.AllowPrivateEndpoints {
+		client = &http.Client{Timeout: pushTimeout}
+	}
+	return &webpush.Client{
+		HTTP:    client,
+		Key:     key,
+		Subject: config.Subject,
+	}, nil
+}
+
+// pushNotification queues a push message about a notification for the devices of userId. Like
+// notifications, push messages are best-effort: they are dropped when the queue is full.
+func (s *Server) pushNotification(userId uint64, event api.NotificationEvent) {
+	if s.push == nil {
+		return
+	}
+
+	message := api.PushMessage{
+		ID:     event.ID,
+		Type:   event.Type,
+		Post:   event.Post,
+		Report: event.Report,
+	}
+	if event.Actor != 0 {
+		var actor pg_model.User
+		err := s.pgDB.Select("id", "username", "display_name", "verified").Take(&actor, "id = ?", event.Actor).Error
+		if err != nil {
+			s.logger.Warnf("unable to get actor %d of push message: %v", event.Actor, err)
+			return
+		}
+		apiActor := getApiUser(actor)
+		message.Actor = &apiActor
+	}
+	payload, err := json.Marshal(message)
+	if err != nil {
+		s.logger.Warnf("unable to encode push message: %v", err)
+		return
+	}
+
+	select {
+	case s.pushQueue <- pushJob{UserID: userId, Payload: payload}:
+	default:
+		s.logger.Debugf("push queue full, dropping push message to user %d", userId)
+	}
+}
+
+func (s *Server) runPushWorker() {
+	for job := range s.pushQueue {
+		s.deliverPush(job)
+	}
+}
+
+// deliverPush sends a push message to all the devices of a user, removing the subscriptions that expired
+func (s *Server) deliverPush(job pushJob) {
+	var subscriptions []pg_model.PushSubscription
+	err := s.pgDB.Where("user_id = ?", job.UserID).Find(&subscriptions).Error
+	if err != nil {
+		s.logger.Warnf("unable to get push subscriptions of user %d: %v", job.UserID, err)
+		return
+	}
+
+	for _, sub := range subscriptions {
+		err = s.sendPush(sub, job.Payload)
+		if errors.Is(err, webpush.ErrGone) {
+			s.logger.Debugf("removing expired push subscription %d of user %d", sub.ID, job.UserID)
+			err = s.pgDB.Delete(&pg_model.PushSubscription{}, "id = ?", sub.ID).Error
+			if err != nil {
+				s.logger.Warnf("unable to remove push subscription %d: %v", sub.ID, err)
+			}
+			continue
+		}
+		if err != nil {
+			s.logger.Warnf("unable to push to subscription %d of user %d: %v", sub.ID, job.UserID, err)
+		}
+	}
+}
+
+func (s *Server) sendPush(sub pg_model.PushSubscription, payload []byte) error {
+	ctx, cancel := context.WithTimeout(context.Background(), pushTimeout)
+	defer cancel()
+
+	err := s.push.Send(ctx, webpush.Subscription{
+		Endpoint: sub.Endpoint,
+		P256dh:   sub.P256dh,
+		Auth:     sub.Auth,
+	}, webpush.Message{
+		Payload: payload,
+		TTL:     pushTTL,
+		Urgency: webpush.UrgencyNormal,
+	})
+	if err != nil {
+		return fmt.Errorf("unable to send push message: %w", err)
+	}
+	return nil
+}
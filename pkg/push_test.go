@@ -0,0 +1,81 @@
+package server
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"database/sql/driver"
+	"encoding/base64"
+	"github.com/denysvitali/social/backend/pkg/webpush"
+	"github.com/sirupsen/logrus"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDeliverPushRemovesGoneSubscriptions(t *testing.T) {
+	delivered := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/gone" {
+			w.WriteHeader(http.StatusGone)
+			return
+		}
+		delivered++
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer srv.Close()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p256dh := base64.RawURLEncoding.EncodeToString(elliptic.Marshal(key.Curve, key.X, key.Y))
+	auth := base64.RawURLEncoding.EncodeToString(make([]byte, 16))
+
+	db, fake := newFakeDB(t, func(query string, args []driver.Value) (fakeResult, error) {
+		if !strings.Contains(query, `FROM "push_subscriptions"`) {
+			t.Errorf("unexpected query %s", query)
+			return fakeResult{}, nil
+		}
+		if len(args) != 1 || args[0] != int64(7) {
+			t.Errorf("got subscriptions of %v, want user 7", args)
+		}
+		now := time.Now()
+		return fakeResult{
+			columns: []string{"id", "user_id", "endpoint", "p256dh", "auth", "user_agent", "created_at"},
+			rows: [][]driver.Value{
+				{int64(1), int64(7), srv.URL + "/gone", p256dh, auth, "", now},
+				{int64(2), int64(7), srv.URL + "/active", p256dh, auth, "", now},
+			},
+		}, nil
+	})
+
+	vapidKey, err := webpush.GenerateVAPIDKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	log := logrus.New()
+	log.SetOutput(io.Discard)
+	s := &Server{
+		logger: log,
+		pgDB:   db,
+		push:   &webpush.Client{HTTP: srv.Client(), Key: vapidKey, Subject: "mailto:admin@example.com"},
+	}
+
+	s.deliverPush(pushJob{UserID: 7, Payload: []byte(`{"type":"like"}`)})
+
+	if delivered != 1 {
+		t.Errorf("delivered %d messages, want 1", delivered)
+	}
+	execs := fake.execs()
+	if len(execs) != 1 {
+		t.Fatalf("got statements %v, want a single DELETE", execs)
+	}
+	if !strings.HasPrefix(execs[0].query, `DELETE FROM "push_subscriptions"`) ||
+		len(execs[0].args) != 1 || execs[0].args[0] != int64(1) {
+		t.Errorf("got statement %v, want the removal of subscription 1", execs[0])
+	}
+}
@@ -0,0 +1,9 @@
+package v1requests
+
+type CreateBookmark struct {
+	CollectionID *uint64 `json:"collectionId"`
+}
+
+type CreateBookmarkCollection struct {
+	Name string `json:"name"`
+}
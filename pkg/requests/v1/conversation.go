@@ -0,0 +1,20 @@
+package v1requests
+
+type CreateConversation struct {
+	// Members are the IDs of the other members. A 1:1 conversation that already exists is returned instead
+	// of being created.
+	Members []uint64 `json:"members"`
+	// Name is the optional name of a group
+	Name string `json:"name"`
+	// Content is the first message, optional
+	Content string `json:"content"`
+}
+
+type SendMessage struct {
+	Content string `json:"content"`
+}
+
+type MarkConversationRead struct {
+	// Message is the ULID of the most recent message read
+	Message string `json:"message"`
+}
@@ -0,0 +1,19 @@
+package v1requests
+
+type CreatePost struct {
+	Content string `json:"content"`
+	// ParentPostID is the ULID of the post this one replies to
+	ParentPostID *string `json:"parentPostId"`
+	// Language is the ISO 639-1 code of the language of Content, defaults to "en"
+	Language string `json:"language"`
+	// Visibility is one of "public" (default), "unlisted" or "followers"
+	Visibility string `json:"visibility"`
+	// Media are the attachments of the post, previously uploaded to /media
+	Media []PostMedia `json:"media"`
+}
+
+type PostMedia struct {
+	// ID is the ULID returned by the upload
+	ID      string  `json:"id"`
+	AltText *string `json:"altText"`
+}
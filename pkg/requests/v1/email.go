@@ -0,0 +1,11 @@
+package v1requests
+
+// UpdateEmailSettings updates the fields that are set
+type UpdateEmailSettings struct {
+	// Email is the address emails are sent to, an empty string disables emails. A new address is only used
+	// once confirmed with the link emailed to it.
+	Email         *string `json:"email"`
+	Notifications *bool   `json:"notifications"`
+	// Digest is one of "off", "daily" or "weekly"
+	Digest *string `json:"digest"`
+}
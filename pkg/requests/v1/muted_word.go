@@ -0,0 +1,12 @@
+package v1requests
+
+type CreateMutedWord struct {
+	// Phrase is a #hashtag, or one or more words
+	Phrase string `json:"phrase"`
+	// Context is "home" or "everywhere" (the default)
+	Context string `json:"context"`
+	// Action is "hide" (the default) or "warn"
+	Action string `json:"action"`
+	// ExpiresIn is the duration of the rule in seconds, 0 for a permanent rule
+	ExpiresIn int64 `json:"expiresIn"`
+}
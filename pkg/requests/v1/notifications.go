@@ -0,0 +1,7 @@
+package v1requests
+
+type MarkNotificationsRead struct {
+	// Cursor is the ID of the most recent notification seen, the notifications up to it are marked as read.
+	// When empty, all the notifications are marked as read.
+	Cursor string `json:"cursor"`
+}
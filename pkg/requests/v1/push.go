@@ -0,0 +1,10 @@
+package v1requests
+
+// CreatePushSubscription is the JSON serialization of a PushSubscription of the browser
+type CreatePushSubscription struct {
+	Endpoint string `json:"endpoint"`
+	Keys     struct {
+		P256dh string `json:"p256dh"`
+		Auth   string `json:"auth"`
+	} `json:"keys"`
+}
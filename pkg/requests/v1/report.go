@@ -0,0 +1,17 @@
+package v1requests
+
+type CreateReport struct {
+	// Post is the ULID of the reported post. Either Post or User must be set.
+	Post string `json:"post"`
+	// User is the ID of the reported user
+	User    uint64 `json:"user"`
+	Reason  string `json:"reason"`
+	Comment string `json:"comment"`
+}
+
+type ResolveReport struct {
+	// Action is one of dismiss, delete_post, suspend_user, warn
+	Action string `json:"action"`
+	// Message is shown to the warned user
+	Message string `json:"message"`
+}
@@ -0,0 +1,10 @@
+package v1requests
+
+type MergeTag struct {
+	// Into is the text of the tag to merge into
+	Into string `json:"into"`
+}
+
+type CreateTagAlias struct {
+	Alias string `json:"alias"`
+}
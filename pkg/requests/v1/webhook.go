@@ -0,0 +1,17 @@
+package v1requests
+
+type CreateWebhook struct {
+	URL         string   `json:"url"`
+	Description string   `json:"description"`
+	Events      []string `json:"events"`
+	// Secret is the key of the HMAC signature of the deliveries, a random one is generated when empty
+	Secret string `json:"secret"`
+}
+
+// UpdateWebhook updates the fields that are set
+type UpdateWebhook struct {
+	URL         *string   `json:"url"`
+	Description *string   `json:"description"`
+	Events      *[]string `json:"events"`
+	Active      *bool     `json:"active"`
+}
@@ -0,0 +1,106 @@
+package safehttp
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestIsPublicIP(t *testing.T) {
+	for _, v := range []struct {
+		ip     string
+		public bool
+	}{
+		{"93.184.216.34", true},
+		{"2606:2800:220:1:248:1893:25c8:1946", true},
+		{"127.0.0.1", false},
+		{"::1", false},
+		{"10.1.2.3", false},
+		{"172.16.0.1", false},
+		{"192.168.1.1", false},
+		{"169.254.169.254", false},
+		{"fe80::1", false},
+		{"fc00::1", false},
+		{"0.0.0.0", false},
+		{"100.64.0.1", false},
+		{"224.0.0.1", false},
+		{"64:ff9b::a00:1", false},
+		{"::ffff:127.0.0.1", false},
+	} {
+		if got := IsPublicIP(net.ParseIP(v.ip)); got != v.public {
+			t.Errorf("IsPublicIP(%s) = %v, want %v", v.ip, got, v.public)
+		}
+	}
+}
+
+func TestClientRejectsPrivateAddresses(t *testing.T) {
+	requested := false
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requested = true
+	}))
+	defer srv.Close()
+
+	client := NewClient(time.Second)
+	_, err := client.Get(srv.URL)
+	if !errors.Is(err, ErrForbiddenAddress) {
+		t.Errorf("got error %v fetching a loopback server, want ErrForbiddenAddress", err)
+	}
+	if requested {
+		t.Errorf("the loopback server was reached")
+	}
+}
+
+// TestClientRejectsRedirectsToPrivateAddresses checks that the address is verified on each connection,
+// not only for the first URL
+func TestClientRejectsRedirectsToPrivateAddresses(t *testing.T) {
+	requested := false
+	private := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requested = true
+	}))
+	defer private.Close()
+	redirector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, private.URL, http.StatusFound)
+	}))
+	defer redirector.Close()
+
+	// The first hop goes through an unrestricted transport, as if the redirector were public
+	client := NewClient(time.Second)
+	safeTransport := client.Transport
+	client.Transport = roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		if req.URL.Host == redirector.Listener.Addr().String() {
+			return http.DefaultTransport.RoundTrip(req)
+		}
+		return safeTransport.RoundTrip(req)
+	})
+
+	_, err := client.Get(redirector.URL)
+	if !errors.Is(err, ErrForbiddenAddress) {
+		t.Errorf("got error %v following a redirect to a loopback server, want ErrForbiddenAddress", err)
+	}
+	if requested {
+		t.Errorf("the loopback server was reached")
+	}
+}
+
+func TestClientRejectsNonHTTPRedirects(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "file:///etc/passwd", http.StatusFound)
+	}))
+	defer srv.Close()
+
+	client := NewClient(time.Second)
+	client.Transport = http.DefaultTransport
+	_, err := client.Get(srv.URL)
+	if err == nil {
+		t.Errorf("expected an error following a redirect to a file URL")
+	}
+}
+
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
@@ -0,0 +1,57 @@
+package server
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+const defaultSearchLanguage = "en"
+
+// searchLanguages maps the languages posts can be written in to their PostgreSQL text search configuration
+var searchLanguages = map[string]string{
+	"da": "danish",
+	"de": "german",
+	"en": "english",
+	"es": "spanish",
+	"fi": "finnish",
+	"fr": "french",
+	"hu": "hungarian",
+	"it": "italian",
+	"nl": "dutch",
+	"no": "norwegian",
+	"pt": "portuguese",
+	"ro": "romanian",
+	"ru": "russian",
+	"sv": "swedish",
+	"tr": "turkish",
+}
+
+// searchHalfLife is the age at which the relevance of a post is halved, when blending relevance with recency
+const searchHalfLife = 48 * 60 * 60 // seconds
+
+// searchConfig returns the text search configuration of language, falling back to "simple" (no stemming)
+func searchConfig(language string) string {
+	if cfg, ok := searchLanguages[language]; ok {
+		return cfg
+	}
+	return "simple"
+}
+
+// searchVectorExpression is the expression of the generated posts.search_vector column.
+// The configuration is picked with a CASE over constants, as casting text to regconfig isn't immutable.
+func searchVectorExpression() string {
+	var codes []string
+	for code := range searchLanguages {
+		codes = append(codes, code)
+	}
+	sort.Strings(codes)
+
+	var b strings.Builder
+	b.WriteString("to_tsvector(CASE language")
+	for _, code := range codes {
+		b.WriteString(fmt.Sprintf(" WHEN '%s' THEN '%s'::regconfig", code, searchLanguages[code]))
+	}
+	b.WriteString(" ELSE 'simple'::regconfig END, content)")
+	return b.String()
+}
@@ -0,0 +1,106 @@
+package searchquery
+
+import (
+	"fmt"
+	"github.com/denysvitali/social/backend/pkg/entities"
+	"strings"
+)
+
+// Compiled is a query translated to SQL over the posts table
+type Compiled struct {
+	// Text is the full-text part of the query, in the syntax of websearch_to_tsquery.
+	// It is empty when the query only has filters.
+	Text string
+	// Where is a condition over posts, with ? placeholders for Args. It is empty when the query has no filters.
+	Where string
+	Args  []any
+}
+
+// Conditions of the filters. Values are only ever passed as arguments.
+var filterConditions = map[Operator]string{
+	From: `posts.author_id IN (SELECT users.id FROM users WHERE lower(users.username) = lower(?))`,
+	To: `posts.parent_post_id IN (
+		SELECT parents.id FROM posts parents
+		INNER JOIN users ON users.id = parents.author_id
+		WHERE lower(users.username) = lower(?))`,
+	Mentions: `EXISTS (
+		SELECT 1 FROM user_mention
+		INNER JOIN users ON users.id = user_mention.user_id
+		WHERE user_mention.post_id = posts.id AND lower(users.username) = lower(?))`,
+	Tag: `EXISTS (
+		SELECT 1 FROM post_tags
+		WHERE post_tags.post_id = posts.id AND post_tags.tag_id IN (
+			SELECT tags.id FROM tags WHERE tags.canonical = ?
+			UNION SELECT tag_aliases.tag_id FROM tag_aliases WHERE tag_aliases.canonical = ?))`,
+	Since:    `posts.created_at >= ?`,
+	Until:    `posts.created_at < ?`,
+	MinLikes: `posts.likes >= ?`,
+}
+
+// Compile translates q to SQL
+func Compile(q Query) (Compiled, error) {
+	var text []string
+	var conditions []string
+	var compiled Compiled
+
+	for _, c := range q.Clauses {
+		switch c := c.(type) {
+		case Term:
+			text = append(text, websearchTerm(c))
+		case Filter:
+			cond, args, err := compileFilter(c)
+			if err != nil {
+				return Compiled{}, err
+			}
+			if c.Negated {
+				// Conditions over nullable columns, e.g. to:, are unknown rather than false
+				cond = "NOT COALESCE((" + cond + "), false)"
+			}
+			conditions = append(conditions, cond)
+			compiled.Args = append(compiled.Args, args...)
+		}
+	}
+
+	compiled.Text = strings.Join(text, " ")
+	if len(conditions) > 0 {
+		compiled.Where = "(" + strings.Join(conditions, ") AND (") + ")"
+	}
+	return compiled, nil
+}
+
+func compileFilter(f Filter) (string, []any, error) {
+	switch f.Operator {
+	case From, To, Mentions:
+		return filterConditions[f.Operator], []any{f.Value}, nil
+	case Tag:
+		canonical := entities.CanonicalTag(f.Value)
+		return filterConditions[Tag], []any{canonical, canonical}, nil
+	case Since:
+		return filterConditions[Since], []any{f.Date}, nil
+	case Until:
+		// until: includes the whole day
+		return filterConditions[Until], []any{f.Date.AddDate(0, 0, 1)}, nil
+	case MinLikes:
+		return filterConditions[MinLikes], []any{f.Number}, nil
+	case Has:
+		if f.Value == HasLinks {
+			return `posts.content ~ ?`, []any{`https?://\S`}, nil
+		}
+		return `EXISTS (SELECT 1 FROM media WHERE media.post_id = posts.id)`, nil, nil
+	case Is:
+		return `posts.parent_post_id IS NOT NULL`, nil, nil
+	}
+	return "", nil, &Error{Pos: f.Pos, Msg: fmt.Sprintf("unsupported operator %s:", f.Operator)}
+}
+
+// websearchTerm writes t in the syntax of websearch_to_tsquery
+func websearchTerm(t Term) string {
+	s := strings.ReplaceAll(t.Text, `"`, " ")
+	if t.Phrase {
+		s = `"` + s + `"`
+	}
+	if t.Negated {
+		s = "-" + s
+	}
+	return s
+}
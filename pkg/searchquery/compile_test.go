@@ -0,0 +1,105 @@
+package searchquery
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCompile(t *testing.T) {
+	for _, tc := range []struct {
+		q     string
+		text  string
+		where string
+		args  []any
+	}{
+		{q: "cats dogs", text: "cats dogs"},
+		{q: `-dogs "black cats"`, text: `-dogs "black cats"`},
+		{
+			q:     "cats from:jdoe",
+			text:  "cats",
+			where: "(" + filterConditions[From] + ")",
+			args:  []any{"jdoe"},
+		},
+		{
+			q:     "-to:jdoe",
+			where: "(NOT COALESCE((" + filterConditions[To] + "), false))",
+			args:  []any{"jdoe"},
+		},
+		{
+			// Tags are matched by their canonical form, and through their aliases
+			q:     "#CATS",
+			where: "(" + filterConditions[Tag] + ")",
+			args:  []any{"cats", "cats"},
+		},
+		{
+			q:     "since:2026-01-01 until:2026-01-31 min_likes:5",
+			where: "(" + filterConditions[Since] + ") AND (" + filterConditions[Until] + ") AND (" + filterConditions[MinLikes] + ")",
+			args: []any{
+				time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC),
+				// until: includes the whole day
+				time.Date(2026, 2, 1, 0, 0, 0, 0, time.UTC),
+				int64(5),
+			},
+		},
+		{
+			q:     "has:media -is:reply",
+			where: "(EXISTS (SELECT 1 FROM media WHERE media.post_id = posts.id)) AND (NOT COALESCE((posts.parent_post_id IS NOT NULL), false))",
+		},
+		{
+			q:     "has:links",
+			where: "(posts.content ~ ?)",
+			args:  []any{`https?://\S`},
+		},
+	} {
+		q, err := Parse(tc.q)
+		if err != nil {
+			t.Fatalf("Parse(%q) returned error %v", tc.q, err)
+		}
+		got, err := Compile(q)
+		if err != nil {
+			t.Errorf("Compile(%q) returned error %v", tc.q, err)
+			continue
+		}
+		if got.Text != tc.text {
+			t.Errorf("Compile(%q) text = %q, want %q", tc.q, got.Text, tc.text)
+		}
+		if got.Where != tc.where {
+			t.Errorf("Compile(%q) where = %q, want %q", tc.q, got.Where, tc.where)
+		}
+		if !reflect.DeepEqual(got.Args, tc.args) {
+			t.Errorf("Compile(%q) args = %#v, want %#v", tc.q, got.Args, tc.args)
+		}
+	}
+}
+
+// TestCompileParameterizes checks that values never end up in the SQL, whatever they contain
+func TestCompileParameterizes(t *testing.T) {
+	const evil = `x');DROP_TABLE_posts;--`
+	q, err := Parse("from:" + evil + " to:" + evil + " mentions:" + evil + " #" + evil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := Compile(q)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(got.Where, "DROP") {
+		t.Errorf("value found in SQL: %s", got.Where)
+	}
+	if n := strings.Count(got.Where, "?"); n != len(got.Args) {
+		t.Errorf("%d placeholders for %d args", n, len(got.Args))
+	}
+}
+
+// TestCompileQuotes checks that quotes within words can't break out of websearch_to_tsquery phrases
+func TestCompileQuotes(t *testing.T) {
+	got, err := Compile(Query{Clauses: []Clause{Term{Text: `a"b`, Phrase: true}, Term{Text: `c"d`}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Text != `"a b" c d` {
+		t.Errorf("got text %q", got.Text)
+	}
+}
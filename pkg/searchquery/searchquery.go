@@ -0,0 +1,228 @@
+// Package searchquery parses the query language of post search.
+//
+// Besides plain words, "quoted phrases" and -excluded words, a query can hold operators
+// restricting the results:
+//
+//	from:jdoe         posts written by @jdoe
+//	to:jdoe           replies to posts of @jdoe
+//	mentions:jdoe     posts mentioning @jdoe
+//	#tag              posts tagged with #tag
+//	since:2026-01-01  posts written on or after a day (UTC)
+//	until:2026-01-31  posts written on or before a day (UTC)
+//	min_likes:10      posts with at least 10 likes
+//	has:media         posts with attachments
+//	has:links         posts with links
+//	is:reply          replies
+//
+// Any operator can be negated with a leading -, e.g. -is:reply.
+package searchquery
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+// MaxClauses is the maximum number of words, phrases and operators of a query
+const MaxClauses = 32
+
+// DateLayout is the format of the values of the since: and until: operators
+const DateLayout = "2006-01-02"
+
+// Operator is the name of a search operator
+type Operator string
+
+const (
+	From     Operator = "from"
+	To       Operator = "to"
+	Mentions Operator = "mentions"
+	Tag      Operator = "tag"
+	Since    Operator = "since"
+	Until    Operator = "until"
+	MinLikes Operator = "min_likes"
+	Has      Operator = "has"
+	Is       Operator = "is"
+)
+
+// Values of the has: and is: operators
+const (
+	HasMedia = "media"
+	HasLinks = "links"
+	IsReply  = "reply"
+)
+
+// Query is the AST of a search query: a conjunction of clauses
+type Query struct {
+	Clauses []Clause
+}
+
+// Clause is either a Term or a Filter
+type Clause interface {
+	clause()
+}
+
+// Term is a word or a phrase to be found in the content of posts
+type Term struct {
+	Text    string
+	Phrase  bool
+	Negated bool
+}
+
+// Filter is an operator and its value
+type Filter struct {
+	Operator Operator
+	// Value is the validated value of the operator: usernames are stripped of their @ and tags of their #
+	Value   string
+	Negated bool
+
+	// Date is the day of since: and until:
+	Date time.Time
+	// Number is the value of min_likes:
+	Number int64
+
+	// Pos is the position of the clause in the query, to report errors
+	Pos int
+}
+
+func (Term) clause()   {}
+func (Filter) clause() {}
+
+// Error is returned for malformed queries, its message is meant to be shown to users
+type Error struct {
+	// Pos is the 1-based position, in characters, of the faulty clause
+	Pos int
+	Msg string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("invalid search query at position %d: %s", e.Pos, e.Msg)
+}
+
+var operators = map[string]Operator{
+	"from":      From,
+	"to":        To,
+	"mentions":  Mentions,
+	"since":     Since,
+	"until":     Until,
+	"min_likes": MinLikes,
+	"has":       Has,
+	"is":        Is,
+}
+
+// Parse parses q. Words followed by a colon that aren't operators, such as links, are
+// kept as plain words.
+func Parse(q string) (Query, error) {
+	var query Query
+
+	i := 0
+	for i < len(q) {
+		r, size := utf8.DecodeRuneInString(q[i:])
+		if unicode.IsSpace(r) {
+			i += size
+			continue
+		}
+
+		pos := utf8.RuneCountInString(q[:i]) + 1
+		negated := false
+		if r == '-' {
+			negated = true
+			i += size
+			if i == len(q) {
+				break
+			}
+			r, size = utf8.DecodeRuneInString(q[i:])
+			if unicode.IsSpace(r) {
+				// A lone dash, most likely punctuation
+				continue
+			}
+		}
+
+		var c Clause
+		if r == '"' {
+			end := strings.IndexByte(q[i+1:], '"')
+			if end < 0 {
+				return Query{}, &Error{Pos: pos, Msg: "unterminated quote"}
+			}
+			phrase := strings.TrimSpace(q[i+1 : i+1+end])
+			i += end + 2
+			if phrase == "" {
+				continue
+			}
+			c = Term{Text: phrase, Phrase: true, Negated: negated}
+		} else {
+			end := strings.IndexFunc(q[i:], unicode.IsSpace)
+			if end < 0 {
+				end = len(q) - i
+			}
+			word := q[i : i+end]
+			i += end
+
+			var err error
+			c, err = parseWord(word, negated, pos)
+			if err != nil {
+				return Query{}, err
+			}
+		}
+
+		if len(query.Clauses) == MaxClauses {
+			return Query{}, &Error{Pos: pos, Msg: fmt.Sprintf("a query can't have more than %d words and operators", MaxClauses)}
+		}
+		query.Clauses = append(query.Clauses, c)
+	}
+
+	return query, nil
+}
+
+func parseWord(word string, negated bool, pos int) (Clause, error) {
+	if strings.HasPrefix(word, "#") {
+		tag := word[1:]
+		if tag == "" {
+			return nil, &Error{Pos: pos, Msg: "# must be followed by a tag"}
+		}
+		return Filter{Operator: Tag, Value: tag, Negated: negated, Pos: pos}, nil
+	}
+
+	name, value, found := strings.Cut(word, ":")
+	op, isOperator := operators[strings.ToLower(name)]
+	if !found || !isOperator {
+		return Term{Text: word, Negated: negated}, nil
+	}
+	if value == "" {
+		return nil, &Error{Pos: pos, Msg: fmt.Sprintf("%s: must be followed by a value", name)}
+	}
+
+	f := Filter{Operator: op, Value: value, Negated: negated, Pos: pos}
+	switch op {
+	case From, To, Mentions:
+		f.Value = strings.TrimPrefix(value, "@")
+		if f.Value == "" {
+			return nil, &Error{Pos: pos, Msg: fmt.Sprintf("%s: must be followed by a username", name)}
+		}
+	case Since, Until:
+		d, err := time.Parse(DateLayout, value)
+		if err != nil {
+			return nil, &Error{Pos: pos, Msg: fmt.Sprintf("%s: expects a date formatted as YYYY-MM-DD, got %q", name, value)}
+		}
+		f.Date = d
+	case MinLikes:
+		n, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || n < 0 {
+			return nil, &Error{Pos: pos, Msg: fmt.Sprintf("%s: expects a positive number, got %q", name, value)}
+		}
+		f.Number = n
+	case Has:
+		f.Value = strings.ToLower(value)
+		if f.Value != HasMedia && f.Value != HasLinks {
+			return nil, &Error{Pos: pos, Msg: fmt.Sprintf("unknown value %q for has:, expected %s or %s", value, HasMedia, HasLinks)}
+		}
+	case Is:
+		f.Value = strings.ToLower(value)
+		if f.Value != IsReply {
+			return nil, &Error{Pos: pos, Msg: fmt.Sprintf("unknown value %q for is:, expected %s", value, IsReply)}
+		}
+	}
+	return f, nil
+}
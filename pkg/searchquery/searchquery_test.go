@@ -0,0 +1,92 @@
+package searchquery
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParse(t *testing.T) {
+	day := func(s string) time.Time {
+		d, err := time.Parse(DateLayout, s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return d
+	}
+
+	for _, tc := range []struct {
+		q    string
+		want []Clause
+	}{
+		{"", nil},
+		{"   ", nil},
+		{"cats", []Clause{Term{Text: "cats"}}},
+		{"cats  dogs", []Clause{Term{Text: "cats"}, Term{Text: "dogs"}}},
+		{"-dogs", []Clause{Term{Text: "dogs", Negated: true}}},
+		{`"black cats"`, []Clause{Term{Text: "black cats", Phrase: true}}},
+		{`-"black cats"`, []Clause{Term{Text: "black cats", Phrase: true, Negated: true}}},
+		{`""`, nil},
+		{"cats - dogs", []Clause{Term{Text: "cats"}, Term{Text: "dogs"}}},
+		{"cats -", []Clause{Term{Text: "cats"}}},
+		{"from:jdoe", []Clause{Filter{Operator: From, Value: "jdoe", Pos: 1}}},
+		{"FROM:@jdoe", []Clause{Filter{Operator: From, Value: "jdoe", Pos: 1}}},
+		{"to:jdoe", []Clause{Filter{Operator: To, Value: "jdoe", Pos: 1}}},
+		{"mentions:jdoe", []Clause{Filter{Operator: Mentions, Value: "jdoe", Pos: 1}}},
+		{"cats #Caturday", []Clause{Term{Text: "cats"}, Filter{Operator: Tag, Value: "Caturday", Pos: 6}}},
+		{"-is:reply", []Clause{Filter{Operator: Is, Value: IsReply, Negated: true, Pos: 1}}},
+		{"has:MEDIA", []Clause{Filter{Operator: Has, Value: HasMedia, Pos: 1}}},
+		{"has:links", []Clause{Filter{Operator: Has, Value: HasLinks, Pos: 1}}},
+		{"min_likes:10", []Clause{Filter{Operator: MinLikes, Value: "10", Number: 10, Pos: 1}}},
+		{"since:2026-01-01", []Clause{Filter{Operator: Since, Value: "2026-01-01", Date: day("2026-01-01"), Pos: 1}}},
+		{"until:2026-01-31", []Clause{Filter{Operator: Until, Value: "2026-01-31", Date: day("2026-01-31"), Pos: 1}}},
+		// Words with a colon that aren't operators stay words
+		{"https://example.com", []Clause{Term{Text: "https://example.com"}}},
+		{"note:", []Clause{Term{Text: "note:"}}},
+		// Positions are counted in characters
+		{"café from:jdoe", []Clause{Term{Text: "café"}, Filter{Operator: From, Value: "jdoe", Pos: 6}}},
+	} {
+		got, err := Parse(tc.q)
+		if err != nil {
+			t.Errorf("Parse(%q) returned error %v", tc.q, err)
+			continue
+		}
+		if !reflect.DeepEqual(got.Clauses, tc.want) {
+			t.Errorf("Parse(%q) = %#v, want %#v", tc.q, got.Clauses, tc.want)
+		}
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	for _, tc := range []struct {
+		q   string
+		pos int
+	}{
+		{`cats "black`, 6},
+		{"#", 1},
+		{"cats from:", 6},
+		{"from:@", 1},
+		{"since:yesterday", 1},
+		{"until:2026-13-01", 1},
+		{"min_likes:-1", 1},
+		{"min_likes:many", 1},
+		{"has:cats", 1},
+		{"is:quote", 1},
+		{strings.Repeat("a ", MaxClauses+1), MaxClauses*2 + 1},
+	} {
+		_, err := Parse(tc.q)
+		var qErr *Error
+		if !errors.As(err, &qErr) {
+			t.Errorf("Parse(%q) returned %v, want an *Error", tc.q, err)
+			continue
+		}
+		if qErr.Pos != tc.pos {
+			t.Errorf("Parse(%q) reported position %d, want %d", tc.q, qErr.Pos, tc.pos)
+		}
+		if qErr.Msg == "" {
+			t.Errorf("Parse(%q) returned an error without message", tc.q)
+		}
+	}
+}
@@ -5,8 +5,15 @@ import (
 	"fmt"
 	"github.com/arangodb/go-driver"
 	arangohttp "github.com/arangodb/go-driver/http"
+	"github.com/denysvitali/social/backend/pkg/imageproxy"
+	"github.com/denysvitali/social/backend/pkg/mail"
 	"github.com/denysvitali/social/backend/pkg/models/api"
 	pg_model "github.com/denysvitali/social/backend/pkg/models/postgres"
+	"github.com/denysvitali/social/backend/pkg/pubsub"
+	"github.com/denysvitali/social/backend/pkg/safehttp"
+	"github.com/denysvitali/social/backend/pkg/storage"
+	"github.com/denysvitali/social/backend/pkg/unfurl"
+	"github.com/denysvitali/social/backend/pkg/webpush"
 	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
 	"github.com/oklog/ulid/v2"
@@ -15,6 +22,7 @@ import (
 	"gorm.io/gorm"
 	"gorm.io/gorm/logger"
 	"net/http"
+	"strings"
 	"time"
 )
 
@@ -23,6 +31,48 @@ type Server struct {
 	e      *gin.Engine
 	pgDB   *gorm.DB
 
+	// arangoDB stores the social graph, it is nil when ArangoDB isn't configured
+	arangoDB driver.Database
+
+	// impressions queues the post impressions to be persisted by the impression worker
+	impressions chan impression
+
+	storage storage.Storage
+	// mediaQueue queues the IDs of the media to be processed by the media workers
+	mediaQueue chan []byte
+	// mediaPublicURL is the base URL media are served from, empty when they are served by the API
+	mediaPublicURL string
+
+	imageProxy *imageproxy.Proxy
+
+	// broker distributes the events sent to the streaming clients
+	broker pubsub.Broker
+
+	// push sends the Web Push messages, it is nil when Web Push isn't configured
+	push      *webpush.Client
+	pushQueue chan pushJob
+	// pushAllowHTTP allows push subscriptions with plain HTTP endpoints
+	pushAllowHTTP bool
+
+	// mailer sends the emails, it is nil when emails aren't configured
+	mailer       mail.Mailer
+	emailQueue   chan emailJob
+	emailBaseURL string
+	emailSecret  string
+
+	webhookClient *http.Client
+	// webhookWake wakes the webhook worker when deliveries are queued
+	webhookWake chan struct{}
+
+	unfurler  *unfurl.Fetcher
+	linkCards *linkCardQueue
+
+	trendingWindow   time.Duration
+	trendingBaseline time.Duration
+
+	// adminToken is the bearer token granting access to the admin API, which is disabled when empty
+	adminToken string
+
 	// isDemo defines whether the server is running in demo mode: when this mode is enabled, the DB is
 	// pre-filled with demo data.
 	isDemo bool
@@ -41,6 +91,18 @@ type Config struct {
 	PostgresDSN string
 	DemoMode    bool
 
+	// TrendingWindow is the period over which trending tags are computed, it is compared
+	// against the usage of tags over the preceding TrendingBaseline.
+	TrendingWindow   time.Duration
+	TrendingBaseline time.Duration
+
+	AdminToken string
+
+	Media      MediaConfig
+	ImageCache ImageCacheConfig
+	WebPush    WebPushConfig
+	Email      EmailConfig
+
 	Logger *logrus.Logger
 }
 
@@ -58,15 +120,74 @@ func New(config Config) (*Server, error) {
 	pgdb.Logger = logger.Default.LogMode(logger.Info)
 
 	s := Server{
-		e:      gin.New(),
-		pgDB:   pgdb,
-		logger: config.Logger,
+		e:           gin.New(),
+		pgDB:        pgdb,
+		logger:      config.Logger,
+		impressions: make(chan impression, impressionQueueSize),
+		unfurler:    unfurl.New(safehttp.NewClient(linkCardFetchTimeout)),
+		linkCards:   newLinkCardQueue(),
+		mediaQueue:  make(chan []byte, mediaQueueSize),
+		broker:      pubsub.NewMemory(0),
+		pushQueue:   make(chan pushJob, pushQueueSize),
+		emailQueue:  make(chan emailJob, emailQueueSize),
+		webhookClient: &http.Client{
+			Timeout: webhookTimeout,
+			CheckRedirect: func(*http.Request, []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+		webhookWake: make(chan struct{}, 1),
+	}
+
+	s.storage, err = setupStorage(config.Media)
+	if err != nil {
+		return nil, fmt.Errorf("unable to set-up media storage: %v", err)
+	}
+	s.mediaPublicURL = config.Media.PublicURL
+
+	s.imageProxy, err = setupImageProxy(config.ImageCache)
+	if err != nil {
+		return nil, fmt.Errorf("unable to set-up image proxy: %v", err)
+	}
+
+	s.push, err = setupWebPush(config.WebPush)
+	if err != nil {
+		return nil, fmt.Errorf("unable to set-up web push: %v", err)
+	}
+	s.pushAllowHTTP = config.WebPush.AllowPrivateEndpoints
+
+	s.mailer, err = setupMailer(config.Email)
+	if err != nil {
+		return nil, fmt.Errorf("unable to set-up mailer: %v", err)
+	}
+	s.emailBaseURL = strings.TrimSuffix(config.Email.BaseURL, "/")
+	s.emailSecret = config.Email.Secret
+
+	if len(config.Arango.Endpoints) > 0 {
+		_, arangoDB, err := setupArango(config)
+		if err != nil {
+			return nil, fmt.Errorf("unable to set-up ArangoDB: %v", err)
+		}
+		s.arangoDB = arangoDB
+	} else {
+		s.logger.Warnf("no ArangoDB endpoint configured, social graph features are disabled")
 	}
 
 	if config.DemoMode {
 		s.isDemo = true
 	}
 
+	s.adminToken = config.AdminToken
+
+	s.trendingWindow = config.TrendingWindow
+	if s.trendingWindow <= 0 {
+		s.trendingWindow = DefaultTrendingWindow
+	}
+	s.trendingBaseline = config.TrendingBaseline
+	if s.trendingBaseline <= 0 {
+		s.trendingBaseline = DefaultTrendingBaseline
+	}
+
 	s.init()
 
 	return &s, nil
@@ -111,6 +232,12 @@ func (s *Server) Listen(addr ...string) error {
 
 func (s *Server) init() {
 	// init db
+	if s.arangoDB != nil {
+		err := s.initArango()
+		if err != nil {
+			s.logger.Fatalf("unable to initialize ArangoDB: %v", err)
+		}
+	}
 	s.initPostgreSQL()
 
 	if s.isDemo {
@@ -121,8 +248,28 @@ func (s *Server) init() {
 		}
 	}
 
+	go s.runImpressionWorker()
+	for i := 0; i < linkCardWorkers; i++ {
+		go s.runLinkCardWorker()
+	}
+	go s.runTrendingWorker()
+	for i := 0; i < mediaWorkers; i++ {
+		go s.runMediaWorker()
+	}
+	if s.push != nil {
+		for i := 0; i < pushWorkers; i++ {
+			go s.runPushWorker()
+		}
+	}
+	if s.mailer != nil {
+		go s.runEmailWorker()
+		go s.runDigestWorker()
+	}
+	go s.runWebhookWorker()
+
 	corsConfig := cors.DefaultConfig()
 	corsConfig.AllowOrigins = []string{"*"}
+	corsConfig.AddAllowHeaders(ViewerHeader)
 	s.e.Use(cors.New(corsConfig))
 	s.e.Use(gin.Logger())
 
@@ -137,21 +284,79 @@ func (s *Server) initPostgreSQL() {
 		&pg_model.BioPicture{},
 		&pg_model.Post{},
 		&pg_model.Tag{},
+		&pg_model.TagAlias{},
+		&pg_model.BookmarkCollection{},
+		&pg_model.Bookmark{},
+		&pg_model.PinnedPost{},
+		&pg_model.PostImpression{},
+		&pg_model.PostDailyStat{},
+		&pg_model.LinkCard{},
+		&pg_model.TrendingTag{},
+		&pg_model.Media{},
+		&pg_model.MediaVariant{},
+		&pg_model.Notification{},
+		&pg_model.PushSubscription{},
+		&pg_model.Webhook{},
+		&pg_model.WebhookEvent{},
+		&pg_model.WebhookDelivery{},
+		&pg_model.Conversation{},
+		&pg_model.ConversationMember{},
+		&pg_model.Message{},
+		&pg_model.Report{},
+		&pg_model.Warning{},
+		&pg_model.MutedWord{},
 	} {
 		err := s.pgDB.AutoMigrate(v)
 		if err != nil {
 			s.logger.Fatalf("unable to automigrate %t: %v", v, err)
 		}
 	}
+
+	// Statements that AutoMigrate can't express. They must be idempotent, as they run on every start.
+	for _, stmt := range []string{
+		// Posts created before posts.created_at existed: the timestamp is the first 48 bits of the ULID
+		`UPDATE posts SET created_at = to_timestamp(('x' || encode(substring(id from 1 for 6), 'hex'))::bit(48)::bigint / 1000.0)
+		WHERE created_at IS NULL`,
+		// Prefix search of tags
+		`DROP INDEX IF EXISTS idx_tags_text_prefix`,
+		`CREATE INDEX IF NOT EXISTS idx_tags_canonical_prefix ON tags (canonical text_pattern_ops)`,
+		// Full-text search of posts
+		`ALTER TABLE posts ADD COLUMN IF NOT EXISTS search_vector tsvector
+		GENERATED ALWAYS AS (` + searchVectorExpression() + `) STORED`,
+		`CREATE INDEX IF NOT EXISTS idx_posts_search_vector ON posts USING GIN (search_vector)`,
+		// Media uploaded before processing existed: they are processed from the file stored under their key
+		`UPDATE media SET source_key = key, status_updated_at = created_at WHERE source_key IS NULL`,
+		// Fuzzy search of users
+		`CREATE EXTENSION IF NOT EXISTS pg_trgm`,
+		`CREATE INDEX IF NOT EXISTS idx_users_username_trgm ON users USING GIN (lower(username) gin_trgm_ops)`,
+		`CREATE INDEX IF NOT EXISTS idx_users_display_name_trgm ON users USING GIN (lower(display_name) gin_trgm_ops)`,
+	} {
+		err := s.pgDB.Exec(stmt).Error
+		if err != nil {
+			s.logger.Fatalf("unable to run migration %q: %v", stmt, err)
+		}
+	}
+
+	err := s.backfillTagCanonicals()
+	if err != nil {
+		s.logger.Fatalf("unable to backfill canonical tags: %v", err)
+	}
 }
 
 func (s *Server) apiV1GetPosts(c *gin.Context) {
 	var posts []pg_model.Post
 
-	tx := s.pgDB.
-		Model(&posts).
+	viewer, _ := s.viewerId(c)
+	hidden, err := s.hiddenUserIds(c.Request.Context(), viewer, true)
+	if err != nil {
+		s.internalServerError(c, "unable to get users hidden from user %d: %v", viewer, err)
+		return
+	}
+
+	tx := excludeAuthors(excludeSuspendedAuthors(s.pgDB.Model(&posts)), hidden).
 		Preload("Author").
 		Joins("INNER JOIN user_likes ON user_likes.post_id = posts.id").
+		Where("posts.deleted = false AND posts.visibility = ?", pg_model.VisibilityPublic).
 		Group("posts.id").
 		Select("posts.*, COUNT(user_likes.post_id) AS likes").
 		Limit(50).
@@ -162,50 +367,18 @@ func (s *Server) apiV1GetPosts(c *gin.Context) {
 		return
 	}
 
-	var apiPosts []api.Post
-	var postsResponse api.PostsResponse
-
-	authorsMap := map[uint64]bool{}
-
-	for _, p := range posts {
-		var ulidBytes [16]byte
-		copy(ulidBytes[:], p.ID[:16])
-		pUlid := ulid.ULID(ulidBytes)
-		apiPosts = append(apiPosts, api.Post{
-			ID:        pUlid.String(),
-			Content:   p.Content,
-			Likes:     p.Likes,
-			Author:    p.AuthorID,
-			CreatedAt: time.Unix(int64(pUlid.Time()/1000), 0),
-		})
-		authorsMap[p.AuthorID] = true
-	}
-
-	// Fetch Authors
-	var authorIds []uint64
-	for k := range authorsMap {
-		authorIds = append(authorIds, k)
-	}
-
-	var apiUsers []api.User
-	var authors []pg_model.User
-	tx = s.pgDB.Where("id IN ?", authorIds).Find(&authors)
-	if tx.Error != nil {
-		s.internalServerError(c, "unable to find authors: %v", tx.Error)
+	postsResponse, err := s.postsResponse(viewer, posts)
+	if err != nil {
+		s.internalServerError(c, "unable to build posts response: %v", err)
 		return
 	}
-
-	for _, u := range authors {
-		apiUsers = append(apiUsers, api.User{
-			ID:          u.ID,
-			DisplayName: u.DisplayName,
-			Username:    u.Username,
-			Verified:    u.Verified,
-		})
+	filter, err := s.loadPostFilter(viewer, pg_model.FilterContextEverywhere)
+	if err != nil {
+		s.internalServerError(c, "unable to get muted words of user %d: %v", viewer, err)
+		return
 	}
-
-	postsResponse.Posts = apiPosts
-	postsResponse.Users = apiUsers
+	postsResponse.Posts = filter.apply(postsResponse.Posts)
+	s.recordImpressions(c, postsResponse.Posts)
 
 	c.JSON(http.StatusOK, postsResponse)
 }
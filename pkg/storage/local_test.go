@@ -0,0 +1,103 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLocalObjects(t *testing.T) {
+	dir := t.TempDir()
+	l, err := NewLocal(filepath.Join(dir, "storage"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+
+	content := []byte("hello")
+	err = l.Put(ctx, "media/a/b.txt", bytes.NewReader(content), int64(len(content)), "text/plain")
+	if err != nil {
+		t.Fatalf("unable to put object: %v", err)
+	}
+	r, err := l.Get(ctx, "media/a/b.txt")
+	if err != nil {
+		t.Fatalf("unable to get object: %v", err)
+	}
+	got, err := io.ReadAll(r)
+	_ = r.Close()
+	if err != nil || !bytes.Equal(got, content) {
+		t.Errorf("got content %q (%v), want %q", got, err, content)
+	}
+
+	// Objects are replaced atomically: a failed upload leaves the previous content and no temporary file
+	err = l.Put(ctx, "media/a/b.txt", strings.NewReader("truncated"), 100, "text/plain")
+	if err == nil {
+		t.Errorf("expected an error putting fewer bytes than announced")
+	}
+	entries, err := os.ReadDir(filepath.Join(dir, "storage", "media", "a"))
+	if err != nil || len(entries) != 1 {
+		t.Errorf("unexpected files after a failed upload: %v (%v)", entries, err)
+	}
+
+	err = l.Delete(ctx, "media/a/b.txt")
+	if err != nil {
+		t.Fatalf("unable to delete object: %v", err)
+	}
+	_, err = l.Get(ctx, "media/a/b.txt")
+	if !errors.Is(err, ErrNotFound) {
+		t.Errorf("got error %v getting a deleted object, want ErrNotFound", err)
+	}
+	err = l.Delete(ctx, "media/a/b.txt")
+	if err != nil {
+		t.Errorf("got error %v deleting a missing object", err)
+	}
+}
+
+func TestLocalRejectsPathTraversal(t *testing.T) {
+	dir := t.TempDir()
+	secret := filepath.Join(dir, "secret.txt")
+	err := os.WriteFile(secret, []byte("secret"), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l, err := NewLocal(filepath.Join(dir, "storage"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+
+	for _, key := range []string{
+		"",
+		"../secret.txt",
+		"media/../../secret.txt",
+		"media/./a.txt",
+		"media//a.txt",
+		"media/",
+		"/secret.txt",
+		secret,
+		`..\secret.txt`,
+	} {
+		_, err = l.Get(ctx, key)
+		if !errors.Is(err, ErrInvalidKey) {
+			t.Errorf("Get(%q) returned %v, want ErrInvalidKey", key, err)
+		}
+		err = l.Put(ctx, key, strings.NewReader("x"), 1, "text/plain")
+		if !errors.Is(err, ErrInvalidKey) {
+			t.Errorf("Put(%q) returned %v, want ErrInvalidKey", key, err)
+		}
+		err = l.Delete(ctx, key)
+		if !errors.Is(err, ErrInvalidKey) {
+			t.Errorf("Delete(%q) returned %v, want ErrInvalidKey", key, err)
+		}
+	}
+
+	got, err := os.ReadFile(secret)
+	if err != nil || string(got) != "secret" {
+		t.Errorf("file outside of the storage was modified: %q (%v)", got, err)
+	}
+}
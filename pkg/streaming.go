@@ -0,0 +1,116 @@
+package server
+
+import (
+	"fmt"
+	"github.com/denysvitali/social/backend/pkg/models/api"
+	pg_model "github.com/denysvitali/social/backend/pkg/models/postgres"
+)
+
+// Types of the events sent to the streaming clients
+const (
+	// streamEventPost carries an api.PostsResponse with a new post
+	streamEventPost = "post"
+	// streamEventCounters carries the api.PostCounters of a post whose counters changed
+	streamEventCounters = "counters"
+	// streamEventNotification carries an api.NotificationEvent
+	streamEventNotification = "notification"
+	// streamEventUnreadCount carries the api.UnreadCount of the user
+	streamEventUnreadCount = "unread_count"
+	// streamEventReset tells the client that events were missed, and that it must reload its data
+	streamEventReset = "reset"
+)
+
+// publicTopic receives the public posts
+const publicTopic = "public"
+
+// userTopic receives the notifications of a user
+func userTopic(userId uint64) string {
+	return fmt.Sprintf("user:%d", userId)
+}
+
+// authorTopic receives the posts of a user, whatever their visibility
+func authorTopic(userId uint64) string {
+	return fmt.Sprintf("author:%d", userId)
+}
+
+// tagTopic receives the public posts carrying a tag
+func tagTopic(tagId uint64) string {
+	return fmt.Sprintf("tag:%d", tagId)
+}
+
+// postTopics returns the topics the events about post are published on. Only the author topic, whose
+// subscribers are the author and their followers, receives the posts that aren't public.
+func postTopics(post pg_model.Post, tagIds []uint64) []string {
+	topics := []string{authorTopic(post.AuthorID)}
+	if post.Visibility != pg_model.VisibilityPublic {
+		return topics
+	}
+	topics = append(topics, publicTopic)
+	for _, t := range tagIds {
+		topics = append(topics, tagTopic(t))
+	}
+	return topics
+}
+
+// publish sends an event to the streaming clients. Like notifications, events are best-effort.
+func (s *Server) publish(topics []string, eventType string, data any) {
+	err := s.broker.Publish(topics, eventType, data)
+	if err != nil {
+		s.logger.Warnf("unable to publish %s event: %v", eventType, err)
+	}
+}
+
+// publishPost streams a newly created post. The post is rendered for an anonymous viewer, as it is sent
+// to all the subscribers.
+func (s *Server) publishPost(post pg_model.Post) {
+	postsResponse, err := s.postsResponse(0, []pg_model.Post{post})
+	if err != nil {
+		s.logger.Warnf("unable to build streamed post: %v", err)
+		return
+	}
+	var tagIds []uint64
+	for _, t := range post.Tags {
+		tagIds = append(tagIds, t.ID)
+	}
+	s.publish(postTopics(post, tagIds), streamEventPost, postsResponse)
+}
+
+// publishCounters streams the current counters of post
+func (s *Server) publishCounters(post pg_model.Post) {
+	var likes uint64
+	err := s.pgDB.
+		Model(&pg_model.Post{}).
+		Select("likes").
+		Where("id = ?", post.ID).
+		Scan(&likes).Error
+	if err != nil {
+		s.logger.Warnf("unable to get counters of post %s: %v", bytesToUlid(post.ID), err)
+		return
+	}
+	var tagIds []uint64
+	if post.Visibility == pg_model.VisibilityPublic {
+		err = s.pgDB.
+			Table("post_tags").
+			Where("post_id = ?", post.ID).
+			Pluck("tag_id", &tagIds).Error
+		if err != nil {
+			s.logger.Warnf("unable to get tags of post %s: %v", bytesToUlid(post.ID), err)
+			return
+		}
+	}
+
+	s.publish(postTopics(post, tagIds), streamEventCounters, api.PostCounters{
+		Post:  bytesToUlid(post.ID).String(),
+		Likes: likes,
+	})
+}
+
+// publishUnreadCount streams the number of unread notifications of userId
+func (s *Server) publishUnreadCount(userId uint64) {
+	count, err := s.unreadNotificationsCount(userId)
+	if err != nil {
+		s.logger.Warnf("unable to count unread notifications of user %d: %v", userId, err)
+		return
+	}
+	s.publish([]string{userTopic(userId)}, streamEventUnreadCount, api.UnreadCount{Count: count})
+}
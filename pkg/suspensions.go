@@ -0,0 +1,23 @@
+package server
+
+import (
+	pg_model "github.com/denysvitali/social/backend/pkg/models/postgres"
+	"gorm.io/gorm"
+)
+
+// Suspended users disappear like deleted ones until their suspension is lifted: their profile isn't
+// found, and their posts are filtered out of listings and search, and can't be opened or interacted with.
+// As suspended users can't act, they don't produce stream events either.
+
+// notSuspendedAuthor is the condition keeping the posts whose author isn't suspended, for raw queries
+const notSuspendedAuthor = "posts.author_id NOT IN (SELECT id FROM users WHERE suspended_at IS NOT NULL)"
+
+// excludeSuspendedAuthors filters the posts of suspended users out of tx
+func excludeSuspendedAuthors(tx *gorm.DB) *gorm.DB {
+	return tx.Where(notSuspendedAuthor)
+}
+
+// userUnavailable returns whether the profile of u is hidden, as u was deleted or suspended
+func userUnavailable(u pg_model.User) bool {
+	return u.Deleted || u.SuspendedAt != nil
+}
@@ -0,0 +1,65 @@
+package server
+
+import (
+	"database/sql/driver"
+	pg_model "github.com/denysvitali/social/backend/pkg/models/postgres"
+	"github.com/gin-gonic/gin"
+	"github.com/oklog/ulid/v2"
+	"github.com/sirupsen/logrus"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSuspendedUsersAreHidden(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	postId := ulid.Make()
+	suspendedAt := time.Now()
+
+	db, _ := newFakeDB(t, func(query string, args []driver.Value) (fakeResult, error) {
+		switch {
+		case strings.Contains(query, `FROM "users"`):
+			return fakeResult{
+				columns: []string{"id", "username", "display_name", "deleted", "suspended_at"},
+				rows:    [][]driver.Value{{int64(2), "mallory", "Mallory", false, suspendedAt}},
+			}, nil
+		case strings.Contains(query, `FROM "posts"`):
+			return fakeResult{
+				columns: []string{"id", "author_id", "content", "visibility", "deleted"},
+				rows:    [][]driver.Value{{postId.Bytes(), int64(2), "hello", "public", false}},
+			}, nil
+		}
+		return fakeResult{}, nil
+	})
+	log := logrus.New()
+	log.SetOutput(io.Discard)
+	s := &Server{logger: log, pgDB: db}
+	e := gin.New()
+	e.GET("/users/@:username", s.apiV1UserByUsername)
+	e.GET("/users/@:username/posts", s.apiV1PostsByAuthorUsername)
+	e.GET("/posts/:id", s.apiV1GetSinglePost)
+
+	for _, path := range []string{"/users/@mallory", "/users/@mallory/posts", "/posts/" + postId.String()} {
+		w := httptest.NewRecorder()
+		e.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		if w.Code != http.StatusNotFound {
+			t.Errorf("%s: got status %d, want 404: %s", path, w.Code, w.Body)
+		}
+	}
+
+	for _, tt := range []struct {
+		user pg_model.User
+		want bool
+	}{
+		{pg_model.User{ID: 1}, false},
+		{pg_model.User{ID: 2, SuspendedAt: &suspendedAt}, true},
+		{pg_model.User{ID: 3, Deleted: true}, true},
+	} {
+		if got := userUnavailable(tt.user); got != tt.want {
+			t.Errorf("userUnavailable(%d) = %v, want %v", tt.user.ID, got, tt.want)
+		}
+	}
+}
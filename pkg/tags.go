@@ -0,0 +1,206 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/denysvitali/social/backend/pkg/entities"
+	pg_model "github.com/denysvitali/social/backend/pkg/models/postgres"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// tagMergeBatchSize is the number of post_tags rows moved per transaction when merging tags,
+// small enough to never hold locks for long
+const tagMergeBatchSize = 1000
+
+// findTagByText returns the tag text refers to, be it directly or through an alias
+func (s *Server) findTagByText(text string) (pg_model.Tag, error) {
+	return findTagByCanonical(s.pgDB, entities.CanonicalTag(text))
+}
+
+func findTagByCanonical(db *gorm.DB, canonical string) (pg_model.Tag, error) {
+	var tag pg_model.Tag
+	err := db.Take(&tag, "canonical = ?", canonical).Error
+	if err == nil || !errors.Is(err, gorm.ErrRecordNotFound) {
+		return tag, err
+	}
+
+	var alias pg_model.TagAlias
+	err = db.Preload("Tag").Take(&alias, "canonical = ?", canonical).Error
+	if err != nil {
+		return tag, err
+	}
+	if alias.Tag == nil {
+		return tag, gorm.ErrRecordNotFound
+	}
+	return *alias.Tag, nil
+}
+
+// findOrCreateTags returns the tags texts refer to, creating the missing ones.
+// Texts resolving to the same tag result in a single tag.
+func findOrCreateTags(tx *gorm.DB, texts []string) ([]pg_model.Tag, error) {
+	var tags []pg_model.Tag
+	seen := map[uint64]bool{}
+	for _, text := range texts {
+		canonical := entities.CanonicalTag(text)
+		tag, err := findTagByCanonical(tx, canonical)
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			tag = pg_model.Tag{Text: text, Canonical: canonical}
+			err = tx.
+				Clauses(clause.OnConflict{
+					Columns: []clause.Column{{Name: "canonical"}},
+					// No-op update, so that the existing row is returned
+					DoUpdates: clause.Assignments(map[string]any{"canonical": canonical}),
+				}).
+				Create(&tag).Error
+		}
+		if err != nil {
+			return nil, err
+		}
+		if seen[tag.ID] {
+			continue
+		}
+		seen[tag.ID] = true
+		tags = append(tags, tag)
+	}
+	return tags, nil
+}
+
+// backfillTagCanonicals sets the canonical form of the tags created before it existed.
+// Tags turning out to have the same canonical form are merged.
+func (s *Server) backfillTagCanonicals() error {
+	var tags []pg_model.Tag
+	err := s.pgDB.
+		Where("canonical IS NULL OR canonical = ''").
+		Order("id ASC").
+		Find(&tags).Error
+	if err != nil {
+		return err
+	}
+
+	for _, tag := range tags {
+		canonical := entities.CanonicalTag(tag.Text)
+		existing, err := findTagByCanonical(s.pgDB, canonical)
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			err = s.pgDB.Model(&tag).Update("canonical", canonical).Error
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		s.logger.Infof("merging tag %q (%d) into %q (%d)", tag.Text, tag.ID, existing.Text, existing.ID)
+		err = s.mergeTags(context.Background(), tag, existing)
+		if err != nil {
+			return fmt.Errorf("unable to merge tag %d into %d: %v", tag.ID, existing.ID, err)
+		}
+	}
+	return nil
+}
+
+// mergeTags moves all the posts and followers of source to target, then replaces source with an alias
+// of target. The posts are moved in small batches, so that the tags remain usable while merging.
+func (s *Server) mergeTags(ctx context.Context, source pg_model.Tag, target pg_model.Tag) error {
+	if source.ID == target.ID {
+		return fmt.Errorf("cannot merge a tag into itself")
+	}
+
+	for {
+		moved, err := movePostTags(s.pgDB.WithContext(ctx), source.ID, target.ID, tagMergeBatchSize)
+		if err != nil {
+			return err
+		}
+		if moved == 0 {
+			break
+		}
+	}
+
+	err := s.pgDB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		// Lock the source tag, so that no post can be tagged with it anymore
+		var locked pg_model.Tag
+		err := tx.
+			Clauses(clause.Locking{Strength: "UPDATE"}).
+			Take(&locked, "id = ?", source.ID).Error
+		if err != nil {
+			return err
+		}
+
+		// Posts tagged between the last batch and the lock
+		_, err = movePostTags(tx, source.ID, target.ID, 0)
+		if err != nil {
+			return err
+		}
+
+		err = tx.Model(&pg_model.TagAlias{}).
+			Where("tag_id = ?", source.ID).
+			Update("tag_id", target.ID).Error
+		if err != nil {
+			return err
+		}
+
+		if locked.Canonical != "" && locked.Canonical != target.Canonical {
+			err = tx.
+				Clauses(clause.OnConflict{UpdateAll: true}).
+				Create(&pg_model.TagAlias{Canonical: locked.Canonical, TagID: target.ID}).Error
+			if err != nil {
+				return err
+			}
+		}
+
+		err = tx.Where("tag_id = ?", source.ID).Delete(&pg_model.TrendingTag{}).Error
+		if err != nil {
+			return err
+		}
+		return tx.Delete(&locked).Error
+	})
+	if err != nil {
+		return err
+	}
+
+	if s.arangoDB == nil {
+		return nil
+	}
+	followers, err := s.inboundIds(ctx, RelationFollows, tagVertex(source.ID), UsersCollection)
+	if err != nil {
+		return fmt.Errorf("unable to get followers of tag %d: %v", source.ID, err)
+	}
+	for _, userId := range followers {
+		_, err = s.addRelation(ctx, RelationFollows, userVertex(userId), tagVertex(target.ID))
+		if err != nil {
+			return err
+		}
+		_, err = s.removeRelation(ctx, RelationFollows, userVertex(userId), tagVertex(source.ID))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// movePostTags re-points up to limit post_tags rows from tag source to tag target, or all of them
+// if limit is zero. It returns the number of rows removed from source.
+func movePostTags(db *gorm.DB, source uint64, target uint64, limit int) (int64, error) {
+	selection := "SELECT ctid FROM post_tags WHERE tag_id = @source"
+	if limit > 0 {
+		selection += " LIMIT @limit"
+	}
+
+	var moved int64
+	err := db.Raw(`
+		WITH moved AS (
+			DELETE FROM post_tags WHERE ctid IN (`+selection+`)
+			RETURNING post_id
+		), inserted AS (
+			INSERT INTO post_tags (post_id, tag_id)
+			SELECT post_id, @target FROM moved
+			ON CONFLICT DO NOTHING
+		)
+		SELECT COUNT(*) FROM moved`,
+		map[string]any{"source": source, "target": target, "limit": limit},
+	).Scan(&moved).Error
+	return moved, err
+}
@@ -0,0 +1,122 @@
+package server
+
+import (
+	pg_model "github.com/denysvitali/social/backend/pkg/models/postgres"
+	"gorm.io/gorm"
+	"math"
+	"sort"
+	"time"
+)
+
+const DefaultTrendingWindow = 6 * time.Hour
+const DefaultTrendingBaseline = 7 * 24 * time.Hour
+
+const trendingRefreshInterval = 5 * time.Minute
+const maxTrendingTags = 50
+
+// trendingMinAuthors is the minimum number of distinct authors that must have used a tag
+// within the trending window for it to trend
+const trendingMinAuthors = 2
+
+// runTrendingWorker periodically recomputes the trending tags ranking
+func (s *Server) runTrendingWorker() {
+	for {
+		err := s.computeTrendingTags(time.Now())
+		if err != nil {
+			s.logger.Errorf("unable to compute trending tags: %v", err)
+		}
+		time.Sleep(trendingRefreshInterval)
+	}
+}
+
+// computeTrendingTags ranks tags by how much their usage within the trending window exceeds
+// the usage expected from the baseline period that precedes it.
+//
+// Usage is measured in distinct authors rather than in posts, so that a single account
+// spamming a tag can't make it trend.
+func (s *Server) computeTrendingTags(now time.Time) error {
+	recentSince := now.Add(-s.trendingWindow)
+	baselineSince := recentSince.Add(-s.trendingBaseline)
+
+	type usage struct {
+		TagID           uint64
+		Uses            int64
+		Authors         int64
+		BaselineAuthors int64
+	}
+
+	var usages []usage
+	tx := s.pgDB.Raw(`
+		WITH recent AS (
+			SELECT post_tags.tag_id, COUNT(*) AS uses, COUNT(DISTINCT posts.author_id) AS authors
+			FROM post_tags
+			INNER JOIN posts ON posts.id = post_tags.post_id
+			WHERE posts.deleted = false AND `+notSuspendedAuthor+` AND posts.created_at >= @recent_since
+			GROUP BY post_tags.tag_id
+		), baseline AS (
+			SELECT post_tags.tag_id, COUNT(DISTINCT posts.author_id) AS authors
+			FROM post_tags
+			INNER JOIN posts ON posts.id = post_tags.post_id
+			WHERE posts.deleted = false AND `+notSuspendedAuthor+`
+				AND posts.created_at >= @baseline_since
+				AND posts.created_at < @recent_since
+				AND post_tags.tag_id IN (SELECT tag_id FROM recent)
+			GROUP BY post_tags.tag_id
+		)
+		SELECT recent.tag_id, recent.uses, recent.authors, COALESCE(baseline.authors, 0) AS baseline_authors
+		FROM recent
+		LEFT JOIN baseline ON baseline.tag_id = recent.tag_id
+		WHERE recent.authors >= @min_authors`,
+		map[string]any{
+			"recent_since":   recentSince,
+			"baseline_since": baselineSince,
+			"min_authors":    trendingMinAuthors,
+		},
+	).Scan(&usages)
+	if tx.Error != nil {
+		return tx.Error
+	}
+
+	ratio := float64(s.trendingWindow) / float64(s.trendingBaseline)
+	var trending []pg_model.TrendingTag
+	for _, u := range usages {
+		expected := float64(u.BaselineAuthors) * ratio
+		// Poisson-like z-score: a jump from 0 to 5 authors matters more than one from 100 to 105
+		score := (float64(u.Authors) - expected) / math.Sqrt(expected+1)
+		if score <= 0 {
+			continue
+		}
+		trending = append(trending, pg_model.TrendingTag{
+			TagID:           u.TagID,
+			Score:           score,
+			Uses:            u.Uses,
+			Authors:         u.Authors,
+			BaselineAuthors: expected,
+			ComputedAt:      now,
+		})
+	}
+
+	sort.Slice(trending, func(i, j int) bool {
+		if trending[i].Score != trending[j].Score {
+			return trending[i].Score > trending[j].Score
+		}
+		return trending[i].Authors > trending[j].Authors
+	})
+	if len(trending) > maxTrendingTags {
+		trending = trending[:maxTrendingTags]
+	}
+	for i := range trending {
+		trending[i].Rank = i + 1
+	}
+
+	return s.pgDB.Transaction(func(tx *gorm.DB) error {
+		err := tx.Where("1 = 1").Delete(&pg_model.TrendingTag{}).Error
+		if err != nil {
+			return err
+		}
+		if len(trending) == 0 {
+			return nil
+		}
+		return tx.Create(&trending).Error
+	})
+}
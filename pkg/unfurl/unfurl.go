@@ -0,0 +1,209 @@
+// Package unfurl extracts preview cards (OpenGraph and Twitter card metadata) from web pages.
+package unfurl
+
+import (
+	"context"
+	"fmt"
+	"golang.org/x/net/html"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// DefaultMaxBytes is the maximum amount of HTML read from a page: metadata lives in <head>,
+// there's no need to download whole documents.
+const DefaultMaxBytes = 512 * 1024
+
+const userAgent = "OpenDolphinBot/1.0 (+link preview)"
+
+var ErrNotHTML = fmt.Errorf("not an HTML document")
+
+type Card struct {
+	URL         string
+	Title       string
+	Description string
+	ImageURL    string
+	SiteName    string
+}
+
+// Empty returns whether the card carries no usable metadata
+func (c Card) Empty() bool {
+	return c.Title == "" && c.Description == "" && c.ImageURL == ""
+}
+
+type Fetcher struct {
+	// Client performs the requests. It is responsible for timeouts and for refusing
+	// to connect to private addresses, see safehttp.NewClient.
+	Client   *http.Client
+	MaxBytes int64
+}
+
+func New(client *http.Client) *Fetcher {
+	return &Fetcher{
+		Client:   client,
+		MaxBytes: DefaultMaxBytes,
+	}
+}
+
+// Fetch downloads the page at rawUrl and extracts its card
+func (f *Fetcher) Fetch(ctx context.Context, rawUrl string) (*Card, error) {
+	u, err := url.Parse(rawUrl)
+	if err != nil {
+		return nil, fmt.Errorf("invalid URL: %v", err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("unsupported scheme %q", u.Scheme)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", userAgent)
+	req.Header.Set("Accept", "text/html,application/xhtml+xml")
+
+	res, err := f.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status code %d", res.StatusCode)
+	}
+
+	mediaType, _, err := mime.ParseMediaType(res.Header.Get("Content-Type"))
+	if err != nil || (mediaType != "text/html" && mediaType != "application/xhtml+xml") {
+		return nil, ErrNotHTML
+	}
+
+	maxBytes := f.MaxBytes
+	if maxBytes <= 0 {
+		maxBytes = DefaultMaxBytes
+	}
+	card, err := Parse(io.LimitReader(res.Body, maxBytes), res.Request.URL)
+	if err != nil {
+		return nil, err
+	}
+	card.URL = rawUrl
+	return card, nil
+}
+
+// Parse extracts the card from an HTML document. base is used to resolve relative image URLs.
+func Parse(r io.Reader, base *url.URL) (*Card, error) {
+	meta := map[string]string{}
+	var title string
+
+	z := html.NewTokenizer(r)
+	inTitle := false
+	for {
+		tt := z.Next()
+		switch tt {
+		case html.ErrorToken:
+			// Either EOF or the size cap was hit: use what we've got so far
+			if z.Err() != io.EOF && z.Err() != nil && len(meta) == 0 && title == "" {
+				return nil, z.Err()
+			}
+			return buildCard(meta, title, base), nil
+		case html.StartTagToken, html.SelfClosingTagToken:
+			t := z.Token()
+			switch t.Data {
+			case "meta":
+				key, content := metaAttributes(t)
+				if key != "" && content != "" {
+					if _, ok := meta[key]; !ok {
+						meta[key] = content
+					}
+				}
+			case "title":
+				inTitle = tt == html.StartTagToken
+			case "body":
+				// Metadata is in <head>
+				return buildCard(meta, title, base), nil
+			}
+		case html.TextToken:
+			if inTitle && title == "" {
+				title = strings.TrimSpace(string(z.Text()))
+			}
+		case html.EndTagToken:
+			if t := z.Token(); t.Data == "title" {
+				inTitle = false
+			} else if t.Data == "head" {
+				return buildCard(meta, title, base), nil
+			}
+		}
+	}
+}
+
+// metaAttributes returns the (lowercased) property or name of a <meta> tag, and its content
+func metaAttributes(t html.Token) (string, string) {
+	var key, content string
+	for _, a := range t.Attr {
+		switch strings.ToLower(a.Key) {
+		case "property", "name":
+			if key == "" {
+				key = strings.ToLower(strings.TrimSpace(a.Val))
+			}
+		case "content":
+			content = strings.TrimSpace(a.Val)
+		}
+	}
+	return key, content
+}
+
+func buildCard(meta map[string]string, title string, base *url.URL) *Card {
+	first := func(keys ...string) string {
+		for _, k := range keys {
+			if v := meta[k]; v != "" {
+				return v
+			}
+		}
+		return ""
+	}
+
+	card := Card{
+		Title:       first("og:title", "twitter:title"),
+		Description: first("og:description", "twitter:description", "description"),
+		ImageURL:    first("og:image:secure_url", "og:image", "og:image:url", "twitter:image", "twitter:image:src"),
+		SiteName:    first("og:site_name", "twitter:site"),
+	}
+	if card.Title == "" {
+		card.Title = title
+	}
+	if card.SiteName == "" && base != nil {
+		card.SiteName = base.Hostname()
+	}
+	card.ImageURL = resolveURL(base, card.ImageURL)
+
+	card.Title = truncate(card.Title, 300)
+	card.Description = truncate(card.Description, 1000)
+	card.SiteName = truncate(card.SiteName, 100)
+	return &card
+}
+
+func resolveURL(base *url.URL, ref string) string {
+	if ref == "" {
+		return ""
+	}
+	u, err := url.Parse(ref)
+	if err != nil {
+		return ""
+	}
+	if base != nil {
+		u = base.ResolveReference(u)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return ""
+	}
+	return u.String()
+}
+
+func truncate(s string, max int) string {
+	r := []rune(s)
+	if len(r) <= max {
+		return s
+	}
+	return string(r[:max-1]) + "…"
+}
@@ -0,0 +1,122 @@
+package unfurl
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestFetch(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/article", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("User-Agent") != userAgent {
+			t.Errorf("unexpected user agent %q", r.Header.Get("User-Agent"))
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		_, _ = w.Write([]byte(`<!doctype html><html><head>
+			<title>Fallback title</title>
+			<meta property="og:title" content=" An article ">
+			<meta property="og:title" content="Ignored duplicate">
+			<meta name="description" content="Plain description">
+			<meta property="og:description" content="OpenGraph description">
+			<meta property="og:image" content="/images/cover.png">
+			</head><body><meta property="og:site_name" content="Ignored, in body"></body></html>`))
+	})
+	mux.HandleFunc("/title-only", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		_, _ = w.Write([]byte(`<html><head><title>Just a title</title></head></html>`))
+	})
+	mux.HandleFunc("/redirect", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/article", http.StatusFound)
+	})
+	mux.HandleFunc("/image.png", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/png")
+		_, _ = w.Write([]byte("\x89PNG"))
+	})
+	mux.HandleFunc("/missing", http.NotFound)
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	f := New(srv.Client())
+	ctx := context.Background()
+
+	card, err := f.Fetch(ctx, srv.URL+"/article")
+	if err != nil {
+		t.Fatalf("unable to fetch article: %v", err)
+	}
+	want := Card{
+		URL:         srv.URL + "/article",
+		Title:       "An article",
+		Description: "OpenGraph description",
+		ImageURL:    srv.URL + "/images/cover.png",
+		SiteName:    "127.0.0.1",
+	}
+	if *card != want {
+		t.Errorf("got card %+v, want %+v", *card, want)
+	}
+
+	// Relative URLs are resolved against the final URL, and the card keeps the requested one
+	card, err = f.Fetch(ctx, srv.URL+"/redirect")
+	if err != nil {
+		t.Fatalf("unable to fetch redirect: %v", err)
+	}
+	if card.URL != srv.URL+"/redirect" || card.ImageURL != srv.URL+"/images/cover.png" {
+		t.Errorf("unexpected card after redirect: %+v", *card)
+	}
+
+	card, err = f.Fetch(ctx, srv.URL+"/title-only")
+	if err != nil {
+		t.Fatalf("unable to fetch title-only page: %v", err)
+	}
+	if card.Title != "Just a title" || card.Empty() {
+		t.Errorf("unexpected title-only card: %+v", *card)
+	}
+
+	_, err = f.Fetch(ctx, srv.URL+"/image.png")
+	if !errors.Is(err, ErrNotHTML) {
+		t.Errorf("got error %v fetching an image, want ErrNotHTML", err)
+	}
+
+	_, err = f.Fetch(ctx, srv.URL+"/missing")
+	if err == nil {
+		t.Errorf("expected an error fetching a missing page")
+	}
+
+	_, err = f.Fetch(ctx, "ftp://example.com/file")
+	if err == nil {
+		t.Errorf("expected an error fetching an ftp URL")
+	}
+}
+
+func TestFetchMaxBytes(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		_, _ = w.Write([]byte(`<html><head><meta property="og:title" content="Early">`))
+		_, _ = w.Write([]byte(strings.Repeat("<!-- padding -->", 1000)))
+		_, _ = w.Write([]byte(`<meta property="og:description" content="Too late"></head></html>`))
+	}))
+	defer srv.Close()
+
+	f := New(srv.Client())
+	f.MaxBytes = 1024
+	card, err := f.Fetch(context.Background(), srv.URL)
+	if err != nil {
+		t.Fatalf("unable to fetch page: %v", err)
+	}
+	if card.Title != "Early" || card.Description != "" {
+		t.Errorf("unexpected card of truncated page: %+v", *card)
+	}
+}
+
+func TestParseDropsUnsafeImages(t *testing.T) {
+	card, err := Parse(strings.NewReader(`<head><meta property="og:image" content="javascript:alert(1)"></head>`), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if card.ImageURL != "" {
+		t.Errorf("got image URL %q, want none", card.ImageURL)
+	}
+}
@@ -2,13 +2,14 @@ package unsplash
 
 import (
 	"fmt"
+	"github.com/denysvitali/social/backend/pkg/imaging"
 	"net/url"
 )
 
 func GetProfilePicture(id string) string {
-	return fmt.Sprintf("https://unsplash.com/photos/%s/download?w=200", url.PathEscape(id))
+	return fmt.Sprintf("https://unsplash.com/photos/%s/download?w=%d", url.PathEscape(id), imaging.AvatarSize)
 }
 
 func GetBioPic(id string) string {
-	return fmt.Sprintf("https://unsplash.com/photos/%s/download?w=800", url.PathEscape(id))
+	return fmt.Sprintf("https://unsplash.com/photos/%s/download?w=%d", url.PathEscape(id), imaging.BannerWidth)
 }
@@ -0,0 +1,43 @@
+package server
+
+import (
+	pg_model "github.com/denysvitali/social/backend/pkg/models/postgres"
+	"github.com/gin-gonic/gin"
+	"strconv"
+)
+
+// viewerId returns the ID of the user performing the request, as carried by ViewerHeader.
+// The second return value is false for anonymous requests.
+func (s *Server) viewerId(c *gin.Context) (uint64, bool) {
+	v := c.GetHeader(ViewerHeader)
+	if v == "" {
+		return 0, false
+	}
+	id, err := strconv.ParseUint(v, 10, 64)
+	if err != nil || id == 0 {
+		return 0, false
+	}
+	return id, true
+}
+
+// requireViewer is like viewerId, but replies with 401 Unauthorized when the request is anonymous, and with
+// 403 Forbidden when the viewer is suspended.
+func (s *Server) requireViewer(c *gin.Context) (uint64, bool) {
+	id, ok := s.viewerId(c)
+	if !ok {
+		s.unauthorized(c, "missing or invalid %s header", ViewerHeader)
+		return 0, false
+	}
+
+	var suspended int64
+	err := s.pgDB.Model(&pg_model.User{}).Where("id = ? AND suspended_at IS NOT NULL", id).Count(&suspended).Error
+	if err != nil {
+		s.internalServerError(c, "unable to get suspension of user %d: %v", id, err)
+		return 0, false
+	}
+	if suspended > 0 {
+		s.forbidden(c, "suspended user %d tried to %s %s", id, c.Request.Method, c.FullPath())
+		return 0, false
+	}
+	return id, true
+}
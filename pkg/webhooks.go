@@ -0,0 +1,289 @@
+package server
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/denysvitali/social/backend/pkg/models/api"
+	pg_model "github.com/denysvitali/social/backend/pkg/models/postgres"
+	"github.com/oklog/ulid/v2"
+	"io"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// webhookMaxAttempts is the number of attempts of a delivery before it is dead-lettered. With the
+// exponential backoff, the last attempt happens about 4 hours after the first one.
+const webhookMaxAttempts = 10
+const webhookBaseBackoff = 30 * time.Second
+
+const webhookTimeout = 10 * time.Second
+
+// webhookLease is how long a delivery is reserved for the worker attempting it: if the server stops
+// during the attempt, the delivery is attempted again once the lease expires
+const webhookLease = 2 * webhookTimeout
+
+const webhookPollInterval = 5 * time.Second
+const webhookBatchSize = 16
+
+// webhookRetention is how long completed deliveries are kept
+const webhookRetention = 30 * 24 * time.Hour
+const webhookPruneInterval = time.Hour
+
+// maxWebhookErrorLength is the length the error of an attempt is truncated to
+const maxWebhookErrorLength = 512
+
+// WebhookEvents are the types of events webhooks can subscribe to
+var WebhookEvents = []string{
+	pg_model.WebhookEventPostCreated,
+	pg_model.WebhookEventUserFollowed,
+	pg_model.WebhookEventPostLiked,
+}
+
+// emitWebhookEvent queues the delivery of an event to the webhooks subscribed to it. data is only called
+// when there are such webhooks. Like notifications, events are best-effort: failing to queue them
+// doesn't fail the action.
+func (s *Server) emitWebhookEvent(event string, data func() (any, error)) {
+	var webhookIds []uint64
+	err := s.pgDB.
+		Model(&pg_model.Webhook{}).
+		Where("active = true AND id IN (SELECT webhook_id FROM webhook_events WHERE event = ?)", event).
+		Pluck("id", &webhookIds).Error
+	if err != nil {
+		s.logger.Warnf("unable to get webhooks of %s: %v", event, err)
+		return
+	}
+	if len(webhookIds) == 0 {
+		return
+	}
+
+	d, err := data()
+	if err != nil {
+		s.logger.Warnf("unable to build %s event: %v", event, err)
+		return
+	}
+	now := time.Now()
+	eventId := ulid.Make()
+	payload, err := json.Marshal(api.WebhookPayload{
+		ID:        eventId.String(),
+		Event:     event,
+		CreatedAt: now,
+		Data:      d,
+	})
+	if err != nil {
+		s.logger.Warnf("unable to encode %s event: %v", event, err)
+		return
+	}
+
+	var deliveries []pg_model.WebhookDelivery
+	for _, id := range webhookIds {
+		deliveries = append(deliveries, pg_model.WebhookDelivery{
+			ID:            ulid.Make().Bytes(),
+			WebhookID:     id,
+			EventID:       eventId.Bytes(),
+			Event:         event,
+			Payload:       string(payload),
+			Status:        pg_model.WebhookDeliveryPending,
+			NextAttemptAt: now,
+			CreatedAt:     now,
+		})
+	}
+	err = s.pgDB.Create(&deliveries).Error
+	if err != nil {
+		s.logger.Warnf("unable to queue %s deliveries: %v", event, err)
+		return
+	}
+	s.wakeWebhookWorker()
+}
+
+// wakeWebhookWorker makes the worker look for due deliveries without waiting for the next poll
+func (s *Server) wakeWebhookWorker() {
+	select {
+	case s.webhookWake <- struct{}{}:
+	default:
+	}
+}
+
+// runWebhookWorker attempts the due deliveries. Deliveries are stored in PostgreSQL, so that they survive
+// restarts and can be shared by several instances.
+func (s *Server) runWebhookWorker() {
+	poll := time.NewTicker(webhookPollInterval)
+	defer poll.Stop()
+	prune := time.NewTicker(webhookPruneInterval)
+	defer prune.Stop()
+
+	for {
+		select {
+		case <-s.webhookWake:
+		case <-poll.C:
+		case <-prune.C:
+			s.pruneWebhookDeliveries()
+			continue
+		}
+		s.attemptDueWebhookDeliveries()
+	}
+}
+
+func (s *Server) attemptDueWebhookDeliveries() {
+	for {
+		deliveries, err := s.claimWebhookDeliveries(time.Now())
+		if err != nil {
+			s.logger.Errorf("unable to claim webhook deliveries: %v", err)
+			return
+		}
+		if len(deliveries) == 0 {
+			return
+		}
+
+		var wg sync.WaitGroup
+		for _, d := range deliveries {
+			wg.Add(1)
+			go func(d pg_model.WebhookDelivery) {
+				defer wg.Done()
+				s.attemptWebhookDelivery(d)
+			}(d)
+		}
+		wg.Wait()
+	}
+}
+
+// claimWebhookDeliveries reserves the due deliveries of active webhooks by pushing their next attempt
+// past the lease
+func (s *Server) claimWebhookDeliveries(now time.Time) ([]pg_model.WebhookDelivery, error) {
+	var deliveries []pg_model.WebhookDelivery
+	tx := s.pgDB.Raw(`
+		UPDATE webhook_deliveries SET next_attempt_at = @lease_until
+		WHERE id IN (
+			SELECT webhook_deliveries.id FROM webhook_deliveries
+			INNER JOIN webhooks ON webhooks.id = webhook_deliveries.webhook_id
+			WHERE webhook_deliveries.status = @pending
+				AND webhook_deliveries.next_attempt_at <= @now
+				AND webhooks.active = true
+			ORDER BY webhook_deliveries.next_attempt_at
+			LIMIT @limit
+			FOR UPDATE OF webhook_deliveries SKIP LOCKED
+		)
+		RETURNING *`,
+		map[string]any{
+			"lease_until": now.Add(webhookLease),
+			"pending":     pg_model.WebhookDeliveryPending,
+			"now":         now,
+			"limit":       webhookBatchSize,
+		},
+	).Scan(&deliveries)
+	return deliveries, tx.Error
+}
+
+// attemptWebhookDelivery posts a delivery, then records the outcome of the attempt
+func (s *Server) attemptWebhookDelivery(d pg_model.WebhookDelivery) {
+	var webhook pg_model.Webhook
+	err := s.pgDB.Take(&webhook, "id = ?", d.WebhookID).Error
+	if err != nil {
+		s.logger.Warnf("unable to get webhook %d: %v", d.WebhookID, err)
+		return
+	}
+
+	status, err := s.postWebhook(webhook, d)
+	now := time.Now()
+	updates := map[string]any{
+		"attempts":        d.Attempts + 1,
+		"last_attempt_at": now,
+		"response_status": status,
+		"error":           "",
+	}
+	switch {
+	case err == nil:
+		updates["status"] = pg_model.WebhookDeliverySucceeded
+	case d.Attempts+1 >= webhookMaxAttempts:
+		updates["status"] = pg_model.WebhookDeliveryDead
+	default:
+		updates["next_attempt_at"] = now.Add(webhookBackoff(d.Attempts + 1))
+	}
+	if err != nil {
+		msg := err.Error()
+		if len(msg) > maxWebhookErrorLength {
+			msg = strings.ToValidUTF8(msg[:maxWebhookErrorLength], "")
+		}
+		updates["error"] = msg
+		s.logger.Debugf("delivery %s to webhook %d failed: %v", bytesToUlid(d.ID), d.WebhookID, err)
+	}
+
+	err = s.pgDB.Model(&pg_model.WebhookDelivery{}).Where("id = ?", d.ID).Updates(updates).Error
+	if err != nil {
+		s.logger.Warnf("unable to record attempt of webhook delivery %s: %v", bytesToUlid(d.ID), err)
+	}
+}
+
+// postWebhook sends a delivery to its webhook, and returns the HTTP status of the response. Redirects
+// aren't followed: they count as failures.
+func (s *Server) postWebhook(webhook pg_model.Webhook, d pg_model.WebhookDelivery) (int, error) {
+	req, err := http.NewRequest(http.MethodPost, webhook.URL, bytes.NewReader([]byte(d.Payload)))
+	if err != nil {
+		return 0, err
+	}
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "OpenDolphin-Webhooks")
+	req.Header.Set("X-Webhook-Event", d.Event)
+	req.Header.Set("X-Webhook-Delivery", bytesToUlid(d.ID).String())
+	req.Header.Set("X-Webhook-Signature", "t="+timestamp+",v1="+signWebhook(webhook.Secret, timestamp, d.Payload))
+
+	res, err := s.webhookClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	_, _ = io.Copy(io.Discard, io.LimitReader(res.Body, 4096))
+	_ = res.Body.Close()
+
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return res.StatusCode, fmt.Errorf("webhook responded with %s", res.Status)
+	}
+	return res.StatusCode, nil
+}
+
+// signWebhook returns the signature of a delivery: the hex-encoded HMAC-SHA256 of the timestamp and the
+// payload, separated by a dot. Receivers should reject old timestamps to prevent replays.
+func signWebhook(secret string, timestamp string, payload string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "." + payload))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// webhookBackoff returns the delay before the attempt following the attempts-th one, doubling with each
+// attempt, with some jitter so that the deliveries of an outage aren't all retried at once
+func webhookBackoff(attempts int) time.Duration {
+	d := webhookBaseBackoff << (attempts - 1)
+	return d + time.Duration(rand.Int63n(int64(d/10)+1))
+}
+
+// pruneWebhookDeliveries removes the completed deliveries past the retention period
+func (s *Server) pruneWebhookDeliveries() {
+	err := s.pgDB.
+		Where("status <> ? AND created_at < ?", pg_model.WebhookDeliveryPending, time.Now().Add(-webhookRetention)).
+		Delete(&pg_model.WebhookDelivery{}).Error
+	if err != nil {
+		s.logger.Warnf("unable to prune webhook deliveries: %v", err)
+	}
+}
+
+// postCreatedEvent returns the data of the post.created event of post, rendered for an anonymous viewer
+func (s *Server) postCreatedEvent(post pg_model.Post) (any, error) {
+	postsResponse, err := s.postsResponse(0, []pg_model.Post{post})
+	if err != nil {
+		return nil, err
+	}
+	event := api.PostCreatedEvent{Post: postsResponse.Posts[0]}
+	for _, u := range postsResponse.Users {
+		if u.ID == post.AuthorID {
+			event.Author = u
+		}
+	}
+	return event, nil
+}
@@ -0,0 +1,154 @@
+package server
+
+import (
+	"database/sql/driver"
+	"fmt"
+	pg_model "github.com/denysvitali/social/backend/pkg/models/postgres"
+	"github.com/oklog/ulid/v2"
+	"github.com/sirupsen/logrus"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSignWebhook(t *testing.T) {
+	// Computed with Python's hmac module
+	got := signWebhook("whsec_test", "1700000000", `{"id":"01H","event":"post.created"}`)
+	want := "299c40830cae50ce3eca1c69ee0f7d7e44587c21e0404e08cf0e431d03c4f3ed"
+	if got != want {
+		t.Errorf("got signature %s, want %s", got, want)
+	}
+}
+
+func TestWebhookBackoff(t *testing.T) {
+	var total time.Duration
+	for attempts := 1; attempts < webhookMaxAttempts; attempts++ {
+		base := webhookBaseBackoff * time.Duration(1<<(attempts-1))
+		for i := 0; i < 100; i++ {
+			d := webhookBackoff(attempts)
+			if d < base || d > base+base/10 {
+				t.Fatalf("backoff after %d attempts is %v, want between %v and %v", attempts, d, base, base+base/10)
+			}
+		}
+		total += base
+	}
+	// The last attempt happens about 4 hours after the first one
+	if total < 4*time.Hour || total > 5*time.Hour {
+		t.Errorf("the last attempt happens %v after the first one", total)
+	}
+}
+
+var updatedColumnRegexp = regexp.MustCompile(`"(\w+)"=\$(\d+)`)
+
+// updatedColumns returns the values set by an UPDATE statement issued by gorm
+func updatedColumns(st fakeStatement) map[string]driver.Value {
+	columns := map[string]driver.Value{}
+	for _, m := range updatedColumnRegexp.FindAllStringSubmatch(st.query, -1) {
+		i, _ := strconv.Atoi(m[2])
+		columns[m[1]] = st.args[i-1]
+	}
+	return columns
+}
+
+func TestAttemptWebhookDelivery(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		status   int
+		attempts int
+		want     string
+	}{
+		{"success", http.StatusNoContent, 0, pg_model.WebhookDeliverySucceeded},
+		{"error", http.StatusInternalServerError, 0, pg_model.WebhookDeliveryPending},
+		{"redirect", http.StatusFound, 3, pg_model.WebhookDeliveryPending},
+		{"last error", http.StatusInternalServerError, webhookMaxAttempts - 1, pg_model.WebhookDeliveryDead},
+		{"success after errors", http.StatusOK, webhookMaxAttempts - 1, pg_model.WebhookDeliverySucceeded},
+	} {
+		d := pg_model.WebhookDelivery{
+			ID:        ulid.Make().Bytes(),
+			WebhookID: 7,
+			Event:     pg_model.WebhookEventPostCreated,
+			Payload:   `{"event":"post.created"}`,
+			Status:    pg_model.WebhookDeliveryPending,
+			Attempts:  tc.attempts,
+		}
+
+		var signature string
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			body, _ := io.ReadAll(r.Body)
+			var timestamp string
+			for _, part := range strings.Split(r.Header.Get("X-Webhook-Signature"), ",") {
+				k, v, _ := strings.Cut(part, "=")
+				switch k {
+				case "t":
+					timestamp = v
+				case "v1":
+					signature = v
+				}
+			}
+			if signature != signWebhook("secret", timestamp, string(body)) {
+				signature = "invalid"
+			}
+			if tc.status == http.StatusFound {
+				w.Header().Set("Location", "/elsewhere")
+			}
+			w.WriteHeader(tc.status)
+		}))
+
+		db, fake := newFakeDB(t, func(query string, args []driver.Value) (fakeResult, error) {
+			if strings.Contains(query, `FROM "webhooks"`) {
+				return fakeResult{
+					columns: []string{"id", "url", "secret", "active"},
+					rows:    [][]driver.Value{{int64(7), srv.URL, "secret", true}},
+				}, nil
+			}
+			return fakeResult{}, nil
+		})
+		log := logrus.New()
+		log.SetOutput(io.Discard)
+		client := srv.Client()
+		client.CheckRedirect = func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }
+		s := &Server{logger: log, pgDB: db, webhookClient: client}
+
+		before := time.Now()
+		s.attemptWebhookDelivery(d)
+		srv.Close()
+
+		if signature == "" || signature == "invalid" {
+			t.Errorf("%s: got signature %q", tc.name, signature)
+		}
+		execs := fake.execs()
+		if len(execs) != 1 || !strings.Contains(execs[0].query, "webhook_deliveries") {
+			t.Fatalf("%s: got statements %v", tc.name, execs)
+		}
+		updates := updatedColumns(execs[0])
+		if fmt.Sprint(updates["attempts"]) != strconv.Itoa(tc.attempts+1) {
+			t.Errorf("%s: attempts set to %v", tc.name, updates["attempts"])
+		}
+		if fmt.Sprint(updates["response_status"]) != strconv.Itoa(tc.status) {
+			t.Errorf("%s: response status set to %v", tc.name, updates["response_status"])
+		}
+
+		status, ok := updates["status"]
+		if !ok {
+			status = pg_model.WebhookDeliveryPending
+		}
+		if status != tc.want {
+			t.Errorf("%s: got status %v, want %s", tc.name, status, tc.want)
+		}
+		next, rescheduled := updates["next_attempt_at"].(time.Time)
+		if rescheduled != (tc.want == pg_model.WebhookDeliveryPending) {
+			t.Errorf("%s: next attempt set to %v", tc.name, updates["next_attempt_at"])
+		}
+		if rescheduled && next.Before(before.Add(webhookBaseBackoff<<tc.attempts)) {
+			t.Errorf("%s: next attempt at %v is too early", tc.name, next)
+		}
+		if (tc.want == pg_model.WebhookDeliverySucceeded) != (updates["error"] == "") {
+			t.Errorf("%s: error set to %q", tc.name, updates["error"])
+		}
+	}
+}
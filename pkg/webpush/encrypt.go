@@ -0,0 +1,156 @@
+package webpush
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"golang.org/x/crypto/hkdf"
+	"io"
+)
+
+const (
+	saltSize       = 16
+	authSecretSize = 16
+	publicKeySize  = 65
+	// headerSize is the size of the aes128gcm header: salt, record size, key ID length and key ID
+	headerSize = saltSize + 4 + 1 + publicKeySize
+	// recordSize is the maximum size of a push message, which is a single record (RFC 8291, section 4)
+	recordSize = 4096
+)
+
+// MaxPayloadSize is the maximum size of a payload: the record also holds a padding delimiter and the
+// authentication tag of AES-GCM
+const MaxPayloadSize = recordSize - headerSize - 1 - 16
+
+var ErrPayloadTooLarge = fmt.Errorf("payload larger than %d bytes", MaxPayloadSize)
+
+var errDecrypt = errors.New("unable to decrypt message")
+
+// Encrypt encrypts payload for the user agent owning the public key uaPublic and the authentication
+// secret authSecret, using the aes128gcm content coding (RFC 8188, RFC 8291)
+func Encrypt(payload []byte, uaPublic []byte, authSecret []byte) ([]byte, error) {
+	// The application server key pair is ephemeral, a new one is generated for each message
+	asKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	salt := make([]byte, saltSize)
+	_, err = rand.Read(salt)
+	if err != nil {
+		return nil, err
+	}
+	return encrypt(payload, uaPublic, authSecret, asKey, salt)
+}
+
+// encrypt is Encrypt, with the given application server key pair and salt
+func encrypt(payload []byte, uaPublic []byte, authSecret []byte, asKey *ecdsa.PrivateKey, salt []byte) ([]byte, error) {
+	if len(payload) > MaxPayloadSize {
+		return nil, ErrPayloadTooLarge
+	}
+	curve := elliptic.P256()
+	uaX, uaY := elliptic.Unmarshal(curve, uaPublic)
+	if uaX == nil {
+		return nil, fmt.Errorf("invalid user agent public key")
+	}
+	if len(authSecret) != authSecretSize {
+		return nil, fmt.Errorf("invalid authentication secret")
+	}
+
+	asPublic := elliptic.Marshal(curve, asKey.X, asKey.Y)
+	sharedX, _ := curve.ScalarMult(uaX, uaY, asKey.D.Bytes())
+	ecdhSecret := sharedX.FillBytes(make([]byte, 32))
+
+	aead, nonce, err := contentKeys(ecdhSecret, authSecret, uaPublic, asPublic, salt)
+	if err != nil {
+		return nil, err
+	}
+
+	header := make([]byte, 0, headerSize)
+	header = append(header, salt...)
+	header = binary.BigEndian.AppendUint32(header, recordSize)
+	header = append(header, publicKeySize)
+	header = append(header, asPublic...)
+
+	// A single record, ended by the last record delimiter and not padded
+	plaintext := append(append([]byte{}, payload...), 2)
+	return aead.Seal(header, nonce, plaintext, nil), nil
+}
+
+// Decrypt decrypts a message encrypted by Encrypt, for the user agent owning uaKey. It is meant for
+// fake push services and clients.
+func Decrypt(message []byte, uaKey *ecdsa.PrivateKey, authSecret []byte) ([]byte, error) {
+	if len(message) < headerSize {
+		return nil, errDecrypt
+	}
+	salt := message[:saltSize]
+	if message[saltSize+4] != publicKeySize {
+		return nil, errDecrypt
+	}
+	asPublic := message[saltSize+5 : headerSize]
+	ciphertext := message[headerSize:]
+
+	curve := elliptic.P256()
+	asX, asY := elliptic.Unmarshal(curve, asPublic)
+	if asX == nil {
+		return nil, errDecrypt
+	}
+	sharedX, _ := curve.ScalarMult(asX, asY, uaKey.D.Bytes())
+	ecdhSecret := sharedX.FillBytes(make([]byte, 32))
+	uaPublic := elliptic.Marshal(curve, uaKey.X, uaKey.Y)
+
+	aead, nonce, err := contentKeys(ecdhSecret, authSecret, uaPublic, asPublic, salt)
+	if err != nil {
+		return nil, err
+	}
+	plaintext, err := aead.Open(nil, nonce, ciphertext, nil)
+	if err != nil {
+		return nil, errDecrypt
+	}
+
+	// Removes the padding, then the last record delimiter
+	plaintext = bytes.TrimRight(plaintext, "\x00")
+	if len(plaintext) == 0 || plaintext[len(plaintext)-1] != 2 {
+		return nil, errDecrypt
+	}
+	return plaintext[:len(plaintext)-1], nil
+}
+
+// contentKeys derives the content encryption key and the nonce of a message (RFC 8291, section 3.4)
+func contentKeys(ecdhSecret, authSecret, uaPublic, asPublic, salt []byte) (cipher.AEAD, []byte, error) {
+	keyInfo := append([]byte("WebPush: info\x00"), uaPublic...)
+	keyInfo = append(keyInfo, asPublic...)
+	ikm := make([]byte, 32)
+	_, err := io.ReadFull(hkdf.New(sha256.New, ecdhSecret, authSecret, keyInfo), ikm)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	prk := hkdf.Extract(sha256.New, ikm, salt)
+	cek := make([]byte, 16)
+	_, err = io.ReadFull(hkdf.Expand(sha256.New, prk, []byte("Content-Encoding: aes128gcm\x00")), cek)
+	if err != nil {
+		return nil, nil, err
+	}
+	nonce := make([]byte, 12)
+	_, err = io.ReadFull(hkdf.Expand(sha256.New, prk, []byte("Content-Encoding: nonce\x00")), nonce)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	block, err := aes.NewCipher(cek)
+	if err != nil {
+		return nil, nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, nil, err
+	}
+	return aead, nonce, nil
+}
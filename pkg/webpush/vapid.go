@@ -0,0 +1,168 @@
+package webpush
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+)
+
+// vapidTokenLifetime is the validity of the tokens sent to push services, which accept up to 24 hours
+const vapidTokenLifetime = 12 * time.Hour
+
+// VAPIDKey identifies the application server to push services (RFC 8292). Browsers only accept
+// subscriptions created with the public key, so the key must not change once clients subscribed.
+type VAPIDKey struct {
+	key *ecdsa.PrivateKey
+}
+
+func GenerateVAPIDKey() (*VAPIDKey, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	return &VAPIDKey{key: key}, nil
+}
+
+// ParseVAPIDKey parses a private key encoded by VAPIDKey.String
+func ParseVAPIDKey(s string) (*VAPIDKey, error) {
+	d, err := decodeBase64(s)
+	if err != nil {
+		return nil, fmt.Errorf("invalid VAPID key: %v", err)
+	}
+	if len(d) != 32 {
+		return nil, fmt.Errorf("invalid VAPID key: expected 32 bytes, got %d", len(d))
+	}
+
+	curve := elliptic.P256()
+	key := &ecdsa.PrivateKey{D: new(big.Int).SetBytes(d)}
+	if key.D.Sign() == 0 || key.D.Cmp(curve.Params().N) >= 0 {
+		return nil, fmt.Errorf("invalid VAPID key: out of range")
+	}
+	key.PublicKey.Curve = curve
+	key.PublicKey.X, key.PublicKey.Y = curve.ScalarBaseMult(d)
+	return &VAPIDKey{key: key}, nil
+}
+
+// String returns the private key, as the URL-safe base64 encoding of its scalar
+func (k *VAPIDKey) String() string {
+	return base64.RawURLEncoding.EncodeToString(k.key.D.FillBytes(make([]byte, 32)))
+}
+
+// PublicKey returns the public key, in the format expected by PushManager.subscribe as applicationServerKey
+func (k *VAPIDKey) PublicKey() string {
+	return base64.RawURLEncoding.EncodeToString(elliptic.Marshal(k.key.Curve, k.key.X, k.key.Y))
+}
+
+// authorization returns the value of the Authorization header of a request to audience, the origin of
+// the push service
+func (k *VAPIDKey) authorization(audience string, subject string, now time.Time) (string, error) {
+	header := map[string]string{"typ": "JWT", "alg": "ES256"}
+	claims := map[string]any{
+		"aud": audience,
+		"exp": now.Add(vapidTokenLifetime).Unix(),
+	}
+	if subject != "" {
+		claims["sub"] = subject
+	}
+
+	rawHeader, err := json.Marshal(header)
+	if err != nil {
+		return "", err
+	}
+	rawClaims, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+	signingInput := base64.RawURLEncoding.EncodeToString(rawHeader) + "." +
+		base64.RawURLEncoding.EncodeToString(rawClaims)
+
+	digest := sha256.Sum256([]byte(signingInput))
+	r, s, err := ecdsa.Sign(rand.Reader, k.key, digest[:])
+	if err != nil {
+		return "", err
+	}
+	// JWS signatures are the concatenation of r and s, each padded to 32 bytes
+	sig := make([]byte, 64)
+	r.FillBytes(sig[:32])
+	s.FillBytes(sig[32:])
+
+	token := signingInput + "." + base64.RawURLEncoding.EncodeToString(sig)
+	return "vapid t=" + token + ", k=" + k.PublicKey(), nil
+}
+
+// decodeBase64 decodes base64, URL-safe or not and with or without padding, as clients aren't consistent
+func decodeBase64(s string) ([]byte, error) {
+	s = strings.NewReplacer("+", "-", "/", "_").Replace(strings.TrimRight(s, "="))
+	return base64.RawURLEncoding.DecodeString(s)
+}
+
+// VerifyAuthorization checks the Authorization header of a push request sent to audience, and returns the
+// public key of the application server. It is meant for fake push services.
+func VerifyAuthorization(header string, audience string, now time.Time) (string, error) {
+	var token, publicKey string
+	if !strings.HasPrefix(header, "vapid ") {
+		return "", fmt.Errorf("not a VAPID authorization")
+	}
+	params := strings.TrimPrefix(header, "vapid ")
+	for _, p := range strings.Split(params, ",") {
+		k, v, _ := strings.Cut(strings.TrimSpace(p), "=")
+		switch k {
+		case "t":
+			token = v
+		case "k":
+			publicKey = v
+		}
+	}
+
+	rawKey, err := decodeBase64(publicKey)
+	if err != nil {
+		return "", fmt.Errorf("invalid public key: %v", err)
+	}
+	curve := elliptic.P256()
+	x, y := elliptic.Unmarshal(curve, rawKey)
+	if x == nil {
+		return "", fmt.Errorf("invalid public key")
+	}
+
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return "", fmt.Errorf("malformed token")
+	}
+	sig, err := decodeBase64(parts[2])
+	if err != nil || len(sig) != 64 {
+		return "", fmt.Errorf("malformed signature")
+	}
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	r := new(big.Int).SetBytes(sig[:32])
+	s := new(big.Int).SetBytes(sig[32:])
+	if !ecdsa.Verify(&ecdsa.PublicKey{Curve: curve, X: x, Y: y}, digest[:], r, s) {
+		return "", fmt.Errorf("invalid signature")
+	}
+
+	rawClaims, err := decodeBase64(parts[1])
+	if err != nil {
+		return "", fmt.Errorf("malformed claims: %v", err)
+	}
+	var claims struct {
+		Aud string `json:"aud"`
+		Exp int64  `json:"exp"`
+	}
+	err = json.Unmarshal(rawClaims, &claims)
+	if err != nil {
+		return "", fmt.Errorf("malformed claims: %v", err)
+	}
+	if claims.Aud != audience {
+		return "", fmt.Errorf("token is for %q", claims.Aud)
+	}
+	if now.Unix() > claims.Exp || time.Unix(claims.Exp, 0).Sub(now) > 24*time.Hour {
+		return "", fmt.Errorf("token expired or valid for more than 24 hours")
+	}
+	return publicKey, nil
+}
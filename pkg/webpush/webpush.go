@@ -0,0 +1,129 @@
+// Package webpush sends push messages to browsers through their push service, following the Web Push
+// protocol (RFC 8030) with VAPID authentication (RFC 8292) and encrypted payloads (RFC 8291).
+package webpush
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// ErrGone is returned when the push service no longer knows the subscription, which must be removed
+var ErrGone = errors.New("subscription expired or unsubscribed")
+
+// Urgency of a message (RFC 8030, section 5.3), push services may defer messages of low urgency to
+// save battery
+const (
+	UrgencyVeryLow = "very-low"
+	UrgencyLow     = "low"
+	UrgencyNormal  = "normal"
+	UrgencyHigh    = "high"
+)
+
+// Subscription is a PushSubscription of a browser, as returned by PushSubscription.toJSON
+type Subscription struct {
+	Endpoint string
+	// P256dh is the public key of the user agent, encoded in URL-safe base64
+	P256dh string
+	// Auth is the authentication secret of the user agent, encoded in URL-safe base64
+	Auth string
+}
+
+// Validate checks that the keys of the subscription are well-formed. allowHTTP lets endpoints use plain
+// HTTP, which is only useful for local push services.
+func (s Subscription) Validate(allowHTTP bool) error {
+	u, err := url.Parse(s.Endpoint)
+	if err != nil {
+		return fmt.Errorf("invalid endpoint: %v", err)
+	}
+	if u.Host == "" || (u.Scheme != "https" && !(allowHTTP && u.Scheme == "http")) {
+		return fmt.Errorf("invalid endpoint %q", s.Endpoint)
+	}
+	_, _, err = s.keys()
+	return err
+}
+
+func (s Subscription) keys() ([]byte, []byte, error) {
+	p256dh, err := decodeBase64(s.P256dh)
+	if err != nil || len(p256dh) != publicKeySize {
+		return nil, nil, fmt.Errorf("invalid p256dh key")
+	}
+	auth, err := decodeBase64(s.Auth)
+	if err != nil || len(auth) != authSecretSize {
+		return nil, nil, fmt.Errorf("invalid auth secret")
+	}
+	return p256dh, auth, nil
+}
+
+type Message struct {
+	Payload []byte
+	// TTL is how long the push service keeps the message when the user agent isn't connected
+	TTL     time.Duration
+	Urgency string
+	// Topic replaces the pending messages with the same topic, when not empty
+	Topic string
+}
+
+// Client sends push messages
+type Client struct {
+	HTTP *http.Client
+	Key  *VAPIDKey
+	// Subject is a mailto: or https: URL that push services can use to contact the operator
+	Subject string
+}
+
+// Send encrypts and sends msg to the push service of sub. It returns ErrGone when the subscription
+// must be removed.
+func (c *Client) Send(ctx context.Context, sub Subscription, msg Message) error {
+	uaPublic, authSecret, err := sub.keys()
+	if err != nil {
+		return err
+	}
+	body, err := Encrypt(msg.Payload, uaPublic, authSecret)
+	if err != nil {
+		return err
+	}
+
+	endpoint, err := url.Parse(sub.Endpoint)
+	if err != nil {
+		return fmt.Errorf("invalid endpoint: %v", err)
+	}
+	authorization, err := c.Key.authorization(endpoint.Scheme+"://"+endpoint.Host, c.Subject, time.Now())
+	if err != nil {
+		return fmt.Errorf("unable to sign VAPID token: %v", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, sub.Endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", authorization)
+	req.Header.Set("Content-Type", "application/octet-stream")
+	req.Header.Set("Content-Encoding", "aes128gcm")
+	req.Header.Set("TTL", strconv.Itoa(int(msg.TTL.Seconds())))
+	if msg.Urgency != "" {
+		req.Header.Set("Urgency", msg.Urgency)
+	}
+	if msg.Topic != "" {
+		req.Header.Set("Topic", msg.Topic)
+	}
+
+	res, err := c.HTTP.Do(req)
+	if err != nil {
+		return err
+	}
+	_ = res.Body.Close()
+
+	switch {
+	case res.StatusCode == http.StatusNotFound || res.StatusCode == http.StatusGone:
+		return ErrGone
+	case res.StatusCode < 200 || res.StatusCode > 299:
+		return fmt.Errorf("push service responded with %s", res.Status)
+	}
+	return nil
+}
@@ -0,0 +1,342 @@
+package webpush
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// userAgent is the key pair and authentication secret of a browser subscription
+type userAgent struct {
+	key        *ecdsa.PrivateKey
+	authSecret []byte
+}
+
+func newUserAgent(t *testing.T) userAgent {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	authSecret := make([]byte, authSecretSize)
+	_, err = rand.Read(authSecret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return userAgent{key: key, authSecret: authSecret}
+}
+
+func (ua userAgent) publicKey() []byte {
+	return elliptic.Marshal(elliptic.P256(), ua.key.X, ua.key.Y)
+}
+
+func (ua userAgent) subscription(endpoint string) Subscription {
+	return Subscription{
+		Endpoint: endpoint,
+		P256dh:   base64.RawURLEncoding.EncodeToString(ua.publicKey()),
+		Auth:     base64.RawURLEncoding.EncodeToString(ua.authSecret),
+	}
+}
+
+func TestEncryptDecrypt(t *testing.T) {
+	ua := newUserAgent(t)
+
+	for _, payload := range [][]byte{
+		[]byte(`{"type":"like"}`),
+		{},
+		bytes.Repeat([]byte{0}, 10),
+		bytes.Repeat([]byte("x"), MaxPayloadSize),
+	} {
+		message, err := Encrypt(payload, ua.publicKey(), ua.authSecret)
+		if err != nil {
+			t.Fatalf("unable to encrypt %d bytes: %v", len(payload), err)
+		}
+		if len(message) > recordSize {
+			t.Errorf("message of %d bytes exceeds the record size", len(message))
+		}
+		got, err := Decrypt(message, ua.key, ua.authSecret)
+		if err != nil {
+			t.Fatalf("unable to decrypt %d bytes: %v", len(payload), err)
+		}
+		if !bytes.Equal(got, payload) {
+			t.Errorf("got payload %q, want %q", got, payload)
+		}
+	}
+
+	_, err := Encrypt(make([]byte, MaxPayloadSize+1), ua.publicKey(), ua.authSecret)
+	if !errors.Is(err, ErrPayloadTooLarge) {
+		t.Errorf("got error %v for a payload too large, want ErrPayloadTooLarge", err)
+	}
+}
+
+func TestDecryptRejectsTampering(t *testing.T) {
+	ua := newUserAgent(t)
+	message, err := Encrypt([]byte("hello"), ua.publicKey(), ua.authSecret)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tampered := append([]byte{}, message...)
+	tampered[len(tampered)-1] ^= 1
+	if _, err := Decrypt(tampered, ua.key, ua.authSecret); err == nil {
+		t.Errorf("a tampered message was decrypted")
+	}
+
+	otherSecret := append([]byte{}, ua.authSecret...)
+	otherSecret[0] ^= 1
+	if _, err := Decrypt(message, ua.key, otherSecret); err == nil {
+		t.Errorf("a message was decrypted with another authentication secret")
+	}
+
+	other := newUserAgent(t)
+	if _, err := Decrypt(message, other.key, ua.authSecret); err == nil {
+		t.Errorf("a message was decrypted with another key")
+	}
+
+	if _, err := Decrypt(message[:headerSize-1], ua.key, ua.authSecret); err == nil {
+		t.Errorf("a truncated message was decrypted")
+	}
+}
+
+// TestEncryptRFC8291 checks the example of RFC 8291, appendix A
+func TestEncryptRFC8291(t *testing.T) {
+	decode := func(s string) []byte {
+		t.Helper()
+		b, err := base64.RawURLEncoding.DecodeString(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return b
+	}
+	asKey, err := ParseVAPIDKey("yfWPiYE-n46HLnH0KqZOF1fJJU3MYrct3AELtAQ-oRw")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := asKey.PublicKey(), "BP4z9KsN6nGRTbVYI_c7VJSPQTBtkgcy27mlmlMoZIIgDll6e3vCYLocInmYWAmS6TlzAC8wEqKK6PBru3jl7A8"; got != want {
+		t.Fatalf("got application server public key %s, want %s", got, want)
+	}
+	uaKey, err := ParseVAPIDKey("q1dXpw3UpT5VOmu_cf_v6ih07Aems3njxI-JWgLcM94")
+	if err != nil {
+		t.Fatal(err)
+	}
+	uaPublic := decode("BCVxsr7N_eNgVRqvHtD0zTZsEc6-VV-JvLexhqUzORcxaOzi6-AYWXvTBHm4bjyPjs7Vd8pZGH6SRpkNtoIAiw4")
+	if got := uaKey.PublicKey(); got != base64.RawURLEncoding.EncodeToString(uaPublic) {
+		t.Fatalf("got user agent public key %s", got)
+	}
+	authSecret := decode("BTBZMqHH6r4Tts7J_aSIgg")
+	salt := decode("DGv6ra1nlYgDCS1FRnbzlw")
+	plaintext := []byte("When I grow up, I want to be a watermelon")
+
+	message, err := encrypt(plaintext, uaPublic, authSecret, asKey.key, salt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := decode("DGv6ra1nlYgDCS1FRnbzlwAAEABBBP4z9KsN6nGRTbVYI_c7VJSPQTBtkgcy27mlmlMoZIIgDll6e3vCYLocInmYWAmS6TlzAC8wEqKK6PBru3jl7A_yl95bQpu6cVPTpK4Mqgkf1CXztLVBSt2Ks3oZwbuwXPXLWyouBWLVWGNWQexSgSxsj_Qulcy4a-fN")
+	if !bytes.Equal(message, want) {
+		t.Errorf("got message\n%x\nwant\n%x", message, want)
+	}
+
+	decrypted, err := Decrypt(want, uaKey.key, authSecret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(decrypted, plaintext) {
+		t.Errorf("got plaintext %q, want %q", decrypted, plaintext)
+	}
+}
+
+func TestVAPIDKeyEncoding(t *testing.T) {
+	key, err := GenerateVAPIDKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed, err := ParseVAPIDKey(key.String())
+	if err != nil {
+		t.Fatalf("unable to parse generated key: %v", err)
+	}
+	if parsed.PublicKey() != key.PublicKey() {
+		t.Errorf("parsed key has public key %s, want %s", parsed.PublicKey(), key.PublicKey())
+	}
+
+	publicKey, err := base64.RawURLEncoding.DecodeString(key.PublicKey())
+	if err != nil || len(publicKey) != publicKeySize || publicKey[0] != 4 {
+		t.Errorf("public key %s isn't an uncompressed P-256 point", key.PublicKey())
+	}
+
+	for _, invalid := range []string{"", "not base64!", base64.RawURLEncoding.EncodeToString(make([]byte, 32))} {
+		if _, err := ParseVAPIDKey(invalid); err == nil {
+			t.Errorf("ParseVAPIDKey(%q) succeeded", invalid)
+		}
+	}
+}
+
+func TestVAPIDAuthorization(t *testing.T) {
+	key, err := GenerateVAPIDKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	const audience = "https://push.example.net"
+
+	header, err := key.authorization(audience, "mailto:admin@example.com", now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	publicKey, err := VerifyAuthorization(header, audience, now)
+	if err != nil {
+		t.Fatalf("unable to verify authorization: %v", err)
+	}
+	if publicKey != key.PublicKey() {
+		t.Errorf("got public key %s, want %s", publicKey, key.PublicKey())
+	}
+
+	// The token is a JWT signed with ES256, as push services expect it
+	token := strings.TrimSuffix(strings.TrimPrefix(header, "vapid t="), ", k="+key.PublicKey())
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		t.Fatalf("token %s isn't a JWT", token)
+	}
+	var jwtHeader map[string]string
+	var claims map[string]any
+	for i, v := range []any{&jwtHeader, &claims} {
+		data, err := base64.RawURLEncoding.DecodeString(parts[i])
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = json.Unmarshal(data, v)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if jwtHeader["alg"] != "ES256" || jwtHeader["typ"] != "JWT" {
+		t.Errorf("unexpected JWT header %v", jwtHeader)
+	}
+	if claims["aud"] != audience || claims["sub"] != "mailto:admin@example.com" ||
+		claims["exp"] != float64(now.Add(vapidTokenLifetime).Unix()) {
+		t.Errorf("unexpected claims %v", claims)
+	}
+
+	if _, err := VerifyAuthorization(header, "https://other.example.net", now); err == nil {
+		t.Errorf("a token for another audience was accepted")
+	}
+	if _, err := VerifyAuthorization(header, audience, now.Add(vapidTokenLifetime+time.Minute)); err == nil {
+		t.Errorf("an expired token was accepted")
+	}
+	other, err := GenerateVAPIDKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	forged := strings.Replace(header, key.PublicKey(), other.PublicKey(), 1)
+	if _, err := VerifyAuthorization(forged, audience, now); err == nil {
+		t.Errorf("a token was accepted with another public key")
+	}
+}
+
+func TestSend(t *testing.T) {
+	key, err := GenerateVAPIDKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ua := newUserAgent(t)
+
+	var received []byte
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/gone":
+			w.WriteHeader(http.StatusGone)
+			return
+		case "/unknown":
+			w.WriteHeader(http.StatusNotFound)
+			return
+		case "/overloaded":
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+
+		audience := "http://" + r.Host
+		if _, err := VerifyAuthorization(r.Header.Get("Authorization"), audience, time.Now()); err != nil {
+			t.Errorf("invalid authorization: %v", err)
+		}
+		if r.Header.Get("Content-Encoding") != "aes128gcm" || r.Header.Get("TTL") != "60" ||
+			r.Header.Get("Urgency") != UrgencyHigh || r.Header.Get("Topic") != "unread" {
+			t.Errorf("unexpected headers %v", r.Header)
+		}
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			t.Error(err)
+		}
+		received, err = Decrypt(body, ua.key, ua.authSecret)
+		if err != nil {
+			t.Errorf("unable to decrypt message: %v", err)
+		}
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer srv.Close()
+
+	client := &Client{HTTP: srv.Client(), Key: key, Subject: "mailto:admin@example.com"}
+	msg := Message{Payload: []byte(`{"type":"follow"}`), TTL: time.Minute, Urgency: UrgencyHigh, Topic: "unread"}
+	ctx := context.Background()
+
+	err = client.Send(ctx, ua.subscription(srv.URL+"/push"), msg)
+	if err != nil {
+		t.Fatalf("unable to send message: %v", err)
+	}
+	if string(received) != `{"type":"follow"}` {
+		t.Errorf("push service received %q", received)
+	}
+
+	for _, path := range []string{"/gone", "/unknown"} {
+		err = client.Send(ctx, ua.subscription(srv.URL+path), msg)
+		if !errors.Is(err, ErrGone) {
+			t.Errorf("%s: got error %v, want ErrGone", path, err)
+		}
+	}
+	err = client.Send(ctx, ua.subscription(srv.URL+"/overloaded"), msg)
+	if err == nil || errors.Is(err, ErrGone) {
+		t.Errorf("got error %v for a transient failure", err)
+	}
+}
+
+func TestSubscriptionValidate(t *testing.T) {
+	ua := newUserAgent(t)
+	valid := ua.subscription("https://push.example.net/send/abc")
+	if err := valid.Validate(false); err != nil {
+		t.Errorf("valid subscription rejected: %v", err)
+	}
+
+	// Browsers may use the standard base64 alphabet, with padding
+	padded := valid
+	padded.Auth = base64.StdEncoding.EncodeToString(ua.authSecret)
+	if err := padded.Validate(false); err != nil {
+		t.Errorf("subscription with padded keys rejected: %v", err)
+	}
+
+	http := ua.subscription("http://localhost:8090/send/abc")
+	if err := http.Validate(false); err == nil {
+		t.Errorf("plain HTTP endpoint accepted")
+	}
+	if err := http.Validate(true); err != nil {
+		t.Errorf("plain HTTP endpoint rejected when allowed: %v", err)
+	}
+
+	for _, sub := range []Subscription{
+		{Endpoint: "https://push.example.net", P256dh: valid.P256dh, Auth: "c2hvcnQ"},
+		{Endpoint: "https://push.example.net", P256dh: "c2hvcnQ", Auth: valid.Auth},
+		{Endpoint: "javascript:alert(1)", P256dh: valid.P256dh, Auth: valid.Auth},
+	} {
+		if err := sub.Validate(false); err == nil {
+			t.Errorf("invalid subscription %+v accepted", sub)
+		}
+	}
+}
@@ -0,0 +1,92 @@
+// Package wordfilter matches the muted words of users against the content of posts.
+//
+// A rule is either a hashtag, written with its leading #, or a phrase of one or more words.
+// A hashtag matches the posts tagged with it. A phrase matches the posts containing its words
+// in the same order, as whole words: "cat" matches "Cat!" and "#cat", but not "category".
+// Matching is case-insensitive, with the same normalization as hashtags.
+package wordfilter
+
+import (
+	"errors"
+	"github.com/denysvitali/social/backend/pkg/entities"
+	"strings"
+	"unicode/utf8"
+)
+
+var (
+	ErrEmpty          = errors.New("the phrase must contain at least one word")
+	ErrInvalidHashtag = errors.New("invalid hashtag")
+)
+
+// Rule is a parsed muted word
+type Rule struct {
+	// hashtag is the canonical form of the hashtag, empty for phrases
+	hashtag string
+	words   []string
+}
+
+// Parse parses a muted word, see the package documentation for the syntax
+func Parse(phrase string) (Rule, error) {
+	phrase = strings.TrimSpace(phrase)
+	if strings.HasPrefix(phrase, "#") {
+		found := entities.Parse(phrase)
+		if len(found) != 1 || found[0].Type != entities.Hashtag || found[0].End != utf8.RuneCountInString(phrase) {
+			return Rule{}, ErrInvalidHashtag
+		}
+		return Rule{hashtag: entities.CanonicalTag(found[0].Text)}, nil
+	}
+
+	words := splitWords(phrase)
+	if len(words) == 0 {
+		return Rule{}, ErrEmpty
+	}
+	return Rule{words: words}, nil
+}
+
+// Text is the content of a post, prepared to be matched against any number of rules
+type Text struct {
+	words    []string
+	hashtags map[string]bool
+}
+
+// NewText prepares content to be matched
+func NewText(content string) Text {
+	t := Text{
+		words:    splitWords(content),
+		hashtags: map[string]bool{},
+	}
+	for _, tag := range entities.Texts(entities.Parse(content), entities.Hashtag) {
+		t.hashtags[entities.CanonicalTag(tag)] = true
+	}
+	return t
+}
+
+// Matches returns whether the rule matches t
+func (r Rule) Matches(t Text) bool {
+	if r.hashtag != "" {
+		return t.hashtags[r.hashtag]
+	}
+
+	for i := 0; i+len(r.words) <= len(t.words); i++ {
+		matches := true
+		for j, w := range r.words {
+			if t.words[i+j] != w {
+				matches = false
+				break
+			}
+		}
+		if matches {
+			return true
+		}
+	}
+	return false
+}
+
+// splitWords returns the normalized words of s
+func splitWords(s string) []string {
+	var words []string
+	for _, w := range strings.FieldsFunc(s, func(r rune) bool { return !entities.IsWordRune(r) }) {
+		words = append(words, entities.CanonicalTag(w))
+	}
+	return words
+}
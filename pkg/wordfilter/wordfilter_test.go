@@ -0,0 +1,70 @@
+package wordfilter
+
+import "testing"
+
+func TestParse(t *testing.T) {
+	for _, tc := range []struct {
+		phrase string
+		err    error
+	}{
+		{"cat", nil},
+		{"  black cat  ", nil},
+		{"#cat", nil},
+		{" #Cat ", nil},
+		{"", ErrEmpty},
+		{"   ", ErrEmpty},
+		{"!?", ErrEmpty},
+		{"#foo bar", ErrInvalidHashtag},
+		{"#foo#bar", ErrInvalidHashtag},
+		{"#", ErrInvalidHashtag},
+		{"#123", ErrInvalidHashtag},
+		{"#foo!", ErrInvalidHashtag},
+	} {
+		_, err := Parse(tc.phrase)
+		if err != tc.err {
+			t.Errorf("Parse(%q): got error %v, want %v", tc.phrase, err, tc.err)
+		}
+	}
+}
+
+func TestMatches(t *testing.T) {
+	for _, tc := range []struct {
+		phrase  string
+		content string
+		want    bool
+	}{
+		// Whole words
+		{"cat", "my cat", true},
+		{"cat", "Cat!", true},
+		{"cat", "category", false},
+		{"cat", "bobcat", false},
+		{"cat", "cat_food", false},
+
+		// Phrases, in order
+		{"black cat", "a black cat crossed", true},
+		{"black cat", "a BLACK, cat", true},
+		{"black cat", "a cat, black", false},
+		{"black cat", "black and cat", false},
+		{"black cat", "black", false},
+
+		// Phrases match hashtags, hashtags only match hashtags
+		{"cat", "I love #cat", true},
+		{"cat", "#CatsOfInstagram", false},
+		{"#cat", "I love #Cat", true},
+		{"#cat", "I love my cat", false},
+		{"#cat", "#category", false},
+
+		// Normalization
+		{"ｃａｔ", "CAT", true},
+		{"straße", "STRASSE", true},
+		{"café", "Café", true},
+	} {
+		r, err := Parse(tc.phrase)
+		if err != nil {
+			t.Fatalf("Parse(%q): %v", tc.phrase, err)
+		}
+		if got := r.Matches(NewText(tc.content)); got != tc.want {
+			t.Errorf("%q matching %q: got %v, want %v", tc.phrase, tc.content, got, tc.want)
+		}
+	}
+}